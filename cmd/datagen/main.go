@@ -10,6 +10,8 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/n1207n/cache-query-aggregator/config"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
 	"github.com/n1207n/cache-query-aggregator/internal/util"
 )
 
@@ -31,14 +33,25 @@ func main() {
 		log.Fatalf("Error loading config: %v", err)
 	}
 
-	log.Println("Connecting to database...")
-	dbpool, err := initDB(cfg.DbURL)
-	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+	log.Println("Connecting to database shards...")
+	var shards []sqlc.Querier
+	for i, shardCfg := range cfg.Shards() {
+		dbpool, err := initDB(shardCfg.PrimaryURL)
+		if err != nil {
+			log.Fatalf("Error connecting to database shard %d: %v", i, err)
+		}
+		defer dbpool.Close()
+		shards = append(shards, sqlc.New(dbpool))
 	}
-	defer dbpool.Close()
 
-	queries := sqlc.New(dbpool)
+	ids, err := snowflake.NewGenerator(cfg.SnowflakeNodeID)
+	if err != nil {
+		log.Fatalf("Error creating ID generator: %v", err)
+	}
+	queries, err := shard.New(shards, nil, ids)
+	if err != nil {
+		log.Fatalf("Error creating shard router: %v", err)
+	}
 
 	log.Printf("Creating %d users...", totalUsers)
 	users, err := createUsers(ctx, queries, totalUsers)
@@ -48,13 +61,13 @@ func main() {
 	log.Printf("Successfully created %d users", len(users))
 
 	log.Printf("Creating %d posts with skewed user distribution range", totalPosts)
-	if err := createPostsInBatches(ctx, dbpool, users, totalPosts); err != nil {
+	if err := createPostsInBatches(ctx, queries, users, totalPosts); err != nil {
 		log.Fatalf("Error creating posts: %v", err)
 	}
 	log.Printf("Successfully created %d posts", totalPosts)
 }
 
-func createPostsInBatches(ctx context.Context, dbpool *pgxpool.Pool, users []sqlc.User, count int) error {
+func createPostsInBatches(ctx context.Context, q sqlc.Querier, users []sqlc.User, count int) error {
 	zipf := rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), skewFactor, skewDistributionRange, uint64(len(users)-1))
 	userIds := make([]int64, len(users))
 
@@ -62,7 +75,6 @@ func createPostsInBatches(ctx context.Context, dbpool *pgxpool.Pool, users []sql
 		userIds[i] = u.ID
 	}
 
-	q := sqlc.New(dbpool)
 	for i := 0; i < count; i += postsPerBatch {
 		end := i + postsPerBatch
 		if end > count {
@@ -93,7 +105,7 @@ func createPostsInBatches(ctx context.Context, dbpool *pgxpool.Pool, users []sql
 	return nil
 }
 
-func createUsers(ctx context.Context, queries *sqlc.Queries, count int) ([]sqlc.User, error) {
+func createUsers(ctx context.Context, queries sqlc.Querier, count int) ([]sqlc.User, error) {
 	users := make([]sqlc.User, 0, count)
 	for i := 0; i < count; i++ {
 		hashedPassword, err := util.HashPassword("password123")
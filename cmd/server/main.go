@@ -18,10 +18,17 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/n1207n/cache-query-aggregator/config"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
 	"github.com/n1207n/cache-query-aggregator/internal/handler"
+	"github.com/n1207n/cache-query-aggregator/internal/live"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
 	"github.com/n1207n/cache-query-aggregator/internal/repository"
 	approuter "github.com/n1207n/cache-query-aggregator/internal/router"
 	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+	"github.com/n1207n/cache-query-aggregator/internal/worker"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -34,75 +41,331 @@ func main() {
 
 	log.Printf("Configuration loaded successfully. App Env: %s, Server: %d", cfg.AppEnv, cfg.AppPort)
 
-	dbPool, err := initDB(cfg.DbURL)
+	shards, err := initShards(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer dbPool.Close()
-	log.Println("Database connection pool established.")
+	defer shards.Close()
+	log.Printf("Database connection pools established for %d shards.", len(shards.primaries))
 
 	redisAddrs := strings.Split(cfg.RedisURL, ",")
 	if len(redisAddrs) == 0 || redisAddrs[0] == "" {
 		log.Fatalf("Failed to initialize Redis: %v", fmt.Errorf("redis address is not configured"))
 	}
 
+	breakers := breaker.NewGroup(breaker.Settings{
+		FailureThreshold: cfg.RedisBreakerFailureThreshold,
+		OpenTimeout:      cfg.RedisBreakerOpenTimeout,
+		HalfOpenProbes:   cfg.RedisBreakerHalfOpenProbes,
+	})
+
 	var rdb redis.Cmdable
 	var rdbCloser io.Closer
+	var rdbSubscriber live.Subscriber
 
 	if len(redisAddrs) == 1 {
-		singleNodeClient, err := initSingleRedis(redisAddrs[0])
+		singleNodeClient, err := initSingleRedis(redisAddrs[0], breakers)
 		if err != nil {
 			log.Fatalf("Failed to initialize Single Redis: %v", err)
 		}
 		rdb = singleNodeClient
 		rdbCloser = singleNodeClient
+		rdbSubscriber = singleNodeClient
 		log.Println("Redis Single client initialized.")
 	} else {
-		clusterClient, err := initClusterRedis(redisAddrs)
+		clusterClient, err := initClusterRedis(redisAddrs, breakers)
 		if err != nil {
 			log.Fatalf("Failed to initialize Cluster Redis: %v", err)
 		}
 		rdb = clusterClient
 		rdbCloser = clusterClient
+		rdbSubscriber = clusterClient
 		log.Println("Redis Cluster client initialized.")
 	}
 	defer func(rdbCloser io.Closer) {
 		_ = rdbCloser.Close()
 	}(rdbCloser)
 
-	sqlcQuerier := sqlc.New(dbPool)
+	partitions := worker.NewPartitionMaintainer(shards.Primaries(), cfg.PostsPartitionInterval, cfg.PostsPartitionMonthsAhead)
+	partitions.Start(context.Background())
+	defer partitions.Stop()
+	log.Println("Posts partition maintenance started.")
+
+	ids, err := snowflake.NewGenerator(cfg.SnowflakeNodeID)
+	if err != nil {
+		log.Fatalf("Failed to initialize ID generator: %v", err)
+	}
+	sqlcQuerier, err := shard.New(shards.queriers, shards.Beginners(), ids)
+	if err != nil {
+		log.Fatalf("Failed to initialize shard router: %v", err)
+	}
 	log.Println("SQLC Querier initialized.")
 
 	userRepo := repository.NewDBUserRepository(sqlcQuerier)
 	log.Println("User repository initialized.")
 	dbPostRepo := repository.NewDBPostRepository(sqlcQuerier)
 	log.Println("Post repository (DB) initialized.")
-	postRepo := repository.NewCachedPostRepository(dbPostRepo, rdb)
+	agg := aggregator.New(rdb, aggregator.Options{
+		ShardTimeout:    cfg.AggregatorShardTimeout,
+		MaxConcurrency:  cfg.AggregatorMaxConcurrency,
+		HedgePercentile: cfg.AggregatorHedgePercentile,
+		HedgeMinDelay:   cfg.AggregatorHedgeMinDelay,
+		Breakers:        breakers,
+
+		TopologyRefreshInterval: cfg.AggregatorTopologyRefreshInterval,
+	})
+	defer func(agg *aggregator.Aggregator) {
+		_ = agg.Close()
+	}(agg)
+	log.Println("Query aggregator initialized.")
+	missedWrites := repository.NewMissedWrites(repository.NewPostCacheSyncer(rdb), agg)
+	// Writes made while a node was unavailable left its timelines stale, they are dropped once it is back
+	breakers.OnClose(func(node string) {
+		if err := missedWrites.Replay(context.Background()); err != nil {
+			log.Printf("failed to replay missed writes after node %s closed: %v", breaker.Label(node), err)
+		}
+	})
+	postOpts := repository.CachedPostOptions{
+		Degraded: repository.NewDegradedLimiter(cfg.DBDegradedMaxConcurrency, cfg.DBDegradedMaxWait),
+		DBHealth: repository.NewDBHealth(breaker.Settings{
+			FailureThreshold: cfg.DBBreakerFailureThreshold,
+			OpenTimeout:      cfg.DBBreakerOpenTimeout,
+		}),
+		StaleTTL:     cfg.StaleCacheTTL,
+		MissedWrites: missedWrites,
+	}
+	postRepo := repository.NewCachedPostRepository(dbPostRepo, rdb, agg, postOpts)
 	log.Println("Post repository (Cache) initialized.")
+	reactionCounter := repository.NewReactionCounter(rdb, agg, sqlcQuerier, cfg.ReactionDirtyShards)
+	reactionRepo := repository.NewCachedReactionRepository(repository.NewDBReactionRepository(sqlcQuerier), rdb, reactionCounter)
+	log.Println("Reaction repository (Cache) initialized.")
+	replyRepo := repository.NewCachedReplyRepository(repository.NewDBReplyRepository(sqlcQuerier), rdb)
+	log.Println("Reply repository (Cache) initialized.")
+	viewRepo := repository.NewViewRepository(rdb, agg, repository.ViewOptions{
+		HourlyRetention: cfg.ViewHourlyRetention,
+		DailyRetention:  cfg.ViewDailyRetention,
+		DirtyShards:     cfg.ViewDirtyShards,
+	})
+	log.Println("View repository initialized.")
+	trendingRepo := repository.NewTrendingRepository(rdb, agg, repository.TrendingOptions{
+		Shards:     cfg.TrendingShards,
+		BucketSize: cfg.TrendingBucketSize,
+		CacheTTL:   cfg.TrendingCacheTTL,
+	})
+	log.Println("Trending repository initialized.")
+	txManager := repository.NewTxManager(func(ctx context.Context) (repository.Transaction, error) {
+		tx, err := sqlcQuerier.BeginTx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}, func(q sqlc.Querier) repository.TxRepositories {
+		return repository.TxRepositories{
+			Users:     repository.NewDBUserRepository(q),
+			Posts:     repository.NewCachedPostRepository(repository.NewDBPostRepository(q), rdb, agg, postOpts),
+			Outbox:    repository.NewDBOutboxRepository(q),
+			Reactions: repository.NewCachedReactionRepository(repository.NewDBReactionRepository(q), rdb, reactionCounter),
+			Replies:   repository.NewCachedReplyRepository(repository.NewDBReplyRepository(q), rdb),
+		}
+	})
+	log.Println("Transaction manager initialized.")
+	followRepo := repository.NewCachedFollowRepository(repository.NewDBFollowRepository(sqlcQuerier), rdb)
+	log.Println("Follow repository (Cache) initialized.")
+	feedRepo := repository.NewCachedFeedRepository(postRepo, followRepo, rdb, agg, cfg.FeedFanoutThreshold)
+	log.Println("Feed repository (Cache) initialized.")
+
+	if cfg.CacheInvalidationEnabled {
+		invalidator := worker.NewCacheInvalidator(shards.primaries, repository.NewPostCacheSyncer(rdb), worker.InvalidationOptions{
+			BatchSize:    cfg.CacheInvalidationBatchSize,
+			PollInterval: cfg.CacheInvalidationPollInterval,
+		})
+		invalidator.Start(context.Background())
+		defer invalidator.Stop()
+		log.Println("Cache invalidation worker started.")
+	}
+	if cfg.OutboxRelayEnabled {
+		relay := worker.NewOutboxRelay(shards.primaries, rdb, worker.OutboxOptions{
+			Stream:       repository.PostEventsStream,
+			StreamMaxLen: int64(cfg.OutboxStreamMaxLen),
+			BatchSize:    cfg.OutboxBatchSize,
+			PollInterval: cfg.OutboxPollInterval,
+			MaxAttempts:  cfg.OutboxMaxAttempts,
+			BaseBackoff:  cfg.OutboxBaseBackoff,
+			Retention:    cfg.OutboxRetention,
+		})
+		relay.Start(context.Background())
+		defer relay.Stop()
+		log.Println("Outbox relay started.")
+	}
+	if cfg.PostCountersEnabled {
+		postCounters := worker.NewStreamConsumer(rdb, worker.NewPostCounter(repository.NewPostCountRepository(rdb, sqlcQuerier)), consumerOptions(cfg, worker.PostCountersGroup))
+		postCounters.Start(context.Background())
+		defer postCounters.Stop()
+		log.Println("Post counters consumer started.")
+	}
+	if cfg.FeedFanoutEnabled {
+		feedFanout := worker.NewStreamConsumer(rdb, worker.NewFeedFanout(feedRepo), consumerOptions(cfg, worker.FeedFanoutGroup))
+		feedFanout.Start(context.Background())
+		defer feedFanout.Stop()
+		log.Println("Feed fan-out consumer started.")
+	}
+	if cfg.WebhooksEnabled {
+		webhookDispatcher := worker.NewStreamConsumer(rdb, worker.NewWebhookDispatcher(repository.NewDBWebhookRepository(sqlcQuerier)), consumerOptions(cfg, worker.WebhooksGroup))
+		webhookDispatcher.Start(context.Background())
+		defer webhookDispatcher.Stop()
+		webhookDeliverer := worker.NewWebhookDeliverer(shards.primaries[shard.CatalogShard], worker.WebhookOptions{
+			BatchSize:           cfg.WebhookBatchSize,
+			PollInterval:        cfg.WebhookPollInterval,
+			Timeout:             cfg.WebhookTimeout,
+			MaxAttempts:         cfg.WebhookMaxAttempts,
+			BaseBackoff:         cfg.WebhookBaseBackoff,
+			DisableAfter:        cfg.WebhookDisableAfter,
+			Retention:           cfg.WebhookDeliveryRetention,
+			AllowPrivateTargets: cfg.WebhookAllowPrivateTargets,
+		})
+		webhookDeliverer.Start(context.Background())
+		defer webhookDeliverer.Stop()
+		log.Println("Webhook delivery started.")
+	}
+	if cfg.ReactionFlushEnabled {
+		reactionFlusher := worker.NewReactionFlusher(reactionCounter, cfg.ReactionFlushInterval, cfg.ReactionFlushBatchSize)
+		reactionFlusher.Start(context.Background())
+		defer reactionFlusher.Stop()
+		log.Println("Reaction count flusher started.")
+	}
+	if cfg.ViewRollupEnabled {
+		viewRollup := worker.NewViewRollup(viewRepo, cfg.ViewRollupInterval, cfg.ViewRollupBatchSize)
+		viewRollup.Start(context.Background())
+		defer viewRollup.Stop()
+		log.Println("Unique view rollup started.")
+	}
+
+	if cfg.TrendingRefreshEnabled {
+		trendingRefresher := worker.NewTrendingRefresher(trendingRepo, cfg.TrendingRefreshInterval)
+		trendingRefresher.Start(context.Background())
+		defer trendingRefresher.Stop()
+		log.Println("Trending refresher started.")
+	}
+	var liveTimelines *live.Hub
+	if cfg.TimelineStreamEnabled {
+		timelinePublisher := worker.NewStreamConsumer(rdb, worker.NewTimelinePublisher(rdb), consumerOptions(cfg, worker.LiveTimelinesGroup))
+		timelinePublisher.Start(context.Background())
+		defer timelinePublisher.Stop()
+		liveTimelines = live.NewHub(rdbSubscriber, live.HubOptions{Buffer: cfg.TimelineStreamBuffer})
+		liveTimelines.Start(context.Background())
+		log.Println("Live timelines started.")
+	}
 
 	// Initialize Services
-	userService := service.NewUserService(userRepo) // Example
+	userService := service.NewUserService(userRepo, txManager)
 	log.Println("User service initialized.")
-	postService := service.NewPostService(postRepo)
+	postService := service.NewPostService(postRepo, txManager)
 	log.Println("Post service initialized.")
+	followService := service.NewFollowService(followRepo)
+	log.Println("Follow service initialized.")
+	feedService := service.NewFeedService(feedRepo)
+	log.Println("Feed service initialized.")
+	webhookService := service.NewWebhookService(repository.NewDBWebhookRepository(sqlcQuerier), cfg.WebhookAllowPrivateTargets)
+	log.Println("Webhook service initialized.")
+	reactionService := service.NewReactionService(postRepo, reactionRepo, trendingRepo, txManager)
+	log.Println("Reaction service initialized.")
+	viewService := service.NewViewService(viewRepo, trendingRepo)
+	log.Println("View service initialized.")
+	trendingService := service.NewTrendingService(postRepo, trendingRepo)
+	log.Println("Trending service initialized.")
+	replyService := service.NewReplyService(postRepo, replyRepo, txManager)
+	log.Println("Reply service initialized.")
 
 	// Initialize Gin router
 	if cfg.AppEnv == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxyList()); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	gateways, err := middleware.ParseNetworks(cfg.TrustedGatewayList())
+	if err != nil {
+		log.Fatalf("Failed to parse trusted gateways: %v", err)
+	}
+	// Admission control, rate limits and handlers all read the identity of the request
+	router.Use(middleware.Identity(gateways))
 
 	// Initialize Handlers
 	userHandler := handler.NewUserHandler(userService)
 	log.Println("User handler initialized.")
-	postHandler := handler.NewPostHandler(postService)
+	postHandler := handler.NewPostHandler(postService, reactionService, viewService)
 	log.Println("Post handler initialized.")
+	followHandler := handler.NewFollowHandler(followService)
+	log.Println("Follow handler initialized.")
+	feedHandler := handler.NewFeedHandler(feedService)
+	log.Println("Feed handler initialized.")
+	webhookHandler := handler.NewWebhookHandler(webhookService)
+	log.Println("Webhook handler initialized.")
+	reactionHandler := handler.NewReactionHandler(reactionService)
+	log.Println("Reaction handler initialized.")
+	analyticsHandler := handler.NewAnalyticsHandler(viewService)
+	log.Println("Analytics handler initialized.")
+	trendingHandler := handler.NewTrendingHandler(trendingService)
+	log.Println("Trending handler initialized.")
+	replyHandler := handler.NewReplyHandler(replyService)
+	log.Println("Reply handler initialized.")
+	healthHandler := handler.NewHealthHandler(shards, breakers)
 
 	// Setup routes
 	v1 := router.Group("/api/v1")
+	if cfg.AdmissionEnabled {
+		// Shed load before any other work is spent on the request
+		v1.Use(middleware.NewAdmissionController(middleware.AdmissionOptions{
+			InitialLimit:  cfg.AdmissionInitialLimit,
+			MinLimit:      cfg.AdmissionMinLimit,
+			MaxLimit:      cfg.AdmissionMaxLimit,
+			LatencyTarget: cfg.AdmissionLatencyTarget,
+			Backoff:       cfg.AdmissionBackoff,
+			RetryAfter:    cfg.AdmissionRetryAfter,
+		}).Handler())
+		log.Println("Admission control initialized.")
+	}
+	var rateLimiter *middleware.RateLimiter
+	if cfg.RateLimitEnabled {
+		if rateLimiter, err = initRateLimiter(cfg, rdb); err != nil {
+			log.Fatalf("Failed to initialize rate limiter: %v", err)
+		}
+		v1.Use(rateLimiter.Handler())
+		log.Println("Rate limiter initialized.")
+	}
+	if shards.Replicated() {
+		v1.Use(middleware.ReadYourWrites())
+	}
+	v1.Use(middleware.NewIdempotency(rdb, cfg.IdempotencyTTL).Handler())
 	{
 		approuter.SetupUserRoutes(v1, userHandler)
 		approuter.SetupPostRoutes(v1, postHandler)
+		approuter.SetupTrendingRoutes(v1, trendingHandler)
+		approuter.SetupFollowRoutes(v1, followHandler)
+		approuter.SetupFeedRoutes(v1, feedHandler)
+		approuter.SetupWebhookRoutes(v1, webhookHandler)
+		approuter.SetupReactionRoutes(v1, reactionHandler)
+		approuter.SetupReplyRoutes(v1, replyHandler)
+		approuter.SetupAnalyticsRoutes(v1, analyticsHandler)
+	}
+
+	if liveTimelines != nil {
+		// Streams are long-lived: admission control and idempotency, made for short requests, are left out
+		// and the streams are bounded by their own connection limit
+		streams := router.Group("/api/v1")
+		if rateLimiter != nil {
+			streams.Use(rateLimiter.Handler())
+		}
+		streamHandler := handler.NewTimelineStreamHandler(postService, liveTimelines, handler.TimelineStreamOptions{
+			Heartbeat:      cfg.TimelineStreamHeartbeat,
+			ResumeLimit:    cfg.TimelineStreamResumeLimit,
+			WriteTimeout:   cfg.TimelineStreamWriteTimeout,
+			MaxConnections: cfg.TimelineStreamMaxConnections,
+			RetryAfter:     cfg.AdmissionRetryAfter,
+		})
+		approuter.SetupTimelineStreamRoutes(streams, streamHandler)
+		log.Println("Timeline stream handler initialized.")
 	}
 
 	// Ping route for health check
@@ -110,6 +373,9 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"message": "pong"})
 	})
 
+	// Readiness route, reporting the Redis circuits
+	router.GET("/ready", healthHandler.Ready)
+
 	// Metrics route
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
@@ -117,6 +383,10 @@ func main() {
 		Addr:    fmt.Sprintf(":%d", cfg.AppPort),
 		Handler: router,
 	}
+	if liveTimelines != nil {
+		// Ending the streams lets Shutdown complete
+		srv.RegisterOnShutdown(liveTimelines.Stop)
+	}
 
 	go func() {
 		log.Printf("Server listening on %d", cfg.AppPort)
@@ -168,7 +438,35 @@ func initDB(databaseURL string) (*pgxpool.Pool, error) {
 	return dbPool, nil
 }
 
-func initSingleRedis(redisURL string) (*redis.Client, error) {
+func initRateLimiter(cfg *config.Config, rdb redis.Cmdable) (*middleware.RateLimiter, error) {
+	var rules []middleware.RateLimitRule
+
+	if cfg.RateLimitPerIP != "" {
+		rate, err := middleware.ParseRate(cfg.RateLimitPerIP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid per IP rate limit: %w", err)
+		}
+		rules = append(rules, middleware.PerIPRule(rate))
+	}
+
+	if cfg.RateLimitPerUser != "" {
+		rate, err := middleware.ParseRate(cfg.RateLimitPerUser)
+		if err != nil {
+			return nil, fmt.Errorf("invalid per user rate limit: %w", err)
+		}
+		rules = append(rules, middleware.PerUserRule(rate))
+	}
+
+	routeRules, err := middleware.ParseRouteRules(cfg.RateLimitRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route rate limits: %w", err)
+	}
+	rules = append(rules, routeRules...)
+
+	return middleware.NewRateLimiter(rdb, rules...), nil
+}
+
+func initSingleRedis(redisURL string, breakers *breaker.Group) (*redis.Client, error) {
 	var rdb *redis.Client
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
@@ -176,6 +474,7 @@ func initSingleRedis(redisURL string) (*redis.Client, error) {
 	}
 
 	rdb = redis.NewClient(opt)
+	rdb.AddHook(breakers.Hook(aggregator.StandaloneNode))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -187,10 +486,16 @@ func initSingleRedis(redisURL string) (*redis.Client, error) {
 	return rdb, nil
 }
 
-func initClusterRedis(redisAddrs []string) (*redis.ClusterClient, error) {
+func initClusterRedis(redisAddrs []string, breakers *breaker.Group) (*redis.ClusterClient, error) {
 	var rdb *redis.ClusterClient
 	rdb = redis.NewClusterClient(&redis.ClusterOptions{
 		Addrs: redisAddrs,
+		// Every node gets its own circuit
+		NewClient: func(opt *redis.Options) *redis.Client {
+			node := redis.NewClient(opt)
+			node.AddHook(breakers.Hook(opt.Addr))
+			return node
+		},
 	})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
@@ -202,3 +507,23 @@ func initClusterRedis(redisAddrs []string) (*redis.ClusterClient, error) {
 
 	return rdb, nil
 }
+
+// consumerOptions are the options of the consumer of this instance in a group of the post events stream
+func consumerOptions(cfg *config.Config, group string) worker.ConsumerOptions {
+	name := cfg.StreamConsumerName
+	if name == "" {
+		var err error
+		if name, err = os.Hostname(); err != nil {
+			log.Fatalf("Failed to name the stream consumer: %v", err)
+		}
+	}
+	return worker.ConsumerOptions{
+		Stream:        repository.PostEventsStream,
+		Group:         group,
+		Consumer:      name,
+		BatchSize:     int64(cfg.StreamConsumerBatchSize),
+		Block:         cfg.StreamConsumerBlock,
+		ClaimMinIdle:  cfg.StreamConsumerClaimMinIdle,
+		MaxDeliveries: int64(cfg.StreamConsumerMaxDeliveries),
+	}
+}
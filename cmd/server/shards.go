@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n1207n/cache-query-aggregator/config"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/dbrouter"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+)
+
+// dbShards holds the connections to every database shard
+type dbShards struct {
+	// queriers are in shard map order, routing reads to replicas for shards having some
+	queriers  []sqlc.Querier
+	primaries []*pgxpool.Pool
+	replicas  []*pgxpool.Pool
+	routers   []*dbrouter.Router
+	// beginners are in shard map order, beginning transactions through the router of the shards having one
+	beginners []shard.Beginner
+}
+
+// initShards connects to the primary and the read replicas of every shard of the shard map
+func initShards(cfg *config.Config) (*dbShards, error) {
+	s := &dbShards{}
+	for i, shardCfg := range cfg.Shards() {
+		primary, err := initDB(shardCfg.PrimaryURL)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		s.primaries = append(s.primaries, primary)
+
+		if len(shardCfg.ReplicaURLs) == 0 {
+			s.queriers = append(s.queriers, sqlc.New(primary))
+			s.beginners = append(s.beginners, primary)
+			continue
+		}
+
+		replicas := make([]dbrouter.Replica, 0, len(shardCfg.ReplicaURLs))
+		for _, url := range shardCfg.ReplicaURLs {
+			pool, err := initDB(url)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("shard %d replica: %w", i, err)
+			}
+			s.replicas = append(s.replicas, pool)
+			connCfg := pool.Config().ConnConfig
+			replicas = append(replicas, dbrouter.Replica{Name: fmt.Sprintf("%s:%d", connCfg.Host, connCfg.Port), DB: pool})
+		}
+
+		router := dbrouter.New(primary, replicas, dbrouter.Options{
+			MaxLag:               cfg.DBReplicaMaxLag,
+			LagCheckInterval:     cfg.DBReplicaLagCheckInterval,
+			ReadYourWritesWindow: cfg.ReadYourWritesWindow,
+		})
+		router.Start(context.Background())
+		s.routers = append(s.routers, router)
+		s.queriers = append(s.queriers, router)
+		// Transactions run through the router, so that their writes open the read-your-writes window
+		s.beginners = append(s.beginners, router)
+	}
+	return s, nil
+}
+
+// Replicated reports whether some shard has read replicas
+func (s *dbShards) Replicated() bool {
+	return len(s.routers) > 0
+}
+
+// Primaries returns the primary of every shard
+func (s *dbShards) Primaries() []sqlc.DBTX {
+	dbs := make([]sqlc.DBTX, len(s.primaries))
+	for i, primary := range s.primaries {
+		dbs[i] = primary
+	}
+	return dbs
+}
+
+// Beginners returns what begins the transactions of every shard on its primary
+func (s *dbShards) Beginners() []shard.Beginner {
+	return s.beginners
+}
+
+// Ping checks every shard primary is reachable
+func (s *dbShards) Ping(ctx context.Context) error {
+	for i, primary := range s.primaries {
+		if err := primary.Ping(ctx); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the replica lag checks and closes every connection pool
+func (s *dbShards) Close() {
+	for _, router := range s.routers {
+		router.Stop()
+	}
+	for _, pool := range s.replicas {
+		pool.Close()
+	}
+	for _, pool := range s.primaries {
+		pool.Close()
+	}
+}
@@ -3,28 +3,316 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	AppEnv    string
-	AppPort   int
-	DbURL     string
-	RedisURL  string
-	SecretKey string
+	AppEnv  string
+	AppPort int
+	DbURL   string
+	// DbReplicaURLs is a "," separated list of read replica URLs, empty sends every read to DbURL
+	DbReplicaURLs string
+	// DbShardURLs is the shard map, a "," separated list of shards written as
+	// "<primary url>[|<replica url>...]". Empty makes DbURL and DbReplicaURLs the only shard.
+	DbShardURLs string
+	// SnowflakeNodeID must be unique to every process creating users or posts
+	SnowflakeNodeID int64
+	RedisURL        string
+	SecretKey       string
+
+	// TrustedProxies is a "," separated list of the IPs and CIDRs of the proxies whose X-Forwarded-For
+	// header gives the client IP. Empty takes the client IP from the connection.
+	TrustedProxies string
+	// TrustedGateways is a "," separated list of the IPs and CIDRs of the gateways authenticating users
+	// with the X-User-ID header. The header is ignored on other connections.
+	TrustedGateways string
+
+	// Rate limits are expressed as "<limit>/<period>", e.g. "100/1m". An empty value disables the rule.
+	RateLimitEnabled bool
+	RateLimitPerIP   string
+	RateLimitPerUser string
+	// RateLimitRoutes is a ";" separated list of "<METHOD> <route>=<limit>/<period>" entries.
+	RateLimitRoutes string
+
+	// IdempotencyTTL is how long a stored response is replayed for a given Idempotency-Key
+	IdempotencyTTL time.Duration
+
+	// FeedFanoutThreshold is the follower count from which an author's posts are merged into feeds
+	// at read time instead of being pushed to every follower's feed
+	FeedFanoutThreshold int64
+	// FeedFanoutEnabled runs the feed-fanout consumer, which pushes new posts into the followers' feeds
+	FeedFanoutEnabled bool
+
+	// AggregatorShardTimeout bounds every per-node query of a scatter-gather read
+	AggregatorShardTimeout time.Duration
+	// AggregatorMaxConcurrency bounds the nodes queried at once by a single scatter-gather read
+	AggregatorMaxConcurrency int
+	// AggregatorHedgePercentile is the percentile of a node's recent latency after which a read is
+	// duplicated on a replica or the DB, zero disables hedging
+	AggregatorHedgePercentile float64
+	AggregatorHedgeMinDelay   time.Duration
+	// AggregatorTopologyRefreshInterval is the interval between reloads of the cluster slot map
+	AggregatorTopologyRefreshInterval time.Duration
+
+	// RedisBreakerFailureThreshold is the number of consecutive failures that opens a node's circuit
+	RedisBreakerFailureThreshold int
+	// RedisBreakerOpenTimeout is how long an open circuit skips its node before probing it again
+	RedisBreakerOpenTimeout    time.Duration
+	RedisBreakerHalfOpenProbes int
+	// DBDegradedMaxConcurrency bounds the DB reads made in place of cache nodes with an open circuit
+	DBDegradedMaxConcurrency int
+	DBDegradedMaxWait        time.Duration
+
+	// StaleCacheTTL is how long last known good copies are kept to be served while DB is unavailable
+	StaleCacheTTL time.Duration
+	// DBBreakerFailureThreshold is the number of consecutive connection errors or timeouts that stop DB reads
+	DBBreakerFailureThreshold int
+	DBBreakerOpenTimeout      time.Duration
+
+	// AdmissionEnabled turns on the adaptive concurrency limit of the API
+	AdmissionEnabled      bool
+	AdmissionInitialLimit int
+	AdmissionMinLimit     int
+	AdmissionMaxLimit     int
+	// AdmissionLatencyTarget is the request latency above which the concurrency limit backs off
+	AdmissionLatencyTarget time.Duration
+	AdmissionBackoff       float64
+	AdmissionRetryAfter    time.Duration
+
+	// DBReplicaMaxLag is the replication lag beyond which a replica stops serving reads
+	DBReplicaMaxLag           time.Duration
+	DBReplicaLagCheckInterval time.Duration
+	// ReadYourWritesWindow is how long a user's reads go to the primary after their write
+	ReadYourWritesWindow time.Duration
+
+	// PostsPartitionInterval is how often the monthly posts partitions are created ahead of time
+	PostsPartitionInterval    time.Duration
+	PostsPartitionMonthsAhead int
+
+	// CacheInvalidationEnabled applies the DB changes logged by triggers to the cache
+	CacheInvalidationEnabled      bool
+	CacheInvalidationBatchSize    int
+	CacheInvalidationPollInterval time.Duration
+
+	// OutboxRelayEnabled publishes the events of the outbox to Redis Streams
+	OutboxRelayEnabled bool
+	OutboxBatchSize    int
+	OutboxPollInterval time.Duration
+	OutboxMaxAttempts  int
+	OutboxBaseBackoff  time.Duration
+	OutboxStreamMaxLen int
+	OutboxRetention    time.Duration
+
+	// StreamConsumerName names this instance in the consumer groups of the event streams, the hostname if empty
+	StreamConsumerName          string
+	StreamConsumerBatchSize     int
+	StreamConsumerBlock         time.Duration
+	StreamConsumerClaimMinIdle  time.Duration
+	StreamConsumerMaxDeliveries int
+	// PostCountersEnabled keeps per-user post counters from the post events
+	PostCountersEnabled bool
+
+	// TimelineStreamEnabled pushes new posts to clients over SSE and WebSockets
+	TimelineStreamEnabled        bool
+	TimelineStreamHeartbeat      time.Duration
+	TimelineStreamResumeLimit    int
+	TimelineStreamBuffer         int
+	TimelineStreamWriteTimeout   time.Duration
+	TimelineStreamMaxConnections int
+
+	// WebhooksEnabled delivers post events to the webhooks subscribed to them
+	WebhooksEnabled          bool
+	WebhookBatchSize         int
+	WebhookPollInterval      time.Duration
+	WebhookTimeout           time.Duration
+	WebhookMaxAttempts       int
+	WebhookBaseBackoff       time.Duration
+	WebhookDisableAfter      int
+	WebhookDeliveryRetention time.Duration
+	// WebhookAllowPrivateTargets lets webhooks point at loopback, link-local and private addresses
+	WebhookAllowPrivateTargets bool
+
+	// ReactionFlushEnabled flushes the reaction counts kept in Redis to DB
+	ReactionFlushEnabled   bool
+	ReactionFlushInterval  time.Duration
+	ReactionFlushBatchSize int
+	ReactionDirtyShards    int
+
+	// ViewRollupEnabled rolls the unique viewers of posts and timelines up by hour and day
+	ViewRollupEnabled   bool
+	ViewRollupInterval  time.Duration
+	ViewRollupBatchSize int
+	ViewHourlyRetention time.Duration
+	ViewDailyRetention  time.Duration
+	ViewDirtyShards     int
+
+	// TrendingRefreshEnabled rebuilds the time-decayed trending windows from the engagement buckets
+	TrendingRefreshEnabled  bool
+	TrendingRefreshInterval time.Duration
+	TrendingShards          int
+	TrendingBucketSize      time.Duration
+	TrendingCacheTTL        time.Duration
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() (*Config, error) {
 	return &Config{
-		AppEnv:    getEnv("APP_ENV", "development"),
-		AppPort:   getEnvAsInt("APP_PORT", 8080),
-		DbURL:     getEnv("POSTGRES_URL", "postgres://user:password@db:5432/mydatabase?sslmode=disable"),
-		RedisURL:  getEnv("REDIS_CLUSTER_URLS", "redis-1:7001,redis-2:7002,redis-3:7003,redis-4:7004,redis-5:7005"),
-		SecretKey: getEnv("SECRET_KEY", "supersecret"),
+		AppEnv:          getEnv("APP_ENV", "development"),
+		AppPort:         getEnvAsInt("APP_PORT", 8080),
+		DbURL:           getEnv("POSTGRES_URL", "postgres://user:password@db:5432/mydatabase?sslmode=disable"),
+		DbReplicaURLs:   getEnv("POSTGRES_REPLICA_URLS", ""),
+		DbShardURLs:     getEnv("POSTGRES_SHARD_URLS", ""),
+		SnowflakeNodeID: int64(getEnvAsInt("SNOWFLAKE_NODE_ID", 0)),
+		RedisURL:        getEnv("REDIS_CLUSTER_URLS", "redis-1:7001,redis-2:7002,redis-3:7003,redis-4:7004,redis-5:7005"),
+		SecretKey:       getEnv("SECRET_KEY", "supersecret"),
+
+		TrustedProxies:  getEnv("TRUSTED_PROXIES", ""),
+		TrustedGateways: getEnv("TRUSTED_GATEWAYS", ""),
+
+		RateLimitEnabled: getEnvAsBool("RATE_LIMIT_ENABLED", true),
+		RateLimitPerIP:   getEnv("RATE_LIMIT_PER_IP", "300/1m"),
+		RateLimitPerUser: getEnv("RATE_LIMIT_PER_USER", "600/1m"),
+		RateLimitRoutes:  getEnv("RATE_LIMIT_ROUTES", "GET /api/v1/users/:id/posts=120/1m"),
+
+		IdempotencyTTL: getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		FeedFanoutThreshold: int64(getEnvAsInt("FEED_FANOUT_THRESHOLD", 10000)),
+		FeedFanoutEnabled:   getEnvAsBool("FEED_FANOUT_ENABLED", true),
+
+		AggregatorShardTimeout:   getEnvAsDuration("AGGREGATOR_SHARD_TIMEOUT", 500*time.Millisecond),
+		AggregatorMaxConcurrency: getEnvAsInt("AGGREGATOR_MAX_CONCURRENCY", 16),
+
+		AggregatorHedgePercentile: getEnvAsFloat("AGGREGATOR_HEDGE_PERCENTILE", 0.95),
+		AggregatorHedgeMinDelay:   getEnvAsDuration("AGGREGATOR_HEDGE_MIN_DELAY", 2*time.Millisecond),
+
+		AggregatorTopologyRefreshInterval: getEnvAsDuration("AGGREGATOR_TOPOLOGY_REFRESH_INTERVAL", 30*time.Second),
+
+		RedisBreakerFailureThreshold: getEnvAsInt("REDIS_BREAKER_FAILURE_THRESHOLD", 5),
+		RedisBreakerOpenTimeout:      getEnvAsDuration("REDIS_BREAKER_OPEN_TIMEOUT", 5*time.Second),
+		RedisBreakerHalfOpenProbes:   getEnvAsInt("REDIS_BREAKER_HALF_OPEN_PROBES", 1),
+		DBDegradedMaxConcurrency:     getEnvAsInt("DB_DEGRADED_MAX_CONCURRENCY", 8),
+		DBDegradedMaxWait:            getEnvAsDuration("DB_DEGRADED_MAX_WAIT", 500*time.Millisecond),
+
+		StaleCacheTTL:             getEnvAsDuration("STALE_CACHE_TTL", 24*time.Hour),
+		DBBreakerFailureThreshold: getEnvAsInt("DB_BREAKER_FAILURE_THRESHOLD", 5),
+		DBBreakerOpenTimeout:      getEnvAsDuration("DB_BREAKER_OPEN_TIMEOUT", 5*time.Second),
+
+		AdmissionEnabled:       getEnvAsBool("ADMISSION_ENABLED", true),
+		AdmissionInitialLimit:  getEnvAsInt("ADMISSION_INITIAL_LIMIT", 100),
+		AdmissionMinLimit:      getEnvAsInt("ADMISSION_MIN_LIMIT", 10),
+		AdmissionMaxLimit:      getEnvAsInt("ADMISSION_MAX_LIMIT", 1000),
+		AdmissionLatencyTarget: getEnvAsDuration("ADMISSION_LATENCY_TARGET", 250*time.Millisecond),
+		AdmissionBackoff:       getEnvAsFloat("ADMISSION_BACKOFF", 0.9),
+		AdmissionRetryAfter:    getEnvAsDuration("ADMISSION_RETRY_AFTER", time.Second),
+
+		DBReplicaMaxLag:           getEnvAsDuration("DB_REPLICA_MAX_LAG", time.Second),
+		DBReplicaLagCheckInterval: getEnvAsDuration("DB_REPLICA_LAG_CHECK_INTERVAL", time.Second),
+		ReadYourWritesWindow:      getEnvAsDuration("READ_YOUR_WRITES_WINDOW", 5*time.Second),
+
+		PostsPartitionInterval:    getEnvAsDuration("POSTS_PARTITION_INTERVAL", time.Hour),
+		PostsPartitionMonthsAhead: getEnvAsInt("POSTS_PARTITION_MONTHS_AHEAD", 3),
+
+		CacheInvalidationEnabled:      getEnvAsBool("CACHE_INVALIDATION_ENABLED", true),
+		CacheInvalidationBatchSize:    getEnvAsInt("CACHE_INVALIDATION_BATCH_SIZE", 500),
+		CacheInvalidationPollInterval: getEnvAsDuration("CACHE_INVALIDATION_POLL_INTERVAL", 5*time.Second),
+
+		OutboxRelayEnabled: getEnvAsBool("OUTBOX_RELAY_ENABLED", true),
+		OutboxBatchSize:    getEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+		OutboxPollInterval: getEnvAsDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond),
+		OutboxMaxAttempts:  getEnvAsInt("OUTBOX_MAX_ATTEMPTS", 10),
+		OutboxBaseBackoff:  getEnvAsDuration("OUTBOX_BASE_BACKOFF", time.Second),
+		OutboxStreamMaxLen: getEnvAsInt("OUTBOX_STREAM_MAX_LEN", 100000),
+		OutboxRetention:    getEnvAsDuration("OUTBOX_RETENTION", 24*time.Hour),
+
+		StreamConsumerName:          getEnv("STREAM_CONSUMER_NAME", ""),
+		StreamConsumerBatchSize:     getEnvAsInt("STREAM_CONSUMER_BATCH_SIZE", 100),
+		StreamConsumerBlock:         getEnvAsDuration("STREAM_CONSUMER_BLOCK", 2*time.Second),
+		StreamConsumerClaimMinIdle:  getEnvAsDuration("STREAM_CONSUMER_CLAIM_MIN_IDLE", time.Minute),
+		StreamConsumerMaxDeliveries: getEnvAsInt("STREAM_CONSUMER_MAX_DELIVERIES", 5),
+		PostCountersEnabled:         getEnvAsBool("POST_COUNTERS_ENABLED", true),
+
+		TimelineStreamEnabled:        getEnvAsBool("TIMELINE_STREAM_ENABLED", true),
+		TimelineStreamHeartbeat:      getEnvAsDuration("TIMELINE_STREAM_HEARTBEAT", 15*time.Second),
+		TimelineStreamResumeLimit:    getEnvAsInt("TIMELINE_STREAM_RESUME_LIMIT", 100),
+		TimelineStreamBuffer:         getEnvAsInt("TIMELINE_STREAM_BUFFER", 64),
+		TimelineStreamWriteTimeout:   getEnvAsDuration("TIMELINE_STREAM_WRITE_TIMEOUT", 10*time.Second),
+		TimelineStreamMaxConnections: getEnvAsInt("TIMELINE_STREAM_MAX_CONNECTIONS", 10000),
+
+		WebhooksEnabled:            getEnvAsBool("WEBHOOKS_ENABLED", true),
+		WebhookBatchSize:           getEnvAsInt("WEBHOOK_BATCH_SIZE", 50),
+		WebhookPollInterval:        getEnvAsDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		WebhookTimeout:             getEnvAsDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:         getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBaseBackoff:         getEnvAsDuration("WEBHOOK_BASE_BACKOFF", 10*time.Second),
+		WebhookDisableAfter:        getEnvAsInt("WEBHOOK_DISABLE_AFTER", 20),
+		WebhookDeliveryRetention:   getEnvAsDuration("WEBHOOK_DELIVERY_RETENTION", 7*24*time.Hour),
+		WebhookAllowPrivateTargets: getEnvAsBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+
+		ReactionFlushEnabled:   getEnvAsBool("REACTION_FLUSH_ENABLED", true),
+		ReactionFlushInterval:  getEnvAsDuration("REACTION_FLUSH_INTERVAL", 5*time.Second),
+		ReactionFlushBatchSize: getEnvAsInt("REACTION_FLUSH_BATCH_SIZE", 500),
+		ReactionDirtyShards:    getEnvAsInt("REACTION_DIRTY_SHARDS", 16),
+
+		ViewRollupEnabled:   getEnvAsBool("VIEW_ROLLUP_ENABLED", true),
+		ViewRollupInterval:  getEnvAsDuration("VIEW_ROLLUP_INTERVAL", time.Minute),
+		ViewRollupBatchSize: getEnvAsInt("VIEW_ROLLUP_BATCH_SIZE", 500),
+		ViewHourlyRetention: getEnvAsDuration("VIEW_HOURLY_RETENTION", 7*24*time.Hour),
+		ViewDailyRetention:  getEnvAsDuration("VIEW_DAILY_RETENTION", 90*24*time.Hour),
+		ViewDirtyShards:     getEnvAsInt("VIEW_DIRTY_SHARDS", 16),
+
+		TrendingRefreshEnabled:  getEnvAsBool("TRENDING_REFRESH_ENABLED", true),
+		TrendingRefreshInterval: getEnvAsDuration("TRENDING_REFRESH_INTERVAL", 30*time.Second),
+		TrendingShards:          getEnvAsInt("TRENDING_SHARDS", 16),
+		TrendingBucketSize:      getEnvAsDuration("TRENDING_BUCKET_SIZE", 5*time.Minute),
+		TrendingCacheTTL:        getEnvAsDuration("TRENDING_CACHE_TTL", 15*time.Second),
 	}, nil
 }
 
+// Shard is a database shard, its primary and read replicas
+type Shard struct {
+	PrimaryURL  string
+	ReplicaURLs []string
+}
+
+// Shards parses the shard map, in shard order
+func (c *Config) Shards() []Shard {
+	if strings.TrimSpace(c.DbShardURLs) == "" {
+		return []Shard{{PrimaryURL: c.DbURL, ReplicaURLs: splitURLs(c.DbReplicaURLs, ",")}}
+	}
+
+	var shards []Shard
+	for _, spec := range splitURLs(c.DbShardURLs, ",") {
+		urls := splitURLs(spec, "|")
+		if len(urls) == 0 {
+			continue
+		}
+		shards = append(shards, Shard{PrimaryURL: urls[0], ReplicaURLs: urls[1:]})
+	}
+	return shards
+}
+
+// TrustedProxyList returns the trusted proxies, nil when there is none
+func (c *Config) TrustedProxyList() []string {
+	return splitURLs(c.TrustedProxies, ",")
+}
+
+// TrustedGatewayList returns the trusted gateways, nil when there is none
+func (c *Config) TrustedGatewayList() []string {
+	return splitURLs(c.TrustedGateways, ",")
+}
+
+func splitURLs(urls, sep string) []string {
+	var out []string
+	for _, url := range strings.Split(urls, sep) {
+		if url = strings.TrimSpace(url); url != "" {
+			out = append(out, url)
+		}
+	}
+	return out
+}
+
 // Helper function to get an environment variable or return a default value
 func getEnv(key string, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -41,3 +329,30 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// Helper function to get an environment variable as float64 or return a default value
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// Helper function to get an environment variable as bool or return a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// Helper function to get an environment variable as time.Duration or return a default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if value, err := time.ParseDuration(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,19 @@
+CREATE TABLE follows (
+    follower_id BIGINT NOT NULL,
+    followee_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CONSTRAINT fk_follower
+        FOREIGN KEY(follower_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_followee
+        FOREIGN KEY(followee_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT chk_no_self_follow
+        CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX idx_follows_follower_created_at ON follows (follower_id, created_at DESC);
+CREATE INDEX idx_follows_followee_created_at ON follows (followee_id, created_at DESC);
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX idx_posts_user_id_created_at ON posts (user_id, created_at DESC, id DESC);
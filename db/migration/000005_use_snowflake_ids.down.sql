@@ -0,0 +1,12 @@
+ALTER TABLE follows ADD CONSTRAINT fk_follower
+    FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE NOT VALID;
+ALTER TABLE follows ADD CONSTRAINT fk_followee
+    FOREIGN KEY(followee_id) REFERENCES users(id) ON DELETE CASCADE NOT VALID;
+
+CREATE SEQUENCE posts_id_seq OWNED BY posts.id;
+SELECT setval('posts_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM posts;
+ALTER TABLE posts ALTER COLUMN id SET DEFAULT nextval('posts_id_seq');
+
+CREATE SEQUENCE users_id_seq OWNED BY users.id;
+SELECT setval('users_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM users;
+ALTER TABLE users ALTER COLUMN id SET DEFAULT nextval('users_id_seq');
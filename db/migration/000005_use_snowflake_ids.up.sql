@@ -0,0 +1,9 @@
+-- IDs are generated by the application so that they stay unique across shards
+ALTER TABLE users ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS users_id_seq;
+ALTER TABLE posts ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS posts_id_seq;
+
+-- A follow is stored on the shards of both users, where the other user may not exist
+ALTER TABLE follows DROP CONSTRAINT fk_follower;
+ALTER TABLE follows DROP CONSTRAINT fk_followee;
@@ -0,0 +1,21 @@
+CREATE TABLE posts_unpartitioned (
+    id BIGINT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+INSERT INTO posts_unpartitioned (id, user_id, content, created_at, updated_at)
+SELECT id, user_id, content, created_at, updated_at FROM posts;
+
+DROP TABLE posts;
+DROP FUNCTION create_posts_partitions(TIMESTAMPTZ, TIMESTAMPTZ);
+
+ALTER TABLE posts_unpartitioned RENAME TO posts;
+ALTER TABLE posts RENAME CONSTRAINT posts_unpartitioned_pkey TO posts_pkey;
+CREATE INDEX idx_posts_user_id_created_at ON posts (user_id, created_at DESC, id DESC);
@@ -0,0 +1,67 @@
+-- Posts are range partitioned by month of created_at. A user's timeline reads the newest partitions
+-- first and every partition keeps its own, smaller, (user_id, created_at, id) index.
+ALTER TABLE posts RENAME TO posts_unpartitioned;
+ALTER TABLE posts_unpartitioned RENAME CONSTRAINT posts_pkey TO posts_unpartitioned_pkey;
+ALTER INDEX idx_posts_user_id_created_at RENAME TO idx_posts_unpartitioned_user_id_created_at;
+
+-- The partition key must be part of the primary key
+CREATE TABLE posts (
+    id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, created_at),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX idx_posts_user_id_created_at ON posts (user_id, created_at DESC, id DESC);
+
+-- Catches posts outside of the created partitions until their month is created
+CREATE TABLE posts_default PARTITION OF posts DEFAULT;
+
+-- create_posts_partitions creates the missing monthly partitions covering [from_ts, to_ts), moving
+-- their posts out of the default partition, and returns the number of partitions created
+CREATE FUNCTION create_posts_partitions(from_ts TIMESTAMPTZ, to_ts TIMESTAMPTZ) RETURNS INTEGER AS $$
+DECLARE
+    month_start    TIMESTAMPTZ := date_trunc('month', from_ts, 'UTC');
+    month_end      TIMESTAMPTZ;
+    partition_name TEXT;
+    created        INTEGER := 0;
+BEGIN
+    -- Every API instance runs the maintenance, one at a time
+    PERFORM pg_advisory_xact_lock(hashtext('create_posts_partitions'));
+
+    WHILE month_start < to_ts LOOP
+        -- Months are UTC months, whatever the session time zone
+        month_end := (month_start AT TIME ZONE 'UTC' + INTERVAL '1 month') AT TIME ZONE 'UTC';
+        partition_name := 'posts_' || to_char(month_start AT TIME ZONE 'UTC', 'YYYY_MM');
+
+        IF to_regclass(partition_name) IS NULL THEN
+            EXECUTE format('CREATE TABLE %I (LIKE posts INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+            EXECUTE format(
+                'WITH moved AS (DELETE FROM posts_default WHERE created_at >= $1 AND created_at < $2 RETURNING *) '
+                'INSERT INTO %I SELECT * FROM moved', partition_name)
+                USING month_start, month_end;
+            EXECUTE format('ALTER TABLE posts ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+                partition_name, month_start, month_end);
+            created := created + 1;
+        END IF;
+
+        month_start := month_end;
+    END LOOP;
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Partitions for the existing posts and the coming months, then the posts themselves
+SELECT create_posts_partitions(
+    COALESCE((SELECT MIN(created_at) FROM posts_unpartitioned), NOW()),
+    NOW() + INTERVAL '3 months'
+);
+INSERT INTO posts (id, user_id, content, created_at, updated_at)
+SELECT id, user_id, content, created_at, updated_at FROM posts_unpartitioned;
+DROP TABLE posts_unpartitioned;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS users_cache_changes ON users;
+DROP TRIGGER IF EXISTS posts_cache_changes ON posts;
+DROP FUNCTION IF EXISTS record_cache_change();
+DROP TABLE IF EXISTS cache_change_positions;
+DROP TABLE IF EXISTS cache_changes;
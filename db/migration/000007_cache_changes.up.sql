@@ -0,0 +1,53 @@
+-- Changes of cached tables, consumed by the cache invalidation worker in id order.
+-- Writes made outside of the application, such as batch inserts or manual SQL, reach Redis through it.
+CREATE TABLE cache_changes (
+    id BIGSERIAL PRIMARY KEY,
+    table_name TEXT NOT NULL,
+    op CHAR(1) NOT NULL, -- I, U or D
+    row_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+-- Position of each consumer in cache_changes, the last change it applied
+CREATE TABLE cache_change_positions (
+    consumer TEXT PRIMARY KEY,
+    position BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO cache_change_positions (consumer) VALUES ('redis');
+
+-- record_cache_change logs a row change of the table given as argument, as the trigger of a partitioned
+-- table runs with the name of the partition. The notification only wakes the consumers up, identical
+-- notifications of a transaction are delivered once.
+CREATE FUNCTION record_cache_change() RETURNS TRIGGER AS $$
+DECLARE
+    changed RECORD;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+    ELSE
+        changed := NEW;
+    END IF;
+
+    IF TG_ARGV[0] = 'users' THEN
+        INSERT INTO cache_changes (table_name, op, row_id, user_id)
+        VALUES ('users', LEFT(TG_OP, 1), changed.id, changed.id);
+    ELSE
+        INSERT INTO cache_changes (table_name, op, row_id, user_id)
+        VALUES (TG_ARGV[0], LEFT(TG_OP, 1), changed.id, changed.user_id);
+    END IF;
+
+    PERFORM pg_notify('cache_changes', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_cache_changes
+    AFTER INSERT OR UPDATE OR DELETE ON posts
+    FOR EACH ROW EXECUTE FUNCTION record_cache_change('posts');
+
+-- Users are not cached, only their deletion drops cached posts lists
+CREATE TRIGGER users_cache_changes
+    AFTER DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION record_cache_change('users');
@@ -0,0 +1,18 @@
+-- Domain events written in the transaction of the change they describe, published to Redis Streams
+-- by the outbox relay. IDs are snowflake IDs, unique across shards.
+CREATE TABLE outbox (
+    id BIGINT PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    aggregate_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    sent_at TIMESTAMPTZ,
+    dead_lettered_at TIMESTAMPTZ
+);
+
+-- Events waiting to be published
+CREATE INDEX idx_outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL AND dead_lettered_at IS NULL;
@@ -0,0 +1,30 @@
+-- One reaction per user and post, on the shard of the reacting user. The post lives on the shard of its
+-- author, so no foreign key points to it.
+CREATE TABLE reactions (
+    user_id BIGINT NOT NULL,
+    post_id BIGINT NOT NULL,
+    reaction TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+-- Reaction counts of the posts, on the shard of their author. They are only written by the flush of the
+-- counters kept in Redis.
+CREATE TABLE post_reaction_counts (
+    post_id BIGINT NOT NULL,
+    -- Author of the post
+    user_id BIGINT NOT NULL,
+    reaction TEXT NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    -- Last flush applied, a flush retried after a crash is applied once
+    flush_seq BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (post_id, reaction),
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS post_reply_counts;
+DROP TABLE IF EXISTS replies;
@@ -0,0 +1,37 @@
+-- Replies to a post and to its replies, on the shard of the author of the post at the root of the
+-- thread, so that a thread is read and written on one shard. Posts are partitioned and the repliers
+-- live on other shards, so no foreign key points to them.
+CREATE TABLE replies (
+    id BIGINT PRIMARY KEY,
+    -- Post at the root of the thread, and its author
+    root_id BIGINT NOT NULL,
+    root_user_id BIGINT NOT NULL,
+    -- Reply replied to, the root post for a reply to the post
+    parent_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    content TEXT NOT NULL,
+    -- Replies to this reply, tombstones included
+    reply_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Set on a deleted reply kept as a tombstone, with its content cleared, while replies hang from it
+    deleted_at TIMESTAMPTZ,
+    CONSTRAINT fk_root_user
+        FOREIGN KEY(root_user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_replies_root_id_created_at ON replies (root_id, created_at, id);
+
+-- Replies to the posts across their thread, tombstones excluded, on the shard of their author
+CREATE TABLE post_reply_counts (
+    post_id BIGINT PRIMARY KEY,
+    -- Author of the post
+    user_id BIGINT NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT fk_user
+        FOREIGN KEY(user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
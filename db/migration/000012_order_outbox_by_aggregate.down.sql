@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_outbox_pending_aggregate;
+DROP INDEX IF EXISTS idx_outbox_pending;
+CREATE INDEX idx_outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL AND dead_lettered_at IS NULL;
@@ -0,0 +1,4 @@
+-- Events are claimed in id order, after the earlier events of their aggregate waiting for a retry
+DROP INDEX IF EXISTS idx_outbox_pending;
+CREATE INDEX idx_outbox_pending ON outbox (id) WHERE sent_at IS NULL AND dead_lettered_at IS NULL;
+CREATE INDEX idx_outbox_pending_aggregate ON outbox (aggregate_id, id) WHERE sent_at IS NULL AND dead_lettered_at IS NULL;
@@ -0,0 +1,7 @@
+-- The user who created the webhook, the only one who may see and change it. Users live on other shards,
+-- so no foreign key points to them. Webhooks created before owners are left without one, and can only
+-- be managed in the database.
+ALTER TABLE webhooks ADD COLUMN owner_id BIGINT;
+
+-- Webhooks of an owner, in creation order
+CREATE INDEX idx_webhooks_owner ON webhooks (owner_id, id);
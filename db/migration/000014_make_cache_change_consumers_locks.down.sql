@@ -0,0 +1,2 @@
+ALTER TABLE cache_change_consumers RENAME TO cache_change_positions;
+ALTER TABLE cache_change_positions ADD COLUMN position BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- Applied changes are deleted rather than tracked by position, so a consumer row is only the lock taken
+-- by the instance applying the changes of the shard
+ALTER TABLE cache_change_positions DROP COLUMN position;
+ALTER TABLE cache_change_positions RENAME TO cache_change_consumers;
@@ -0,0 +1,47 @@
+-- name: CreateFollow :execrows
+INSERT INTO follows (
+    follower_id,
+    followee_id
+) VALUES (
+    $1, $2
+) ON CONFLICT DO NOTHING;
+
+-- name: DeleteFollow :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: DeleteFollowsByUser :exec
+DELETE FROM follows
+WHERE follower_id = $1 OR followee_id = $1;
+
+-- name: ListFollowers :many
+SELECT * FROM follows
+WHERE followee_id = $1
+ORDER BY created_at DESC
+LIMIT $2
+OFFSET $3;
+
+-- name: ListFollowing :many
+SELECT * FROM follows
+WHERE follower_id = $1
+ORDER BY created_at DESC
+LIMIT $2
+OFFSET $3;
+
+-- name: ListAllFollowers :many
+SELECT * FROM follows
+WHERE followee_id = $1
+ORDER BY created_at DESC;
+
+-- name: ListAllFollowing :many
+SELECT * FROM follows
+WHERE follower_id = $1
+ORDER BY created_at DESC;
+
+-- name: CountFollowers :one
+SELECT COUNT(*) FROM follows
+WHERE followee_id = $1;
+
+-- name: CountFollowing :one
+SELECT COUNT(*) FROM follows
+WHERE follower_id = $1;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- name: CreateOutboxEvent :one
+INSERT INTO outbox (
+    id,
+    event_type,
+    aggregate_id,
+    user_id,
+    payload
+) VALUES (
+    $1, $2, $3, $4, $5
+) RETURNING *;
@@ -1,15 +1,21 @@
 -- name: CreatePost :one
 INSERT INTO posts (
+    id,
     user_id,
     content
 ) VALUES (
-    $1, $2
+    $1, $2, $3
 ) RETURNING *;
 
 -- name: GetPost :one
 SELECT * FROM posts
 WHERE id = $1 LIMIT 1;
 
+-- name: LockPost :one
+SELECT id FROM posts
+WHERE id = $1 AND user_id = $2
+FOR SHARE;
+
 -- name: ListPostsByUser :many
 SELECT * FROM posts
 WHERE user_id = $1
@@ -17,10 +23,38 @@ ORDER BY created_at DESC
 LIMIT $2
 OFFSET $3;
 
+-- name: CountPostsByUser :one
+SELECT count(*) FROM posts
+WHERE user_id = $1;
+
 -- name: CreatePostsInBatch :copyfrom
 INSERT INTO posts (
+    id,
     user_id,
     content
 ) VALUES (
-    $1, $2
-);
\ No newline at end of file
+    $1, $2, $3
+);
+-- name: GetPostsByIDs :many
+SELECT * FROM posts
+WHERE id = ANY(@ids::bigint[]);
+
+-- name: ListRecentPostsByUsers :many
+SELECT * FROM posts
+WHERE user_id = ANY(@user_ids::bigint[])
+  AND (floor(extract(epoch FROM created_at))::bigint, id) < (@before_score::bigint, @before_id::bigint)
+ORDER BY floor(extract(epoch FROM created_at))::bigint DESC, id DESC
+LIMIT @row_limit;
+
+-- name: UpdatePost :one
+UPDATE posts
+SET
+    content = $3,
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeletePost :one
+DELETE FROM posts
+WHERE id = $1 AND user_id = $2
+RETURNING *;
@@ -0,0 +1,60 @@
+-- name: CreateReaction :execrows
+INSERT INTO reactions (
+    user_id,
+    post_id,
+    reaction
+) VALUES (
+    $1, $2, $3
+) ON CONFLICT (user_id, post_id) DO NOTHING;
+
+-- name: GetReaction :one
+SELECT reaction FROM reactions
+WHERE user_id = $1 AND post_id = $2;
+
+-- name: GetReactionForUpdate :one
+SELECT reaction FROM reactions
+WHERE user_id = $1 AND post_id = $2
+FOR UPDATE;
+
+-- name: UpdateReaction :exec
+UPDATE reactions
+SET
+    reaction = $3,
+    created_at = NOW()
+WHERE user_id = $1 AND post_id = $2;
+
+-- name: DeleteReaction :one
+DELETE FROM reactions
+WHERE user_id = $1 AND post_id = $2
+RETURNING reaction;
+
+-- name: ListReactedPostIDs :many
+SELECT post_id FROM reactions
+WHERE user_id = $1
+ORDER BY post_id
+LIMIT $2;
+
+-- name: GetPostReactionCounts :many
+SELECT * FROM post_reaction_counts
+WHERE post_id = $1 AND user_id = $2
+ORDER BY reaction;
+
+-- name: AddPostReactionCounts :execrows
+INSERT INTO post_reaction_counts (
+    post_id,
+    user_id,
+    reaction,
+    count,
+    flush_seq
+)
+SELECT
+    unnest(@post_ids::bigint[]),
+    unnest(@user_ids::bigint[]),
+    unnest(@reactions::text[]),
+    unnest(@deltas::bigint[]),
+    unnest(@flush_seqs::bigint[])
+ON CONFLICT (post_id, reaction) DO UPDATE
+SET
+    count = post_reaction_counts.count + EXCLUDED.count,
+    flush_seq = EXCLUDED.flush_seq
+WHERE post_reaction_counts.flush_seq < EXCLUDED.flush_seq;
@@ -0,0 +1,69 @@
+-- name: CreateReply :one
+INSERT INTO replies (
+    id,
+    root_id,
+    root_user_id,
+    parent_id,
+    user_id,
+    content
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+) RETURNING *;
+
+-- name: GetReplyForUpdate :one
+SELECT * FROM replies
+WHERE id = $1 AND root_id = $2 AND root_user_id = $3
+FOR UPDATE;
+
+-- name: IncrementReplyCount :execrows
+UPDATE replies
+SET reply_count = reply_count + 1
+WHERE id = $1 AND root_id = $2 AND root_user_id = $3 AND deleted_at IS NULL;
+
+-- name: DecrementReplyCount :one
+UPDATE replies
+SET reply_count = reply_count - 1
+WHERE id = $1 AND root_user_id = $2
+RETURNING *;
+
+-- name: TombstoneReply :one
+UPDATE replies
+SET
+    content = '',
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND root_user_id = $2
+RETURNING *;
+
+-- name: DeleteReply :exec
+DELETE FROM replies
+WHERE id = $1 AND root_user_id = $2;
+
+-- name: ListThreadReplies :many
+SELECT * FROM replies
+WHERE root_id = $1 AND root_user_id = $2
+ORDER BY created_at, id
+LIMIT $3
+OFFSET $4;
+
+-- name: DeleteThread :execrows
+DELETE FROM replies
+WHERE root_id = $1 AND root_user_id = $2;
+
+-- name: AddPostReplyCount :exec
+INSERT INTO post_reply_counts (
+    post_id,
+    user_id,
+    count
+) VALUES (
+    $1, $2, $3
+) ON CONFLICT (post_id) DO UPDATE
+SET count = post_reply_counts.count + EXCLUDED.count;
+
+-- name: GetPostReplyCount :one
+SELECT count FROM post_reply_counts
+WHERE post_id = $1 AND user_id = $2;
+
+-- name: DeletePostReplyCount :exec
+DELETE FROM post_reply_counts
+WHERE post_id = $1 AND user_id = $2;
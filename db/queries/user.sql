@@ -1,11 +1,12 @@
 -- name: CreateUser :one
 INSERT INTO users (
+    id,
     first_name,
     last_name,
     email,
     hashed_password
 ) VALUES (
-    $1, $2, $3, $4
+    $1, $2, $3, $4, $5
 ) RETURNING *;
 
 -- name: GetUserByID :one
@@ -0,0 +1,55 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (
+    url,
+    event_types,
+    user_ids,
+    secret,
+    owner_id
+) VALUES (
+    $1, $2, $3, $4, $5
+) RETURNING *;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks
+WHERE id = $1 LIMIT 1;
+
+-- name: ListWebhooks :many
+SELECT * FROM webhooks
+WHERE owner_id = $1
+ORDER BY id
+LIMIT $2
+OFFSET $3;
+
+-- name: ListWebhooksForEvent :many
+SELECT * FROM webhooks
+WHERE disabled_at IS NULL
+  AND @event_type::text = ANY(event_types)
+  AND (cardinality(user_ids) = 0 OR @user_id::bigint = ANY(user_ids))
+ORDER BY id;
+
+-- name: EnableWebhook :one
+UPDATE webhooks
+SET disabled_at = NULL, consecutive_failures = 0
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks
+WHERE id = $1;
+
+-- name: CreateWebhookDelivery :execrows
+INSERT INTO webhook_deliveries (
+    webhook_id,
+    event_id,
+    event_type,
+    payload
+) VALUES (
+    $1, $2, $3, $4
+) ON CONFLICT (webhook_id, event_id) DO NOTHING;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE webhook_id = $1
+ORDER BY id DESC
+LIMIT $2
+OFFSET $3;
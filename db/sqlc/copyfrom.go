@@ -29,6 +29,7 @@ func (r *iteratorForCreatePostsInBatch) Next() bool {
 
 func (r iteratorForCreatePostsInBatch) Values() ([]interface{}, error) {
 	return []interface{}{
+		r.rows[0].ID,
 		r.rows[0].UserID,
 		r.rows[0].Content,
 	}, nil
@@ -39,5 +40,5 @@ func (r iteratorForCreatePostsInBatch) Err() error {
 }
 
 func (q *Queries) CreatePostsInBatch(ctx context.Context, arg []CreatePostsInBatchParams) (int64, error) {
-	return q.db.CopyFrom(ctx, []string{"posts"}, []string{"user_id", "content"}, &iteratorForCreatePostsInBatch{rows: arg})
+	return q.db.CopyFrom(ctx, []string{"posts"}, []string{"id", "user_id", "content"}, &iteratorForCreatePostsInBatch{rows: arg})
 }
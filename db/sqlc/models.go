@@ -6,8 +6,30 @@ package sqlc
 
 import (
 	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Follow struct {
+	FollowerID int64     `json:"follower_id"`
+	FolloweeID int64     `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Outbox struct {
+	ID             int64              `json:"id"`
+	EventType      string             `json:"event_type"`
+	AggregateID    int64              `json:"aggregate_id"`
+	UserID         int64              `json:"user_id"`
+	Payload        []byte             `json:"payload"`
+	CreatedAt      time.Time          `json:"created_at"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  time.Time          `json:"next_attempt_at"`
+	LastError      pgtype.Text        `json:"last_error"`
+	SentAt         pgtype.Timestamptz `json:"sent_at"`
+	DeadLetteredAt pgtype.Timestamptz `json:"dead_lettered_at"`
+}
+
 type Post struct {
 	ID        int64     `json:"id"`
 	UserID    int64     `json:"user_id"`
@@ -16,6 +38,40 @@ type Post struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+type PostReactionCount struct {
+	PostID   int64  `json:"post_id"`
+	UserID   int64  `json:"user_id"`
+	Reaction string `json:"reaction"`
+	Count    int64  `json:"count"`
+	FlushSeq int64  `json:"flush_seq"`
+}
+
+type PostReplyCount struct {
+	PostID int64 `json:"post_id"`
+	UserID int64 `json:"user_id"`
+	Count  int64 `json:"count"`
+}
+
+type Reaction struct {
+	UserID    int64     `json:"user_id"`
+	PostID    int64     `json:"post_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Reply struct {
+	ID         int64              `json:"id"`
+	RootID     int64              `json:"root_id"`
+	RootUserID int64              `json:"root_user_id"`
+	ParentID   int64              `json:"parent_id"`
+	UserID     int64              `json:"user_id"`
+	Content    string             `json:"content"`
+	ReplyCount int32              `json:"reply_count"`
+	CreatedAt  time.Time          `json:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+	DeletedAt  pgtype.Timestamptz `json:"deleted_at"`
+}
+
 type User struct {
 	ID             int64     `json:"id"`
 	FirstName      string    `json:"first_name"`
@@ -25,3 +81,30 @@ type User struct {
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
+
+type Webhook struct {
+	ID                  int64              `json:"id"`
+	Url                 string             `json:"url"`
+	EventTypes          []string           `json:"event_types"`
+	UserIds             []int64            `json:"user_ids"`
+	Secret              string             `json:"secret"`
+	ConsecutiveFailures int32              `json:"consecutive_failures"`
+	DisabledAt          pgtype.Timestamptz `json:"disabled_at"`
+	CreatedAt           time.Time          `json:"created_at"`
+	OwnerID             pgtype.Int8        `json:"owner_id"`
+}
+
+type WebhookDelivery struct {
+	ID             int64              `json:"id"`
+	WebhookID      int64              `json:"webhook_id"`
+	EventID        int64              `json:"event_id"`
+	EventType      string             `json:"event_type"`
+	Payload        []byte             `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  time.Time          `json:"next_attempt_at"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	LastError      pgtype.Text        `json:"last_error"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
@@ -9,22 +9,36 @@ import (
 	"context"
 )
 
+const countPostsByUser = `-- name: CountPostsByUser :one
+SELECT count(*) FROM posts
+WHERE user_id = $1
+`
+
+func (q *Queries) CountPostsByUser(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, countPostsByUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createPost = `-- name: CreatePost :one
 INSERT INTO posts (
+    id,
     user_id,
     content
 ) VALUES (
-    $1, $2
+    $1, $2, $3
 ) RETURNING id, user_id, content, created_at, updated_at
 `
 
 type CreatePostParams struct {
+	ID      int64  `json:"id"`
 	UserID  int64  `json:"user_id"`
 	Content string `json:"content"`
 }
 
 func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRow(ctx, createPost, arg.UserID, arg.Content)
+	row := q.db.QueryRow(ctx, createPost, arg.ID, arg.UserID, arg.Content)
 	var i Post
 	err := row.Scan(
 		&i.ID,
@@ -37,10 +51,35 @@ func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, e
 }
 
 type CreatePostsInBatchParams struct {
+	ID      int64  `json:"id"`
 	UserID  int64  `json:"user_id"`
 	Content string `json:"content"`
 }
 
+const deletePost = `-- name: DeletePost :one
+DELETE FROM posts
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, content, created_at, updated_at
+`
+
+type DeletePostParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) DeletePost(ctx context.Context, arg DeletePostParams) (Post, error) {
+	row := q.db.QueryRow(ctx, deletePost, arg.ID, arg.UserID)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getPost = `-- name: GetPost :one
 SELECT id, user_id, content, created_at, updated_at FROM posts
 WHERE id = $1 LIMIT 1
@@ -59,6 +98,37 @@ func (q *Queries) GetPost(ctx context.Context, id int64) (Post, error) {
 	return i, err
 }
 
+const getPostsByIDs = `-- name: GetPostsByIDs :many
+SELECT id, user_id, content, created_at, updated_at FROM posts
+WHERE id = ANY($1::bigint[])
+`
+
+func (q *Queries) GetPostsByIDs(ctx context.Context, ids []int64) ([]Post, error) {
+	rows, err := q.db.Query(ctx, getPostsByIDs, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Post{}
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listPostsByUser = `-- name: ListPostsByUser :many
 SELECT id, user_id, content, created_at, updated_at FROM posts
 WHERE user_id = $1
@@ -98,3 +168,95 @@ func (q *Queries) ListPostsByUser(ctx context.Context, arg ListPostsByUserParams
 	}
 	return items, nil
 }
+
+const listRecentPostsByUsers = `-- name: ListRecentPostsByUsers :many
+SELECT id, user_id, content, created_at, updated_at FROM posts
+WHERE user_id = ANY($1::bigint[])
+  AND (floor(extract(epoch FROM created_at))::bigint, id) < ($2::bigint, $3::bigint)
+ORDER BY floor(extract(epoch FROM created_at))::bigint DESC, id DESC
+LIMIT $4
+`
+
+type ListRecentPostsByUsersParams struct {
+	UserIds     []int64 `json:"user_ids"`
+	BeforeScore int64   `json:"before_score"`
+	BeforeID    int64   `json:"before_id"`
+	RowLimit    int32   `json:"row_limit"`
+}
+
+func (q *Queries) ListRecentPostsByUsers(ctx context.Context, arg ListRecentPostsByUsersParams) ([]Post, error) {
+	rows, err := q.db.Query(ctx, listRecentPostsByUsers,
+		arg.UserIds,
+		arg.BeforeScore,
+		arg.BeforeID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Post{}
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockPost = `-- name: LockPost :one
+SELECT id FROM posts
+WHERE id = $1 AND user_id = $2
+FOR SHARE
+`
+
+type LockPostParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) LockPost(ctx context.Context, arg LockPostParams) (int64, error) {
+	row := q.db.QueryRow(ctx, lockPost, arg.ID, arg.UserID)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const updatePost = `-- name: UpdatePost :one
+UPDATE posts
+SET
+    content = $3,
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING id, user_id, content, created_at, updated_at
+`
+
+type UpdatePostParams struct {
+	ID      int64  `json:"id"`
+	UserID  int64  `json:"user_id"`
+	Content string `json:"content"`
+}
+
+func (q *Queries) UpdatePost(ctx context.Context, arg UpdatePostParams) (Post, error) {
+	row := q.db.QueryRow(ctx, updatePost, arg.ID, arg.UserID, arg.Content)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
@@ -9,15 +9,58 @@ import (
 )
 
 type Querier interface {
+	AddPostReactionCounts(ctx context.Context, arg AddPostReactionCountsParams) (int64, error)
+	AddPostReplyCount(ctx context.Context, arg AddPostReplyCountParams) error
+	CountFollowers(ctx context.Context, followeeID int64) (int64, error)
+	CountFollowing(ctx context.Context, followerID int64) (int64, error)
+	CreateFollow(ctx context.Context, arg CreateFollowParams) (int64, error)
+	CreateOutboxEvent(ctx context.Context, arg CreateOutboxEventParams) (Outbox, error)
+	CountPostsByUser(ctx context.Context, userID int64) (int64, error)
 	CreatePost(ctx context.Context, arg CreatePostParams) (Post, error)
 	CreatePostsInBatch(ctx context.Context, arg []CreatePostsInBatchParams) (int64, error)
+	CreateReaction(ctx context.Context, arg CreateReactionParams) (int64, error)
+	CreateReply(ctx context.Context, arg CreateReplyParams) (Reply, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	CreateWebhook(ctx context.Context, arg CreateWebhookParams) (Webhook, error)
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) (int64, error)
+	DecrementReplyCount(ctx context.Context, arg DecrementReplyCountParams) (Reply, error)
+	DeleteFollow(ctx context.Context, arg DeleteFollowParams) (int64, error)
+	DeleteFollowsByUser(ctx context.Context, followerID int64) error
+	DeletePost(ctx context.Context, arg DeletePostParams) (Post, error)
+	DeletePostReplyCount(ctx context.Context, arg DeletePostReplyCountParams) error
+	DeleteReaction(ctx context.Context, arg DeleteReactionParams) (string, error)
+	DeleteReply(ctx context.Context, arg DeleteReplyParams) error
+	DeleteThread(ctx context.Context, arg DeleteThreadParams) (int64, error)
 	DeleteUser(ctx context.Context, id int64) error
+	DeleteWebhook(ctx context.Context, id int64) (int64, error)
+	EnableWebhook(ctx context.Context, id int64) (Webhook, error)
 	GetPost(ctx context.Context, id int64) (Post, error)
+	GetPostReactionCounts(ctx context.Context, arg GetPostReactionCountsParams) ([]PostReactionCount, error)
+	GetPostReplyCount(ctx context.Context, arg GetPostReplyCountParams) (int64, error)
+	GetPostsByIDs(ctx context.Context, ids []int64) ([]Post, error)
+	GetReaction(ctx context.Context, arg GetReactionParams) (string, error)
+	GetReactionForUpdate(ctx context.Context, arg GetReactionForUpdateParams) (string, error)
+	GetReplyForUpdate(ctx context.Context, arg GetReplyForUpdateParams) (Reply, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id int64) (User, error)
+	GetWebhook(ctx context.Context, id int64) (Webhook, error)
+	IncrementReplyCount(ctx context.Context, arg IncrementReplyCountParams) (int64, error)
+	ListAllFollowers(ctx context.Context, followeeID int64) ([]Follow, error)
+	ListAllFollowing(ctx context.Context, followerID int64) ([]Follow, error)
+	ListFollowers(ctx context.Context, arg ListFollowersParams) ([]Follow, error)
+	ListFollowing(ctx context.Context, arg ListFollowingParams) ([]Follow, error)
 	ListPostsByUser(ctx context.Context, arg ListPostsByUserParams) ([]Post, error)
+	ListReactedPostIDs(ctx context.Context, arg ListReactedPostIDsParams) ([]int64, error)
+	ListRecentPostsByUsers(ctx context.Context, arg ListRecentPostsByUsersParams) ([]Post, error)
+	ListThreadReplies(ctx context.Context, arg ListThreadRepliesParams) ([]Reply, error)
 	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	ListWebhooks(ctx context.Context, arg ListWebhooksParams) ([]Webhook, error)
+	ListWebhooksForEvent(ctx context.Context, arg ListWebhooksForEventParams) ([]Webhook, error)
+	LockPost(ctx context.Context, arg LockPostParams) (int64, error)
+	TombstoneReply(ctx context.Context, arg TombstoneReplyParams) (Reply, error)
+	UpdatePost(ctx context.Context, arg UpdatePostParams) (Post, error)
+	UpdateReaction(ctx context.Context, arg UpdateReactionParams) error
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
 }
 
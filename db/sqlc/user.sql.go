@@ -13,16 +13,18 @@ import (
 
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (
+    id,
     first_name,
     last_name,
     email,
     hashed_password
 ) VALUES (
-    $1, $2, $3, $4
+    $1, $2, $3, $4, $5
 ) RETURNING id, first_name, last_name, email, hashed_password, created_at, updated_at
 `
 
 type CreateUserParams struct {
+	ID             int64  `json:"id"`
 	FirstName      string `json:"first_name"`
 	LastName       string `json:"last_name"`
 	Email          string `json:"email"`
@@ -31,6 +33,7 @@ type CreateUserParams struct {
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
 	row := q.db.QueryRow(ctx, createUser,
+		arg.ID,
 		arg.FirstName,
 		arg.LastName,
 		arg.Email,
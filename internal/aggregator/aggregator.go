@@ -0,0 +1,251 @@
+// Package aggregator runs scatter-gather queries over a Redis cluster.
+// Keys are planned by the node owning their slot, every node is queried once with its own
+// deadline, and the caller receives the per-key results along with the shards that failed.
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// Options tunes how shards are queried
+type Options struct {
+	// ShardTimeout bounds every shard query, zero leaves only the caller's deadline
+	ShardTimeout time.Duration
+	// MaxConcurrency bounds the shards queried at once by a single query, zero means no limit
+	MaxConcurrency int
+	// HedgePercentile is the percentile of a node's recent latency after which a hedged query
+	// sends its duplicate read, zero disables hedging
+	HedgePercentile float64
+	// HedgeMinDelay is the shortest wait before a duplicate read is sent
+	HedgeMinDelay time.Duration
+	// Breakers, if set, fails the shards of nodes whose circuit is open without querying them
+	Breakers *breaker.Group
+	// TopologyRefreshInterval is the interval between reloads of the cluster slot map, zero only
+	// reloads it after MOVED or ASK replies
+	TopologyRefreshInterval time.Duration
+}
+
+// Aggregator plans keys by cluster node and runs sub-queries against every node
+type Aggregator struct {
+	rdb      redis.Cmdable
+	topology *Topology
+	opts     Options
+	latency  *latencyTracker
+
+	replicaMux     sync.Mutex
+	replicaClients map[string]*redis.Client // replica address -> client
+}
+
+// New creates a new Aggregator over rdb
+func New(rdb redis.Cmdable, opts Options) *Aggregator {
+	topology := NewTopology(rdb)
+	topology.RefreshEvery(opts.TopologyRefreshInterval)
+	return &Aggregator{
+		rdb:            rdb,
+		topology:       topology,
+		opts:           opts,
+		latency:        newLatencyTracker(),
+		replicaClients: make(map[string]*redis.Client),
+	}
+}
+
+// Shard is the group of keys owned by one node
+type Shard struct {
+	Node string
+	Keys []string
+	// Indexes holds the position of every key in the planned key list
+	Indexes []int
+}
+
+// ShardError reports a shard whose query failed or missed its deadline
+type ShardError struct {
+	Node string
+	Keys []string
+	Err  error
+}
+
+func (e ShardError) Error() string {
+	return fmt.Sprintf("shard %q (%d keys): %v", e.Node, len(e.Keys), e.Err)
+}
+
+func (e ShardError) Unwrap() error {
+	return e.Err
+}
+
+// Result holds the gathered value of every key, in the order of the planned keys.
+// Keys of failed shards hold the zero value unless the shard query returned values with its error.
+type Result[T any] struct {
+	Values []T
+	Failed []ShardError
+	// Shards is the number of shards queried
+	Shards int
+}
+
+// Partial reports whether some shards failed
+func (r Result[T]) Partial() bool {
+	return len(r.Failed) > 0
+}
+
+// NodeForKey returns the address of the node owning the key
+func (a *Aggregator) NodeForKey(key string) (string, bool) {
+	return a.topology.NodeForKey(key)
+}
+
+// NodeOpen reports whether the circuit of the node owning the key is open
+func (a *Aggregator) NodeOpen(key string) bool {
+	node, ok := a.topology.NodeForKey(key)
+	if !ok {
+		node = StandaloneNode
+	}
+	return a.opts.Breakers.Open(node)
+}
+
+// Plan groups keys by the node owning them. Keys of unknown slots share the StandaloneNode shard.
+func (a *Aggregator) Plan(keys []string) []Shard {
+	byNode := make(map[string]*Shard)
+	for i, key := range keys {
+		addr, ok := a.topology.NodeForKey(key)
+		if !ok {
+			addr = StandaloneNode
+		}
+		shard, ok := byNode[addr]
+		if !ok {
+			shard = &Shard{Node: addr}
+			byNode[addr] = shard
+		}
+		shard.Keys = append(shard.Keys, key)
+		shard.Indexes = append(shard.Indexes, i)
+	}
+
+	shards := make([]Shard, 0, len(byNode))
+	for _, shard := range byNode {
+		shards = append(shards, *shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Node < shards[j].Node })
+	return shards
+}
+
+// ShardQuery reads the keys of one shard, returning one value per key in the order of shard.Keys
+type ShardQuery[T any] func(ctx context.Context, shard Shard) ([]T, error)
+
+// Gather plans keys by node and runs query once per shard concurrently. Values returned along with
+// an error are kept so that callers can inspect them.
+func Gather[T any](ctx context.Context, a *Aggregator, keys []string, query ShardQuery[T]) Result[T] {
+	return gather(ctx, a, keys, query, nil)
+}
+
+func gather[T any](ctx context.Context, a *Aggregator, keys []string, query ShardQuery[T], hedge ShardQuery[T]) Result[T] {
+	shards := a.Plan(keys)
+	res := Result[T]{Values: make([]T, len(keys)), Shards: len(shards)}
+
+	var sem chan struct{}
+	if a.opts.MaxConcurrency > 0 && a.opts.MaxConcurrency < len(shards) {
+		sem = make(chan struct{}, a.opts.MaxConcurrency)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(shard Shard) {
+			defer wg.Done()
+
+			values, err := func() ([]T, error) {
+				if a.opts.Breakers.Open(shard.Node) {
+					return nil, breaker.ErrOpen
+				}
+				if sem != nil {
+					select {
+					case sem <- struct{}{}:
+						defer func() { <-sem }()
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+				}
+
+				shardCtx := ctx
+				if a.opts.ShardTimeout > 0 {
+					var cancel context.CancelFunc
+					shardCtx, cancel = context.WithTimeout(ctx, a.opts.ShardTimeout)
+					defer cancel()
+				}
+				return runShard(shardCtx, a, shard, query, hedge)
+			}()
+			if values != nil && len(values) != len(shard.Keys) {
+				err = errors.Join(err, fmt.Errorf("shard query returned %d values for %d keys", len(values), len(shard.Keys)))
+				values = nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for i, v := range values {
+				res.Values[shard.Indexes[i]] = v
+			}
+			if err != nil {
+				if isRedirect(err) {
+					a.topology.Redirected()
+				}
+				if !errors.Is(err, breaker.ErrOpen) {
+					log.Printf("scatter-gather query failed on node %q for %d keys: %v", shard.Node, len(shard.Keys), err)
+				}
+				metrics.AggregatorShardFailures.WithLabelValues(shard.Node).Inc()
+				res.Failed = append(res.Failed, ShardError{Node: shard.Node, Keys: shard.Keys, Err: err})
+			}
+		}(shard)
+	}
+	wg.Wait()
+
+	return res
+}
+
+// Pipeline queues one command per key and executes one pipeline per shard. Values hold the command
+// of every key with its own result or error, or nil when its shard never ran. A shard fails when its
+// pipeline cannot complete, replies such as redis.Nil or WRONGTYPE are left on their commands.
+func (a *Aggregator) Pipeline(ctx context.Context, keys []string, queue PipelineQueue) Result[redis.Cmder] {
+	return Gather(ctx, a, keys, func(ctx context.Context, shard Shard) ([]redis.Cmder, error) {
+		return a.execPipeline(ctx, a.rdb, shard.Keys, queue)
+	})
+}
+
+// execPipeline is ExecPipeline reloading the slot map when a command was redirected, as the shard
+// was planned on a node that no longer owns some of its keys
+func (a *Aggregator) execPipeline(ctx context.Context, rdb redis.Cmdable, keys []string, queue PipelineQueue) ([]redis.Cmder, error) {
+	cmds, err := ExecPipeline(ctx, rdb, keys, queue)
+	for _, cmd := range cmds {
+		if isRedirect(cmd.Err()) {
+			a.topology.Redirected()
+			break
+		}
+	}
+	return cmds, err
+}
+
+// PipelineQueue queues the command of one key
+type PipelineQueue func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder
+
+// ExecPipeline queues one command per key on a pipeline of rdb and executes it.
+// Replies such as redis.Nil or WRONGTYPE are left on their commands and are not returned.
+func ExecPipeline(ctx context.Context, rdb redis.Cmdable, keys []string, queue PipelineQueue) ([]redis.Cmder, error) {
+	pipe := rdb.Pipeline()
+	cmds := make([]redis.Cmder, len(keys))
+	for i, key := range keys {
+		cmds[i] = queue(ctx, pipe, key)
+	}
+
+	_, err := pipe.Exec(ctx)
+	var replyErr redis.Error
+	if err == redis.Nil || errors.As(err, &replyErr) {
+		err = nil
+	}
+	return cmds, err
+}
@@ -0,0 +1,197 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClusterAggregator builds an Aggregator whose keys are spread over two nodes by slot parity
+func newClusterAggregator(rdb redis.Cmdable, opts Options) *Aggregator {
+	slotMap := make(map[uint16]string)
+	for slot := 0; slot < 16384; slot++ {
+		slotMap[uint16(slot)] = fmt.Sprintf("node-%d:7000", slot%2)
+	}
+	agg := New(rdb, opts)
+	agg.topology = &Topology{clusterClient: new(redis.ClusterClient), slotMap: slotMap}
+	return agg
+}
+
+func TestKeySlot(t *testing.T) {
+	// Keys sharing a hash tag land on the same slot
+	assert.Equal(t, KeySlot("{user:1}:posts"), KeySlot("{user:1}:following"))
+	assert.Equal(t, KeySlot("user:1"), KeySlot("{user:1}:posts"))
+	// Known slot from the Redis cluster spec
+	assert.Equal(t, uint16(12182), KeySlot("foo"))
+	// An empty hash tag hashes the whole key
+	assert.NotEqual(t, KeySlot("foo"), KeySlot("{}foo"))
+}
+
+func TestAggregator_Plan(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	agg := newClusterAggregator(db, Options{})
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	shards := agg.Plan(keys)
+
+	planned := 0
+	for _, shard := range shards {
+		for i, key := range shard.Keys {
+			addr, _ := agg.NodeForKey(key)
+			assert.Equal(t, shard.Node, addr)
+			assert.Equal(t, keys[shard.Indexes[i]], key)
+		}
+		planned += len(shard.Keys)
+	}
+	assert.Len(t, shards, 2)
+	assert.Equal(t, len(keys), planned)
+}
+
+func TestGather(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+
+	t.Run("values_follow_key_order", func(t *testing.T) {
+		agg := newClusterAggregator(db, Options{MaxConcurrency: 1})
+		keys := []string{"a", "b", "c", "d", "e", "f"}
+
+		res := Gather(context.Background(), agg, keys, func(ctx context.Context, shard Shard) ([]string, error) {
+			return shard.Keys, nil
+		})
+
+		assert.Equal(t, keys, res.Values)
+		assert.Equal(t, 2, res.Shards)
+		assert.False(t, res.Partial())
+	})
+
+	t.Run("partial_results", func(t *testing.T) {
+		agg := newClusterAggregator(db, Options{})
+		keys := []string{"a", "b", "c", "d", "e", "f"}
+		failing := agg.Plan(keys)[0].Node
+
+		res := Gather(context.Background(), agg, keys, func(ctx context.Context, shard Shard) ([]string, error) {
+			if shard.Node == failing {
+				return nil, errors.New("connection refused")
+			}
+			return shard.Keys, nil
+		})
+
+		require.Len(t, res.Failed, 1)
+		assert.Equal(t, failing, res.Failed[0].Node)
+		for i, key := range keys {
+			addr, _ := agg.NodeForKey(key)
+			if addr == failing {
+				assert.Empty(t, res.Values[i])
+			} else {
+				assert.Equal(t, key, res.Values[i])
+			}
+		}
+	})
+
+	t.Run("shard_deadline", func(t *testing.T) {
+		agg := New(db, Options{ShardTimeout: 10 * time.Millisecond})
+
+		res := Gather(context.Background(), agg, []string{"a"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+		require.Len(t, res.Failed, 1)
+		assert.ErrorIs(t, res.Failed[0], context.DeadlineExceeded)
+	})
+
+	t.Run("wrong_value_count", func(t *testing.T) {
+		agg := New(db, Options{})
+
+		res := Gather(context.Background(), agg, []string{"a", "b"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			return []string{"a"}, nil
+		})
+
+		require.Len(t, res.Failed, 1)
+		assert.Equal(t, []string{"", ""}, res.Values)
+	})
+}
+
+func TestAggregator_Pipeline(t *testing.T) {
+	t.Run("missing_keys_are_not_failures", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		agg := New(db, Options{})
+
+		rdbMock.ExpectGet("a").SetVal("1")
+		rdbMock.ExpectGet("b").RedisNil()
+
+		res := agg.Pipeline(context.Background(), []string{"a", "b"}, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+			return pipe.Get(ctx, key)
+		})
+
+		assert.False(t, res.Partial())
+		assert.Equal(t, "1", res.Values[0].(*redis.StringCmd).Val())
+		assert.Equal(t, redis.Nil, res.Values[1].Err())
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("failed_node", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		agg := New(db, Options{})
+
+		rdbMock.ExpectGet("a").SetErr(errors.New("i/o timeout"))
+
+		res := agg.Pipeline(context.Background(), []string{"a"}, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+			return pipe.Get(ctx, key)
+		})
+
+		require.Len(t, res.Failed, 1)
+		assert.Equal(t, []string{"a"}, res.Failed[0].Keys)
+		assert.Error(t, res.Values[0].Err())
+	})
+}
+
+func TestMerge(t *testing.T) {
+	desc := func(a, b int) bool { return a > b }
+
+	t.Run("limit", func(t *testing.T) {
+		merged := Merge([][]int{{9, 5, 1}, {8, 7}, {}, {6}}, desc, 4)
+		assert.Equal(t, []int{9, 8, 7, 6}, merged)
+	})
+
+	t.Run("drops_duplicates", func(t *testing.T) {
+		merged := Merge([][]int{{9, 5}, {9, 6, 5}}, desc, 0)
+		assert.Equal(t, []int{9, 6, 5}, merged)
+	})
+}
+
+func TestReduce(t *testing.T) {
+	total := Reduce([]int{1, 2, 3}, 0, func(acc, v int) int { return acc + v })
+	assert.Equal(t, 6, total)
+}
+
+func TestParseConsistency(t *testing.T) {
+	for input, expected := range map[string]Consistency{"": Complete, "complete": Complete, "partial": Partial} {
+		c, err := ParseConsistency(input)
+		require.NoError(t, err)
+		assert.Equal(t, expected, c)
+	}
+
+	_, err := ParseConsistency("eventual")
+	assert.Error(t, err)
+}
+
+func TestPartialReport(t *testing.T) {
+	// Reads without a report are not recorded
+	RecordPartial(context.Background(), []ShardError{{Node: "node-1:7000"}})
+
+	ctx, report := WithPartialReport(context.Background())
+	assert.False(t, report.Partial())
+
+	RecordPartial(ctx, []ShardError{{Node: "node-1:7000"}, {Node: "node-0:7000"}})
+	RecordPartial(ctx, []ShardError{{Node: "node-1:7000"}})
+
+	assert.True(t, report.Partial())
+	assert.Equal(t, []string{"node-0:7000", "node-1:7000"}, report.MissingShards())
+}
@@ -0,0 +1,102 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
+)
+
+// Consistency tells what an aggregated read does with keys on shards that failed or timed out
+type Consistency int
+
+const (
+	// Complete reads the keys of failed shards from the source of truth
+	Complete Consistency = iota
+	// Partial returns the keys that came back in time and reports the failed shards
+	Partial
+)
+
+func (c Consistency) String() string {
+	if c == Partial {
+		return "partial"
+	}
+	return "complete"
+}
+
+// ParseConsistency parses a consistency preference, an empty value means Complete
+func ParseConsistency(s string) (Consistency, error) {
+	switch s {
+	case "", "complete":
+		return Complete, nil
+	case "partial":
+		return Partial, nil
+	default:
+		return Complete, fmt.Errorf("unknown consistency %q", s)
+	}
+}
+
+type consistencyKey struct{}
+
+type reportKey struct{}
+
+// WithConsistency sets the consistency preference of the reads made with ctx
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// ConsistencyFrom returns the consistency preference of ctx, Complete by default
+func ConsistencyFrom(ctx context.Context) Consistency {
+	if c, ok := ctx.Value(consistencyKey{}).(Consistency); ok {
+		return c
+	}
+	return Complete
+}
+
+// PartialReport collects the shards left out of the partial reads of one request
+type PartialReport struct {
+	mu     sync.Mutex
+	shards map[string]struct{}
+}
+
+// WithPartialReport attaches a new report to ctx for the reads made with it
+func WithPartialReport(ctx context.Context) (context.Context, *PartialReport) {
+	report := &PartialReport{shards: make(map[string]struct{})}
+	return context.WithValue(ctx, reportKey{}, report), report
+}
+
+// RecordPartial adds shards left out of a read to the report of ctx, if any
+func RecordPartial(ctx context.Context, failed []ShardError) {
+	report, ok := ctx.Value(reportKey{}).(*PartialReport)
+	if !ok || len(failed) == 0 {
+		return
+	}
+
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	for _, f := range failed {
+		report.shards[breaker.Label(f.Node)] = struct{}{}
+	}
+}
+
+// Partial reports whether some reads left shards out
+func (r *PartialReport) Partial() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.shards) > 0
+}
+
+// MissingShards returns the nodes left out, sorted
+func (r *PartialReport) MissingShards() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shards := make([]string, 0, len(r.shards))
+	for node := range r.shards {
+		shards = append(shards, node)
+	}
+	sort.Strings(shards)
+	return shards
+}
@@ -0,0 +1,167 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// GatherHedged is Gather for reads sensitive to tail latency. When a shard has not answered within
+// the hedge percentile of its node's recent latency, hedge runs as a duplicate read, typically on a
+// replica or the database. The first successful answer wins and the other read is cancelled.
+func GatherHedged[T any](ctx context.Context, a *Aggregator, keys []string, query ShardQuery[T], hedge ShardQuery[T]) Result[T] {
+	return gather(ctx, a, keys, query, hedge)
+}
+
+// ReadPipeline is Pipeline for read-only commands, hedged on a replica of slow nodes
+func (a *Aggregator) ReadPipeline(ctx context.Context, keys []string, queue PipelineQueue) Result[redis.Cmder] {
+	query := func(ctx context.Context, shard Shard) ([]redis.Cmder, error) {
+		return a.execPipeline(ctx, a.rdb, shard.Keys, queue)
+	}
+	hedge := func(ctx context.Context, shard Shard) ([]redis.Cmder, error) {
+		replica, ok := a.Replica(shard.Node)
+		if !ok {
+			return nil, errNoReplica
+		}
+		return a.execPipeline(ctx, replica, shard.Keys, queue)
+	}
+	return GatherHedged(ctx, a, keys, query, hedge)
+}
+
+var errNoReplica = errors.New("node has no replica")
+
+// Replica returns a read-only client to a replica of node, if the cluster has one
+func (a *Aggregator) Replica(node string) (redis.Cmdable, bool) {
+	if a.topology.clusterClient == nil {
+		return nil, false
+	}
+	replicas := a.topology.ReplicasOf(node)
+	if len(replicas) == 0 {
+		return nil, false
+	}
+	addr := replicas[0]
+
+	a.replicaMux.Lock()
+	defer a.replicaMux.Unlock()
+
+	if client, ok := a.replicaClients[addr]; ok {
+		return client, true
+	}
+
+	opt := a.topology.clusterClient.Options()
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Username:     opt.Username,
+		Password:     opt.Password,
+		TLSConfig:    opt.TLSConfig,
+		DialTimeout:  opt.DialTimeout,
+		ReadTimeout:  opt.ReadTimeout,
+		WriteTimeout: opt.WriteTimeout,
+		PoolSize:     opt.PoolSize,
+		// Cluster replicas only serve reads on connections in READONLY mode
+		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
+			return cn.ReadOnly(ctx).Err()
+		},
+	})
+	a.replicaClients[addr] = client
+	return client, true
+}
+
+// Close stops the topology refreshes and closes the replica clients opened for hedged reads
+func (a *Aggregator) Close() error {
+	a.topology.Close()
+
+	a.replicaMux.Lock()
+	defer a.replicaMux.Unlock()
+
+	var errs []error
+	for addr, client := range a.replicaClients {
+		errs = append(errs, client.Close())
+		delete(a.replicaClients, addr)
+	}
+	return errors.Join(errs...)
+}
+
+// hedgeDelay returns how long a shard query on node runs before it is hedged
+func (a *Aggregator) hedgeDelay(node string) (time.Duration, bool) {
+	if a.opts.HedgePercentile <= 0 {
+		return 0, false
+	}
+	delay, ok := a.latency.percentile(node, a.opts.HedgePercentile)
+	if !ok {
+		return 0, false
+	}
+	if delay < a.opts.HedgeMinDelay {
+		delay = a.opts.HedgeMinDelay
+	}
+	return delay, true
+}
+
+// shardOutcome is the answer of the primary or the hedged read of a shard
+type shardOutcome[T any] struct {
+	values []T
+	err    error
+	hedged bool
+}
+
+// runShard runs query on shard, and hedge as well once query is slower than the node usually is.
+// When both fail the outcome of query is returned.
+func runShard[T any](ctx context.Context, a *Aggregator, shard Shard, query ShardQuery[T], hedge ShardQuery[T]) ([]T, error) {
+	delay, ok := a.hedgeDelay(shard.Node)
+	if hedge == nil || !ok {
+		start := time.Now()
+		values, err := query(ctx, shard)
+		if err == nil {
+			a.latency.observe(shard.Node, time.Since(start))
+		}
+		return values, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make(chan shardOutcome[T], 2)
+	start := time.Now()
+	go func() {
+		values, err := query(ctx, shard)
+		if err == nil {
+			a.latency.observe(shard.Node, time.Since(start))
+		}
+		outcomes <- shardOutcome[T]{values: values, err: err}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	var primary *shardOutcome[T]
+	for {
+		select {
+		case <-timer.C:
+			metrics.AggregatorHedges.WithLabelValues(shard.Node).Inc()
+			pending++
+			go func() {
+				values, err := hedge(ctx, shard)
+				outcomes <- shardOutcome[T]{values: values, err: err, hedged: true}
+			}()
+		case o := <-outcomes:
+			pending--
+			if o.err == nil {
+				if o.hedged {
+					metrics.AggregatorHedgeWins.WithLabelValues(shard.Node).Inc()
+				}
+				return o.values, nil
+			}
+			if !o.hedged {
+				primary = &o
+			}
+			if pending == 0 {
+				// A query failing before its hedge is sent is not retried, hedging is about latency
+				return primary.values, primary.err
+			}
+		}
+	}
+}
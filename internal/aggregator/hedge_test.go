@@ -0,0 +1,115 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// warmLatency records enough fast queries on node for it to be hedged
+func warmLatency(a *Aggregator, node string) {
+	for i := 0; i < minLatencySamples; i++ {
+		a.latency.observe(node, time.Millisecond)
+	}
+}
+
+func TestLatencyTracker_Percentile(t *testing.T) {
+	tracker := newLatencyTracker()
+
+	_, ok := tracker.percentile("node", 0.95)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		tracker.observe("node", time.Duration(i)*time.Millisecond)
+	}
+	p95, ok := tracker.percentile("node", 0.95)
+	require.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, p95)
+
+	// Older samples leave the window
+	for i := 0; i < latencyWindowSize; i++ {
+		tracker.observe("node", time.Millisecond)
+	}
+	p95, _ = tracker.percentile("node", 0.95)
+	assert.Equal(t, time.Millisecond, p95)
+}
+
+func TestGatherHedged(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+
+	t.Run("hedge_wins", func(t *testing.T) {
+		agg := New(db, Options{HedgePercentile: 0.95})
+		warmLatency(agg, StandaloneNode)
+
+		cancelled := make(chan struct{})
+		res := GatherHedged(context.Background(), agg, []string{"a"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}, func(ctx context.Context, shard Shard) ([]string, error) {
+			return []string{"from replica"}, nil
+		})
+
+		assert.False(t, res.Partial())
+		assert.Equal(t, []string{"from replica"}, res.Values)
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("slow query was not cancelled")
+		}
+	})
+
+	t.Run("fast_query_is_not_hedged", func(t *testing.T) {
+		agg := New(db, Options{HedgePercentile: 0.95, HedgeMinDelay: time.Second})
+		warmLatency(agg, StandaloneNode)
+
+		var hedged atomic.Bool
+		res := GatherHedged(context.Background(), agg, []string{"a"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			return []string{"from primary"}, nil
+		}, func(ctx context.Context, shard Shard) ([]string, error) {
+			hedged.Store(true)
+			return []string{"from replica"}, nil
+		})
+
+		assert.Equal(t, []string{"from primary"}, res.Values)
+		assert.False(t, hedged.Load())
+	})
+
+	t.Run("both_fail", func(t *testing.T) {
+		agg := New(db, Options{HedgePercentile: 0.95})
+		warmLatency(agg, StandaloneNode)
+
+		primaryErr := errors.New("primary timeout")
+		res := GatherHedged(context.Background(), agg, []string{"a"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			time.Sleep(20 * time.Millisecond)
+			return nil, primaryErr
+		}, func(ctx context.Context, shard Shard) ([]string, error) {
+			return nil, errors.New("replica down")
+		})
+
+		require.Len(t, res.Failed, 1)
+		assert.ErrorIs(t, res.Failed[0], primaryErr)
+	})
+
+	t.Run("cold_node_is_not_hedged", func(t *testing.T) {
+		agg := New(db, Options{HedgePercentile: 0.95})
+
+		var hedged atomic.Bool
+		res := GatherHedged(context.Background(), agg, []string{"a"}, func(ctx context.Context, shard Shard) ([]string, error) {
+			time.Sleep(10 * time.Millisecond)
+			return []string{"from primary"}, nil
+		}, func(ctx context.Context, shard Shard) ([]string, error) {
+			hedged.Store(true)
+			return []string{"from replica"}, nil
+		})
+
+		assert.Equal(t, []string{"from primary"}, res.Values)
+		assert.False(t, hedged.Load())
+	})
+}
@@ -0,0 +1,71 @@
+package aggregator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWindowSize is the number of recent shard latencies kept per node
+	latencyWindowSize = 128
+	// minLatencySamples is the number of latencies a node needs before it is hedged
+	minLatencySamples = 16
+)
+
+// latencyTracker keeps a sliding window of recent shard query latencies per node
+type latencyTracker struct {
+	mu      sync.Mutex
+	windows map[string]*latencyWindow
+}
+
+// latencyWindow is a ring buffer of latencies
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{windows: make(map[string]*latencyWindow)}
+}
+
+// observe records the latency of a shard query answered by node
+func (t *latencyTracker) observe(node string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.windows[node]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+		t.windows[node] = w
+	}
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of the node's recent latencies.
+// It reports false until the node has enough samples.
+func (t *latencyTracker) percentile(node string, p float64) (time.Duration, bool) {
+	t.mu.Lock()
+	w, ok := t.windows[node]
+	if !ok || len(w.samples) < minLatencySamples {
+		t.mu.Unlock()
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	t.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank], true
+}
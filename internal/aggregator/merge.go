@@ -0,0 +1,65 @@
+package aggregator
+
+import "container/heap"
+
+// Merge k-way merges lists each sorted by less, keeping the first limit values.
+// A value equal to the previously kept one is dropped, so an item read from several shards appears once.
+// A limit of zero or less keeps every value.
+func Merge[T any](lists [][]T, less func(a, b T) bool, limit int) []T {
+	h := &mergeHeap[T]{less: less}
+	total := 0
+	for _, list := range lists {
+		if len(list) > 0 {
+			h.lists = append(h.lists, list)
+			total += len(list)
+		}
+	}
+	heap.Init(h)
+
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+	merged := make([]T, 0, limit)
+	for h.Len() > 0 && len(merged) < limit {
+		list := h.lists[0]
+		v := list[0]
+		if n := len(merged); n == 0 || less(merged[n-1], v) {
+			merged = append(merged, v)
+		}
+
+		if len(list) == 1 {
+			heap.Pop(h)
+		} else {
+			h.lists[0] = list[1:]
+			heap.Fix(h, 0)
+		}
+	}
+
+	return merged
+}
+
+// Reduce folds gathered values into a single one
+func Reduce[T, R any](values []T, init R, fn func(acc R, v T) R) R {
+	acc := init
+	for _, v := range values {
+		acc = fn(acc, v)
+	}
+	return acc
+}
+
+// mergeHeap orders non-empty lists by their head value
+type mergeHeap[T any] struct {
+	lists [][]T
+	less  func(a, b T) bool
+}
+
+func (h *mergeHeap[T]) Len() int           { return len(h.lists) }
+func (h *mergeHeap[T]) Less(i, j int) bool { return h.less(h.lists[i][0], h.lists[j][0]) }
+func (h *mergeHeap[T]) Swap(i, j int)      { h.lists[i], h.lists[j] = h.lists[j], h.lists[i] }
+func (h *mergeHeap[T]) Push(x interface{}) { h.lists = append(h.lists, x.([]T)) }
+func (h *mergeHeap[T]) Pop() interface{} {
+	n := len(h.lists)
+	x := h.lists[n-1]
+	h.lists = h.lists[:n-1]
+	return x
+}
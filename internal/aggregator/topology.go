@@ -0,0 +1,212 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	crc16_redis "github.com/sigurn/crc16"
+)
+
+// StandaloneNode groups keys when Redis is not a cluster or a slot owner is unknown
+const StandaloneNode = ""
+
+var crc16Table = crc16_redis.MakeTable(crc16_redis.CRC16_XMODEM)
+
+const (
+	// redirectRefreshInterval is the shortest interval between refreshes caused by MOVED or ASK replies,
+	// so that a resharding does not make every query reload the slot map
+	redirectRefreshInterval = time.Second
+	// refreshTimeout bounds a refresh of the slot map
+	refreshTimeout = 5 * time.Second
+)
+
+// Topology keeps the slot -> master node mapping of a Redis cluster. The mapping is refreshed every
+// interval given to RefreshEvery, and soon after a query is redirected by a MOVED or ASK reply.
+type Topology struct {
+	clusterClient *redis.ClusterClient
+	// loadSlots reads the slots of the cluster, nil when Redis is not a cluster
+	loadSlots  func(ctx context.Context) ([]redis.ClusterSlot, error)
+	slotMap    map[uint16]string   // slot -> node address
+	replicas   map[string][]string // master address -> replica addresses
+	slotMapMux sync.RWMutex
+
+	refreshMux     sync.Mutex
+	refreshing     bool
+	lastRedirected time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTopology loads the slot map when rdb is a cluster client
+func NewTopology(rdb redis.Cmdable) *Topology {
+	t := &Topology{}
+
+	if clusterClient, ok := rdb.(*redis.ClusterClient); ok {
+		t.clusterClient = clusterClient
+		t.loadSlots = func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return clusterClient.ClusterSlots(ctx).Result()
+		}
+		if err := t.Refresh(context.Background()); err != nil {
+			log.Printf("failed to initialize redis cluster slot cache: %v", err)
+		} else {
+			log.Println("Redis cluster slot cache initialized successfully.")
+		}
+	}
+
+	return t
+}
+
+// RefreshEvery reloads the slot map every interval in the background, until Close
+func (t *Topology) RefreshEvery(interval time.Duration) {
+	if t.loadSlots == nil || interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.refresh(ctx, "periodic")
+			}
+		}
+	}()
+}
+
+// Close stops the periodic refreshes
+func (t *Topology) Close() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+}
+
+// Redirected reloads the slot map in the background after a MOVED or ASK reply, which tells that slots
+// moved since it was loaded. Refreshes already running or run within redirectRefreshInterval are enough.
+func (t *Topology) Redirected() {
+	if t.loadSlots == nil {
+		return
+	}
+
+	t.refreshMux.Lock()
+	if t.refreshing || time.Since(t.lastRedirected) < redirectRefreshInterval {
+		t.refreshMux.Unlock()
+		return
+	}
+	t.refreshing = true
+	t.lastRedirected = time.Now()
+	t.refreshMux.Unlock()
+
+	go func() {
+		defer func() {
+			t.refreshMux.Lock()
+			t.refreshing = false
+			t.refreshMux.Unlock()
+		}()
+		t.refresh(context.Background(), "redirect")
+	}()
+}
+
+// refresh reloads the slot map within refreshTimeout, logging failures
+func (t *Topology) refresh(ctx context.Context, trigger string) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	if err := t.Refresh(ctx); err != nil {
+		log.Printf("failed to refresh redis cluster slot cache after %s trigger: %v", trigger, err)
+		metrics.AggregatorTopologyRefreshes.WithLabelValues(trigger, "failed").Inc()
+		return
+	}
+	metrics.AggregatorTopologyRefreshes.WithLabelValues(trigger, "refreshed").Inc()
+}
+
+// Refresh reloads the slot map from the cluster
+func (t *Topology) Refresh(ctx context.Context) error {
+	if t.loadSlots == nil {
+		return nil
+	}
+
+	slots, err := t.loadSlots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster slots: %w", err)
+	}
+
+	newSlotMap := make(map[uint16]string)
+	newReplicas := make(map[string][]string)
+	for _, slotRange := range slots {
+		if len(slotRange.Nodes) == 0 {
+			continue
+		}
+		master := slotRange.Nodes[0].Addr
+		for i := slotRange.Start; i <= slotRange.End; i++ {
+			// Map to the master node address
+			newSlotMap[uint16(i)] = master
+		}
+		if _, ok := newReplicas[master]; !ok {
+			for _, replica := range slotRange.Nodes[1:] {
+				newReplicas[master] = append(newReplicas[master], replica.Addr)
+			}
+		}
+	}
+
+	t.slotMapMux.Lock()
+	t.slotMap = newSlotMap
+	t.replicas = newReplicas
+	t.slotMapMux.Unlock()
+	return nil
+}
+
+// NodeForKey returns the address of the master node owning the key
+func (t *Topology) NodeForKey(key string) (string, bool) {
+	if t.clusterClient == nil {
+		return StandaloneNode, false
+	}
+
+	t.slotMapMux.RLock()
+	defer t.slotMapMux.RUnlock()
+	addr, ok := t.slotMap[KeySlot(key)]
+	return addr, ok
+}
+
+// ReplicasOf returns the replica addresses of a master node
+func (t *Topology) ReplicasOf(node string) []string {
+	t.slotMapMux.RLock()
+	defer t.slotMapMux.RUnlock()
+	return t.replicas[node]
+}
+
+// isRedirect reports whether err is a MOVED or ASK reply of a node no longer owning the slot
+func isRedirect(err error) bool {
+	var replyErr redis.Error
+	if !errors.As(err, &replyErr) {
+		return false
+	}
+	msg := replyErr.Error()
+	return strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ")
+}
+
+// KeySlot computes the cluster slot of a key, honouring {hash tags} as per Redis spec
+func KeySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	// CRC16 Checksum as per Redis spec for cluster key hashing.
+	return crc16_redis.Checksum([]byte(key), crc16Table) & 0x3FFF
+}
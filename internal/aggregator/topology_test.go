@@ -0,0 +1,86 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replyError is an error reply of Redis, as go-redis returns them
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+func (replyError) RedisError() {}
+
+// newLoadedTopology returns a cluster topology whose slots all move to node when reloaded, along with
+// the number of reloads
+func newLoadedTopology(node string) (*Topology, *atomic.Int32) {
+	loads := new(atomic.Int32)
+	t := &Topology{
+		clusterClient: new(redis.ClusterClient),
+		slotMap:       map[uint16]string{KeySlot("a"): "node-0:7000"},
+		loadSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			loads.Add(1)
+			return []redis.ClusterSlot{{Start: 0, End: 16383, Nodes: []redis.ClusterNode{{Addr: node}}}}, nil
+		},
+	}
+	return t, loads
+}
+
+func TestIsRedirect(t *testing.T) {
+	assert.True(t, isRedirect(replyError("MOVED 15495 node-1:7000")))
+	assert.True(t, isRedirect(replyError("ASK 15495 node-1:7000")))
+	assert.True(t, isRedirect(errors.Join(errors.New("shard failed"), replyError("MOVED 15495 node-1:7000"))))
+	assert.False(t, isRedirect(replyError("WRONGTYPE Operation against a key holding the wrong kind of value")))
+	assert.False(t, isRedirect(errors.New("MOVED 15495 node-1:7000")))
+	assert.False(t, isRedirect(redis.Nil))
+	assert.False(t, isRedirect(nil))
+}
+
+func TestTopology_RefreshesOnRedirect(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	agg := New(db, Options{})
+	topology, loads := newLoadedTopology("node-1:7000")
+	agg.topology = topology
+	get := func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.Get(ctx, key)
+	}
+
+	rdbMock.ExpectGet("a").SetErr(replyError("MOVED 15495 node-1:7000"))
+	rdbMock.ExpectGet("a").SetErr(replyError("MOVED 15495 node-1:7000"))
+
+	res := agg.Pipeline(context.Background(), []string{"a"}, get)
+	assert.False(t, res.Partial())
+	assert.Eventually(t, func() bool {
+		node, _ := agg.NodeForKey("a")
+		return node == "node-1:7000"
+	}, time.Second, time.Millisecond)
+
+	// Redirects right after a refresh do not reload the slot map again
+	agg.Pipeline(context.Background(), []string{"a"}, get)
+	assert.Equal(t, int32(1), loads.Load())
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestTopology_RefreshEvery(t *testing.T) {
+	topology, loads := newLoadedTopology("node-1:7000")
+
+	topology.RefreshEvery(time.Millisecond)
+	assert.Eventually(t, func() bool { return loads.Load() >= 2 }, time.Second, time.Millisecond)
+	topology.Close()
+
+	stopped := loads.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, loads.Load())
+	node, ok := topology.NodeForKey("a")
+	assert.True(t, ok)
+	assert.Equal(t, "node-1:7000", node)
+}
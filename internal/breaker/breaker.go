@@ -0,0 +1,172 @@
+// Package breaker implements circuit breakers for Redis nodes.
+// A circuit opens after consecutive failures, rejects calls for a cooldown, then lets a few probe
+// calls through half-open: a successful probe closes it, a failed one opens it again.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned for calls rejected by an open circuit
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// Settings tunes when a circuit opens and how it recovers
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long an open circuit rejects calls before probing
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of calls let through while half-open
+	HalfOpenProbes int
+}
+
+// Breaker is a single circuit
+type Breaker struct {
+	settings Settings
+	onChange func(State)
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// New creates a new closed Breaker. onChange, if any, is called on every state change.
+func New(settings Settings, onChange func(State)) *Breaker {
+	if settings.FailureThreshold < 1 {
+		settings.FailureThreshold = 1
+	}
+	if settings.HalfOpenProbes < 1 {
+		settings.HalfOpenProbes = 1
+	}
+	return &Breaker{
+		settings: settings,
+		onChange: onChange,
+		now:      time.Now,
+	}
+}
+
+// Allow reports whether a call may go through. An open circuit turns half-open once its cooldown
+// has passed, and every call allowed while half-open is a probe.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.settings.OpenTimeout {
+			return false
+		}
+		b.setState(StateHalfOpen)
+		b.probes = 1
+		return true
+	case StateHalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Rejecting reports whether a call would be rejected, without using up a probe
+func (b *Breaker) Rejecting() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		return b.now().Sub(b.openedAt) < b.settings.OpenTimeout
+	case StateHalfOpen:
+		return b.probes >= b.settings.HalfOpenProbes
+	default:
+		return false
+	}
+}
+
+// Success records a successful call
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == StateHalfOpen {
+		b.setState(StateClosed)
+	}
+}
+
+// Failure records a failed call
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateHalfOpen:
+		b.open()
+	case StateClosed:
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// Cancel records a call whose outcome tells nothing about the node, such as one cancelled by its
+// caller. A probe cancelled while half-open is given back.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) open() {
+	b.failures = 0
+	b.openedAt = b.now()
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
@@ -0,0 +1,166 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBreaker returns a breaker whose clock is moved by the returned function
+func newTestBreaker(settings Settings) (*Breaker, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	b := New(settings, nil)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreaker(t *testing.T) {
+	settings := Settings{FailureThreshold: 3, OpenTimeout: 5 * time.Second, HalfOpenProbes: 1}
+
+	t.Run("opens_after_consecutive_failures", func(t *testing.T) {
+		b, _ := newTestBreaker(settings)
+
+		b.Failure()
+		b.Failure()
+		b.Success()
+		b.Failure()
+		b.Failure()
+		assert.Equal(t, StateClosed, b.State())
+		assert.True(t, b.Allow())
+
+		b.Failure()
+		assert.Equal(t, StateOpen, b.State())
+		assert.True(t, b.Rejecting())
+		assert.False(t, b.Allow())
+	})
+
+	t.Run("probe_closes", func(t *testing.T) {
+		b, advance := newTestBreaker(settings)
+		for i := 0; i < settings.FailureThreshold; i++ {
+			b.Failure()
+		}
+
+		advance(settings.OpenTimeout)
+		assert.False(t, b.Rejecting())
+		require.True(t, b.Allow())
+		assert.Equal(t, StateHalfOpen, b.State())
+		// Only one probe at a time
+		assert.False(t, b.Allow())
+
+		b.Success()
+		assert.Equal(t, StateClosed, b.State())
+		assert.True(t, b.Allow())
+	})
+
+	t.Run("failed_probe_reopens", func(t *testing.T) {
+		b, advance := newTestBreaker(settings)
+		for i := 0; i < settings.FailureThreshold; i++ {
+			b.Failure()
+		}
+
+		advance(settings.OpenTimeout)
+		require.True(t, b.Allow())
+		b.Failure()
+		assert.Equal(t, StateOpen, b.State())
+		assert.False(t, b.Allow())
+
+		advance(settings.OpenTimeout)
+		assert.True(t, b.Allow())
+	})
+
+	t.Run("cancelled_probe_is_given_back", func(t *testing.T) {
+		b, advance := newTestBreaker(settings)
+		for i := 0; i < settings.FailureThreshold; i++ {
+			b.Failure()
+		}
+
+		advance(settings.OpenTimeout)
+		require.True(t, b.Allow())
+		b.Cancel()
+		assert.Equal(t, StateHalfOpen, b.State())
+		assert.True(t, b.Allow())
+	})
+}
+
+func TestGroup_Hook(t *testing.T) {
+	ctx := context.Background()
+	group := NewGroup(Settings{FailureThreshold: 2, OpenTimeout: time.Minute})
+	hook := group.Hook("node-1:6379")
+
+	run := func(err error) error {
+		cmd := redis.NewStringCmd(ctx, "get", "key")
+		if _, hookErr := hook.BeforeProcess(ctx, cmd); hookErr != nil {
+			cmd.SetErr(hookErr)
+		} else {
+			cmd.SetErr(err)
+		}
+		require.NoError(t, hook.AfterProcess(ctx, cmd))
+		return cmd.Err()
+	}
+
+	// Replies show the node is answering
+	run(redis.Nil)
+	run(redis.Nil)
+	run(context.Canceled)
+	assert.False(t, group.Open("node-1:6379"))
+
+	run(errors.New("dial tcp: connection refused"))
+	run(context.DeadlineExceeded)
+	assert.True(t, group.Open("node-1:6379"))
+	assert.ErrorIs(t, run(nil), ErrOpen)
+
+	// Other nodes keep their own circuit
+	assert.False(t, group.Open("node-2:6379"))
+	assert.Equal(t, map[string]State{"node-1:6379": StateOpen, "node-2:6379": StateClosed}, group.States())
+}
+
+func TestGroup_HookPipeline(t *testing.T) {
+	ctx := context.Background()
+	group := NewGroup(Settings{FailureThreshold: 1, OpenTimeout: time.Minute})
+	hook := group.Hook("")
+
+	ok := redis.NewStringCmd(ctx, "get", "a")
+	missing := redis.NewStringCmd(ctx, "get", "b")
+	missing.SetErr(redis.Nil)
+	_, err := hook.BeforeProcessPipeline(ctx, []redis.Cmder{ok, missing})
+	require.NoError(t, err)
+	require.NoError(t, hook.AfterProcessPipeline(ctx, []redis.Cmder{ok, missing}))
+	assert.False(t, group.Open(""))
+
+	failed := redis.NewStringCmd(ctx, "get", "a")
+	failed.SetErr(errors.New("i/o timeout"))
+	require.NoError(t, hook.AfterProcessPipeline(ctx, []redis.Cmder{failed}))
+	assert.True(t, group.Open(""))
+	assert.Equal(t, map[string]State{StandaloneLabel: StateOpen}, group.States())
+
+	_, err = hook.BeforeProcessPipeline(ctx, []redis.Cmder{ok})
+	assert.ErrorIs(t, err, ErrOpen)
+}
+
+func TestGroup_OnClose(t *testing.T) {
+	group := NewGroup(Settings{FailureThreshold: 1, OpenTimeout: 5 * time.Second})
+	closed := make(chan string, 1)
+	group.OnClose(func(node string) { closed <- node })
+
+	b := group.Breaker("node-1:6379")
+	now := time.Unix(1700000000, 0)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	now = now.Add(5 * time.Second)
+	require.True(t, b.Allow())
+	assert.Empty(t, closed)
+
+	b.Success()
+	select {
+	case node := <-closed:
+		assert.Equal(t, "node-1:6379", node)
+	case <-time.After(time.Second):
+		t.Fatal("close not notified")
+	}
+}
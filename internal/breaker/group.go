@@ -0,0 +1,165 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// StandaloneLabel names the circuit of a non-cluster Redis client, tracked under the empty node
+const StandaloneLabel = "standalone"
+
+// Group keeps one circuit per Redis node, created on first use
+type Group struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+	onClose  []func(node string)
+}
+
+// NewGroup creates a new Group whose circuits use settings
+func NewGroup(settings Settings) *Group {
+	return &Group{
+		settings: settings,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Breaker returns the circuit of node, the empty node being the standalone client
+func (g *Group) Breaker(node string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.breakers[node]
+	if !ok {
+		gauge := metrics.RedisCircuitState.WithLabelValues(Label(node))
+		gauge.Set(float64(StateClosed))
+		b = New(g.settings, func(state State) {
+			gauge.Set(float64(state))
+			if state == StateClosed {
+				g.notifyClose(node)
+			}
+		})
+		g.breakers[node] = b
+	}
+	return b
+}
+
+// OnClose registers fn to be called whenever the circuit of a node closes again. fn runs on its own
+// goroutine, as the circuit is locked while it changes state.
+func (g *Group) OnClose(fn func(node string)) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onClose = append(g.onClose, fn)
+}
+
+func (g *Group) notifyClose(node string) {
+	g.mu.Lock()
+	listeners := g.onClose
+	g.mu.Unlock()
+	for _, fn := range listeners {
+		go fn(node)
+	}
+}
+
+// Open reports whether calls to node are currently rejected. A nil Group never rejects.
+func (g *Group) Open(node string) bool {
+	if g == nil {
+		return false
+	}
+	return g.Breaker(node).Rejecting()
+}
+
+// States returns the state of every known circuit, keyed by node label
+func (g *Group) States() map[string]State {
+	states := make(map[string]State)
+	if g == nil {
+		return states
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for node, b := range g.breakers {
+		states[Label(node)] = b.State()
+	}
+	return states
+}
+
+// Label returns the name of node in metrics and reports
+func Label(node string) string {
+	if node == "" {
+		return StandaloneLabel
+	}
+	return node
+}
+
+// Hook returns a redis.Hook guarding the commands of node's client with its circuit.
+// Only connection errors and timeouts count as failures, replies such as redis.Nil or WRONGTYPE
+// show the node is answering.
+func (g *Group) Hook(node string) redis.Hook {
+	return &hook{breaker: g.Breaker(node)}
+}
+
+type hook struct {
+	breaker *Breaker
+}
+
+func (h *hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if !h.breaker.Allow() {
+		return ctx, ErrOpen
+	}
+	return ctx, nil
+}
+
+func (h *hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.record(cmd.Err())
+	return nil
+}
+
+func (h *hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if !h.breaker.Allow() {
+		return ctx, ErrOpen
+	}
+	return ctx, nil
+}
+
+func (h *hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); !isReply(cmdErr) {
+			err = cmdErr
+			break
+		}
+	}
+	h.record(err)
+	return nil
+}
+
+func (h *hook) record(err error) {
+	switch {
+	case errors.Is(err, ErrOpen):
+		// Rejected before reaching the node
+	case errors.Is(err, context.Canceled):
+		h.breaker.Cancel()
+	case isReply(err):
+		h.breaker.Success()
+	default:
+		h.breaker.Failure()
+	}
+}
+
+// isReply reports whether err, if any, was answered by the node
+func isReply(err error) bool {
+	if err == nil {
+		return true
+	}
+	var replyErr redis.Error
+	return errors.As(err, &replyErr)
+}
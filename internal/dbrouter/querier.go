@@ -0,0 +1,341 @@
+package dbrouter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// Reads by ID that miss on a replica are retried on the primary, as the row may not have been
+// replicated yet. Posts are cached as read, so those updated or deleted within the read-your-writes
+// window are read from the primary: a replica behind would put the old row back in the cache.
+
+func (r *Router) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
+	q, onReplica := r.postReader(ctx, id)
+	post, err := q.GetPost(ctx, id)
+	if onReplica && errors.Is(err, pgx.ErrNoRows) {
+		return r.fromPrimary().GetPost(ctx, id)
+	}
+	return post, err
+}
+
+func (r *Router) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	q, onReplica := r.postReader(ctx, ids...)
+	posts, err := q.GetPostsByIDs(ctx, ids)
+	if err != nil || !onReplica || len(posts) >= len(ids) {
+		return posts, err
+	}
+
+	found := make(map[int64]struct{}, len(posts))
+	for _, post := range posts {
+		found[post.ID] = struct{}{}
+	}
+	var missing []int64
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return posts, nil
+	}
+
+	rest, err := r.fromPrimary().GetPostsByIDs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	return append(posts, rest...), nil
+}
+
+func (r *Router) GetUserByID(ctx context.Context, id int64) (sqlc.User, error) {
+	q, onReplica := r.reader(ctx, id)
+	user, err := q.GetUserByID(ctx, id)
+	if onReplica && errors.Is(err, pgx.ErrNoRows) {
+		return r.fromPrimary().GetUserByID(ctx, id)
+	}
+	return user, err
+}
+
+func (r *Router) GetUserByEmail(ctx context.Context, email string) (sqlc.User, error) {
+	q, onReplica := r.reader(ctx)
+	user, err := q.GetUserByEmail(ctx, email)
+	if onReplica && errors.Is(err, pgx.ErrNoRows) {
+		return r.fromPrimary().GetUserByEmail(ctx, email)
+	}
+	return user, err
+}
+
+// fromPrimary returns the primary queries for a read retried after a replica miss
+func (r *Router) fromPrimary() *sqlc.Queries {
+	metrics.DBReads.WithLabelValues(primaryTarget).Inc()
+	return r.primary
+}
+
+func (r *Router) CountFollowers(ctx context.Context, followeeID int64) (int64, error) {
+	q, _ := r.reader(ctx, followeeID)
+	return q.CountFollowers(ctx, followeeID)
+}
+
+func (r *Router) CountFollowing(ctx context.Context, followerID int64) (int64, error) {
+	q, _ := r.reader(ctx, followerID)
+	return q.CountFollowing(ctx, followerID)
+}
+
+func (r *Router) ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error) {
+	q, _ := r.reader(ctx, followeeID)
+	return q.ListAllFollowers(ctx, followeeID)
+}
+
+func (r *Router) ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error) {
+	q, _ := r.reader(ctx, followerID)
+	return q.ListAllFollowing(ctx, followerID)
+}
+
+func (r *Router) ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	q, _ := r.reader(ctx, arg.FolloweeID)
+	return q.ListFollowers(ctx, arg)
+}
+
+func (r *Router) ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	q, _ := r.reader(ctx, arg.FollowerID)
+	return q.ListFollowing(ctx, arg)
+}
+
+func (r *Router) ListPostsByUser(ctx context.Context, arg sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
+	q, _ := r.reader(ctx, arg.UserID)
+	return q.ListPostsByUser(ctx, arg)
+}
+
+func (r *Router) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	q, _ := r.reader(ctx, arg.UserIds...)
+	return q.ListRecentPostsByUsers(ctx, arg)
+}
+
+func (r *Router) ListUsers(ctx context.Context, arg sqlc.ListUsersParams) ([]sqlc.User, error) {
+	q, _ := r.reader(ctx)
+	return q.ListUsers(ctx, arg)
+}
+
+// Writes go to the primary and open the read-your-writes window of the users they touch
+
+func (r *Router) CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (int64, error) {
+	rows, err := r.primary.CreateFollow(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.FollowerID, arg.FolloweeID)
+	}
+	return rows, err
+}
+
+func (r *Router) DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (int64, error) {
+	rows, err := r.primary.DeleteFollow(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.FollowerID, arg.FolloweeID)
+	}
+	return rows, err
+}
+
+func (r *Router) DeleteFollowsByUser(ctx context.Context, followerID int64) error {
+	err := r.primary.DeleteFollowsByUser(ctx, followerID)
+	if err == nil {
+		r.wrote(ctx, followerID)
+	}
+	return err
+}
+
+func (r *Router) CreatePost(ctx context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error) {
+	post, err := r.primary.CreatePost(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.UserID)
+	}
+	return post, err
+}
+
+func (r *Router) CreatePostsInBatch(ctx context.Context, arg []sqlc.CreatePostsInBatchParams) (int64, error) {
+	rows, err := r.primary.CreatePostsInBatch(ctx, arg)
+	if err == nil {
+		users := make([]int64, 0, len(arg))
+		for _, p := range arg {
+			users = append(users, p.UserID)
+		}
+		r.wrote(ctx, users...)
+	}
+	return rows, err
+}
+
+func (r *Router) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	post, err := r.primary.UpdatePost(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.UserID)
+		r.wrotePosts(arg.ID)
+	}
+	return post, err
+}
+
+func (r *Router) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	post, err := r.primary.DeletePost(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.UserID)
+		r.wrotePosts(arg.ID)
+	}
+	return post, err
+}
+
+// CountPostsByUser counts on the primary, as the count seeds a counter the writes after it add to
+func (r *Router) CountPostsByUser(ctx context.Context, userID int64) (int64, error) {
+	return r.primary.CountPostsByUser(ctx, userID)
+}
+
+// LockPost locks the post against its deletion, which only the primary can do
+func (r *Router) LockPost(ctx context.Context, arg sqlc.LockPostParams) (int64, error) {
+	return r.primary.LockPost(ctx, arg)
+}
+
+func (r *Router) CreateUser(ctx context.Context, arg sqlc.CreateUserParams) (sqlc.User, error) {
+	user, err := r.primary.CreateUser(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, user.ID)
+	}
+	return user, err
+}
+
+func (r *Router) UpdateUser(ctx context.Context, arg sqlc.UpdateUserParams) (sqlc.User, error) {
+	user, err := r.primary.UpdateUser(ctx, arg)
+	if err == nil {
+		r.wrote(ctx, arg.ID)
+	}
+	return user, err
+}
+
+func (r *Router) DeleteUser(ctx context.Context, id int64) error {
+	err := r.primary.DeleteUser(ctx, id)
+	if err == nil {
+		r.wrote(ctx, id)
+	}
+	return err
+}
+
+// Outbox events are only read by the relay, which runs on the primary
+func (r *Router) CreateOutboxEvent(ctx context.Context, arg sqlc.CreateOutboxEventParams) (sqlc.Outbox, error) {
+	return r.primary.CreateOutboxEvent(ctx, arg)
+}
+
+// Reactions are read back by the writes changing them and by the cache of the users' reactions, a
+// replica behind would undo a reaction in cache: they are kept on the primary like the reaction counts,
+// which seed the counters kept in Redis
+
+func (r *Router) CreateReaction(ctx context.Context, arg sqlc.CreateReactionParams) (int64, error) {
+	return r.primary.CreateReaction(ctx, arg)
+}
+
+func (r *Router) GetReaction(ctx context.Context, arg sqlc.GetReactionParams) (string, error) {
+	return r.primary.GetReaction(ctx, arg)
+}
+
+func (r *Router) GetReactionForUpdate(ctx context.Context, arg sqlc.GetReactionForUpdateParams) (string, error) {
+	return r.primary.GetReactionForUpdate(ctx, arg)
+}
+
+func (r *Router) UpdateReaction(ctx context.Context, arg sqlc.UpdateReactionParams) error {
+	return r.primary.UpdateReaction(ctx, arg)
+}
+
+func (r *Router) DeleteReaction(ctx context.Context, arg sqlc.DeleteReactionParams) (string, error) {
+	return r.primary.DeleteReaction(ctx, arg)
+}
+
+func (r *Router) ListReactedPostIDs(ctx context.Context, arg sqlc.ListReactedPostIDsParams) ([]int64, error) {
+	return r.primary.ListReactedPostIDs(ctx, arg)
+}
+
+func (r *Router) GetPostReactionCounts(ctx context.Context, arg sqlc.GetPostReactionCountsParams) ([]sqlc.PostReactionCount, error) {
+	return r.primary.GetPostReactionCounts(ctx, arg)
+}
+
+func (r *Router) AddPostReactionCounts(ctx context.Context, arg sqlc.AddPostReactionCountsParams) (int64, error) {
+	return r.primary.AddPostReactionCounts(ctx, arg)
+}
+
+// Threads are read back into the cache of the replies, a replica behind would leave the newest replies
+// out of it: they are kept on the primary like their reply counts
+
+func (r *Router) CreateReply(ctx context.Context, arg sqlc.CreateReplyParams) (sqlc.Reply, error) {
+	return r.primary.CreateReply(ctx, arg)
+}
+
+func (r *Router) GetReplyForUpdate(ctx context.Context, arg sqlc.GetReplyForUpdateParams) (sqlc.Reply, error) {
+	return r.primary.GetReplyForUpdate(ctx, arg)
+}
+
+func (r *Router) IncrementReplyCount(ctx context.Context, arg sqlc.IncrementReplyCountParams) (int64, error) {
+	return r.primary.IncrementReplyCount(ctx, arg)
+}
+
+func (r *Router) DecrementReplyCount(ctx context.Context, arg sqlc.DecrementReplyCountParams) (sqlc.Reply, error) {
+	return r.primary.DecrementReplyCount(ctx, arg)
+}
+
+func (r *Router) TombstoneReply(ctx context.Context, arg sqlc.TombstoneReplyParams) (sqlc.Reply, error) {
+	return r.primary.TombstoneReply(ctx, arg)
+}
+
+func (r *Router) DeleteReply(ctx context.Context, arg sqlc.DeleteReplyParams) error {
+	return r.primary.DeleteReply(ctx, arg)
+}
+
+func (r *Router) ListThreadReplies(ctx context.Context, arg sqlc.ListThreadRepliesParams) ([]sqlc.Reply, error) {
+	return r.primary.ListThreadReplies(ctx, arg)
+}
+
+func (r *Router) DeleteThread(ctx context.Context, arg sqlc.DeleteThreadParams) (int64, error) {
+	return r.primary.DeleteThread(ctx, arg)
+}
+
+func (r *Router) AddPostReplyCount(ctx context.Context, arg sqlc.AddPostReplyCountParams) error {
+	return r.primary.AddPostReplyCount(ctx, arg)
+}
+
+func (r *Router) GetPostReplyCount(ctx context.Context, arg sqlc.GetPostReplyCountParams) (int64, error) {
+	return r.primary.GetPostReplyCount(ctx, arg)
+}
+
+func (r *Router) DeletePostReplyCount(ctx context.Context, arg sqlc.DeletePostReplyCountParams) error {
+	return r.primary.DeletePostReplyCount(ctx, arg)
+}
+
+// Webhooks are a few rows written and read back by their administrators, and their delivery state
+// changes on every attempt: they are kept on the primary
+
+func (r *Router) CreateWebhook(ctx context.Context, arg sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	return r.primary.CreateWebhook(ctx, arg)
+}
+
+func (r *Router) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.primary.GetWebhook(ctx, id)
+}
+
+func (r *Router) ListWebhooks(ctx context.Context, arg sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	return r.primary.ListWebhooks(ctx, arg)
+}
+
+func (r *Router) ListWebhooksForEvent(ctx context.Context, arg sqlc.ListWebhooksForEventParams) ([]sqlc.Webhook, error) {
+	return r.primary.ListWebhooksForEvent(ctx, arg)
+}
+
+func (r *Router) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.primary.EnableWebhook(ctx, id)
+}
+
+func (r *Router) DeleteWebhook(ctx context.Context, id int64) (int64, error) {
+	return r.primary.DeleteWebhook(ctx, id)
+}
+
+func (r *Router) CreateWebhookDelivery(ctx context.Context, arg sqlc.CreateWebhookDeliveryParams) (int64, error) {
+	return r.primary.CreateWebhookDelivery(ctx, arg)
+}
+
+func (r *Router) ListWebhookDeliveries(ctx context.Context, arg sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	return r.primary.ListWebhookDeliveries(ctx, arg)
+}
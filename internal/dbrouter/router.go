@@ -0,0 +1,220 @@
+// Package dbrouter spreads sqlc queries over a Postgres primary and its read replicas.
+// Reads go to replicas whose replication lag is within bounds, writes and reads that must see a
+// recent write go to the primary.
+package dbrouter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// lagQuery measures how far a replica is behind its primary. A replica that replayed everything it
+// received is not lagging, even if the primary has been idle since its last transaction.
+const lagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+// Replica is a read replica of the primary
+type Replica struct {
+	// Name identifies the replica in logs and metrics, e.g. its host
+	Name string
+	DB   sqlc.DBTX
+}
+
+// Options tunes replica routing
+type Options struct {
+	// MaxLag is the replication lag beyond which a replica stops serving reads
+	MaxLag time.Duration
+	// LagCheckInterval is how often replica lag is measured
+	LagCheckInterval time.Duration
+	// ReadYourWritesWindow is how long reads by or about a user go to the primary after the user's write
+	ReadYourWritesWindow time.Duration
+}
+
+// Router is a sqlc.Querier sending reads to healthy replicas and writes to the primary
+type Router struct {
+	primary   *sqlc.Queries
+	primaryDB sqlc.DBTX
+	replicas  []*replica
+	opts      Options
+	writes    *writeTracker
+	// postWrites tracks the posts updated or deleted, by id
+	postWrites *writeTracker
+	next       atomic.Uint64
+	// tx is set on the copy of the Router bound to a transaction, holding its writes until it commits
+	tx *txWrites
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type replica struct {
+	name    string
+	db      sqlc.DBTX
+	queries *sqlc.Queries
+	// healthy is false until the first lag check passes
+	healthy atomic.Bool
+}
+
+var _ sqlc.Querier = (*Router)(nil)
+
+// New creates a new Router. Replicas serve reads once their lag has been checked, see Start.
+func New(primary sqlc.DBTX, replicas []Replica, opts Options) *Router {
+	r := &Router{
+		primary:    sqlc.New(primary),
+		primaryDB:  primary,
+		opts:       opts,
+		writes:     newWriteTracker(opts.ReadYourWritesWindow),
+		postWrites: newWriteTracker(opts.ReadYourWritesWindow),
+		stop:       make(chan struct{}),
+	}
+	for _, rep := range replicas {
+		r.replicas = append(r.replicas, &replica{name: rep.Name, db: rep.DB, queries: sqlc.New(rep.DB)})
+	}
+	return r
+}
+
+// Start checks replica lag now and then every LagCheckInterval until Stop
+func (r *Router) Start(ctx context.Context) {
+	r.CheckLag(ctx)
+	if len(r.replicas) == 0 || r.opts.LagCheckInterval <= 0 {
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.opts.LagCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.CheckLag(ctx)
+				r.writes.expire()
+				r.postWrites.expire()
+			case <-r.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the lag checks
+func (r *Router) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// CheckLag measures the lag of every replica, pulling back those too far behind or unreachable
+func (r *Router) CheckLag(ctx context.Context) {
+	for _, rep := range r.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, r.lagCheckTimeout())
+		var seconds float64
+		err := rep.db.QueryRow(checkCtx, lagQuery).Scan(&seconds)
+		cancel()
+
+		lag := time.Duration(seconds * float64(time.Second))
+		healthy := err == nil && lag <= r.opts.MaxLag
+		if err != nil {
+			log.Printf("failed to check lag of replica %s: %v", rep.name, err)
+		} else {
+			metrics.DBReplicaLag.WithLabelValues(rep.name).Set(seconds)
+		}
+
+		if rep.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Printf("replica %s is serving reads again", rep.name)
+			} else {
+				log.Printf("replica %s pulled back from reads: %s", rep.name, describeLag(lag, err))
+			}
+		}
+		if healthy {
+			metrics.DBReplicaHealthy.WithLabelValues(rep.name).Set(1)
+		} else {
+			metrics.DBReplicaHealthy.WithLabelValues(rep.name).Set(0)
+		}
+	}
+}
+
+func (r *Router) lagCheckTimeout() time.Duration {
+	if r.opts.LagCheckInterval > 0 {
+		return r.opts.LagCheckInterval
+	}
+	return time.Second
+}
+
+func describeLag(lag time.Duration, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("lag %s", lag)
+}
+
+// reader returns the queries a read should use and whether they run on a replica. Reads by the
+// session user, or about any of users, go to the primary within the read-your-writes window of
+// their writes.
+func (r *Router) reader(ctx context.Context, users ...int64) (*sqlc.Queries, bool) {
+	if !r.writes.recent(append(users, sessionUsers(ctx)...)...) {
+		n := len(r.replicas)
+		start := int(r.next.Add(1))
+		for i := 0; i < n; i++ {
+			rep := r.replicas[(start+i)%n]
+			if rep.healthy.Load() {
+				metrics.DBReads.WithLabelValues(rep.name).Inc()
+				return rep.queries, true
+			}
+		}
+	}
+
+	metrics.DBReads.WithLabelValues(primaryTarget).Inc()
+	return r.primary, false
+}
+
+// postReader returns the queries reading the posts by id and whether they run on a replica. Posts
+// written within the read-your-writes window are read from the primary.
+func (r *Router) postReader(ctx context.Context, ids ...int64) (*sqlc.Queries, bool) {
+	if r.postWrites.recent(ids...) {
+		metrics.DBReads.WithLabelValues(primaryTarget).Inc()
+		return r.primary, false
+	}
+	return r.reader(ctx)
+}
+
+// primaryTarget labels reads served by the primary
+const primaryTarget = "primary"
+
+// wrote records a write by or about users. Within a transaction it is recorded once the transaction
+// commits.
+func (r *Router) wrote(ctx context.Context, users ...int64) {
+	users = append(users, sessionUsers(ctx)...)
+	if r.tx != nil {
+		r.tx.mu.Lock()
+		defer r.tx.mu.Unlock()
+		r.tx.users = append(r.tx.users, users...)
+		return
+	}
+	r.writes.record(users...)
+}
+
+// wrotePosts records the update or deletion of posts by id. Within a transaction it is recorded once the
+// transaction commits.
+func (r *Router) wrotePosts(ids ...int64) {
+	if r.tx != nil {
+		r.tx.mu.Lock()
+		defer r.tx.mu.Unlock()
+		r.tx.posts = append(r.tx.posts, ids...)
+		return
+	}
+	r.postWrites.record(ids...)
+}
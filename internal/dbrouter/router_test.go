@@ -0,0 +1,281 @@
+package dbrouter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB serves posts from memory and records the sqlc queries it ran, lag checks aside
+type fakeDB struct {
+	lag     float64
+	posts   map[int64]sqlc.Post
+	queries []string
+}
+
+func newFakeDB(posts ...sqlc.Post) *fakeDB {
+	db := &fakeDB{posts: make(map[int64]sqlc.Post)}
+	for _, post := range posts {
+		db.posts[post.ID] = post
+	}
+	return db
+}
+
+func queryName(sql string) string {
+	// sqlc queries start with "-- name: <Name> :<kind>"
+	return strings.Fields(sql)[2]
+}
+
+func (db *fakeDB) Exec(_ context.Context, sql string, _ ...interface{}) (pgconn.CommandTag, error) {
+	db.queries = append(db.queries, queryName(sql))
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	name := queryName(sql)
+	db.queries = append(db.queries, name)
+
+	rows := &fakeRows{}
+	switch name {
+	case "GetPostsByIDs":
+		for _, id := range args[0].([]int64) {
+			if post, ok := db.posts[id]; ok {
+				rows.posts = append(rows.posts, post)
+			}
+		}
+	case "ListPostsByUser":
+		for _, post := range db.posts {
+			if post.UserID == args[0].(int64) {
+				rows.posts = append(rows.posts, post)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (db *fakeDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	if sql == lagQuery {
+		return fakeRow{values: []interface{}{db.lag}}
+	}
+
+	name := queryName(sql)
+	db.queries = append(db.queries, name)
+	switch name {
+	case "GetPost":
+		if post, ok := db.posts[args[0].(int64)]; ok {
+			return postRow(post)
+		}
+	case "CreatePost":
+		post := sqlc.Post{ID: args[0].(int64), UserID: args[1].(int64), Content: args[2].(string)}
+		db.posts[post.ID] = post
+		return postRow(post)
+	case "UpdatePost":
+		if post, ok := db.posts[args[0].(int64)]; ok && post.UserID == args[1].(int64) {
+			post.Content = args[2].(string)
+			db.posts[post.ID] = post
+			return postRow(post)
+		}
+	}
+	return fakeRow{err: pgx.ErrNoRows}
+}
+
+func (db *fakeDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	db.queries = append(db.queries, "CopyFrom")
+	return 0, nil
+}
+
+// ran returns and resets the recorded queries
+func (db *fakeDB) ran() []string {
+	queries := db.queries
+	db.queries = nil
+	return queries
+}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func postRow(post sqlc.Post) fakeRow {
+	return fakeRow{values: []interface{}{post.ID, post.UserID, post.Content, post.CreatedAt, post.UpdatedAt}}
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, value := range r.values {
+		switch d := dest[i].(type) {
+		case *int64:
+			*d = value.(int64)
+		case *float64:
+			*d = value.(float64)
+		case *string:
+			*d = value.(string)
+		case *time.Time:
+			*d = value.(time.Time)
+		}
+	}
+	return nil
+}
+
+type fakeRows struct {
+	pgx.Rows
+	posts []sqlc.Post
+	next  int
+}
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next <= len(r.posts)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	return postRow(r.posts[r.next-1]).Scan(dest...)
+}
+
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{MaxLag: time.Second, ReadYourWritesWindow: 5 * time.Second}
+
+	t.Run("reads_go_to_replicas_writes_to_primary", func(t *testing.T) {
+		primary, replica := newFakeDB(), newFakeDB(sqlc.Post{ID: 1, UserID: 7})
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+
+		// Replicas serve no reads before their lag is known
+		_, err := r.GetPost(ctx, 1)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		assert.Equal(t, []string{"GetPost"}, primary.ran())
+
+		r.CheckLag(ctx)
+		post, err := r.GetPost(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), post.UserID)
+		assert.Equal(t, []string{"GetPost"}, replica.ran())
+
+		_, err = r.CreatePost(ctx, sqlc.CreatePostParams{ID: 2, UserID: 8, Content: "hello"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"CreatePost"}, primary.ran())
+		assert.Empty(t, replica.ran())
+	})
+
+	t.Run("lagging_replica_is_pulled_back", func(t *testing.T) {
+		primary, lagging, fresh := newFakeDB(), newFakeDB(), newFakeDB()
+		r := New(primary, []Replica{{Name: "replica-1", DB: lagging}, {Name: "replica-2", DB: fresh}}, opts)
+
+		lagging.lag = 5
+		r.CheckLag(ctx)
+		for i := 0; i < 4; i++ {
+			_, err := r.ListUsers(ctx, sqlc.ListUsersParams{Limit: 10})
+			require.NoError(t, err)
+		}
+		assert.Empty(t, lagging.ran())
+		assert.Len(t, fresh.ran(), 4)
+
+		// Both replicas lagging sends reads to the primary, until one catches up
+		fresh.lag = 2
+		r.CheckLag(ctx)
+		_, err := r.ListUsers(ctx, sqlc.ListUsersParams{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListUsers"}, primary.ran())
+
+		lagging.lag = 0
+		r.CheckLag(ctx)
+		_, err = r.ListUsers(ctx, sqlc.ListUsersParams{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListUsers"}, lagging.ran())
+		assert.Empty(t, primary.ran())
+	})
+
+	t.Run("read_your_writes", func(t *testing.T) {
+		primary, replica := newFakeDB(), newFakeDB()
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		now := time.Unix(1700000000, 0)
+		r.writes.now = func() time.Time { return now }
+		r.CheckLag(ctx)
+
+		_, err := r.CreatePost(WithUser(ctx, 7), sqlc.CreatePostParams{ID: 1, UserID: 7, Content: "hello"})
+		require.NoError(t, err)
+		primary.ran()
+
+		// Reads about the writer, or by the writer, see the write
+		posts, err := r.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 7, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, posts, 1)
+		_, err = r.ListUsers(WithUser(ctx, 7), sqlc.ListUsersParams{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListPostsByUser", "ListUsers"}, primary.ran())
+
+		// Others read from replicas
+		_, err = r.ListPostsByUser(WithUser(ctx, 8), sqlc.ListPostsByUserParams{UserID: 8, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListPostsByUser"}, replica.ran())
+
+		now = now.Add(opts.ReadYourWritesWindow)
+		_, err = r.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 7, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListPostsByUser"}, replica.ran())
+		assert.Empty(t, primary.ran())
+	})
+
+	t.Run("written_posts_are_read_from_primary", func(t *testing.T) {
+		primary := newFakeDB(sqlc.Post{ID: 1, UserID: 7, Content: "hello"}, sqlc.Post{ID: 2, UserID: 7})
+		// The replica has yet to replay the update
+		replica := newFakeDB(sqlc.Post{ID: 1, UserID: 7, Content: "hello"}, sqlc.Post{ID: 2, UserID: 7})
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		now := time.Unix(1700000000, 0)
+		r.postWrites.now = func() time.Time { return now }
+		r.CheckLag(ctx)
+
+		_, err := r.UpdatePost(ctx, sqlc.UpdatePostParams{ID: 1, UserID: 7, Content: "edited"})
+		require.NoError(t, err)
+		primary.ran()
+
+		// Reads by id are cached, the old row must not be read back from the replica
+		post, err := r.GetPost(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "edited", post.Content)
+		posts, err := r.GetPostsByIDs(ctx, []int64{1, 2})
+		require.NoError(t, err)
+		assert.Len(t, posts, 2)
+		assert.Equal(t, []string{"GetPost", "GetPostsByIDs"}, primary.ran())
+
+		// Other posts are read from the replica
+		_, err = r.GetPost(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"GetPost"}, replica.ran())
+
+		now = now.Add(opts.ReadYourWritesWindow)
+		_, err = r.GetPost(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"GetPost"}, replica.ran())
+		assert.Empty(t, primary.ran())
+	})
+
+	t.Run("replica_misses_are_retried_on_primary", func(t *testing.T) {
+		primary := newFakeDB(sqlc.Post{ID: 1, UserID: 7}, sqlc.Post{ID: 2, UserID: 7})
+		replica := newFakeDB(sqlc.Post{ID: 1, UserID: 7})
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		r.CheckLag(ctx)
+
+		post, err := r.GetPost(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), post.ID)
+
+		posts, err := r.GetPostsByIDs(ctx, []int64{1, 2})
+		require.NoError(t, err)
+		assert.Len(t, posts, 2)
+		assert.Equal(t, []string{"GetPost", "GetPostsByIDs"}, replica.ran())
+		assert.Equal(t, []string{"GetPost", "GetPostsByIDs"}, primary.ran())
+	})
+}
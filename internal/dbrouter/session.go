@@ -0,0 +1,83 @@
+package dbrouter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type sessionKey struct{}
+
+// WithUser attaches the user making the request to the context, so that reads within the
+// read-your-writes window of the user's writes go to the primary
+func WithUser(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, sessionKey{}, userID)
+}
+
+func sessionUsers(ctx context.Context) []int64 {
+	if userID, ok := ctx.Value(sessionKey{}).(int64); ok {
+		return []int64{userID}
+	}
+	return nil
+}
+
+// writeTracker remembers the users who wrote within the read-your-writes window, or the posts written.
+// It is kept per process, so the window holds for requests served by the same instance.
+type writeTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	writes map[int64]time.Time // user id -> last write
+}
+
+func newWriteTracker(window time.Duration) *writeTracker {
+	return &writeTracker{
+		window: window,
+		now:    time.Now,
+		writes: make(map[int64]time.Time),
+	}
+}
+
+// record marks a write by or about users
+func (t *writeTracker) record(users ...int64) {
+	if t.window <= 0 || len(users) == 0 {
+		return
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, userID := range users {
+		t.writes[userID] = now
+	}
+}
+
+// recent reports whether any of users wrote within the window
+func (t *writeTracker) recent(users ...int64) bool {
+	if t.window <= 0 || len(users) == 0 {
+		return false
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, userID := range users {
+		if at, ok := t.writes[userID]; ok && now.Sub(at) < t.window {
+			return true
+		}
+	}
+	return false
+}
+
+// expire forgets writes older than the window
+func (t *writeTracker) expire() {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userID, at := range t.writes {
+		if now.Sub(at) >= t.window {
+			delete(t.writes, userID)
+		}
+	}
+}
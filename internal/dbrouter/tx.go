@@ -0,0 +1,52 @@
+package dbrouter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// beginner begins transactions, as *pgxpool.Pool does
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// txWrites holds the writes of a transaction until it commits
+type txWrites struct {
+	mu    sync.Mutex
+	users []int64
+	posts []int64
+}
+
+// Begin begins a transaction on the primary
+func (r *Router) Begin(ctx context.Context) (pgx.Tx, error) {
+	db, ok := r.primaryDB.(beginner)
+	if !ok {
+		return nil, fmt.Errorf("primary cannot begin transactions")
+	}
+	return db.Begin(ctx)
+}
+
+// TxQueries returns the queries running on db, a transaction begun by Begin, along with the function to
+// call once it committed. Its writes open the read-your-writes window of the users and the posts they
+// touch when it commits, and its reads run on it.
+func (r *Router) TxQueries(db sqlc.DBTX) (sqlc.Querier, func()) {
+	tx := &txWrites{}
+	bound := &Router{
+		primary:    sqlc.New(db),
+		primaryDB:  db,
+		opts:       r.opts,
+		writes:     r.writes,
+		postWrites: r.postWrites,
+		tx:         tx,
+	}
+	return bound, func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		r.writes.record(tx.users...)
+		r.postWrites.record(tx.posts...)
+	}
+}
@@ -0,0 +1,132 @@
+package dbrouter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	return &fakeTx{db: db}, nil
+}
+
+// fakeTx runs its queries on its fakeDB
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return tx.db.Query(ctx, sql, args...)
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Commit(context.Context) error   { return nil }
+func (tx *fakeTx) Rollback(context.Context) error { return nil }
+
+func TestRouter_Tx(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{MaxLag: time.Second, ReadYourWritesWindow: 5 * time.Second}
+
+	// newTxManager runs units of work on a single shard whose transactions begin through r
+	newTxManager := func(t *testing.T, r *Router) (*shard.Router, repository.TxManager) {
+		ids, err := snowflake.NewGenerator(1)
+		require.NoError(t, err)
+		shards, err := shard.New([]sqlc.Querier{r}, []shard.Beginner{r}, ids)
+		require.NoError(t, err)
+		manager := repository.NewTxManager(func(ctx context.Context) (repository.Transaction, error) {
+			tx, err := shards.BeginTx(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return tx, nil
+		}, func(q sqlc.Querier) repository.TxRepositories {
+			return repository.TxRepositories{Posts: repository.NewDBPostRepository(q)}
+		})
+		return shards, manager
+	}
+
+	t.Run("committed_writes_are_read_from_primary", func(t *testing.T) {
+		primary, replica := newFakeDB(), newFakeDB()
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		r.CheckLag(ctx)
+		shards, manager := newTxManager(t, r)
+
+		err := manager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+			_, err := repos.Posts.CreatePost(ctx, sqlc.CreatePostParams{UserID: 7, Content: "hello"})
+			require.NoError(t, err)
+			// The window opens once the transaction commits
+			_, err = shards.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 7, Limit: 10})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"ListPostsByUser"}, replica.ran())
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"CreatePost"}, primary.ran())
+
+		posts, err := shards.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 7, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, posts, 1)
+		assert.Equal(t, []string{"ListPostsByUser"}, primary.ran())
+		assert.Empty(t, replica.ran())
+	})
+
+	t.Run("committed_post_writes_are_read_from_primary", func(t *testing.T) {
+		primary := newFakeDB(sqlc.Post{ID: 1, UserID: 7, Content: "hello"})
+		// The replica has yet to replay the update
+		replica := newFakeDB(sqlc.Post{ID: 1, UserID: 7, Content: "hello"})
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		r.CheckLag(ctx)
+		shards, manager := newTxManager(t, r)
+
+		err := manager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+			_, err := repos.Posts.UpdatePost(ctx, sqlc.UpdatePostParams{ID: 1, UserID: 7, Content: "edited"})
+			return err
+		})
+		require.NoError(t, err)
+		primary.ran()
+
+		// Read by anyone, the post is read from the primary
+		post, err := shards.GetPost(WithUser(ctx, 8), 1)
+		require.NoError(t, err)
+		assert.Equal(t, "edited", post.Content)
+		assert.Equal(t, []string{"GetPostsByIDs"}, primary.ran())
+		assert.Empty(t, replica.ran())
+	})
+
+	t.Run("rolled_back_writes_are_not_recorded", func(t *testing.T) {
+		primary, replica := newFakeDB(), newFakeDB()
+		r := New(primary, []Replica{{Name: "replica-1", DB: replica}}, opts)
+		r.CheckLag(ctx)
+		shards, manager := newTxManager(t, r)
+
+		failure := errors.New("second step failed")
+		err := manager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+			_, err := repos.Posts.CreatePost(ctx, sqlc.CreatePostParams{UserID: 7, Content: "hello"})
+			require.NoError(t, err)
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+
+		_, err = shards.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 7, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ListPostsByUser"}, replica.ran())
+	})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// Default number of buckets returned, by granularity
+const (
+	defaultHourlyViewBuckets = 24
+	defaultDailyViewBuckets  = 30
+)
+
+// AnalyticsHandler handles HTTP requests for the unique views of posts and timelines.
+type AnalyticsHandler struct {
+	viewService service.ViewService
+}
+
+// NewAnalyticsHandler creates a new AnalyticsHandler.
+func NewAnalyticsHandler(viewService service.ViewService) *AnalyticsHandler {
+	return &AnalyticsHandler{viewService: viewService}
+}
+
+// ViewBucketResponse is the approximate number of distinct viewers in an hour or a day.
+type ViewBucketResponse struct {
+	Start   time.Time `json:"start"`
+	Viewers int64     `json:"viewers"`
+}
+
+// UniqueViewsResponse describes the approximate distinct viewers of a post or timeline.
+type UniqueViewsResponse struct {
+	ID          int64                `json:"id"`
+	Granularity string               `json:"granularity"`
+	Buckets     []ViewBucketResponse `json:"buckets"`
+	// Total is the distinct viewers across all buckets, not their sum
+	Total int64 `json:"total"`
+}
+
+// GetPostViews returns the unique viewers of a post by hour or day, newest first.
+// GET /api/v1/analytics/posts/:id/views?granularity=hour&buckets=24
+func (h *AnalyticsHandler) GetPostViews(c *gin.Context) {
+	h.getViews(c, "Invalid post ID format", h.viewService.GetPostViews)
+}
+
+// GetTimelineViews returns the unique viewers of the posts of a user by hour or day, newest first.
+// GET /api/v1/analytics/users/:id/views?granularity=day&buckets=30
+func (h *AnalyticsHandler) GetTimelineViews(c *gin.Context) {
+	h.getViews(c, "Invalid user ID format", h.viewService.GetTimelineViews)
+}
+
+func (h *AnalyticsHandler) getViews(c *gin.Context, invalidID string,
+	getViews func(ctx context.Context, id int64, granularity string, buckets int) (service.UniqueViews, error)) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidID})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", repository.ViewsByHour)
+	buckets := defaultHourlyViewBuckets
+	if granularity == repository.ViewsByDay {
+		buckets = defaultDailyViewBuckets
+	}
+	if raw := c.Query("buckets"); raw != "" {
+		if buckets, err = strconv.Atoi(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid buckets, must be a number"})
+			return
+		}
+	}
+
+	views, err := getViews(c.Request.Context(), id, granularity, buckets)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidViewRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count views: " + err.Error()})
+		return
+	}
+
+	res := UniqueViewsResponse{
+		ID:          id,
+		Granularity: granularity,
+		Buckets:     make([]ViewBucketResponse, 0, len(views.Buckets)),
+		Total:       views.Total,
+	}
+	for _, b := range views.Buckets {
+		res.Buckets = append(res.Buckets, ViewBucketResponse{Start: b.Start, Viewers: b.Viewers})
+	}
+	c.JSON(http.StatusOK, res)
+}
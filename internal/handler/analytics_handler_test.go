@@ -0,0 +1,89 @@
+//go:build unit
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAnalyticsRouter(analyticsHandler *AnalyticsHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/api/v1/analytics/posts/:id/views", analyticsHandler.GetPostViews)
+	router.GET("/api/v1/analytics/users/:id/views", analyticsHandler.GetTimelineViews)
+	return router
+}
+
+func TestAnalyticsHandler_GetPostViews(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.ViewService)
+	router := setupAnalyticsRouter(NewAnalyticsHandler(mockService))
+
+	t.Run("default_range", func(t *testing.T) {
+		start := time.Date(2026, time.October, 18, 14, 0, 0, 0, time.UTC)
+		mockService.On("GetPostViews", mock.Anything, int64(100), repository.ViewsByHour, defaultHourlyViewBuckets).
+			Return(service.UniqueViews{Buckets: []repository.ViewBucket{{Start: start, Viewers: 3}}, Total: 3}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/analytics/posts/100/views", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res UniqueViewsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, UniqueViewsResponse{
+			ID:          100,
+			Granularity: repository.ViewsByHour,
+			Buckets:     []ViewBucketResponse{{Start: start, Viewers: 3}},
+			Total:       3,
+		}, res)
+	})
+
+	t.Run("invalid_range", func(t *testing.T) {
+		mockService.On("GetPostViews", mock.Anything, int64(100), "week", defaultHourlyViewBuckets).
+			Return(nil, fmt.Errorf("%w: granularity must be hour or day", service.ErrInvalidViewRange)).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/analytics/posts/100/views?granularity=week", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		req, _ = http.NewRequest(http.MethodGet, "/api/v1/analytics/posts/100/views?buckets=many", nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+	mockService.AssertExpectations(t)
+}
+
+func TestAnalyticsHandler_GetTimelineViews(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.ViewService)
+	router := setupAnalyticsRouter(NewAnalyticsHandler(mockService))
+
+	mockService.On("GetTimelineViews", mock.Anything, int64(1), repository.ViewsByDay, 7).
+		Return(service.UniqueViews{Buckets: []repository.ViewBucket{}, Total: 0}, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/analytics/users/1/views?granularity=day&buckets=7", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/analytics/users/abc/views", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockService.AssertExpectations(t)
+}
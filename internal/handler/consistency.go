@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+)
+
+// readContext attaches the consistency preference given as the consistency query parameter and
+// a report of the shards left out to the request context
+func readContext(c *gin.Context) (context.Context, *aggregator.PartialReport, error) {
+	consistency, err := aggregator.ParseConsistency(c.Query("consistency"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := aggregator.WithConsistency(c.Request.Context(), consistency)
+	ctx, report := aggregator.WithPartialReport(ctx)
+	return ctx, report, nil
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// postCacheControl lets browsers and CDNs keep a single post for a short while
+	postCacheControl = "public, max-age=30, must-revalidate"
+	// viewerPostCacheControl keeps a post read by an authenticated user, which tells whether they reacted
+	// to it, out of shared caches
+	viewerPostCacheControl = "private, no-cache"
+	// timelineCacheControl lets caches store a timeline but revalidate it with its ETag on every poll
+	timelineCacheControl = "public, no-cache"
+	// trendingCacheControl lets caches share the trending posts for as long as the server keeps them
+	trendingCacheControl = "public, max-age=15"
+	// degradedHeader flags responses built from stale copies while DB was unavailable
+	degradedHeader = "X-Degraded"
+)
+
+// strongETag builds a quoted strong entity tag from the given parts
+func strongETag(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// notModified sets the caching headers and reports whether If-None-Match matches the ETag.
+// If-None-Match uses the weak comparison, so a W/ prefix on the client's tag is ignored.
+func notModified(c *gin.Context, etag string, cacheControl string) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// markStale flags a response built from stale copies, which must be neither stored nor revalidated
+func markStale(c *gin.Context) {
+	c.Header(degradedHeader, "stale")
+	c.Header("ETag", "")
+	c.Header("Cache-Control", "no-store")
+}
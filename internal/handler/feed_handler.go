@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// maxFeedLimit bounds the page size, every followee timeline is read up to the page size
+const maxFeedLimit = 100
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// FeedHandler handles HTTP requests for home feeds.
+type FeedHandler struct {
+	feedService service.FeedService
+}
+
+// NewFeedHandler creates a new FeedHandler.
+func NewFeedHandler(feedService service.FeedService) *FeedHandler {
+	return &FeedHandler{feedService: feedService}
+}
+
+// FeedResponse is a page of the home feed. NextCursor is passed back as the cursor query parameter.
+type FeedResponse struct {
+	Data       []PostResponse `json:"data"`
+	HasMore    bool           `json:"has_more"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Limit      int            `json:"limit"`
+	// Partial is set when posts on unavailable cache nodes were left out, see MissingShards
+	Partial       bool     `json:"partial,omitempty"`
+	MissingShards []string `json:"missing_shards,omitempty"`
+	// Degraded is set when posts were served from stale copies because DB was unavailable
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// GetFeed handles fetching a page of the posts of the users the user follows.
+// GET /api/v1/users/:id/feed
+func (h *FeedHandler) GetFeed(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxFeedLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, must be between 1 and %d", maxFeedLimit)})
+		return
+	}
+
+	cursor, err := decodeFeedCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	ctx, report, err := readContext(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consistency, must be complete or partial"})
+		return
+	}
+	ctx, stale := repository.WithStaleReport(ctx)
+
+	// Fetch limit + 1 items to check if there is a next page.
+	posts, err := h.feedService.ListFeed(ctx, userID, cursor, limit+1)
+	if err != nil {
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve feed: " + err.Error()})
+		return
+	}
+
+	res := FeedResponse{
+		Data:          make([]PostResponse, 0, len(posts)),
+		Limit:         limit,
+		Partial:       report.Partial(),
+		MissingShards: report.MissingShards(),
+		Degraded:      stale.Stale(),
+	}
+	if stale.Stale() {
+		markStale(c)
+	}
+	if len(posts) > limit {
+		res.HasMore = true
+		posts = posts[:limit] // Trim the extra item
+		last := posts[len(posts)-1]
+		res.NextCursor = encodeFeedCursor(repository.FeedCursor{Score: last.CreatedAt.Unix(), PostID: last.ID})
+	}
+
+	for _, post := range posts {
+		res.Data = append(res.Data, PostResponse{
+			ID:        post.ID,
+			UserID:    post.UserID,
+			Content:   post.Content,
+			CreatedAt: post.CreatedAt,
+			UpdatedAt: post.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// encodeFeedCursor makes an opaque cursor out of the last post's score and id
+func encodeFeedCursor(cursor repository.FeedCursor) string {
+	raw := strconv.FormatInt(cursor.Score, 10) + ":" + strconv.FormatInt(cursor.PostID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeFeedCursor parses a cursor made by encodeFeedCursor, an empty cursor starts from the newest post
+func decodeFeedCursor(s string) (repository.FeedCursor, error) {
+	if s == "" {
+		return repository.FeedCursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return repository.FeedCursor{}, errInvalidCursor
+	}
+	scoreStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return repository.FeedCursor{}, errInvalidCursor
+	}
+	score, err := strconv.ParseInt(scoreStr, 10, 64)
+	if err != nil {
+		return repository.FeedCursor{}, errInvalidCursor
+	}
+	postID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || postID <= 0 {
+		return repository.FeedCursor{}, errInvalidCursor
+	}
+
+	return repository.FeedCursor{Score: score, PostID: postID}, nil
+}
@@ -0,0 +1,89 @@
+//go:build unit
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedHandler_GetFeed(t *testing.T) {
+	mockService := new(servicemocks.FeedService)
+	feedHandler := NewFeedHandler(mockService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/api/v1/users/:id/feed", feedHandler.GetFeed)
+
+	t.Run("first_page", func(t *testing.T) {
+		createdAt := time.Unix(1700000000, 0)
+		posts := []sqlc.Post{
+			{ID: 3, UserID: 2, Content: "Post 3", CreatedAt: createdAt},
+			{ID: 2, UserID: 3, Content: "Post 2", CreatedAt: createdAt},
+			{ID: 1, UserID: 2, Content: "Post 1", CreatedAt: createdAt},
+		}
+		mockService.On("ListFeed", mock.Anything, int64(1), repository.FeedCursor{}, 3).Return(posts, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/feed?limit=2", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var res FeedResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Len(t, res.Data, 2)
+		assert.True(t, res.HasMore)
+
+		cursor, err := decodeFeedCursor(res.NextCursor)
+		require.NoError(t, err)
+		assert.Equal(t, repository.FeedCursor{Score: createdAt.Unix(), PostID: 2}, cursor)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("next_page", func(t *testing.T) {
+		cursor := repository.FeedCursor{Score: 1700000000, PostID: 2}
+		posts := []sqlc.Post{{ID: 1, UserID: 2, Content: "Post 1"}}
+		mockService.On("ListFeed", mock.Anything, int64(1), cursor, 21).Return(posts, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/feed?cursor="+encodeFeedCursor(cursor), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var res FeedResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Len(t, res.Data, 1)
+		assert.False(t, res.HasMore)
+		assert.Empty(t, res.NextCursor)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_cursor", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/feed?cursor=not-a-cursor", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("limit_too_large", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/feed?limit=1000", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// foreignKeyViolation is the Postgres error code raised when a referenced user does not exist.
+const foreignKeyViolation = "23503"
+
+// maxFollowLimit bounds the page size of followers and followees
+const maxFollowLimit = 100
+
+// FollowHandler handles HTTP requests for the follow graph.
+type FollowHandler struct {
+	followService service.FollowService
+}
+
+// NewFollowHandler creates a new FollowHandler.
+func NewFollowHandler(followService service.FollowService) *FollowHandler {
+	return &FollowHandler{followService: followService}
+}
+
+// FollowRequest defines the expected request body for following a user.
+type FollowRequest struct {
+	FolloweeID int64 `json:"followee_id" binding:"required"`
+}
+
+// FollowResponse describes one side of a follow relationship.
+type FollowResponse struct {
+	UserID     int64     `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// PaginatedFollowsResponse is a page of followers or followed users.
+type PaginatedFollowsResponse struct {
+	Data    []FollowResponse `json:"data"`
+	Total   int64            `json:"total"`
+	HasMore bool             `json:"has_more"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+// Follow makes the authenticated user follow another user.
+// POST /api/v1/users/:id/following
+func (h *FollowHandler) Follow(c *gin.Context) {
+	followerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+	if !authorizeUser(c, followerID) {
+		return
+	}
+
+	var req FollowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	created, err := h.followService.Follow(c.Request.Context(), followerID, req.FolloweeID)
+	if err != nil {
+		if errors.Is(err, service.ErrSelfFollow) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow user: " + err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"follower_id": followerID, "followee_id": req.FolloweeID})
+}
+
+// Unfollow removes a follow relationship of the authenticated user.
+// DELETE /api/v1/users/:id/following/:followee_id
+func (h *FollowHandler) Unfollow(c *gin.Context) {
+	followerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+	followeeID, err := strconv.ParseInt(c.Param("followee_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid followee ID format"})
+		return
+	}
+	if !authorizeUser(c, followerID) {
+		return
+	}
+
+	deleted, err := h.followService.Unfollow(c.Request.Context(), followerID, followeeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow user: " + err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Follow relationship not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListFollowers handles fetching a page of the user's followers.
+// GET /api/v1/users/:id/followers
+func (h *FollowHandler) ListFollowers(c *gin.Context) {
+	userID, limit, offset, ok := parseFollowPage(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	total, err := h.followService.CountFollowers(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count followers: " + err.Error()})
+		return
+	}
+
+	follows, err := h.followService.ListFollowers(ctx, sqlc.ListFollowersParams{
+		FolloweeID: userID,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve followers: " + err.Error()})
+		return
+	}
+
+	data := make([]FollowResponse, 0, len(follows))
+	for _, f := range follows {
+		data = append(data, FollowResponse{UserID: f.FollowerID, FollowedAt: f.CreatedAt})
+	}
+	c.JSON(http.StatusOK, newPaginatedFollowsResponse(data, total, limit, offset))
+}
+
+// ListFollowing handles fetching a page of the users the user follows.
+// GET /api/v1/users/:id/following
+func (h *FollowHandler) ListFollowing(c *gin.Context) {
+	userID, limit, offset, ok := parseFollowPage(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	total, err := h.followService.CountFollowing(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count following: " + err.Error()})
+		return
+	}
+
+	follows, err := h.followService.ListFollowing(ctx, sqlc.ListFollowingParams{
+		FollowerID: userID,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve following: " + err.Error()})
+		return
+	}
+
+	data := make([]FollowResponse, 0, len(follows))
+	for _, f := range follows {
+		data = append(data, FollowResponse{UserID: f.FolloweeID, FollowedAt: f.CreatedAt})
+	}
+	c.JSON(http.StatusOK, newPaginatedFollowsResponse(data, total, limit, offset))
+}
+
+func parseFollowPage(c *gin.Context) (int64, int, int, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return 0, 0, 0, false
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxFollowLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, must be between 1 and %d", maxFollowLimit)})
+		return 0, 0, 0, false
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return 0, 0, 0, false
+	}
+
+	return userID, limit, offset, true
+}
+
+func newPaginatedFollowsResponse(data []FollowResponse, total int64, limit int, offset int) PaginatedFollowsResponse {
+	return PaginatedFollowsResponse{
+		Data:    data,
+		Total:   total,
+		HasMore: int64(offset+len(data)) < total,
+		Limit:   limit,
+		Offset:  offset,
+	}
+}
@@ -0,0 +1,203 @@
+//go:build unit
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFollowHandler_Follow(t *testing.T) {
+	mockService := new(servicemocks.FollowService)
+	followHandler := NewFollowHandler(mockService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(middleware.Identity(testGateways))
+	router.POST("/api/v1/users/:id/following", followHandler.Follow)
+
+	followAs := func(userID, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/1/following", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	follow := func(body string) *httptest.ResponseRecorder {
+		return followAs("1", body)
+	}
+
+	t.Run("created", func(t *testing.T) {
+		mockService.On("Follow", mock.Anything, int64(1), int64(2)).Return(true, nil).Once()
+
+		rr := follow(`{"followee_id":2}`)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("already_following", func(t *testing.T) {
+		mockService.On("Follow", mock.Anything, int64(1), int64(2)).Return(false, nil).Once()
+
+		rr := follow(`{"followee_id":2}`)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("self_follow", func(t *testing.T) {
+		mockService.On("Follow", mock.Anything, int64(1), int64(1)).Return(false, service.ErrSelfFollow).Once()
+
+		rr := follow(`{"followee_id":1}`)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("unknown_user", func(t *testing.T) {
+		mockService.On("Follow", mock.Anything, int64(1), int64(99)).
+			Return(false, &pgconn.PgError{Code: foreignKeyViolation}).Once()
+
+		rr := follow(`{"followee_id":99}`)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_payload", func(t *testing.T) {
+		rr := follow(`{}`)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rr := followAs("", `{"followee_id":2}`)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		mockService.AssertNumberOfCalls(t, "Follow", 4)
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		rr := followAs("3", `{"followee_id":2}`)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		mockService.AssertNumberOfCalls(t, "Follow", 4)
+	})
+}
+
+func TestFollowHandler_Unfollow(t *testing.T) {
+	mockService := new(servicemocks.FollowService)
+	followHandler := NewFollowHandler(mockService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(middleware.Identity(testGateways))
+	router.DELETE("/api/v1/users/:id/following/:followee_id", followHandler.Unfollow)
+
+	unfollow := func(userID, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodDelete, path, nil)
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockService.On("Unfollow", mock.Anything, int64(1), int64(2)).Return(true, nil).Once()
+
+		rr := unfollow("1", "/api/v1/users/1/following/2")
+
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("not_following", func(t *testing.T) {
+		mockService.On("Unfollow", mock.Anything, int64(1), int64(3)).Return(false, nil).Once()
+
+		rr := unfollow("1", "/api/v1/users/1/following/3")
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rr := unfollow("", "/api/v1/users/1/following/2")
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		mockService.AssertNumberOfCalls(t, "Unfollow", 2)
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		rr := unfollow("3", "/api/v1/users/1/following/2")
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		mockService.AssertNumberOfCalls(t, "Unfollow", 2)
+	})
+}
+
+func TestFollowHandler_ListFollowers(t *testing.T) {
+	mockService := new(servicemocks.FollowService)
+	followHandler := NewFollowHandler(mockService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/api/v1/users/:id/followers", followHandler.ListFollowers)
+
+	t.Run("success", func(t *testing.T) {
+		followedAt := time.Now().UTC().Truncate(time.Second)
+		follows := []sqlc.Follow{
+			{FollowerID: 3, FolloweeID: 1, CreatedAt: followedAt},
+			{FollowerID: 2, FolloweeID: 1, CreatedAt: followedAt},
+		}
+
+		mockService.On("CountFollowers", mock.Anything, int64(1)).Return(int64(5), nil).Once()
+		mockService.On("ListFollowers", mock.Anything, sqlc.ListFollowersParams{FolloweeID: 1, Limit: 2, Offset: 0}).
+			Return(follows, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/followers?limit=2", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var res PaginatedFollowsResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		assert.Equal(t, []FollowResponse{
+			{UserID: 3, FollowedAt: followedAt},
+			{UserID: 2, FollowedAt: followedAt},
+		}, res.Data)
+		assert.Equal(t, int64(5), res.Total)
+		assert.True(t, res.HasMore)
+
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_limit", func(t *testing.T) {
+		for _, limit := range []string{"0", "101"} {
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/followers?limit="+limit, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		}
+	})
+}
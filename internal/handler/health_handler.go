@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+const (
+	readyPingTimeout = 2 * time.Second
+	// overloadRetryAfter is the Retry-After, in seconds, of reads shed while the cache or DB is down
+	overloadRetryAfter = "1"
+)
+
+// Pinger checks that a backing store is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	db       Pinger
+	breakers *breaker.Group
+}
+
+func NewHealthHandler(db Pinger, breakers *breaker.Group) *HealthHandler {
+	return &HealthHandler{db: db, breakers: breakers}
+}
+
+type ReadyResponse struct {
+	// Status is "ready", "degraded" when some Redis circuits are not closed, or "unavailable"
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	// Redis holds the circuit state of every Redis node
+	Redis map[string]string `json:"redis"`
+}
+
+// Ready reports whether the server can take traffic. Open Redis circuits only degrade it, reads
+// are then served from DB, while an unreachable DB makes it unavailable.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	res := ReadyResponse{Status: "ready", Database: "up", Redis: make(map[string]string)}
+
+	for node, state := range h.breakers.States() {
+		res.Redis[node] = state.String()
+		if state != breaker.StateClosed {
+			res.Status = "degraded"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyPingTimeout)
+	defer cancel()
+	if err := h.db.Ping(ctx); err != nil {
+		res.Status = "unavailable"
+		res.Database = "down"
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// serviceUnavailable answers reads that failed because DB is saturated while the cache is down, or
+// unreachable without a stale copy to stand in. It reports false for any other error.
+func serviceUnavailable(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, repository.ErrDBOverloaded):
+		c.Header("Retry-After", overloadRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily overloaded, please retry"})
+	case errors.Is(err, repository.ErrDBUnavailable):
+		c.Header("Retry-After", overloadRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database temporarily unavailable, please retry"})
+	default:
+		return false
+	}
+	return true
+}
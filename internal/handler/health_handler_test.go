@@ -0,0 +1,77 @@
+//go:build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubPinger struct {
+	err error
+}
+
+func (p stubPinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestHealthHandler_Ready(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	serve := func(h *HealthHandler) (*httptest.ResponseRecorder, ReadyResponse) {
+		router := gin.Default()
+		router.GET("/ready", h.Ready)
+
+		req, _ := http.NewRequest(http.MethodGet, "/ready", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var res ReadyResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		return rr, res
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		breakers := breaker.NewGroup(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute})
+		breakers.Breaker("redis-1:7001")
+
+		rr, res := serve(NewHealthHandler(stubPinger{}, breakers))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "ready", res.Status)
+		assert.Equal(t, map[string]string{"redis-1:7001": "closed"}, res.Redis)
+	})
+
+	t.Run("degraded", func(t *testing.T) {
+		breakers := breaker.NewGroup(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute})
+		breakers.Breaker("redis-1:7001")
+		breakers.Breaker("redis-2:7002").Failure()
+
+		rr, res := serve(NewHealthHandler(stubPinger{}, breakers))
+
+		// Reads are served from DB while a node is down
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "degraded", res.Status)
+		assert.Equal(t, map[string]string{"redis-1:7001": "closed", "redis-2:7002": "open"}, res.Redis)
+	})
+
+	t.Run("database_down", func(t *testing.T) {
+		breakers := breaker.NewGroup(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+		rr, res := serve(NewHealthHandler(stubPinger{err: errors.New("connection refused")}, breakers))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "unavailable", res.Status)
+		assert.Equal(t, "down", res.Database)
+	})
+}
@@ -1,21 +1,28 @@
 package handler
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
 	"github.com/n1207n/cache-query-aggregator/internal/service"
 )
 
 type PostHandler struct {
-	postService service.PostService
+	postService     service.PostService
+	reactionService service.ReactionService
+	viewService     service.ViewService
 }
 
-func NewPostHandler(postService service.PostService) *PostHandler {
-	return &PostHandler{postService: postService}
+func NewPostHandler(postService service.PostService, reactionService service.ReactionService, viewService service.ViewService) *PostHandler {
+	return &PostHandler{postService: postService, reactionService: reactionService, viewService: viewService}
 }
 
 type CreatePostRequest struct {
@@ -23,12 +30,22 @@ type CreatePostRequest struct {
 	Content string `json:"content" binding:"required"`
 }
 
+type UpdatePostRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
 type PostResponse struct {
 	ID        int64     `json:"id"`
 	UserID    int64     `json:"user_id"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Reactions counts every reaction to the post, on single post reads
+	Reactions map[string]int64 `json:"reactions,omitempty"`
+	// Reacted tells whether the authenticated user reacted to the post, on single post reads
+	Reacted *bool `json:"reacted,omitempty"`
+	// Degraded is set when the post was served from a stale copy because DB was unavailable
+	Degraded bool `json:"degraded,omitempty"`
 }
 
 func (h *PostHandler) CreatePost(c *gin.Context) {
@@ -60,11 +77,177 @@ func (h *PostHandler) CreatePost(c *gin.Context) {
 	c.JSON(http.StatusCreated, res)
 }
 
+func (h *PostHandler) GetPost(c *gin.Context) {
+	postIDStr := c.Param("id")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return
+	}
+
+	ctx, stale := repository.WithStaleReport(c.Request.Context())
+	post, err := h.postService.GetPost(ctx, postID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post: " + err.Error()})
+		return
+	}
+
+	viewerID, _ := middleware.AuthUserID(c)
+	if viewerID != 0 {
+		if err := h.viewService.RecordPostView(c.Request.Context(), post.ID, viewerID); err != nil {
+			log.Printf("failed to record view of post %d: %v", post.ID, err)
+		}
+	}
+
+	res := PostResponse{
+		ID:        post.ID,
+		UserID:    post.UserID,
+		Content:   post.Content,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+		Degraded:  stale.Stale(),
+	}
+	etagParts := []string{"post", strconv.FormatInt(post.ID, 10), strconv.FormatInt(post.UpdatedAt.UnixNano(), 10)}
+	cacheControl := postCacheControl
+
+	// The post is served without its reactions when they cannot be read
+	if reactions, err := h.reactionService.GetPostReactions(c.Request.Context(), post, viewerID); err != nil {
+		log.Printf("failed to get reactions to post %d: %v", post.ID, err)
+	} else {
+		res.Reactions = reactions.Counts
+		for _, reaction := range repository.ReactionTypes {
+			etagParts = append(etagParts, strconv.FormatInt(reactions.Counts[reaction], 10))
+		}
+		if viewerID != 0 {
+			res.Reacted = &reactions.Reacted
+			etagParts = append(etagParts, strconv.FormatInt(viewerID, 10), strconv.FormatBool(reactions.Reacted))
+			cacheControl = viewerPostCacheControl
+		}
+	}
+
+	if stale.Stale() {
+		markStale(c)
+	} else if notModified(c, strongETag(etagParts...), cacheControl) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// UpdatePost changes the content of a post of the authenticated user.
+// PATCH /api/v1/users/:id/posts/:post_id
+func (h *PostHandler) UpdatePost(c *gin.Context) {
+	userID, postID, ok := parseUserPost(c)
+	if !ok || !authorizeUser(c, userID) {
+		return
+	}
+
+	var req UpdatePostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	post, err := h.postService.UpdatePost(c.Request.Context(), sqlc.UpdatePostParams{
+		ID:      postID,
+		UserID:  userID,
+		Content: req.Content,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, PostResponse{
+		ID:        post.ID,
+		UserID:    post.UserID,
+		Content:   post.Content,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+	})
+}
+
+// DeletePost deletes a post of the authenticated user.
+// DELETE /api/v1/users/:id/posts/:post_id
+func (h *PostHandler) DeletePost(c *gin.Context) {
+	userID, postID, ok := parseUserPost(c)
+	if !ok || !authorizeUser(c, userID) {
+		return
+	}
+
+	_, err := h.postService.DeletePost(c.Request.Context(), sqlc.DeletePostParams{ID: postID, UserID: userID})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post: " + err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// parseUserPost reads the user and post ids of the path, answering 400 when either is malformed
+func parseUserPost(c *gin.Context) (userID, postID int64, ok bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return 0, 0, false
+	}
+	postID, err = strconv.ParseInt(c.Param("post_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return 0, 0, false
+	}
+	return userID, postID, true
+}
+
+// authenticatedUser returns the user of the request, answering 401 to anonymous requests
+func authenticatedUser(c *gin.Context) (int64, bool) {
+	authUserID, ok := middleware.AuthUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing " + middleware.UserIDHeader + " header"})
+		return 0, false
+	}
+	return authUserID, true
+}
+
+// authorizeUser answers 401 to anonymous requests and 403 to those of another user than userID
+func authorizeUser(c *gin.Context, userID int64) bool {
+	authUserID, ok := authenticatedUser(c)
+	if !ok {
+		return false
+	}
+	if authUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot act on behalf of another user"})
+		return false
+	}
+	return true
+}
+
 type PaginatedPostsResponse struct {
 	Data    []PostResponse `json:"data"`
 	HasMore bool           `json:"has_more"`
 	Limit   int            `json:"limit"`
 	Offset  int            `json:"offset"`
+	// Partial is set when posts on unavailable cache nodes were left out, see MissingShards
+	Partial       bool     `json:"partial,omitempty"`
+	MissingShards []string `json:"missing_shards,omitempty"`
+	// Degraded is set when posts were served from stale copies because DB was unavailable
+	Degraded bool `json:"degraded,omitempty"`
 }
 
 func (h *PostHandler) ListPostsByUser(c *gin.Context) {
@@ -78,6 +261,29 @@ func (h *PostHandler) ListPostsByUser(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
+	ctx, report, err := readContext(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consistency, must be complete or partial"})
+		return
+	}
+	ctx, stale := repository.WithStaleReport(ctx)
+	page := []string{strconv.FormatInt(userID, 10), strconv.Itoa(limit), strconv.Itoa(offset)}
+
+	// Check the timeline version before any post body is fetched
+	version, err := h.postService.GetTimelineVersion(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("failed to get timeline version for user %d: %v", userID, err)
+		version = 0
+	}
+	if version != 0 {
+		etag := strongETag(append([]string{"timeline", strconv.FormatInt(version, 10)}, page...)...)
+		if notModified(c, etag, timelineCacheControl) {
+			h.recordTimelineView(c, userID)
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
 	// Fetch limit + 1 items to check if there is a next page.
 	params := sqlc.ListPostsByUserParams{
 		UserID: userID,
@@ -85,11 +291,15 @@ func (h *PostHandler) ListPostsByUser(c *gin.Context) {
 		Offset: int32(offset),
 	}
 
-	posts, err := h.postService.ListPostsByUser(c.Request.Context(), params)
+	posts, err := h.postService.ListPostsByUser(ctx, params)
 	if err != nil {
+		if serviceUnavailable(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts: " + err.Error()})
 		return
 	}
+	h.recordTimelineView(c, userID)
 
 	hasMore := false
 	if len(posts) > limit {
@@ -97,6 +307,24 @@ func (h *PostHandler) ListPostsByUser(c *gin.Context) {
 		posts = posts[:limit] // Trim the extra item
 	}
 
+	if stale.Stale() {
+		markStale(c)
+	} else if report.Partial() {
+		// A partial page must never be revalidated as the complete one
+		c.Header("ETag", "")
+		c.Header("Cache-Control", "no-store")
+	} else if version == 0 {
+		// Without a version, derive the ETag from the posts themselves
+		parts := append([]string{"posts", strconv.FormatBool(hasMore)}, page...)
+		for _, post := range posts {
+			parts = append(parts, strconv.FormatInt(post.ID, 10), strconv.FormatInt(post.UpdatedAt.UnixNano(), 10))
+		}
+		if notModified(c, strongETag(parts...), timelineCacheControl) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
 	var postResponses []PostResponse
 	for _, post := range posts {
 		postResponses = append(postResponses, PostResponse{
@@ -109,9 +337,24 @@ func (h *PostHandler) ListPostsByUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, PaginatedPostsResponse{
-		Data:    postResponses,
-		HasMore: hasMore,
-		Limit:   limit,
-		Offset:  offset,
+		Data:          postResponses,
+		HasMore:       hasMore,
+		Limit:         limit,
+		Offset:        offset,
+		Partial:       report.Partial(),
+		MissingShards: report.MissingShards(),
+		Degraded:      stale.Stale(),
 	})
 }
+
+// recordTimelineView records a view of the posts of userID by the authenticated user, if any. A view that
+// cannot be recorded is left out of the unique views.
+func (h *PostHandler) recordTimelineView(c *gin.Context, userID int64) {
+	viewerID, ok := middleware.AuthUserID(c)
+	if !ok {
+		return
+	}
+	if err := h.viewService.RecordTimelineView(c.Request.Context(), userID, viewerID); err != nil {
+		log.Printf("failed to record view of timeline of user %d: %v", userID, err)
+	}
+}
@@ -4,22 +4,40 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
 	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// testGateways trusts the gateway the authenticated requests of the tests come from
+var testGateways = []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+
+// authenticate makes the request come from the gateway on behalf of the user
+func authenticate(req *http.Request, userID string) {
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set(middleware.UserIDHeader, userID)
+}
+
 func TestPostHandler_CreatePost(t *testing.T) {
 	mockService := new(servicemocks.PostService)
-	postHandler := NewPostHandler(mockService)
+	postHandler := NewPostHandler(mockService, nil, nil)
 
 	gin.SetMode(gin.TestMode)
 
@@ -75,9 +93,108 @@ func TestPostHandler_CreatePost(t *testing.T) {
 	})
 }
 
+func TestPostHandler_UpdatePost(t *testing.T) {
+	mockService := new(servicemocks.PostService)
+	postHandler := NewPostHandler(mockService, nil, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(middleware.Identity(testGateways))
+	router.PATCH("/api/v1/users/:id/posts/:post_id", postHandler.UpdatePost)
+
+	updatePost := func(path, userID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPatch, path, bytes.NewBufferString(`{"content":"edited"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("success", func(t *testing.T) {
+		params := sqlc.UpdatePostParams{ID: 10, UserID: 1, Content: "edited"}
+		mockService.On("UpdatePost", mock.Anything, params).Return(sqlc.Post{ID: 10, UserID: 1, Content: "edited"}, nil).Once()
+
+		rr := updatePost("/api/v1/users/1/posts/10", "1")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var resPost PostResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resPost))
+		assert.Equal(t, "edited", resPost.Content)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		mockService.On("UpdatePost", mock.Anything, mock.Anything).Return(nil, pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, updatePost("/api/v1/users/2/posts/10", "2").Code)
+	})
+
+	t.Run("invalid_post_id", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, updatePost("/api/v1/users/1/posts/abc", "1").Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, updatePost("/api/v1/users/1/posts/10", "").Code)
+		mockService.AssertNumberOfCalls(t, "UpdatePost", 2)
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, updatePost("/api/v1/users/1/posts/10", "2").Code)
+		mockService.AssertNumberOfCalls(t, "UpdatePost", 2)
+	})
+}
+
+func TestPostHandler_DeletePost(t *testing.T) {
+	mockService := new(servicemocks.PostService)
+	postHandler := NewPostHandler(mockService, nil, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(middleware.Identity(testGateways))
+	router.DELETE("/api/v1/users/:id/posts/:post_id", postHandler.DeletePost)
+
+	deletePost := func(path, userID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodDelete, path, nil)
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("success", func(t *testing.T) {
+		params := sqlc.DeletePostParams{ID: 10, UserID: 1}
+		mockService.On("DeletePost", mock.Anything, params).Return(sqlc.Post{ID: 10, UserID: 1}, nil).Once()
+
+		assert.Equal(t, http.StatusNoContent, deletePost("/api/v1/users/1/posts/10", "1").Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		mockService.On("DeletePost", mock.Anything, mock.Anything).Return(nil, pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, deletePost("/api/v1/users/1/posts/11", "1").Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, deletePost("/api/v1/users/1/posts/10", "").Code)
+		mockService.AssertNumberOfCalls(t, "DeletePost", 2)
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, deletePost("/api/v1/users/1/posts/10", "2").Code)
+		mockService.AssertNumberOfCalls(t, "DeletePost", 2)
+	})
+}
+
 func TestPostHandler_ListPostsByUser(t *testing.T) {
 	mockService := new(servicemocks.PostService)
-	postHandler := NewPostHandler(mockService)
+	mockViews := new(servicemocks.ViewService)
+	postHandler := NewPostHandler(mockService, nil, mockViews)
 
 	gin.SetMode(gin.TestMode)
 
@@ -88,6 +205,7 @@ func TestPostHandler_ListPostsByUser(t *testing.T) {
 			{ID: 2, UserID: userID, Content: "Post 2"},
 		}
 
+		mockService.On("GetTimelineVersion", mock.Anything, userID).Return(int64(0), nil).Once()
 		mockService.On("ListPostsByUser", mock.Anything, mock.MatchedBy(func(params sqlc.ListPostsByUserParams) bool {
 			return params.UserID == userID
 		})).Return(expectedPosts, nil).Once()
@@ -106,10 +224,114 @@ func TestPostHandler_ListPostsByUser(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Len(t, res.Data, 2)
 		assert.Equal(t, expectedPosts[0].Content, res.Data[0].Content)
+		assert.NotEmpty(t, rr.Header().Get("ETag"))
+		assert.Equal(t, timelineCacheControl, rr.Header().Get("Cache-Control"))
+
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("records_view", func(t *testing.T) {
+		userID := int64(1)
+		mockService.On("GetTimelineVersion", mock.Anything, userID).Return(int64(0), nil).Once()
+		mockService.On("ListPostsByUser", mock.Anything, mock.Anything).Return([]sqlc.Post{}, nil).Once()
+		mockViews.On("RecordTimelineView", mock.Anything, userID, int64(5)).Return(nil).Once()
+
+		router := gin.Default()
+		router.Use(middleware.Identity(testGateways))
+		router.GET("/api/v1/users/:id/posts", postHandler.ListPostsByUser)
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+		authenticate(req, "5")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockViews.AssertExpectations(t)
+	})
+
+	t.Run("not_modified_by_version", func(t *testing.T) {
+		userID := int64(1)
+		mockService.On("GetTimelineVersion", mock.Anything, userID).Return(int64(42), nil).Once()
+
+		router := gin.Default()
+		router.GET("/api/v1/users/:id/posts", postHandler.ListPostsByUser)
+
+		etag := strongETag("timeline", "42", "1", "10", "0")
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+		req.Header.Set("If-None-Match", etag)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("not_modified_by_content", func(t *testing.T) {
+		userID := int64(2)
+		expectedPosts := []sqlc.Post{{ID: 3, UserID: userID, Content: "Post 3", UpdatedAt: time.Now()}}
+
+		mockService.On("GetTimelineVersion", mock.Anything, userID).Return(int64(0), nil).Twice()
+		mockService.On("ListPostsByUser", mock.Anything, mock.MatchedBy(func(params sqlc.ListPostsByUserParams) bool {
+			return params.UserID == userID
+		})).Return(expectedPosts, nil).Twice()
+
+		router := gin.Default()
+		router.GET("/api/v1/users/:id/posts", postHandler.ListPostsByUser)
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/2/posts", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		req, _ = http.NewRequest(http.MethodGet, "/api/v1/users/2/posts", nil)
+		req.Header.Set("If-None-Match", "W/"+etag)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
 
+		assert.Equal(t, http.StatusNotModified, rr.Code)
 		mockService.AssertExpectations(t)
 	})
 
+	t.Run("partial", func(t *testing.T) {
+		userID := int64(3)
+		mockService.On("GetTimelineVersion", mock.Anything, userID).Return(int64(7), nil).Once()
+		mockService.On("ListPostsByUser", mock.MatchedBy(func(ctx context.Context) bool {
+			return aggregator.ConsistencyFrom(ctx) == aggregator.Partial
+		}), mock.Anything).Run(func(args mock.Arguments) {
+			aggregator.RecordPartial(args.Get(0).(context.Context), []aggregator.ShardError{{Node: "redis-2:7002"}})
+		}).Return([]sqlc.Post{{ID: 4, UserID: userID}}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/3/posts?consistency=partial", nil)
+		rr := httptest.NewRecorder()
+		router := gin.Default()
+		router.GET("/api/v1/users/:id/posts", postHandler.ListPostsByUser)
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res PaginatedPostsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.True(t, res.Partial)
+		assert.Equal(t, []string{"redis-2:7002"}, res.MissingShards)
+		assert.Empty(t, rr.Header().Get("ETag"))
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_consistency", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts?consistency=eventual", nil)
+		rr := httptest.NewRecorder()
+		router := gin.Default()
+		router.GET("/api/v1/users/:id/posts", postHandler.ListPostsByUser)
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
 	t.Run("invalid_user_id", func(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/abc/posts", nil)
 		rr := httptest.NewRecorder()
@@ -121,3 +343,160 @@ func TestPostHandler_ListPostsByUser(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 	})
 }
+
+func TestPostHandler_GetPost(t *testing.T) {
+	mockService := new(servicemocks.PostService)
+	mockReactions := new(servicemocks.ReactionService)
+	mockViews := new(servicemocks.ViewService)
+	postHandler := NewPostHandler(mockService, mockReactions, mockViews)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(middleware.Identity(testGateways))
+	router.GET("/api/v1/posts/:id", postHandler.GetPost)
+
+	t.Run("success", func(t *testing.T) {
+		expectedPost := sqlc.Post{ID: 1, UserID: 1, Content: "Post 1", UpdatedAt: time.Now()}
+		counts := map[string]int64{repository.ReactionLike: 3, repository.ReactionLove: 1}
+		mockService.On("GetPost", mock.Anything, expectedPost.ID).Return(expectedPost, nil).Once()
+		mockReactions.On("GetPostReactions", mock.Anything, expectedPost, int64(0)).Return(service.PostReactions{Counts: counts}, nil).Twice()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/1", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, postCacheControl, rr.Header().Get("Cache-Control"))
+
+		var res PostResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedPost.Content, res.Content)
+		assert.Equal(t, counts, res.Reactions)
+		assert.Nil(t, res.Reacted)
+
+		mockService.On("GetPost", mock.Anything, expectedPost.ID).Return(expectedPost, nil).Once()
+		req, _ = http.NewRequest(http.MethodGet, "/api/v1/posts/1", nil)
+		req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		mockService.AssertExpectations(t)
+		mockReactions.AssertExpectations(t)
+	})
+
+	t.Run("viewer", func(t *testing.T) {
+		post := sqlc.Post{ID: 2, UserID: 1, Content: "Post 2", UpdatedAt: time.Now()}
+		mockService.On("GetPost", mock.Anything, post.ID).Return(post, nil).Twice()
+		mockViews.On("RecordPostView", mock.Anything, post.ID, int64(5)).Return(nil).Twice()
+		mockReactions.On("GetPostReactions", mock.Anything, post, int64(5)).
+			Return(service.PostReactions{Counts: map[string]int64{repository.ReactionLike: 1}, Reacted: true}, nil).Once()
+		mockReactions.On("GetPostReactions", mock.Anything, post, int64(5)).
+			Return(service.PostReactions{Counts: map[string]int64{repository.ReactionLike: 0}}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/2", nil)
+		authenticate(req, "5")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, viewerPostCacheControl, rr.Header().Get("Cache-Control"))
+		var res PostResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		require.NotNil(t, res.Reacted)
+		assert.True(t, *res.Reacted)
+
+		// Unreacting changes the ETag
+		req, _ = http.NewRequest(http.MethodGet, "/api/v1/posts/2", nil)
+		authenticate(req, "5")
+		req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		require.NotNil(t, res.Reacted)
+		assert.False(t, *res.Reacted)
+		mockReactions.AssertExpectations(t)
+		mockViews.AssertExpectations(t)
+	})
+
+	t.Run("reactions_unavailable", func(t *testing.T) {
+		post := sqlc.Post{ID: 3, UserID: 1, Content: "Post 3", UpdatedAt: time.Now()}
+		mockService.On("GetPost", mock.Anything, post.ID).Return(post, nil).Once()
+		mockViews.On("RecordPostView", mock.Anything, post.ID, int64(5)).Return(errors.New("redis down")).Once()
+		mockReactions.On("GetPostReactions", mock.Anything, post, int64(5)).Return(nil, errors.New("redis down")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/3", nil)
+		authenticate(req, "5")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res PostResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, post.Content, res.Content)
+		assert.Nil(t, res.Reactions)
+		assert.Nil(t, res.Reacted)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		mockService.On("GetPost", mock.Anything, int64(404)).Return(nil, pgx.ErrNoRows).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/404", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		stalePost := sqlc.Post{ID: 7, UserID: 1, Content: "last known good"}
+		mockService.On("GetPost", mock.Anything, stalePost.ID).Run(func(args mock.Arguments) {
+			repository.RecordStale(args.Get(0).(context.Context))
+		}).Return(stalePost, nil).Once()
+		mockReactions.On("GetPostReactions", mock.Anything, stalePost, int64(0)).Return(service.PostReactions{Counts: map[string]int64{}}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/7", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res PostResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.True(t, res.Degraded)
+		assert.Equal(t, "stale", rr.Header().Get(degradedHeader))
+		assert.Empty(t, rr.Header().Get("ETag"))
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	})
+
+	t.Run("db_unavailable", func(t *testing.T) {
+		mockService.On("GetPost", mock.Anything, int64(8)).Return(nil, repository.ErrDBUnavailable).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/8", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	})
+
+	t.Run("overloaded", func(t *testing.T) {
+		mockService.On("GetPost", mock.Anything, int64(503)).Return(nil, repository.ErrDBOverloaded).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/503", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, overloadRetryAfter, rr.Header().Get("Retry-After"))
+	})
+
+	t.Run("invalid_post_id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/abc", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// ReactionHandler handles HTTP requests for the reactions of users to posts.
+type ReactionHandler struct {
+	reactionService service.ReactionService
+}
+
+// NewReactionHandler creates a new ReactionHandler.
+func NewReactionHandler(reactionService service.ReactionService) *ReactionHandler {
+	return &ReactionHandler{reactionService: reactionService}
+}
+
+// ReactRequest defines the expected request body for reacting to a post.
+type ReactRequest struct {
+	Reaction string `json:"reaction" binding:"required"`
+}
+
+// ReactionResponse describes the reaction of a user to a post.
+type ReactionResponse struct {
+	UserID   int64  `json:"user_id"`
+	PostID   int64  `json:"post_id"`
+	Reaction string `json:"reaction"`
+	// Replaced is the reaction the user had given before, if any
+	Replaced string `json:"replaced,omitempty"`
+}
+
+// React sets the reaction of the authenticated user to a post, replacing the one they had given.
+// PUT /api/v1/users/:id/reactions/:post_id
+func (h *ReactionHandler) React(c *gin.Context) {
+	userID, postID, ok := parseUserPost(c)
+	if !ok || !authorizeUser(c, userID) {
+		return
+	}
+
+	var req ReactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	previous, err := h.reactionService.React(c.Request.Context(), userID, postID, req.Reaction)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(err, service.ErrInvalidReaction):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reaction, must be one of like, love, haha, wow, sad or angry"})
+		case errors.Is(err, pgx.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		case errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			if serviceUnavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to react to post: " + err.Error()})
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if previous == "" {
+		status = http.StatusCreated
+	}
+	c.JSON(status, ReactionResponse{
+		UserID:   userID,
+		PostID:   postID,
+		Reaction: req.Reaction,
+		Replaced: previous,
+	})
+}
+
+// Unreact removes the reaction of the authenticated user to a post.
+// DELETE /api/v1/users/:id/reactions/:post_id
+func (h *ReactionHandler) Unreact(c *gin.Context) {
+	userID, postID, ok := parseUserPost(c)
+	if !ok || !authorizeUser(c, userID) {
+		return
+	}
+
+	if err := h.reactionService.Unreact(c.Request.Context(), userID, postID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reaction not found"})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction: " + err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
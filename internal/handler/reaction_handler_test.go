@@ -0,0 +1,152 @@
+//go:build unit
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupReactionRouter(reactionHandler *ReactionHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.Identity(testGateways))
+	router.PUT("/api/v1/users/:id/reactions/:post_id", reactionHandler.React)
+	router.DELETE("/api/v1/users/:id/reactions/:post_id", reactionHandler.Unreact)
+	return router
+}
+
+func TestReactionHandler_React(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	reactAs := func(router *gin.Engine, userID, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPut, "/api/v1/users/2/reactions/100", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	react := func(router *gin.Engine, body string) *httptest.ResponseRecorder {
+		return reactAs(router, "2", body)
+	}
+
+	t.Run("created", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), repository.ReactionLike).Return("", nil).Once()
+
+		rr := react(router, `{"reaction":"like"}`)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var res ReactionResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, ReactionResponse{UserID: 2, PostID: 100, Reaction: repository.ReactionLike}, res)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), repository.ReactionLove).Return(repository.ReactionLike, nil).Once()
+
+		rr := react(router, `{"reaction":"love"}`)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res ReactionResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, repository.ReactionLike, res.Replaced)
+	})
+
+	t.Run("invalid_reaction", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), "meh").Return("", service.ErrInvalidReaction).Once()
+
+		assert.Equal(t, http.StatusBadRequest, react(router, `{"reaction":"meh"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, react(router, `{}`).Code)
+	})
+
+	t.Run("post_not_found", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), repository.ReactionLike).Return("", pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, react(router, `{"reaction":"like"}`).Code)
+	})
+
+	t.Run("user_not_found", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), repository.ReactionLike).
+			Return("", &pgconn.PgError{Code: foreignKeyViolation}).Once()
+
+		assert.Equal(t, http.StatusNotFound, react(router, `{"reaction":"like"}`).Code)
+	})
+
+	t.Run("db_unavailable", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+		mockService.On("React", mock.Anything, int64(2), int64(100), repository.ReactionLike).Return("", repository.ErrDBUnavailable).Once()
+
+		assert.Equal(t, http.StatusServiceUnavailable, react(router, `{"reaction":"like"}`).Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+
+		assert.Equal(t, http.StatusUnauthorized, reactAs(router, "", `{"reaction":"like"}`).Code)
+		mockService.AssertNotCalled(t, "React")
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		mockService := new(servicemocks.ReactionService)
+		router := setupReactionRouter(NewReactionHandler(mockService))
+
+		assert.Equal(t, http.StatusForbidden, reactAs(router, "3", `{"reaction":"like"}`).Code)
+		mockService.AssertNotCalled(t, "React")
+	})
+}
+
+func TestReactionHandler_Unreact(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.ReactionService)
+	router := setupReactionRouter(NewReactionHandler(mockService))
+
+	unreact := func(userID, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodDelete, path, nil)
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	mockService.On("Unreact", mock.Anything, int64(2), int64(100)).Return(nil).Once()
+	mockService.On("Unreact", mock.Anything, int64(2), int64(101)).Return(pgx.ErrNoRows).Once()
+
+	assert.Equal(t, http.StatusNoContent, unreact("2", "/api/v1/users/2/reactions/100").Code)
+	assert.Equal(t, http.StatusNotFound, unreact("2", "/api/v1/users/2/reactions/101").Code)
+	assert.Equal(t, http.StatusBadRequest, unreact("2", "/api/v1/users/2/reactions/abc").Code)
+	assert.Equal(t, http.StatusUnauthorized, unreact("", "/api/v1/users/2/reactions/100").Code)
+	assert.Equal(t, http.StatusForbidden, unreact("3", "/api/v1/users/2/reactions/100").Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNumberOfCalls(t, "Unreact", 2)
+}
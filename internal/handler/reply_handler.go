@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// maxThreadLimit bounds the page size of a thread
+const maxThreadLimit = 100
+
+// ReplyHandler handles HTTP requests for the threads of replies to posts.
+type ReplyHandler struct {
+	replyService service.ReplyService
+}
+
+// NewReplyHandler creates a new ReplyHandler.
+func NewReplyHandler(replyService service.ReplyService) *ReplyHandler {
+	return &ReplyHandler{replyService: replyService}
+}
+
+// CreateReplyRequest defines the expected request body for replying to a post, or to a reply of its
+// thread when ParentID is set.
+type CreateReplyRequest struct {
+	UserID   int64  `json:"user_id" binding:"required"`
+	Content  string `json:"content" binding:"required"`
+	ParentID int64  `json:"parent_id"`
+}
+
+// ReplyResponse describes a reply. A deleted reply kept for the replies to it has neither author nor
+// content.
+type ReplyResponse struct {
+	ID         int64     `json:"id"`
+	PostID     int64     `json:"post_id"`
+	ParentID   int64     `json:"parent_id"`
+	UserID     int64     `json:"user_id,omitempty"`
+	Content    string    `json:"content"`
+	ReplyCount int32     `json:"reply_count"`
+	Deleted    bool      `json:"deleted,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ThreadResponse is a page of the replies to a post, oldest first.
+type ThreadResponse struct {
+	PostID int64 `json:"post_id"`
+	// ReplyCount counts the replies of the whole thread, deleted ones excluded
+	ReplyCount int64           `json:"reply_count"`
+	Data       []ReplyResponse `json:"data"`
+	HasMore    bool            `json:"has_more"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+}
+
+// CreateReply handles replying to a post or to a reply of its thread.
+// POST /api/v1/posts/:id/replies
+func (h *ReplyHandler) CreateReply(c *gin.Context) {
+	postID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return
+	}
+
+	var req CreateReplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	reply, err := h.replyService.CreateReply(c.Request.Context(), postID, req.ParentID, req.UserID, req.Content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post or parent reply not found"})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reply: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newReplyResponse(reply))
+}
+
+// ListThread handles fetching a page of the replies to a post, deleted replies with replies included.
+// GET /api/v1/posts/:id/replies
+func (h *ReplyHandler) ListThread(c *gin.Context) {
+	postID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxThreadLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, must be between 1 and %d", maxThreadLimit)})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	// Fetch limit + 1 replies to check if there is a next page.
+	thread, err := h.replyService.ListThread(c.Request.Context(), postID, int32(limit+1), int32(offset))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve replies: " + err.Error()})
+		return
+	}
+
+	replies := thread.Replies
+	hasMore := len(replies) > limit
+	if hasMore {
+		replies = replies[:limit]
+	}
+	data := make([]ReplyResponse, 0, len(replies))
+	for _, reply := range replies {
+		data = append(data, newReplyResponse(reply))
+	}
+
+	c.JSON(http.StatusOK, ThreadResponse{
+		PostID:     postID,
+		ReplyCount: thread.ReplyCount,
+		Data:       data,
+		HasMore:    hasMore,
+		Limit:      limit,
+		Offset:     offset,
+	})
+}
+
+// DeleteReply handles deleting a reply of the authenticated user. A reply with replies is kept in its
+// thread as deleted.
+// DELETE /api/v1/posts/:id/replies/:reply_id
+func (h *ReplyHandler) DeleteReply(c *gin.Context) {
+	postID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID format"})
+		return
+	}
+	replyID, err := strconv.ParseInt(c.Param("reply_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply ID format"})
+		return
+	}
+	userID, ok := middleware.AuthUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing " + middleware.UserIDHeader + " header"})
+		return
+	}
+
+	if err := h.replyService.DeleteReply(c.Request.Context(), postID, replyID, userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reply not found"})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reply: " + err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// newReplyResponse hides the author of a deleted reply
+func newReplyResponse(reply sqlc.Reply) ReplyResponse {
+	res := ReplyResponse{
+		ID:         reply.ID,
+		PostID:     reply.RootID,
+		ParentID:   reply.ParentID,
+		UserID:     reply.UserID,
+		Content:    reply.Content,
+		ReplyCount: reply.ReplyCount,
+		CreatedAt:  reply.CreatedAt,
+		UpdatedAt:  reply.UpdatedAt,
+	}
+	if reply.DeletedAt.Valid {
+		res.UserID, res.Content, res.Deleted = 0, "", true
+	}
+	return res
+}
@@ -0,0 +1,171 @@
+//go:build unit
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupReplyRouter(replyHandler *ReplyHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.Identity(testGateways))
+	router.POST("/api/v1/posts/:id/replies", replyHandler.CreateReply)
+	router.GET("/api/v1/posts/:id/replies", replyHandler.ListThread)
+	router.DELETE("/api/v1/posts/:id/replies/:reply_id", replyHandler.DeleteReply)
+	return router
+}
+
+func TestReplyHandler_CreateReply(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	createReply := func(router *gin.Engine, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/posts/100/replies", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("created", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		reply := sqlc.Reply{ID: 202, RootID: 100, ParentID: 201, UserID: 2, Content: "hello"}
+		mockService.On("CreateReply", mock.Anything, int64(100), int64(201), int64(2), "hello").Return(reply, nil).Once()
+
+		rr := createReply(router, `{"user_id":2,"content":"hello","parent_id":201}`)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var res ReplyResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, ReplyResponse{ID: 202, PostID: 100, ParentID: 201, UserID: 2, Content: "hello"}, res)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_payload", func(t *testing.T) {
+		router := setupReplyRouter(NewReplyHandler(new(servicemocks.ReplyService)))
+
+		assert.Equal(t, http.StatusBadRequest, createReply(router, `{"user_id":2}`).Code)
+		assert.Equal(t, http.StatusBadRequest, createReply(router, `{"content":"hello"}`).Code)
+	})
+
+	t.Run("parent_not_found", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		mockService.On("CreateReply", mock.Anything, int64(100), int64(201), int64(2), "hello").Return(sqlc.Reply{}, pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, createReply(router, `{"user_id":2,"content":"hello","parent_id":201}`).Code)
+	})
+}
+
+func TestReplyHandler_ListThread(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	listThread := func(router *gin.Engine, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/100/replies"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("hides_deleted_replies", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		createdAt := time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC)
+		thread := service.Thread{
+			Post: sqlc.Post{ID: 100, UserID: 1},
+			Replies: []sqlc.Reply{
+				{ID: 201, RootID: 100, ParentID: 100, UserID: 2, ReplyCount: 1, CreatedAt: createdAt,
+					DeletedAt: pgtype.Timestamptz{Time: createdAt.Add(time.Hour), Valid: true}},
+				{ID: 202, RootID: 100, ParentID: 201, UserID: 3, Content: "still here", CreatedAt: createdAt},
+				{ID: 203, RootID: 100, ParentID: 100, UserID: 3, Content: "next page", CreatedAt: createdAt},
+			},
+			ReplyCount: 2,
+		}
+		mockService.On("ListThread", mock.Anything, int64(100), int32(3), int32(0)).Return(thread, nil).Once()
+
+		rr := listThread(router, "?limit=2")
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res ThreadResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, int64(2), res.ReplyCount)
+		assert.True(t, res.HasMore)
+		require.Len(t, res.Data, 2)
+		assert.True(t, res.Data[0].Deleted)
+		assert.Zero(t, res.Data[0].UserID)
+		assert.Equal(t, int32(1), res.Data[0].ReplyCount)
+		assert.Equal(t, "still here", res.Data[1].Content)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_page", func(t *testing.T) {
+		router := setupReplyRouter(NewReplyHandler(new(servicemocks.ReplyService)))
+
+		assert.Equal(t, http.StatusBadRequest, listThread(router, "?limit=0").Code)
+		assert.Equal(t, http.StatusBadRequest, listThread(router, "?limit=101").Code)
+		assert.Equal(t, http.StatusBadRequest, listThread(router, "?offset=-1").Code)
+	})
+
+	t.Run("post_not_found", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		mockService.On("ListThread", mock.Anything, int64(100), int32(21), int32(0)).Return(service.Thread{}, pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, listThread(router, "").Code)
+	})
+}
+
+func TestReplyHandler_DeleteReply(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	deleteReply := func(router *gin.Engine, userID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodDelete, "/api/v1/posts/100/replies/201", nil)
+		if userID != "" {
+			authenticate(req, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		mockService.On("DeleteReply", mock.Anything, int64(100), int64(201), int64(2)).Return(nil).Once()
+
+		assert.Equal(t, http.StatusNoContent, deleteReply(router, "2").Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+
+		assert.Equal(t, http.StatusUnauthorized, deleteReply(router, "").Code)
+		mockService.AssertNotCalled(t, "DeleteReply")
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		mockService := new(servicemocks.ReplyService)
+		router := setupReplyRouter(NewReplyHandler(mockService))
+		mockService.On("DeleteReply", mock.Anything, int64(100), int64(201), int64(3)).Return(pgx.ErrNoRows).Once()
+
+		assert.Equal(t, http.StatusNotFound, deleteReply(router, "3").Code)
+	})
+}
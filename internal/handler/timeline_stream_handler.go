@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/live"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"golang.org/x/net/websocket"
+)
+
+// lastEventIDParam resumes a stream from a post where the Last-Event-ID header cannot be set,
+// as on the first connection of an EventSource or on WebSockets
+const lastEventIDParam = "last_event_id"
+
+// LiveTimelines subscribes to the new posts of users
+type LiveTimelines interface {
+	Subscribe(ctx context.Context, userID int64) (live.Subscription, error)
+}
+
+// TimelineStreamOptions tunes TimelineStreamHandler
+type TimelineStreamOptions struct {
+	// Heartbeat is the interval of the heartbeats keeping idle streams open through proxies
+	Heartbeat time.Duration
+	// ResumeLimit bounds the posts replayed to a stream resuming from a post
+	ResumeLimit int
+	// WriteTimeout bounds every write to the client, a client slower than that is disconnected
+	WriteTimeout time.Duration
+	// MaxConnections bounds the streams open on the instance
+	MaxConnections int
+	// RetryAfter is sent with streams refused at MaxConnections
+	RetryAfter time.Duration
+}
+
+// TimelineStreamHandler pushes the new posts of a user to clients over Server-Sent Events or WebSockets
+type TimelineStreamHandler struct {
+	postService service.PostService
+	live        LiveTimelines
+	opts        TimelineStreamOptions
+	// slots holds a token per open stream
+	slots chan struct{}
+}
+
+// NewTimelineStreamHandler creates a new TimelineStreamHandler
+func NewTimelineStreamHandler(postService service.PostService, live LiveTimelines, opts TimelineStreamOptions) *TimelineStreamHandler {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 15 * time.Second
+	}
+	if opts.MaxConnections < 1 {
+		opts.MaxConnections = 1
+	}
+	return &TimelineStreamHandler{
+		postService: postService,
+		live:        live,
+		opts:        opts,
+		slots:       make(chan struct{}, opts.MaxConnections),
+	}
+}
+
+// timelineWriter writes a timeline stream in the format of its transport
+type timelineWriter interface {
+	writePost(post PostResponse) error
+	writeHeartbeat() error
+	// writeEnd tells the client why the stream ends, before it is closed
+	writeEnd(reason string) error
+}
+
+// StreamSSE streams the new posts of the user as Server-Sent Events, the id of every event being the post id.
+// GET /api/v1/users/:id/posts/stream
+func (h *TimelineStreamHandler) StreamSSE(c *gin.Context) {
+	userID, lastID, ok := parseTimelineStream(c)
+	if !ok {
+		return
+	}
+	sub, release, ok := h.open(c, userID, "sse")
+	if !ok {
+		return
+	}
+	defer release()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Connection", "keep-alive")
+	// Proxies must not buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	h.serve(c.Request.Context(), userID, lastID, sub, &sseWriter{c: c, rc: http.NewResponseController(c.Writer), timeout: h.opts.WriteTimeout})
+}
+
+// StreamWebSocket streams the new posts of the user over a WebSocket, as JSON messages of type post,
+// heartbeat and end.
+// GET /api/v1/users/:id/posts/ws
+func (h *TimelineStreamHandler) StreamWebSocket(c *gin.Context) {
+	userID, lastID, ok := parseTimelineStream(c)
+	if !ok {
+		return
+	}
+	sub, release, ok := h.open(c, userID, "websocket")
+	if !ok {
+		return
+	}
+	defer release()
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		// The hijacked connection is only known to be closed by reading it
+		go func() {
+			defer cancel()
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+		h.serve(ctx, userID, lastID, sub, &wsWriter{ws: ws, timeout: h.opts.WriteTimeout})
+	}}.ServeHTTP(c.Writer, c.Request)
+}
+
+// open takes a connection slot and subscribes to the user's new posts, answering 503 when either fails
+func (h *TimelineStreamHandler) open(c *gin.Context, userID int64, transport string) (live.Subscription, func(), bool) {
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		metrics.TimelineStreamRejections.Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(h.opts.RetryAfter.Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many timeline streams, please retry"})
+		return nil, nil, false
+	}
+
+	sub, err := h.live.Subscribe(c.Request.Context(), userID)
+	if err != nil {
+		<-h.slots
+		log.Printf("failed to subscribe to live timeline of user %d: %v", userID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Live timelines are unavailable"})
+		return nil, nil, false
+	}
+
+	metrics.TimelineStreamConnections.WithLabelValues(transport).Inc()
+	return sub, func() {
+		sub.Close()
+		metrics.TimelineStreamConnections.WithLabelValues(transport).Dec()
+		<-h.slots
+	}, true
+}
+
+// serve replays the posts published after lastID, then pushes the new posts until the client leaves or
+// the subscription ends. The subscription is opened before the replay, so that no post falls in between.
+func (h *TimelineStreamHandler) serve(ctx context.Context, userID, lastID int64, sub live.Subscription, w timelineWriter) {
+	replayed, err := h.replay(ctx, userID, lastID, w)
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			reason := "closed"
+			switch {
+			case errors.Is(sub.Err(), live.ErrSlowSubscriber):
+				reason = "slow_consumer"
+			case errors.Is(sub.Err(), live.ErrHubStopped):
+				reason = "shutdown"
+			}
+			_ = w.writeEnd(reason)
+			return
+		case post := <-sub.Posts():
+			if _, ok := replayed[post.ID]; ok {
+				continue
+			}
+			if err := w.writePost(postResponse(post)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := w.writeHeartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replay writes the posts of the user newer than lastID, oldest first, as read from the user's posts
+// list. Post IDs grow with time, so that a lastID deleted since still tells the posts after it. A lastID
+// older than the ResumeLimit newest posts replays those. It returns the ids written.
+func (h *TimelineStreamHandler) replay(ctx context.Context, userID, lastID int64, w timelineWriter) (map[int64]struct{}, error) {
+	if lastID == 0 || h.opts.ResumeLimit < 1 {
+		return nil, nil
+	}
+	posts, err := h.postService.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{
+		UserID: userID,
+		Limit:  int32(h.opts.ResumeLimit),
+	})
+	if err != nil {
+		// The stream goes on with the new posts, the client can page the missed ones
+		log.Printf("failed to replay timeline of user %d after post %d: %v", userID, lastID, err)
+		return nil, nil
+	}
+
+	newer := posts
+	for i, p := range posts {
+		if p.ID <= lastID {
+			newer = posts[:i]
+			break
+		}
+	}
+	replayed := make(map[int64]struct{}, len(newer))
+	for i := len(newer) - 1; i >= 0; i-- {
+		if err := w.writePost(postResponse(newer[i])); err != nil {
+			return nil, err
+		}
+		replayed[newer[i].ID] = struct{}{}
+	}
+	return replayed, nil
+}
+
+// parseTimelineStream reads the user id of the path and the post to resume from, answering 400 when malformed
+func parseTimelineStream(c *gin.Context) (userID, lastID int64, ok bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return 0, 0, false
+	}
+
+	last := c.GetHeader("Last-Event-ID")
+	if last == "" {
+		last = c.Query(lastEventIDParam)
+	}
+	if last != "" {
+		if lastID, err = strconv.ParseInt(last, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID, must be a post ID"})
+			return 0, 0, false
+		}
+	}
+	return userID, lastID, true
+}
+
+func postResponse(post sqlc.Post) PostResponse {
+	return PostResponse{
+		ID:        post.ID,
+		UserID:    post.UserID,
+		Content:   post.Content,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+	}
+}
+
+// sseWriter writes a timeline stream as Server-Sent Events
+type sseWriter struct {
+	c       *gin.Context
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *sseWriter) writePost(post PostResponse) error {
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	return w.write(fmt.Sprintf("id: %d\nevent: post\ndata: %s\n\n", post.ID, data))
+}
+
+func (w *sseWriter) writeHeartbeat() error {
+	return w.write(": heartbeat\n\n")
+}
+
+func (w *sseWriter) writeEnd(reason string) error {
+	return w.write(fmt.Sprintf("event: end\ndata: {\"reason\":%q}\n\n", reason))
+}
+
+func (w *sseWriter) write(frame string) error {
+	if w.timeout > 0 {
+		if err := w.rc.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	if _, err := w.c.Writer.WriteString(frame); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+// wsWriter writes a timeline stream as WebSocket JSON messages
+type wsWriter struct {
+	ws      *websocket.Conn
+	timeout time.Duration
+}
+
+// timelineMessage is a WebSocket message of a timeline stream
+type timelineMessage struct {
+	Type   string        `json:"type"`
+	Post   *PostResponse `json:"post,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+}
+
+func (w *wsWriter) writePost(post PostResponse) error {
+	return w.write(timelineMessage{Type: "post", Post: &post})
+}
+
+func (w *wsWriter) writeHeartbeat() error {
+	return w.write(timelineMessage{Type: "heartbeat"})
+}
+
+func (w *wsWriter) writeEnd(reason string) error {
+	return w.write(timelineMessage{Type: "end", Reason: reason})
+}
+
+func (w *wsWriter) write(msg timelineMessage) error {
+	if w.timeout > 0 {
+		if err := w.ws.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+			return err
+		}
+	}
+	return websocket.JSON.Send(w.ws, msg)
+}
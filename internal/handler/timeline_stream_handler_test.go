@@ -0,0 +1,208 @@
+//go:build unit
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/live"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// fakeSubscription delivers the posts sent on posts until end is called
+type fakeSubscription struct {
+	posts  chan sqlc.Post
+	done   chan struct{}
+	err    error
+	closed bool
+}
+
+func newFakeSubscription() *fakeSubscription {
+	return &fakeSubscription{posts: make(chan sqlc.Post), done: make(chan struct{})}
+}
+
+func (s *fakeSubscription) Posts() <-chan sqlc.Post { return s.posts }
+func (s *fakeSubscription) Done() <-chan struct{}   { return s.done }
+func (s *fakeSubscription) Err() error              { return s.err }
+func (s *fakeSubscription) Close()                  { s.closed = true }
+
+func (s *fakeSubscription) end(err error) {
+	s.err = err
+	close(s.done)
+}
+
+// fakeLiveTimelines hands out sub, or fails with err
+type fakeLiveTimelines struct {
+	sub *fakeSubscription
+	err error
+}
+
+func (l *fakeLiveTimelines) Subscribe(context.Context, int64) (live.Subscription, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.sub, nil
+}
+
+func TestTimelineStreamHandler_StreamSSE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	opts := TimelineStreamOptions{Heartbeat: time.Minute, ResumeLimit: 10, MaxConnections: 2, RetryAfter: 2 * time.Second}
+
+	t.Run("replays_then_streams_new_posts", func(t *testing.T) {
+		mockService := new(servicemocks.PostService)
+		sub := newFakeSubscription()
+		streamHandler := NewTimelineStreamHandler(mockService, &fakeLiveTimelines{sub: sub}, opts)
+
+		// Newest first, as listed by the cache
+		mockService.On("ListPostsByUser", mock.Anything, sqlc.ListPostsByUserParams{UserID: 1, Limit: 10}).Return([]sqlc.Post{
+			{ID: 12, UserID: 1, Content: "third"},
+			{ID: 11, UserID: 1, Content: "second"},
+			{ID: 10, UserID: 1, Content: "first"},
+		}, nil).Once()
+
+		go func() {
+			// Published while replaying, already sent
+			sub.posts <- sqlc.Post{ID: 12, UserID: 1, Content: "third"}
+			sub.posts <- sqlc.Post{ID: 13, UserID: 1, Content: "fourth"}
+			sub.end(live.ErrSlowSubscriber)
+		}()
+
+		router := gin.New()
+		router.GET("/api/v1/users/:id/posts/stream", streamHandler.StreamSSE)
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts/stream", nil)
+		req.Header.Set("Last-Event-ID", "10")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+		body := rr.Body.String()
+		assert.Equal(t, []string{"11", "12", "13"}, sseIDs(body))
+		assert.True(t, strings.HasSuffix(body, "event: end\ndata: {\"reason\":\"slow_consumer\"}\n\n"))
+		assert.True(t, sub.closed)
+		assert.Empty(t, streamHandler.slots)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("replays_after_deleted_last_event", func(t *testing.T) {
+		mockService := new(servicemocks.PostService)
+		sub := newFakeSubscription()
+		streamHandler := NewTimelineStreamHandler(mockService, &fakeLiveTimelines{sub: sub}, opts)
+
+		// The post 11 the client saw last was deleted since
+		mockService.On("ListPostsByUser", mock.Anything, sqlc.ListPostsByUserParams{UserID: 1, Limit: 10}).Return([]sqlc.Post{
+			{ID: 13, UserID: 1, Content: "fourth"},
+			{ID: 12, UserID: 1, Content: "third"},
+			{ID: 10, UserID: 1, Content: "first"},
+		}, nil).Once()
+		go sub.end(live.ErrSlowSubscriber)
+
+		router := gin.New()
+		router.GET("/api/v1/users/:id/posts/stream", streamHandler.StreamSSE)
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts/stream", nil)
+		req.Header.Set("Last-Event-ID", "11")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, []string{"12", "13"}, sseIDs(rr.Body.String()))
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_last_event_id", func(t *testing.T) {
+		streamHandler := NewTimelineStreamHandler(new(servicemocks.PostService), &fakeLiveTimelines{}, opts)
+		router := gin.New()
+		router.GET("/api/v1/users/:id/posts/stream", streamHandler.StreamSSE)
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts/stream?last_event_id=abc", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("connection_limit", func(t *testing.T) {
+		streamHandler := NewTimelineStreamHandler(new(servicemocks.PostService), &fakeLiveTimelines{sub: newFakeSubscription()}, opts)
+		streamHandler.slots <- struct{}{}
+		streamHandler.slots <- struct{}{}
+		router := gin.New()
+		router.GET("/api/v1/users/:id/posts/stream", streamHandler.StreamSSE)
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts/stream", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	})
+
+	t.Run("subscribe_failure", func(t *testing.T) {
+		streamHandler := NewTimelineStreamHandler(new(servicemocks.PostService), &fakeLiveTimelines{err: errors.New("redis down")}, opts)
+		router := gin.New()
+		router.GET("/api/v1/users/:id/posts/stream", streamHandler.StreamSSE)
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts/stream", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Empty(t, streamHandler.slots)
+	})
+}
+
+func TestTimelineStreamHandler_StreamWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sub := newFakeSubscription()
+	streamHandler := NewTimelineStreamHandler(new(servicemocks.PostService), &fakeLiveTimelines{sub: sub}, TimelineStreamOptions{
+		Heartbeat:      time.Minute,
+		WriteTimeout:   time.Second,
+		MaxConnections: 1,
+	})
+
+	router := gin.New()
+	router.GET("/api/v1/users/:id/posts/ws", streamHandler.StreamWebSocket)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/users/1/posts/ws", "", srv.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	go func() {
+		sub.posts <- sqlc.Post{ID: 10, UserID: 1, Content: "hello"}
+		sub.end(live.ErrHubStopped)
+	}()
+
+	var msg timelineMessage
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	assert.Equal(t, "post", msg.Type)
+	require.NotNil(t, msg.Post)
+	assert.Equal(t, "hello", msg.Post.Content)
+
+	msg = timelineMessage{}
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	assert.Equal(t, timelineMessage{Type: "end", Reason: "shutdown"}, msg)
+}
+
+// sseIDs returns the ids of the events of an SSE body
+func sseIDs(body string) []string {
+	var ids []string
+	for _, line := range strings.Split(body, "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// TrendingHandler handles HTTP requests for the posts with the most engagement.
+type TrendingHandler struct {
+	trendingService service.TrendingService
+}
+
+// NewTrendingHandler creates a new TrendingHandler.
+func NewTrendingHandler(trendingService service.TrendingService) *TrendingHandler {
+	return &TrendingHandler{trendingService: trendingService}
+}
+
+// TrendingPostResponse is a trending post and its engagement score.
+type TrendingPostResponse struct {
+	PostResponse
+	Score float64 `json:"score"`
+}
+
+// TrendingResponse lists the trending posts of a window, highest score first.
+type TrendingResponse struct {
+	Window string                 `json:"window"`
+	Data   []TrendingPostResponse `json:"data"`
+	// Partial is set when posts on unavailable cache nodes were left out, see MissingShards
+	Partial       bool     `json:"partial,omitempty"`
+	MissingShards []string `json:"missing_shards,omitempty"`
+	// Degraded is set when posts were served from stale copies because DB was unavailable
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// ListTrending handles fetching the posts with the most engagement in a window.
+// GET /api/v1/posts/trending?window=1h&limit=20
+func (h *TrendingHandler) ListTrending(c *gin.Context) {
+	window := c.DefaultQuery("window", "1h")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > repository.MaxTrendingPosts {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, must be between 1 and %d", repository.MaxTrendingPosts)})
+		return
+	}
+
+	ctx, report, err := readContext(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consistency, must be complete or partial"})
+		return
+	}
+	ctx, stale := repository.WithStaleReport(ctx)
+
+	posts, err := h.trendingService.ListTrending(ctx, window, limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidTrendingWindow) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if serviceUnavailable(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trending posts: " + err.Error()})
+		return
+	}
+
+	res := TrendingResponse{
+		Window:        window,
+		Data:          make([]TrendingPostResponse, 0, len(posts)),
+		Partial:       report.Partial(),
+		MissingShards: report.MissingShards(),
+		Degraded:      stale.Stale(),
+	}
+	switch {
+	case stale.Stale():
+		markStale(c)
+	case report.Partial():
+		c.Header("Cache-Control", "no-store")
+	default:
+		c.Header("Cache-Control", trendingCacheControl)
+	}
+
+	for _, trending := range posts {
+		post := trending.Post
+		res.Data = append(res.Data, TrendingPostResponse{
+			PostResponse: PostResponse{
+				ID:        post.ID,
+				UserID:    post.UserID,
+				Content:   post.Content,
+				CreatedAt: post.CreatedAt,
+				UpdatedAt: post.UpdatedAt,
+			},
+			Score: trending.Score,
+		})
+	}
+
+	c.JSON(http.StatusOK, res)
+}
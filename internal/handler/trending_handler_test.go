@@ -0,0 +1,112 @@
+//go:build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTrendingRouter(trendingHandler *TrendingHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/api/v1/posts/trending", trendingHandler.ListTrending)
+	return router
+}
+
+func TestTrendingHandler_ListTrending(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("success", func(t *testing.T) {
+		mockService := new(servicemocks.TrendingService)
+		router := setupTrendingRouter(NewTrendingHandler(mockService))
+		mockService.On("ListTrending", mock.Anything, "6h", 2).Return([]service.TrendingPost{
+			{Post: sqlc.Post{ID: 101, UserID: 1, Content: "hot"}, Score: 9.5},
+			{Post: sqlc.Post{ID: 100, UserID: 2, Content: "warm"}, Score: 4},
+		}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/trending?window=6h&limit=2", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, trendingCacheControl, rr.Header().Get("Cache-Control"))
+		var res TrendingResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, "6h", res.Window)
+		require.Len(t, res.Data, 2)
+		assert.Equal(t, int64(101), res.Data[0].ID)
+		assert.Equal(t, 9.5, res.Data[0].Score)
+		assert.False(t, res.Partial)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		mockService := new(servicemocks.TrendingService)
+		router := setupTrendingRouter(NewTrendingHandler(mockService))
+		mockService.On("ListTrending", mock.Anything, "1h", 20).Run(func(args mock.Arguments) {
+			aggregator.RecordPartial(args.Get(0).(context.Context), []aggregator.ShardError{{Node: "node-b:6379"}})
+		}).Return([]service.TrendingPost{}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/trending?consistency=partial", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		var res TrendingResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.True(t, res.Partial)
+		assert.Equal(t, []string{"node-b:6379"}, res.MissingShards)
+	})
+
+	t.Run("invalid_window", func(t *testing.T) {
+		mockService := new(servicemocks.TrendingService)
+		router := setupTrendingRouter(NewTrendingHandler(mockService))
+		mockService.On("ListTrending", mock.Anything, "1w", 20).
+			Return(nil, fmt.Errorf("%w: window must be 1h, 6h or 24h", service.ErrInvalidTrendingWindow)).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/trending?window=1w", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("invalid_limit", func(t *testing.T) {
+		mockService := new(servicemocks.TrendingService)
+		router := setupTrendingRouter(NewTrendingHandler(mockService))
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/trending?limit=101", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockService.AssertNotCalled(t, "ListTrending")
+	})
+
+	t.Run("service_error", func(t *testing.T) {
+		mockService := new(servicemocks.TrendingService)
+		router := setupTrendingRouter(NewTrendingHandler(mockService))
+		mockService.On("ListTrending", mock.Anything, "1h", 20).Return(nil, errors.New("connection refused")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/trending", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
@@ -30,6 +30,8 @@ type CreateUserRequest struct {
 	LastName  string `json:"last_name" binding:"required"`
 	Email     string `json:"email" binding:"required,email"`
 	Password  string `json:"password" binding:"required,min=8"`
+	// InitialPost, if set, is the content of a first post created along with the user
+	InitialPost string `json:"initial_post"`
 }
 
 // UserResponse defines the structure for user responses, omitting sensitive data like password.
@@ -40,6 +42,8 @@ type UserResponse struct {
 	Email     string `json:"email"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
+	// InitialPost is the first post created along with the user
+	InitialPost *PostResponse `json:"initial_post,omitempty"`
 }
 
 // CreateUser handles the creation of a new user.
@@ -58,7 +62,22 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		HashedPassword: req.Password,
 	}
 
-	user, err := h.userService.CreateUser(c.Request.Context(), params)
+	var user sqlc.User
+	var initialPost *PostResponse
+	var err error
+	if req.InitialPost != "" {
+		var post sqlc.Post
+		user, post, err = h.userService.CreateUserWithPost(c.Request.Context(), params, req.InitialPost)
+		initialPost = &PostResponse{
+			ID:        post.ID,
+			UserID:    post.UserID,
+			Content:   post.Content,
+			CreatedAt: post.CreatedAt,
+			UpdatedAt: post.UpdatedAt,
+		}
+	} else {
+		user, err = h.userService.CreateUser(c.Request.Context(), params)
+	}
 	if err != nil {
 		// TODO: Implement more specific error handling, e.g., for duplicate email.
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
@@ -66,12 +85,13 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	}
 
 	resp := UserResponse{
-		ID:        user.ID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: user.UpdatedAt.Format(time.RFC3339),
+		ID:          user.ID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Email:       user.Email,
+		CreatedAt:   user.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   user.UpdatedAt.Format(time.RFC3339),
+		InitialPost: initialPost,
 	}
 	c.JSON(http.StatusCreated, resp)
 }
@@ -64,6 +64,41 @@ func TestUserHandler_CreateUser(t *testing.T) {
 		mockService.AssertExpectations(t)
 	})
 
+	t.Run("with_initial_post", func(t *testing.T) {
+		reqBody := CreateUserRequest{
+			FirstName:   "Test",
+			LastName:    "User",
+			Email:       "first.post@example.com",
+			Password:    "password123",
+			InitialPost: "hello world",
+		}
+		expectedUser := sqlc.User{ID: 2, FirstName: reqBody.FirstName, LastName: reqBody.LastName, Email: reqBody.Email}
+		expectedPost := sqlc.Post{ID: 20, UserID: expectedUser.ID, Content: reqBody.InitialPost}
+
+		mockService.On("CreateUserWithPost", mock.Anything, mock.MatchedBy(func(params sqlc.CreateUserParams) bool {
+			return params.Email == reqBody.Email
+		}), reqBody.InitialPost).Return(expectedUser, expectedPost, nil).Once()
+
+		jsonBody, _ := json.Marshal(reqBody)
+		req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var resUser UserResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resUser)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedUser.ID, resUser.ID)
+		if assert.NotNil(t, resUser.InitialPost) {
+			assert.Equal(t, expectedPost.ID, resUser.InitialPost.ID)
+			assert.Equal(t, expectedPost.Content, resUser.InitialPost.Content)
+		}
+		mockService.AssertExpectations(t)
+	})
+
 	t.Run("invalid_payload", func(t *testing.T) {
 		// Missing email
 		reqBody := `{"first_name": "Test", "last_name": "User", "password": "password123"}`
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+)
+
+// WebhookHandler handles HTTP requests for webhook subscriptions and their delivery log.
+type WebhookHandler struct {
+	webhookService service.WebhookService
+}
+
+// NewWebhookHandler creates a new WebhookHandler.
+func NewWebhookHandler(webhookService service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// CreateWebhookRequest defines the expected request body for creating a webhook.
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types" binding:"required"`
+	// UserIDs limits the deliveries to the events of these users, every user when empty
+	UserIDs []int64 `json:"user_ids"`
+	// Secret signs the deliveries, generated when empty
+	Secret string `json:"secret"`
+}
+
+// WebhookResponse describes a webhook. The secret is only returned by its creation.
+type WebhookResponse struct {
+	ID                  int64      `json:"id"`
+	OwnerID             int64      `json:"owner_id"`
+	URL                 string     `json:"url"`
+	EventTypes          []string   `json:"event_types"`
+	UserIDs             []int64    `json:"user_ids"`
+	Secret              string     `json:"secret,omitempty"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// PaginatedWebhooksResponse is a page of webhooks.
+type PaginatedWebhooksResponse struct {
+	Data    []WebhookResponse `json:"data"`
+	HasMore bool              `json:"has_more"`
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+}
+
+// WebhookDeliveryResponse describes a delivery of an event to a webhook.
+type WebhookDeliveryResponse struct {
+	ID             int64      `json:"id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int32      `json:"attempts"`
+	ResponseStatus *int32     `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// PaginatedWebhookDeliveriesResponse is a page of the delivery log of a webhook, newest first.
+type PaginatedWebhookDeliveriesResponse struct {
+	Data    []WebhookDeliveryResponse `json:"data"`
+	HasMore bool                      `json:"has_more"`
+	Limit   int                       `json:"limit"`
+	Offset  int                       `json:"offset"`
+}
+
+// CreateWebhook subscribes a URL to post events, on behalf of the authenticated user.
+// POST /api/v1/webhooks
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	ownerID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	webhook, err := h.webhookService.CreateWebhook(c.Request.Context(), sqlc.CreateWebhookParams{
+		Url:        req.URL,
+		EventTypes: req.EventTypes,
+		UserIds:    req.UserIDs,
+		Secret:     req.Secret,
+		OwnerID:    pgtype.Int8{Int64: ownerID, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidWebhook) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+
+	res := webhookResponse(webhook)
+	res.Secret = webhook.Secret
+	c.JSON(http.StatusCreated, res)
+}
+
+// GetWebhook handles fetching a webhook of the authenticated user by its ID.
+// GET /api/v1/webhooks/:id
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.authorizeWebhook(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, webhookResponse(webhook))
+}
+
+// ListWebhooks handles fetching a page of the webhooks of the authenticated user.
+// GET /api/v1/webhooks
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	ownerID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	limit, offset, ok := parseWebhookPage(c)
+	if !ok {
+		return
+	}
+
+	// Fetch limit + 1 items to check if there is a next page.
+	webhooks, err := h.webhookService.ListWebhooks(c.Request.Context(), sqlc.ListWebhooksParams{
+		OwnerID: pgtype.Int8{Int64: ownerID, Valid: true},
+		Limit:   int32(limit + 1),
+		Offset:  int32(offset),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhooks: " + err.Error()})
+		return
+	}
+
+	hasMore := len(webhooks) > limit
+	if hasMore {
+		webhooks = webhooks[:limit]
+	}
+	data := make([]WebhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		data = append(data, webhookResponse(webhook))
+	}
+	c.JSON(http.StatusOK, PaginatedWebhooksResponse{Data: data, HasMore: hasMore, Limit: limit, Offset: offset})
+}
+
+// EnableWebhook enables a webhook of the authenticated user disabled after repeated delivery failures.
+// POST /api/v1/webhooks/:id/enable
+func (h *WebhookHandler) EnableWebhook(c *gin.Context) {
+	webhook, ok := h.authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	webhook, err := h.webhookService.EnableWebhook(c.Request.Context(), webhook.ID)
+	if err != nil {
+		webhookError(c, err, "Failed to enable webhook: ")
+		return
+	}
+	c.JSON(http.StatusOK, webhookResponse(webhook))
+}
+
+// DeleteWebhook deletes a webhook of the authenticated user along with its delivery log.
+// DELETE /api/v1/webhooks/:id
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := h.webhookService.DeleteWebhook(c.Request.Context(), webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook: " + err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries handles fetching a page of the delivery log of a webhook of the authenticated user.
+// GET /api/v1/webhooks/:id/deliveries
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	limit, offset, ok := parseWebhookPage(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(c.Request.Context(), sqlc.ListWebhookDeliveriesParams{
+		WebhookID: webhook.ID,
+		Limit:     int32(limit + 1),
+		Offset:    int32(offset),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook deliveries: " + err.Error()})
+		return
+	}
+
+	hasMore := len(deliveries) > limit
+	if hasMore {
+		deliveries = deliveries[:limit]
+	}
+	data := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		res := WebhookDeliveryResponse{
+			ID:        d.ID,
+			EventID:   d.EventID,
+			EventType: d.EventType,
+			Status:    d.Status,
+			Attempts:  d.Attempts,
+			LastError: d.LastError.String,
+			CreatedAt: d.CreatedAt,
+		}
+		if d.ResponseStatus.Valid {
+			res.ResponseStatus = &d.ResponseStatus.Int32
+		}
+		if d.Status == repository.WebhookDeliveryPending {
+			res.NextAttemptAt = &d.NextAttemptAt
+		}
+		if d.DeliveredAt.Valid {
+			res.DeliveredAt = &d.DeliveredAt.Time
+		}
+		data = append(data, res)
+	}
+	c.JSON(http.StatusOK, PaginatedWebhookDeliveriesResponse{Data: data, HasMore: hasMore, Limit: limit, Offset: offset})
+}
+
+// authorizeWebhook returns the webhook of the path, answering 401 to anonymous requests and 403 to those
+// of another user than its owner
+func (h *WebhookHandler) authorizeWebhook(c *gin.Context) (sqlc.Webhook, bool) {
+	id, ok := parseWebhookID(c)
+	if !ok {
+		return sqlc.Webhook{}, false
+	}
+	authUserID, ok := authenticatedUser(c)
+	if !ok {
+		return sqlc.Webhook{}, false
+	}
+
+	webhook, err := h.webhookService.GetWebhook(c.Request.Context(), id)
+	if err != nil {
+		webhookError(c, err, "Failed to retrieve webhook: ")
+		return sqlc.Webhook{}, false
+	}
+	if !webhook.OwnerID.Valid || webhook.OwnerID.Int64 != authUserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Webhooks can only be managed by their owner"})
+		return sqlc.Webhook{}, false
+	}
+	return webhook, true
+}
+
+func parseWebhookID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID format"})
+		return 0, false
+	}
+	return id, true
+}
+
+func parseWebhookPage(c *gin.Context) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return 0, 0, false
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
+
+// webhookError answers 404 for a missing webhook and 500 otherwise
+func webhookError(c *gin.Context, err error, message string) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message + err.Error()})
+}
+
+func webhookResponse(webhook sqlc.Webhook) WebhookResponse {
+	res := WebhookResponse{
+		ID:                  webhook.ID,
+		OwnerID:             webhook.OwnerID.Int64,
+		URL:                 webhook.Url,
+		EventTypes:          webhook.EventTypes,
+		UserIDs:             webhook.UserIds,
+		Enabled:             !webhook.DisabledAt.Valid,
+		ConsecutiveFailures: webhook.ConsecutiveFailures,
+		CreatedAt:           webhook.CreatedAt,
+	}
+	if webhook.DisabledAt.Valid {
+		res.DisabledAt = &webhook.DisabledAt.Time
+	}
+	return res
+}
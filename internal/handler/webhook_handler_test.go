@@ -0,0 +1,228 @@
+//go:build unit
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/middleware"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	servicemocks "github.com/n1207n/cache-query-aggregator/internal/service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupWebhookRouter(webhookHandler *WebhookHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.Identity(testGateways))
+	router.POST("/api/v1/webhooks", webhookHandler.CreateWebhook)
+	router.GET("/api/v1/webhooks", webhookHandler.ListWebhooks)
+	router.GET("/api/v1/webhooks/:id", webhookHandler.GetWebhook)
+	router.DELETE("/api/v1/webhooks/:id", webhookHandler.DeleteWebhook)
+	router.POST("/api/v1/webhooks/:id/enable", webhookHandler.EnableWebhook)
+	router.GET("/api/v1/webhooks/:id/deliveries", webhookHandler.ListDeliveries)
+	return router
+}
+
+// webhookRequest sends a request to the router as userID, anonymously when empty
+func webhookRequest(router *gin.Engine, method, path, userID string, body io.Reader) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		authenticate(req, userID)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// ownedBy is the owner of a webhook created by userID
+func ownedBy(userID int64) pgtype.Int8 {
+	return pgtype.Int8{Int64: userID, Valid: true}
+}
+
+func TestWebhookHandler_CreateWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("success_returns_secret", func(t *testing.T) {
+		mockService := new(servicemocks.WebhookService)
+		router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+		params := sqlc.CreateWebhookParams{Url: "https://example.com/hooks", EventTypes: []string{"post.created"}, UserIds: []int64{1}, OwnerID: ownedBy(7)}
+		mockService.On("CreateWebhook", mock.Anything, params).Return(sqlc.Webhook{
+			ID:         1,
+			OwnerID:    params.OwnerID,
+			Url:        params.Url,
+			EventTypes: params.EventTypes,
+			UserIds:    params.UserIds,
+			Secret:     "whsec_generated",
+			CreatedAt:  time.Now(),
+		}, nil).Once()
+
+		body, _ := json.Marshal(CreateWebhookRequest{URL: params.Url, EventTypes: params.EventTypes, UserIDs: params.UserIds})
+		rr := webhookRequest(router, http.MethodPost, "/api/v1/webhooks", "7", bytes.NewBuffer(body))
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var res WebhookResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, "whsec_generated", res.Secret)
+		assert.Equal(t, int64(7), res.OwnerID)
+		assert.True(t, res.Enabled)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid_webhook", func(t *testing.T) {
+		mockService := new(servicemocks.WebhookService)
+		router := setupWebhookRouter(NewWebhookHandler(mockService))
+		mockService.On("CreateWebhook", mock.Anything, mock.Anything).
+			Return(nil, fmt.Errorf("%w: url must be an absolute http or https URL", service.ErrInvalidWebhook)).Once()
+
+		rr := webhookRequest(router, http.MethodPost, "/api/v1/webhooks", "7", bytes.NewBufferString(`{"url":"/hooks","event_types":["post.created"]}`))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		mockService := new(servicemocks.WebhookService)
+		router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+		rr := webhookRequest(router, http.MethodPost, "/api/v1/webhooks", "", bytes.NewBufferString(`{"url":"https://example.com/hooks","event_types":["post.created"]}`))
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		mockService.AssertNotCalled(t, "CreateWebhook")
+	})
+}
+
+func TestWebhookHandler_ListWebhooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.WebhookService)
+	router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+	mockService.On("ListWebhooks", mock.Anything, sqlc.ListWebhooksParams{OwnerID: ownedBy(7), Limit: 21, Offset: 0}).
+		Return([]sqlc.Webhook{{ID: 1, OwnerID: ownedBy(7)}}, nil).Once()
+
+	rr := webhookRequest(router, http.MethodGet, "/api/v1/webhooks", "7", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res PaginatedWebhooksResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.Len(t, res.Data, 1)
+	assert.Equal(t, int64(7), res.Data[0].OwnerID)
+
+	assert.Equal(t, http.StatusUnauthorized, webhookRequest(router, http.MethodGet, "/api/v1/webhooks", "", nil).Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestWebhookHandler_GetWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.WebhookService)
+	router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+	disabledAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockService.On("GetWebhook", mock.Anything, int64(1)).Return(sqlc.Webhook{
+		ID:                  1,
+		OwnerID:             ownedBy(7),
+		Url:                 "https://example.com/hooks",
+		Secret:              "whsec_hidden",
+		ConsecutiveFailures: 20,
+		DisabledAt:          pgtype.Timestamptz{Time: disabledAt, Valid: true},
+	}, nil).Twice()
+	mockService.On("GetWebhook", mock.Anything, int64(2)).Return(nil, pgx.ErrNoRows).Once()
+
+	rr := webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1", "7", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res WebhookResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.Empty(t, res.Secret)
+	assert.False(t, res.Enabled)
+	assert.Equal(t, disabledAt, *res.DisabledAt)
+
+	assert.Equal(t, http.StatusNotFound, webhookRequest(router, http.MethodGet, "/api/v1/webhooks/2", "7", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1", "8", nil).Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestWebhookHandler_EnableWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.WebhookService)
+	router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+	mockService.On("GetWebhook", mock.Anything, int64(1)).Return(sqlc.Webhook{ID: 1, OwnerID: ownedBy(7)}, nil).Twice()
+	mockService.On("EnableWebhook", mock.Anything, int64(1)).Return(sqlc.Webhook{ID: 1, OwnerID: ownedBy(7)}, nil).Once()
+
+	rr := webhookRequest(router, http.MethodPost, "/api/v1/webhooks/1/enable", "7", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res WebhookResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.True(t, res.Enabled)
+
+	assert.Equal(t, http.StatusUnauthorized, webhookRequest(router, http.MethodPost, "/api/v1/webhooks/1/enable", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, webhookRequest(router, http.MethodPost, "/api/v1/webhooks/1/enable", "8", nil).Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestWebhookHandler_DeleteWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.WebhookService)
+	router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+	mockService.On("GetWebhook", mock.Anything, int64(1)).Return(sqlc.Webhook{ID: 1, OwnerID: ownedBy(7)}, nil).Twice()
+	mockService.On("GetWebhook", mock.Anything, int64(2)).Return(nil, pgx.ErrNoRows).Once()
+	// Webhooks created before owners belong to nobody
+	mockService.On("GetWebhook", mock.Anything, int64(3)).Return(sqlc.Webhook{ID: 3}, nil).Once()
+	mockService.On("DeleteWebhook", mock.Anything, int64(1)).Return(true, nil).Once()
+
+	assert.Equal(t, http.StatusNoContent, webhookRequest(router, http.MethodDelete, "/api/v1/webhooks/1", "7", nil).Code)
+	assert.Equal(t, http.StatusNotFound, webhookRequest(router, http.MethodDelete, "/api/v1/webhooks/2", "7", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, webhookRequest(router, http.MethodDelete, "/api/v1/webhooks/1", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, webhookRequest(router, http.MethodDelete, "/api/v1/webhooks/1", "8", nil).Code)
+	assert.Equal(t, http.StatusForbidden, webhookRequest(router, http.MethodDelete, "/api/v1/webhooks/3", "7", nil).Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNumberOfCalls(t, "DeleteWebhook", 1)
+}
+
+func TestWebhookHandler_ListDeliveries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(servicemocks.WebhookService)
+	router := setupWebhookRouter(NewWebhookHandler(mockService))
+
+	deliveredAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockService.On("GetWebhook", mock.Anything, int64(1)).Return(sqlc.Webhook{ID: 1, OwnerID: ownedBy(7)}, nil).Twice()
+	mockService.On("ListDeliveries", mock.Anything, sqlc.ListWebhookDeliveriesParams{WebhookID: 1, Limit: 3, Offset: 0}).Return([]sqlc.WebhookDelivery{
+		{ID: 3, EventID: 30, EventType: "post.created", Status: "pending", Attempts: 1, NextAttemptAt: deliveredAt,
+			ResponseStatus: pgtype.Int4{Int32: 503, Valid: true}, LastError: pgtype.Text{String: "receiver answered 503 Service Unavailable", Valid: true}},
+		{ID: 2, EventID: 20, EventType: "post.created", Status: "delivered", Attempts: 1,
+			ResponseStatus: pgtype.Int4{Int32: 200, Valid: true}, DeliveredAt: pgtype.Timestamptz{Time: deliveredAt, Valid: true}},
+		{ID: 1, EventID: 10, EventType: "post.created", Status: "delivered", Attempts: 1},
+	}, nil).Once()
+
+	rr := webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1/deliveries?limit=2", "7", nil)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res PaginatedWebhookDeliveriesResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.True(t, res.HasMore)
+	require.Len(t, res.Data, 2)
+	assert.Equal(t, int32(503), *res.Data[0].ResponseStatus)
+	assert.Equal(t, "receiver answered 503 Service Unavailable", res.Data[0].LastError)
+	assert.Equal(t, deliveredAt, *res.Data[0].NextAttemptAt)
+	assert.Nil(t, res.Data[1].NextAttemptAt)
+	assert.Equal(t, deliveredAt, *res.Data[1].DeliveredAt)
+
+	assert.Equal(t, http.StatusUnauthorized, webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1/deliveries", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, webhookRequest(router, http.MethodGet, "/api/v1/webhooks/1/deliveries", "8", nil).Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNumberOfCalls(t, "ListDeliveries", 1)
+}
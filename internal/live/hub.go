@@ -0,0 +1,203 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// timelineChannelPrefix is followed by the user id in the pub/sub channel of the user's new posts
+	timelineChannelPrefix = "timeline:"
+)
+
+// Subscriber opens pub/sub connections, implemented by the Redis clients
+type Subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// pubSub is the pub/sub connection of a Hub, implemented by *redis.PubSub
+type pubSub interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	Channel(opts ...redis.ChannelOption) <-chan *redis.Message
+	Close() error
+}
+
+// Publish announces a new post to the subscribers of its author's timeline on every instance
+func Publish(ctx context.Context, rdb redis.Cmdable, post sqlc.Post) error {
+	payload, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("failed to marshal post %d for live timelines: %w", post.ID, err)
+	}
+	if err := rdb.Publish(ctx, timelineChannel(post.UserID), payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish post %d to live timelines: %w", post.ID, err)
+	}
+	return nil
+}
+
+func timelineChannel(userID int64) string {
+	return timelineChannelPrefix + strconv.FormatInt(userID, 10)
+}
+
+// HubOptions tunes Hub
+type HubOptions struct {
+	// Buffer is the number of posts a subscription holds for its reader. A subscription falling further
+	// behind is closed rather than slowing the others down.
+	Buffer int
+}
+
+// Hub shares one pub/sub connection between the live timeline subscriptions of the instance. A user's
+// channel is subscribed to while the user has subscribers on the instance.
+type Hub struct {
+	rdb  Subscriber
+	opts HubOptions
+
+	mu      sync.Mutex
+	pubsub  pubSub
+	subs    map[int64]map[*subscription]struct{}
+	stopped bool
+
+	wg sync.WaitGroup
+}
+
+// NewHub creates a new Hub
+func NewHub(rdb Subscriber, opts HubOptions) *Hub {
+	if opts.Buffer < 1 {
+		opts.Buffer = 1
+	}
+	return &Hub{
+		rdb:  rdb,
+		opts: opts,
+		subs: make(map[int64]map[*subscription]struct{}),
+	}
+}
+
+// Start opens the pub/sub connection and dispatches the published posts until Stop
+func (h *Hub) Start(ctx context.Context) {
+	h.listen(h.rdb.Subscribe(ctx))
+}
+
+func (h *Hub) listen(pubsub pubSub) {
+	h.mu.Lock()
+	h.pubsub = pubsub
+	h.mu.Unlock()
+
+	ch := pubsub.Channel()
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for msg := range ch {
+			h.dispatch(msg)
+		}
+	}()
+}
+
+// Stop closes the pub/sub connection and every subscription
+func (h *Hub) Stop() {
+	h.mu.Lock()
+	h.stopped = true
+	var subs []*subscription
+	for _, userSubs := range h.subs {
+		for s := range userSubs {
+			subs = append(subs, s)
+		}
+	}
+	h.subs = make(map[int64]map[*subscription]struct{})
+	h.mu.Unlock()
+
+	metrics.LiveTimelineSubscriptions.Sub(float64(len(subs)))
+	for _, s := range subs {
+		s.end(ErrHubStopped)
+	}
+	if h.pubsub != nil {
+		_ = h.pubsub.Close()
+	}
+	h.wg.Wait()
+}
+
+// Subscribe subscribes to the new posts of the user, until the subscription is closed
+func (h *Hub) Subscribe(ctx context.Context, userID int64) (Subscription, error) {
+	s := &subscription{
+		hub:    h,
+		userID: userID,
+		posts:  make(chan sqlc.Post, h.opts.Buffer),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped || h.pubsub == nil {
+		return nil, ErrHubStopped
+	}
+	userSubs, ok := h.subs[userID]
+	if !ok {
+		if err := h.pubsub.Subscribe(ctx, timelineChannel(userID)); err != nil {
+			return nil, fmt.Errorf("failed to subscribe to live timeline of user %d: %w", userID, err)
+		}
+		userSubs = make(map[*subscription]struct{})
+		h.subs[userID] = userSubs
+	}
+	userSubs[s] = struct{}{}
+	metrics.LiveTimelineSubscriptions.Inc()
+	return s, nil
+}
+
+// remove drops the subscription, and the user's channel along with their last subscription
+func (h *Hub) remove(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	userSubs, ok := h.subs[s.userID]
+	if !ok {
+		return
+	}
+	if _, ok := userSubs[s]; !ok {
+		return
+	}
+	delete(userSubs, s)
+	metrics.LiveTimelineSubscriptions.Dec()
+	if len(userSubs) > 0 {
+		return
+	}
+	delete(h.subs, s.userID)
+	if err := h.pubsub.Unsubscribe(context.Background(), timelineChannel(s.userID)); err != nil {
+		log.Printf("failed to unsubscribe from live timeline of user %d: %v", s.userID, err)
+	}
+}
+
+// dispatch hands a published post to the subscriptions of its author, closing those that are full
+func (h *Hub) dispatch(msg *redis.Message) {
+	userID, err := strconv.ParseInt(strings.TrimPrefix(msg.Channel, timelineChannelPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+	var post sqlc.Post
+	if err := json.Unmarshal([]byte(msg.Payload), &post); err != nil {
+		log.Printf("dropping malformed live post of user %d: %v", userID, err)
+		return
+	}
+
+	var overflowed []*subscription
+	h.mu.Lock()
+	for s := range h.subs[userID] {
+		select {
+		case s.posts <- post:
+		default:
+			overflowed = append(overflowed, s)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range overflowed {
+		metrics.LiveTimelineOverflows.Inc()
+		s.end(ErrSlowSubscriber)
+	}
+}
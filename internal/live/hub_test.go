@@ -0,0 +1,182 @@
+//go:build unit
+
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePubSub records the channels subscribed to and delivers the messages sent on msgs
+type fakePubSub struct {
+	msgs         chan *redis.Message
+	subscribed   []string
+	unsubscribed []string
+	subscribeErr error
+}
+
+func newFakePubSub() *fakePubSub {
+	return &fakePubSub{msgs: make(chan *redis.Message)}
+}
+
+func (p *fakePubSub) Subscribe(_ context.Context, channels ...string) error {
+	if p.subscribeErr != nil {
+		return p.subscribeErr
+	}
+	p.subscribed = append(p.subscribed, channels...)
+	return nil
+}
+
+func (p *fakePubSub) Unsubscribe(_ context.Context, channels ...string) error {
+	p.unsubscribed = append(p.unsubscribed, channels...)
+	return nil
+}
+
+func (p *fakePubSub) Channel(...redis.ChannelOption) <-chan *redis.Message {
+	return p.msgs
+}
+
+func (p *fakePubSub) Close() error {
+	close(p.msgs)
+	return nil
+}
+
+func publishedPost(t *testing.T, post sqlc.Post) *redis.Message {
+	payload, err := json.Marshal(post)
+	require.NoError(t, err)
+	return &redis.Message{Channel: timelineChannel(post.UserID), Payload: string(payload)}
+}
+
+func receive(t *testing.T, sub Subscription) sqlc.Post {
+	select {
+	case post := <-sub.Posts():
+		return post
+	case <-time.After(time.Second):
+		t.Fatal("no post received")
+		return sqlc.Post{}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	rdb, rdbMock := redismock.NewClientMock()
+	post := sqlc.Post{ID: 10, UserID: 1, Content: "hello", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	payload, err := json.Marshal(post)
+	require.NoError(t, err)
+
+	rdbMock.ExpectPublish("timeline:1", payload).SetVal(1)
+
+	require.NoError(t, Publish(context.Background(), rdb, post))
+	assert.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestHub_Subscribe(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("shares_the_channel_of_a_user", func(t *testing.T) {
+		pubsub := newFakePubSub()
+		h := NewHub(nil, HubOptions{Buffer: 4})
+		h.listen(pubsub)
+		defer h.Stop()
+
+		first, err := h.Subscribe(ctx, 1)
+		require.NoError(t, err)
+		second, err := h.Subscribe(ctx, 1)
+		require.NoError(t, err)
+		other, err := h.Subscribe(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"timeline:1", "timeline:2"}, pubsub.subscribed)
+
+		post := sqlc.Post{ID: 10, UserID: 1, Content: "hello"}
+		pubsub.msgs <- publishedPost(t, post)
+		assert.Equal(t, post.ID, receive(t, first).ID)
+		assert.Equal(t, post.ID, receive(t, second).ID)
+		assert.Empty(t, other.Posts())
+
+		first.Close()
+		assert.Empty(t, pubsub.unsubscribed)
+		assert.NoError(t, first.Err())
+		second.Close()
+		assert.Equal(t, []string{"timeline:1"}, pubsub.unsubscribed)
+	})
+
+	t.Run("subscribe_failure", func(t *testing.T) {
+		pubsub := newFakePubSub()
+		pubsub.subscribeErr = errors.New("connection refused")
+		h := NewHub(nil, HubOptions{Buffer: 4})
+		h.listen(pubsub)
+		defer h.Stop()
+
+		_, err := h.Subscribe(ctx, 1)
+		assert.Error(t, err)
+		assert.Empty(t, h.subs)
+	})
+
+	t.Run("not_started", func(t *testing.T) {
+		h := NewHub(nil, HubOptions{})
+
+		_, err := h.Subscribe(ctx, 1)
+		assert.ErrorIs(t, err, ErrHubStopped)
+	})
+}
+
+func TestHub_Dispatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("closes_slow_subscriber", func(t *testing.T) {
+		pubsub := newFakePubSub()
+		h := NewHub(nil, HubOptions{Buffer: 1})
+		h.pubsub = pubsub
+		slow, err := h.Subscribe(ctx, 1)
+		require.NoError(t, err)
+
+		h.dispatch(publishedPost(t, sqlc.Post{ID: 10, UserID: 1}))
+		h.dispatch(publishedPost(t, sqlc.Post{ID: 11, UserID: 1}))
+
+		select {
+		case <-slow.Done():
+		default:
+			t.Fatal("slow subscription not closed")
+		}
+		assert.ErrorIs(t, slow.Err(), ErrSlowSubscriber)
+		assert.Equal(t, []string{"timeline:1"}, pubsub.unsubscribed)
+		// The posts buffered before the overflow are still delivered
+		assert.Equal(t, int64(10), receive(t, slow).ID)
+	})
+
+	t.Run("skips_malformed_messages", func(t *testing.T) {
+		h := NewHub(nil, HubOptions{Buffer: 1})
+		h.pubsub = newFakePubSub()
+		sub, err := h.Subscribe(ctx, 1)
+		require.NoError(t, err)
+
+		h.dispatch(&redis.Message{Channel: "timeline:1", Payload: "not json"})
+		h.dispatch(&redis.Message{Channel: "timeline:abc", Payload: "{}"})
+
+		assert.Empty(t, sub.Posts())
+		assert.NoError(t, sub.Err())
+	})
+}
+
+func TestHub_Stop(t *testing.T) {
+	pubsub := newFakePubSub()
+	h := NewHub(nil, HubOptions{Buffer: 1})
+	h.listen(pubsub)
+
+	sub, err := h.Subscribe(context.Background(), 1)
+	require.NoError(t, err)
+	h.Stop()
+
+	<-sub.Done()
+	assert.ErrorIs(t, sub.Err(), ErrHubStopped)
+	_, err = h.Subscribe(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrHubStopped)
+}
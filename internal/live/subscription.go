@@ -0,0 +1,67 @@
+package live
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+var (
+	// ErrSlowSubscriber ends a subscription whose reader fell more than the buffer behind
+	ErrSlowSubscriber = errors.New("live: subscriber fell behind")
+	// ErrHubStopped ends the subscriptions of a stopped Hub
+	ErrHubStopped = errors.New("live: hub stopped")
+)
+
+// Subscription receives the new posts of a user's timeline
+type Subscription interface {
+	// Posts delivers the new posts in the order they were published
+	Posts() <-chan sqlc.Post
+	// Done is closed when the subscription ends, see Err
+	Done() <-chan struct{}
+	// Err tells why the subscription ended: ErrSlowSubscriber, ErrHubStopped, or nil once closed by its reader
+	Err() error
+	// Close ends the subscription
+	Close()
+}
+
+// subscription is a Subscription of a Hub
+type subscription struct {
+	hub    *Hub
+	userID int64
+	posts  chan sqlc.Post
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (s *subscription) Posts() <-chan sqlc.Post {
+	return s.posts
+}
+
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *subscription) Close() {
+	s.end(nil)
+}
+
+func (s *subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+		s.hub.remove(s)
+	})
+}
@@ -35,4 +35,282 @@ var (
 		Name: "redis_node_reads_by_user_total",
 		Help: "Total number of reads from a specific Redis node, partitioned by user.",
 	}, []string{"node_addr", "user_id"})
+
+	// RateLimitRejections counts requests rejected by the rate limiter, partitioned by rule
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Total number of requests rejected by the rate limiter, partitioned by rule.",
+	}, []string{"rule"})
+
+	// IdempotentReplays counts responses replayed for a repeated Idempotency-Key
+	IdempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idempotent_replays_total",
+		Help: "Total number of stored responses replayed for a repeated Idempotency-Key.",
+	})
+
+	// FeedShardsTouched tells # of Redis nodes read to build one home feed
+	FeedShardsTouched = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "feed_shards_touched",
+		Help:    "Number of Redis nodes read per home feed request.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 7),
+	})
+
+	// FeedColdTimelines counts followee timelines read from DB because they were not cached
+	FeedColdTimelines = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feed_cold_timelines_total",
+		Help: "Total number of followee timelines read from DB while building home feeds.",
+	})
+
+	// FeedFanoutJobs counts fan-out jobs of new posts, partitioned by result
+	FeedFanoutJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feed_fanout_jobs_total",
+		Help: "Total number of post fan-out jobs, partitioned by result (delivered, celebrity).",
+	}, []string{"result"})
+
+	// AggregatorShardFailures counts scatter-gather sub-queries that failed or missed their deadline, partitioned by node
+	AggregatorShardFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_shard_failures_total",
+		Help: "Total number of scatter-gather shard queries that failed or timed out, partitioned by node.",
+	}, []string{"node_addr"})
+
+	// AggregatorHedges counts duplicate reads sent for slow shards, partitioned by node
+	AggregatorHedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_hedges_total",
+		Help: "Total number of hedged reads sent because a shard was slower than its recent latency, partitioned by node.",
+	}, []string{"node_addr"})
+
+	// AggregatorHedgeWins counts hedged reads that answered before the original read, partitioned by node
+	AggregatorHedgeWins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_hedge_wins_total",
+		Help: "Total number of hedged reads that answered before the original read, partitioned by node.",
+	}, []string{"node_addr"})
+
+	// AggregatorTopologyRefreshes counts reloads of the cluster slot map, partitioned by trigger and result
+	AggregatorTopologyRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_topology_refreshes_total",
+		Help: "Total number of cluster slot map reloads, partitioned by trigger (periodic, redirect) and result (refreshed, failed).",
+	}, []string{"trigger", "result"})
+
+	// RedisCircuitState tells the circuit breaker state of every Redis node
+	RedisCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redis_circuit_state",
+		Help: "Circuit breaker state of a Redis node: 0 closed, 1 half-open, 2 open.",
+	}, []string{"node_addr"})
+
+	// DegradedDBReads counts DB reads made in place of cache nodes with an open circuit, partitioned by result
+	DegradedDBReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "degraded_db_reads_total",
+		Help: "Total number of DB reads made while their cache node circuit was open, partitioned by result (served, rejected).",
+	}, []string{"result"})
+
+	// PostStaleServed counts Posts served from their last known good copy while DB was unavailable
+	PostStaleServed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "post_repository_stale_served_total",
+		Help: "Total number of posts served from their last known good copy while the DB was unavailable.",
+	})
+
+	// DBCircuitState tells the state of the DB health circuit
+	DBCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "db_circuit_state",
+		Help: "DB health circuit state: 0 closed, 1 half-open, 2 open.",
+	})
+
+	// AdmissionLimit tells the adaptive concurrency limit of the API
+	AdmissionLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_concurrency_limit",
+		Help: "Current adaptive limit of API requests in flight.",
+	})
+
+	// AdmissionInflight tells the API requests in flight
+	AdmissionInflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_inflight_requests",
+		Help: "Number of admitted API requests in flight.",
+	})
+
+	// AdmissionRejections counts requests shed by admission control, partitioned by priority
+	AdmissionRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_rejections_total",
+		Help: "Total number of API requests shed by admission control, partitioned by priority (anonymous, authenticated, write).",
+	}, []string{"priority"})
+
+	// DBReplicaLag tells the replication lag of a read replica
+	DBReplicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "db_replica_lag_seconds",
+		Help: "Replication lag of a Postgres read replica in seconds.",
+	}, []string{"replica"})
+
+	// DBReplicaHealthy tells whether a read replica serves reads
+	DBReplicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "db_replica_healthy",
+		Help: "Whether a Postgres read replica serves reads: 1 serving, 0 pulled back for lag or errors.",
+	}, []string{"replica"})
+
+	// DBReads counts DB reads, partitioned by the server they were routed to
+	DBReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_reads_total",
+		Help: "Total number of DB reads, partitioned by target (primary or the replica name).",
+	}, []string{"target"})
+
+	// DBShardQueries counts queries run on every database shard
+	DBShardQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_shard_queries_total",
+		Help: "Total number of queries run on a database shard, partitioned by shard index.",
+	}, []string{"shard"})
+
+	// PostsPartitionMaintenance counts the runs of the posts partition maintenance, partitioned by result
+	PostsPartitionMaintenance = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "posts_partition_maintenance_total",
+		Help: "Total number of posts partition maintenance runs per shard, partitioned by result (success, failed).",
+	}, []string{"result"})
+
+	// CacheInvalidationChanges counts the DB changes applied to the cache, partitioned by table and operation
+	CacheInvalidationChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_invalidation_changes_total",
+		Help: "Total number of DB changes applied to the cache, partitioned by table and op (I, U, D).",
+	}, []string{"table", "op"})
+
+	// CacheInvalidationBatches counts the batches of DB changes applied to the cache, partitioned by result
+	CacheInvalidationBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_invalidation_batches_total",
+		Help: "Total number of batches of DB changes applied to the cache, partitioned by result (success, failed).",
+	}, []string{"result"})
+
+	// CacheInvalidationLag tells how far behind DB the cache of a shard is
+	CacheInvalidationLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cache_invalidation_lag_seconds",
+		Help: "Age in seconds of the last DB change applied to the cache per shard, 0 once every change is applied.",
+	}, []string{"shard"})
+
+	// OutboxEvents counts the outbox events handled by the relay, partitioned by result
+	OutboxEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_total",
+		Help: "Total number of outbox events handled by the relay, partitioned by result (sent, retried, dead_lettered).",
+	}, []string{"result"})
+
+	// OutboxLag tells how long the oldest due event of a shard has waited to be published
+	OutboxLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "outbox_lag_seconds",
+		Help: "Age in seconds of the oldest outbox event due for publishing per shard, 0 once every event is sent.",
+	}, []string{"shard"})
+
+	// StreamConsumerEvents counts the stream events of a consumer group, partitioned by result
+	StreamConsumerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_consumer_events_total",
+		Help: "Total number of stream events of a consumer group, partitioned by result (handled, failed, claimed, dropped, malformed).",
+	}, []string{"group", "result"})
+
+	// StreamConsumerLag tells how far behind the stream a consumer group is
+	StreamConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_consumer_lag_seconds",
+		Help: "Age in seconds of the last stream event read by a consumer group, 0 once no new event is left.",
+	}, []string{"stream", "group"})
+
+	// StreamConsumerPending tells how many events of a consumer group are delivered but not acknowledged
+	StreamConsumerPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_consumer_pending",
+		Help: "Number of stream events delivered to a consumer group and not acknowledged yet.",
+	}, []string{"stream", "group"})
+
+	// LiveTimelineSubscriptions tells how many live timeline subscriptions the instance holds
+	LiveTimelineSubscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "live_timeline_subscriptions",
+		Help: "Number of live timeline subscriptions held by the instance.",
+	})
+
+	// LiveTimelineOverflows counts the live timeline subscriptions closed for falling behind
+	LiveTimelineOverflows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "live_timeline_overflows_total",
+		Help: "Total number of live timeline subscriptions closed because their reader fell behind.",
+	})
+
+	// TimelineStreamConnections tells how many timeline streams are open, partitioned by transport
+	TimelineStreamConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "timeline_stream_connections",
+		Help: "Number of open timeline streams, partitioned by transport (sse, websocket).",
+	}, []string{"transport"})
+
+	// TimelineStreamRejections counts the timeline streams refused at the connection limit
+	TimelineStreamRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "timeline_stream_rejections_total",
+		Help: "Total number of timeline streams refused because the instance holds its maximum of streams.",
+	})
+
+	// WebhookDeliveries counts the delivery attempts of webhooks, partitioned by result
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Total number of webhook delivery attempts, partitioned by result (delivered, retried, failed).",
+	}, []string{"result"})
+
+	// WebhookDeliveryDuration tracks how long receivers take to answer a delivery
+	WebhookDeliveryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhook_delivery_duration_seconds",
+		Help:    "Duration in seconds of webhook delivery requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// WebhooksDisabled counts the webhooks disabled after repeated delivery failures
+	WebhooksDisabled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhooks_disabled_total",
+		Help: "Total number of webhooks disabled after consecutive delivery failures.",
+	})
+
+	// ReactionCountMisses counts the reads of reaction counts that were not in Redis, seeded by the next flush
+	ReactionCountMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reaction_count_misses_total",
+		Help: "Total number of reads of post reaction counts missing from Redis.",
+	})
+
+	// ReactionFlushes counts the posts whose reaction counts were flushed to DB, partitioned by result
+	ReactionFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reaction_flushes_total",
+		Help: "Total number of posts handled by the reaction counts flush, partitioned by result (flushed, failed).",
+	}, []string{"result"})
+
+	// ReactedCacheHits counts the reaction checks answered by the cached set of the user
+	ReactedCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reacted_cache_hits_total",
+		Help: "Total number of checks of a user's reaction answered from cache.",
+	})
+
+	// ReactedCacheMisses counts the reaction checks of users whose set was not cached
+	ReactedCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reacted_cache_misses_total",
+		Help: "Total number of checks of a user's reaction that missed the cache.",
+	})
+
+	// ViewsRecorded counts the views recorded for unique view counting, partitioned by kind
+	ViewsRecorded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "views_recorded_total",
+		Help: "Total number of views recorded for unique view counting, partitioned by kind (post, user).",
+	}, []string{"kind"})
+
+	// ViewRollups counts the posts and timelines whose viewers were rolled up, partitioned by result
+	ViewRollups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "view_rollups_total",
+		Help: "Total number of posts and timelines handled by the unique view rollup, partitioned by result (rolled_up, failed).",
+	}, []string{"result"})
+
+	// TrendingRefreshes counts the rebuilds of the trending windows, partitioned by result
+	TrendingRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "trending_refreshes_total",
+		Help: "Total number of rebuilds of the trending windows, partitioned by result (refreshed, failed).",
+	}, []string{"result"})
+
+	// TrendingReads counts the reads of trending posts, partitioned by source
+	TrendingReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "trending_reads_total",
+		Help: "Total number of reads of trending posts, partitioned by source (memory, redis).",
+	}, []string{"source"})
+
+	// ReplyThreadCacheHits counts the reads of reply threads answered from cache
+	ReplyThreadCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reply_thread_cache_hits_total",
+		Help: "Total number of reads of a reply thread answered from cache.",
+	})
+
+	// ReplyThreadCacheMisses counts the reads of reply threads that were not cached
+	ReplyThreadCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reply_thread_cache_misses_total",
+		Help: "Total number of reads of a reply thread that missed the cache.",
+	})
 )
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// Priority orders requests under saturation, lower priorities are shed first
+type Priority int
+
+const (
+	PriorityAnonymous Priority = iota
+	PriorityAuthenticated
+	PriorityWrite
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityWrite:
+		return "write"
+	case PriorityAuthenticated:
+		return "authenticated"
+	default:
+		return "anonymous"
+	}
+}
+
+// priorityShares is the part of the concurrency limit every priority may fill, so that anonymous
+// scrapes are shed while writes and authenticated reads still get through
+var priorityShares = map[Priority]float64{
+	PriorityAnonymous:     0.6,
+	PriorityAuthenticated: 0.85,
+	PriorityWrite:         1,
+}
+
+// RequestPriority ranks writes over authenticated reads, and those over anonymous reads
+func RequestPriority(c *gin.Context) Priority {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return PriorityWrite
+	}
+	if _, ok := AuthUserID(c); ok {
+		return PriorityAuthenticated
+	}
+	return PriorityAnonymous
+}
+
+// AdmissionOptions tunes the adaptive concurrency limit
+type AdmissionOptions struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// LatencyTarget is the latency above which a request signals congestion
+	LatencyTarget time.Duration
+	// Backoff multiplies the limit on congestion, e.g. 0.9
+	Backoff float64
+	// RetryAfter is sent with shed requests
+	RetryAfter time.Duration
+}
+
+// AdmissionController bounds the requests in flight with an AIMD limit: every request completing
+// within LatencyTarget while the server is busy grows the limit by 1/limit, i.e. by one per
+// limit's worth of requests, and a slow or 503 response shrinks it by Backoff.
+type AdmissionController struct {
+	opts AdmissionOptions
+	now  func() time.Time
+
+	mu           sync.Mutex
+	limit        float64
+	inflight     int
+	lastDecrease time.Time
+}
+
+// NewAdmissionController creates a new instance of AdmissionController
+func NewAdmissionController(opts AdmissionOptions) *AdmissionController {
+	if opts.MinLimit < 1 {
+		opts.MinLimit = 1
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	opts.InitialLimit = max(opts.MinLimit, min(opts.InitialLimit, opts.MaxLimit))
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		opts.Backoff = 0.9
+	}
+
+	metrics.AdmissionLimit.Set(float64(opts.InitialLimit))
+	return &AdmissionController{
+		opts:  opts,
+		now:   time.Now,
+		limit: float64(opts.InitialLimit),
+	}
+}
+
+// Handler returns a gin middleware shedding requests beyond their priority's share of the limit
+// with 503 and Retry-After
+func (a *AdmissionController) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		priority := RequestPriority(c)
+		start, ok := a.acquire(priority)
+		if !ok {
+			metrics.AdmissionRejections.WithLabelValues(priority.String()).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(a.opts.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is overloaded, please retry"})
+			return
+		}
+
+		defer func() {
+			a.release(start, c.Writer.Status() == http.StatusServiceUnavailable)
+		}()
+		c.Next()
+	}
+}
+
+// acquire admits a request of priority, returning its start time
+func (a *AdmissionController) acquire(priority Priority) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	allowed := int(math.Max(1, math.Floor(a.limit*priorityShares[priority])))
+	if a.inflight >= allowed {
+		return time.Time{}, false
+	}
+	a.inflight++
+	metrics.AdmissionInflight.Set(float64(a.inflight))
+	return a.now(), true
+}
+
+// release completes a request started at start, adjusting the limit with its outcome.
+// Only requests started after the last decrease can shrink the limit again, so that one slow
+// burst backs off once.
+func (a *AdmissionController) release(start time.Time, overloaded bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	inflight := a.inflight
+	a.inflight--
+	metrics.AdmissionInflight.Set(float64(a.inflight))
+
+	congested := overloaded || (a.opts.LatencyTarget > 0 && now.Sub(start) > a.opts.LatencyTarget)
+	switch {
+	case congested && start.After(a.lastDecrease):
+		a.limit = math.Max(float64(a.opts.MinLimit), a.limit*a.opts.Backoff)
+		a.lastDecrease = now
+	case !congested && float64(inflight) >= a.limit/2:
+		// An idle server learns nothing about its capacity
+		a.limit = math.Min(float64(a.opts.MaxLimit), a.limit+1/a.limit)
+	default:
+		return
+	}
+	metrics.AdmissionLimit.Set(a.limit)
+}
+
+// Limit returns the current concurrency limit
+func (a *AdmissionController) Limit() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
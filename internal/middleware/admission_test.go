@@ -0,0 +1,155 @@
+//go:build unit
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAdmission returns a controller whose clock is moved by the returned function
+func newTestAdmission(opts AdmissionOptions) (*AdmissionController, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	a := NewAdmissionController(opts)
+	a.now = func() time.Time { return now }
+	return a, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRequestPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	priority := func(method string, userID int64) Priority {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest(method, "/api/v1/posts", nil)
+		if userID != 0 {
+			c.Set(AuthUserIDKey, userID)
+		}
+		return RequestPriority(c)
+	}
+
+	assert.Equal(t, PriorityWrite, priority(http.MethodPost, 0))
+	assert.Equal(t, PriorityWrite, priority(http.MethodDelete, 1))
+	assert.Equal(t, PriorityAuthenticated, priority(http.MethodGet, 1))
+	assert.Equal(t, PriorityAnonymous, priority(http.MethodGet, 0))
+}
+
+func TestAdmissionController_Shares(t *testing.T) {
+	a, _ := newTestAdmission(AdmissionOptions{InitialLimit: 10, MinLimit: 1, MaxLimit: 10})
+
+	// Anonymous reads fill 6 of the 10 slots
+	for i := 0; i < 6; i++ {
+		_, ok := a.acquire(PriorityAnonymous)
+		require.True(t, ok)
+	}
+	_, ok := a.acquire(PriorityAnonymous)
+	assert.False(t, ok)
+
+	// Authenticated reads and writes still get through
+	for i := 0; i < 2; i++ {
+		_, ok = a.acquire(PriorityAuthenticated)
+		require.True(t, ok)
+	}
+	_, ok = a.acquire(PriorityAuthenticated)
+	assert.False(t, ok)
+	for i := 0; i < 2; i++ {
+		_, ok = a.acquire(PriorityWrite)
+		require.True(t, ok)
+	}
+	_, ok = a.acquire(PriorityWrite)
+	assert.False(t, ok)
+}
+
+func TestNewAdmissionController_ClampsInitialLimit(t *testing.T) {
+	limits := map[string]struct{ initial, want int }{
+		"within":      {initial: 50, want: 50},
+		"below_min":   {initial: 2, want: 10},
+		"above_max":   {initial: 500, want: 100},
+		"unspecified": {initial: 0, want: 10},
+	}
+	for name, l := range limits {
+		t.Run(name, func(t *testing.T) {
+			a := NewAdmissionController(AdmissionOptions{InitialLimit: l.initial, MinLimit: 10, MaxLimit: 100})
+			assert.Equal(t, float64(l.want), a.limit)
+		})
+	}
+}
+
+func TestAdmissionController_AIMD(t *testing.T) {
+	opts := AdmissionOptions{InitialLimit: 10, MinLimit: 2, MaxLimit: 20, LatencyTarget: 100 * time.Millisecond, Backoff: 0.5}
+
+	t.Run("grows_while_busy", func(t *testing.T) {
+		a, advance := newTestAdmission(opts)
+
+		starts := make([]time.Time, 10)
+		for i := range starts {
+			starts[i], _ = a.acquire(PriorityWrite)
+		}
+		advance(10 * time.Millisecond)
+		for _, start := range starts {
+			a.release(start, false)
+		}
+		// Grows by less than one per limit's worth of fast requests
+		assert.Greater(t, a.Limit(), float64(10))
+		assert.Less(t, a.Limit(), float64(11))
+	})
+
+	t.Run("idle_does_not_grow", func(t *testing.T) {
+		a, _ := newTestAdmission(opts)
+
+		start, _ := a.acquire(PriorityWrite)
+		a.release(start, false)
+		assert.Equal(t, float64(10), a.Limit())
+	})
+
+	t.Run("backs_off_once_per_burst", func(t *testing.T) {
+		a, advance := newTestAdmission(opts)
+
+		first, _ := a.acquire(PriorityWrite)
+		second, _ := a.acquire(PriorityWrite)
+		advance(time.Second)
+		a.release(first, false)
+		a.release(second, false)
+		assert.Equal(t, float64(5), a.Limit())
+
+		// Later congestion backs off again, down to the minimum
+		advance(time.Millisecond)
+		start, _ := a.acquire(PriorityWrite)
+		a.release(start, true)
+		assert.Equal(t, 2.5, a.Limit())
+		advance(time.Millisecond)
+		start, _ = a.acquire(PriorityWrite)
+		a.release(start, true)
+		assert.Equal(t, float64(2), a.Limit())
+	})
+}
+
+func TestAdmissionController_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := NewAdmissionController(AdmissionOptions{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, RetryAfter: 2 * time.Second})
+
+	router := gin.New()
+	router.Use(a.Handler())
+	router.GET("/api/v1/posts/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts/1", nil)
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The only slot is taken
+	_, ok := a.acquire(PriorityWrite)
+	require.True(t, ok)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+}
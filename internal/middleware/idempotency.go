@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header clients set to make a POST retry-safe
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed from the idempotency store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	idempotencyKeyPattern   = "{idempotency:%s:%s}"
+	idempotencyMaxKeyLength = 255
+	idempotencyLockTTL      = 1 * time.Minute
+
+	idempotencyStateInFlight  = "in_flight"
+	idempotencyStateCompleted = "completed"
+)
+
+type idempotencyRecord struct {
+	State       string `json:"state"`
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Idempotency stores POST responses in Redis under the client's Idempotency-Key
+type Idempotency struct {
+	rdb redis.Cmdable
+	ttl time.Duration
+}
+
+// NewIdempotency creates a new instance of Idempotency
+func NewIdempotency(rdb redis.Cmdable, ttl time.Duration) *Idempotency {
+	return &Idempotency{rdb: rdb, ttl: ttl}
+}
+
+// responseRecorder tees the response body so it can be stored after the handler completes
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Handler returns a gin middleware for POST requests carrying an Idempotency-Key header.
+// A retry with the same payload replays the stored response, a different payload gets 422
+// and a duplicate arriving while the first request is still running gets 409.
+func (i *Idempotency) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idemKey := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || idemKey == "" {
+			c.Next()
+			return
+		}
+
+		if len(idemKey) > idempotencyMaxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		key := fmt.Sprintf(idempotencyKeyPattern, ClientKey(c), idemKey)
+		fingerprint := requestFingerprint(c.Request.Method, c.FullPath(), body)
+
+		inFlight, _ := json.Marshal(idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint})
+		acquired, err := i.rdb.SetNX(ctx, key, string(inFlight), idempotencyLockTTL).Result()
+		if err != nil {
+			log.Printf("idempotency store error for key %s: %v", key, err)
+			c.Next()
+			return
+		}
+
+		if !acquired {
+			i.handleDuplicate(c, key, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Failed requests are not stored so the client is free to retry them
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := i.rdb.Del(ctx, key).Err(); err != nil {
+				log.Printf("failed to release idempotency key %s: %v", key, err)
+			}
+			return
+		}
+
+		completed, err := json.Marshal(idempotencyRecord{
+			State:       idempotencyStateCompleted,
+			Fingerprint: fingerprint,
+			Status:      recorder.Status(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			log.Printf("failed to marshal idempotency record for key %s: %v", key, err)
+			return
+		}
+
+		if err := i.rdb.Set(ctx, key, string(completed), i.ttl).Err(); err != nil {
+			log.Printf("failed to store idempotent response for key %s: %v", key, err)
+		}
+	}
+}
+
+func (i *Idempotency) handleDuplicate(c *gin.Context, key string, fingerprint string) {
+	val, err := i.rdb.Get(c.Request.Context(), key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("idempotency store error for key %s: %v", key, err)
+		}
+		// The first request has just finished without storing a response, the client may retry
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is being processed"})
+		return
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(val), &record); err != nil {
+		log.Printf("failed to unmarshal idempotency record for key %s: %v", key, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to read idempotency record"})
+		return
+	}
+
+	if record.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request payload"})
+		return
+	}
+
+	if record.State != idempotencyStateCompleted {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is being processed"})
+		return
+	}
+
+	metrics.IdempotentReplays.Inc()
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+}
+
+// requestFingerprint identifies a request by its route and payload
+func requestFingerprint(method string, route string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + route + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,162 @@
+//go:build unit
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdempotencyTTL = 24 * time.Hour
+
+func newIdempotentRouter(idem *Idempotency, status int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Identity(testGateways), idem.Handler())
+	router.POST("/api/v1/posts", func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"id": 1})
+	})
+	return router
+}
+
+func newIdempotentRequest(body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/posts", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, "abc")
+	req.RemoteAddr = testGatewayAddr
+	req.Header.Set(UserIDHeader, "7")
+	return req
+}
+
+func marshalRecord(t *testing.T, record idempotencyRecord) string {
+	b, err := json.Marshal(record)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestIdempotency_FirstRequestIsStored(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	calls := 0
+	router := newIdempotentRouter(NewIdempotency(db, testIdempotencyTTL), http.StatusCreated, &calls)
+
+	body := `{"user_id":7,"content":"hello"}`
+	key := "{idempotency:user:7:abc}"
+	fingerprint := requestFingerprint(http.MethodPost, "/api/v1/posts", []byte(body))
+
+	rdbMock.ExpectSetNX(key, marshalRecord(t, idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint}), idempotencyLockTTL).SetVal(true)
+	rdbMock.ExpectSet(key, marshalRecord(t, idempotencyRecord{
+		State:       idempotencyStateCompleted,
+		Fingerprint: fingerprint,
+		Status:      http.StatusCreated,
+		ContentType: "application/json; charset=utf-8",
+		Body:        []byte(`{"id":1}`),
+	}), testIdempotencyTTL).SetVal("OK")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newIdempotentRequest(body))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, 1, calls)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestIdempotency_RetryReplaysStoredResponse(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	calls := 0
+	router := newIdempotentRouter(NewIdempotency(db, testIdempotencyTTL), http.StatusCreated, &calls)
+
+	body := `{"user_id":7,"content":"hello"}`
+	key := "{idempotency:user:7:abc}"
+	fingerprint := requestFingerprint(http.MethodPost, "/api/v1/posts", []byte(body))
+
+	rdbMock.ExpectSetNX(key, marshalRecord(t, idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint}), idempotencyLockTTL).SetVal(false)
+	rdbMock.ExpectGet(key).SetVal(marshalRecord(t, idempotencyRecord{
+		State:       idempotencyStateCompleted,
+		Fingerprint: fingerprint,
+		Status:      http.StatusCreated,
+		ContentType: "application/json; charset=utf-8",
+		Body:        []byte(`{"id":99}`),
+	}))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newIdempotentRequest(body))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.JSONEq(t, `{"id":99}`, rr.Body.String())
+	assert.Equal(t, "true", rr.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 0, calls)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestIdempotency_ConflictingPayload(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	calls := 0
+	router := newIdempotentRouter(NewIdempotency(db, testIdempotencyTTL), http.StatusCreated, &calls)
+
+	body := `{"user_id":7,"content":"changed"}`
+	key := "{idempotency:user:7:abc}"
+	fingerprint := requestFingerprint(http.MethodPost, "/api/v1/posts", []byte(body))
+
+	rdbMock.ExpectSetNX(key, marshalRecord(t, idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint}), idempotencyLockTTL).SetVal(false)
+	rdbMock.ExpectGet(key).SetVal(marshalRecord(t, idempotencyRecord{State: idempotencyStateCompleted, Fingerprint: "other"}))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newIdempotentRequest(body))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, 0, calls)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestIdempotency_ConcurrentDuplicate(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	calls := 0
+	router := newIdempotentRouter(NewIdempotency(db, testIdempotencyTTL), http.StatusCreated, &calls)
+
+	body := `{"user_id":7,"content":"hello"}`
+	key := "{idempotency:user:7:abc}"
+	inFlight := marshalRecord(t, idempotencyRecord{
+		State:       idempotencyStateInFlight,
+		Fingerprint: requestFingerprint(http.MethodPost, "/api/v1/posts", []byte(body)),
+	})
+
+	rdbMock.ExpectSetNX(key, inFlight, idempotencyLockTTL).SetVal(false)
+	rdbMock.ExpectGet(key).SetVal(inFlight)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newIdempotentRequest(body))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, 0, calls)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestIdempotency_ServerErrorReleasesKey(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	calls := 0
+	router := newIdempotentRouter(NewIdempotency(db, testIdempotencyTTL), http.StatusInternalServerError, &calls)
+
+	body := `{"user_id":7,"content":"hello"}`
+	key := "{idempotency:user:7:abc}"
+	fingerprint := requestFingerprint(http.MethodPost, "/api/v1/posts", []byte(body))
+
+	rdbMock.ExpectSetNX(key, marshalRecord(t, idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint}), idempotencyLockTTL).SetVal(true)
+	rdbMock.ExpectDel(key).SetVal(1)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newIdempotentRequest(body))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, 1, calls)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
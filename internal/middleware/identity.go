@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// UserIDHeader carries the authenticated user id set by the upstream gateway
+	UserIDHeader = "X-User-ID"
+	// AuthUserIDKey is the gin context key an auth middleware stores the user id under
+	AuthUserIDKey = "auth_user_id"
+)
+
+// ParseNetworks parses IPs and CIDRs, such as the addresses of the trusted gateways
+func ParseNetworks(values []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			network, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", value, err)
+			}
+			networks = append(networks, network.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", value, err)
+		}
+		networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return networks, nil
+}
+
+// Identity authenticates the requests of the gateways by their X-User-ID header. Any client can set the
+// header, so it is only honored on connections from the gateways, other requests stay anonymous and are
+// told apart by IP. It must come before every middleware reading the identity.
+func Identity(gateways []netip.Prefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader(UserIDHeader); header != "" && fromGateway(c, gateways) {
+			if id, err := strconv.ParseInt(header, 10, 64); err == nil && id > 0 {
+				c.Set(AuthUserIDKey, id)
+			}
+		}
+		c.Next()
+	}
+}
+
+// fromGateway tells whether the connection of the request comes from a gateway, proxies aside
+func fromGateway(c *gin.Context, gateways []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, gateway := range gateways {
+		if gateway.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthUserID returns the authenticated user id of the request, if any
+func AuthUserID(c *gin.Context) (int64, bool) {
+	if v, ok := c.Get(AuthUserIDKey); ok {
+		if id, ok := v.(int64); ok {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// ClientKey identifies the caller by user id when authenticated, falling back to client IP
+func ClientKey(c *gin.Context) string {
+	if id, ok := AuthUserID(c); ok {
+		return "user:" + strconv.FormatInt(id, 10)
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,67 @@
+//go:build unit
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGatewayAddr is the remote address of requests made by a trusted gateway
+const testGatewayAddr = "192.168.1.1:1234"
+
+var testGateways = []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.1", " 192.168.1.7/24", "::1"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/32"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("::1/128"),
+	}, networks)
+
+	_, err = ParseNetworks([]string{"gateway"})
+	assert.Error(t, err)
+	_, err = ParseNetworks([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	identify := func(remoteAddr, userID string) (int64, bool) {
+		var id int64
+		var ok bool
+		router := gin.New()
+		router.Use(Identity(testGateways))
+		router.GET("/api/v1/posts", func(c *gin.Context) {
+			id, ok = AuthUserID(c)
+		})
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/posts", nil)
+		req.RemoteAddr = remoteAddr
+		if userID != "" {
+			req.Header.Set(UserIDHeader, userID)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return id, ok
+	}
+
+	id, ok := identify(testGatewayAddr, "42")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), id)
+
+	// Other clients cannot pick their identity
+	_, ok = identify("203.0.113.7:1234", "42")
+	assert.False(t, ok)
+	_, ok = identify(testGatewayAddr, "")
+	assert.False(t, ok)
+	_, ok = identify(testGatewayAddr, "-1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const rateLimitKeyPattern = "{ratelimit:%s:%s}"
+
+// gcraScript implements the generic cell rate algorithm on a single key.
+// The whole key is a hash tag, so every limiter state lives in exactly one cluster slot.
+// Redis TIME is used as the clock so that all app instances agree on "now".
+//
+// KEYS[1] limiter key
+// ARGV[1] emission interval in microseconds (period / limit)
+// ARGV[2] burst size (limit)
+// ARGV[3] 1 to charge the request when allowed, 0 to only check it
+// Returns {allowed, remaining, retry_after_seconds, reset_seconds}
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local charge = ARGV[3] == '1'
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+local new_tat = tat + emission
+local allow_at = new_tat - emission * burst
+if now < allow_at then
+  return {0, 0, math.ceil((allow_at - now) / 1000000), math.ceil((tat - now) / 1000000)}
+end
+if not charge then
+  return {1, math.floor((now - allow_at) / emission), 0, math.ceil((tat - now) / 1000000)}
+end
+redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now - allow_at) / emission), 0, math.ceil((new_tat - now) / 1000000)}
+`)
+
+// Rate is a number of requests allowed per period
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRate parses "<limit>/<period>" such as "100/1m" or "10/1s"
+func ParseRate(s string) (Rate, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected <limit>/<period>", s)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate limit %q", parts[0])
+	}
+
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate period %q", parts[1])
+	}
+
+	return Rate{Limit: limit, Period: period}, nil
+}
+
+// RateLimitRule limits requests sharing the same key.
+// Key returns false when the rule does not apply to the request.
+type RateLimitRule struct {
+	Name string
+	Rate Rate
+	Key  func(c *gin.Context) (string, bool)
+}
+
+// PerIPRule limits every client IP
+func PerIPRule(rate Rate) RateLimitRule {
+	return RateLimitRule{
+		Name: "ip",
+		Rate: rate,
+		Key: func(c *gin.Context) (string, bool) {
+			return fmt.Sprintf(rateLimitKeyPattern, "ip", c.ClientIP()), true
+		},
+	}
+}
+
+// PerUserRule limits every authenticated user
+func PerUserRule(rate Rate) RateLimitRule {
+	return RateLimitRule{
+		Name: "user",
+		Rate: rate,
+		Key: func(c *gin.Context) (string, bool) {
+			userID, ok := AuthUserID(c)
+			if !ok {
+				return "", false
+			}
+			return fmt.Sprintf(rateLimitKeyPattern, "user", strconv.FormatInt(userID, 10)), true
+		},
+	}
+}
+
+// PerRouteRule limits every client on a single route, e.g. "GET /api/v1/users/:id/posts"
+func PerRouteRule(route string, rate Rate) RateLimitRule {
+	return RateLimitRule{
+		Name: "route:" + route,
+		Rate: rate,
+		Key: func(c *gin.Context) (string, bool) {
+			if c.Request.Method+" "+c.FullPath() != route {
+				return "", false
+			}
+			return fmt.Sprintf(rateLimitKeyPattern, "route:"+route, ClientKey(c)), true
+		},
+	}
+}
+
+// ParseRouteRules parses "<METHOD> <route>=<limit>/<period>" entries separated by ";"
+func ParseRouteRules(s string) ([]RateLimitRule, error) {
+	var rules []RateLimitRule
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		idx := strings.LastIndex(entry, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid route rate limit %q", entry)
+		}
+
+		rate, err := ParseRate(entry[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		route := strings.Join(strings.Fields(entry[:idx]), " ")
+		rules = append(rules, PerRouteRule(route, rate))
+	}
+	return rules, nil
+}
+
+// RateLimiter is a Redis-backed distributed rate limiter
+type RateLimiter struct {
+	rdb   redis.Cmdable
+	rules []RateLimitRule
+}
+
+// NewRateLimiter creates a new instance of RateLimiter
+func NewRateLimiter(rdb redis.Cmdable, rules ...RateLimitRule) *RateLimiter {
+	return &RateLimiter{rdb: rdb, rules: rules}
+}
+
+type rateLimitDecision struct {
+	rule       RateLimitRule
+	allowed    bool
+	remaining  int64
+	retryAfter int64
+	reset      int64
+}
+
+// Handler returns a gin middleware evaluating every applicable rule.
+// Every rule is checked before any is charged, so that a request rejected by one rule does not use up
+// the others. The limiter keys live in different slots and cannot be charged in a single script.
+// Redis failures are logged and the request is let through.
+func (l *RateLimiter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rules []RateLimitRule
+		var keys []string
+
+		for _, rule := range l.rules {
+			key, ok := rule.Key(c)
+			if !ok {
+				continue
+			}
+
+			decision, err := l.allow(c, rule, key, false)
+			if err != nil {
+				log.Printf("rate limiter error for rule %s: %v", rule.Name, err)
+				continue
+			}
+			if !decision.allowed {
+				rejectRateLimited(c, decision)
+				return
+			}
+			rules, keys = append(rules, rule), append(keys, key)
+		}
+
+		var tightest *rateLimitDecision
+		for i, rule := range rules {
+			decision, err := l.allow(c, rule, keys[i], true)
+			if err != nil {
+				log.Printf("rate limiter error for rule %s: %v", rule.Name, err)
+				continue
+			}
+			// A concurrent request may have taken the last cell since the check
+			if !decision.allowed {
+				rejectRateLimited(c, decision)
+				return
+			}
+
+			if tightest == nil || decision.remaining < tightest.remaining {
+				tightest = decision
+			}
+		}
+
+		if tightest != nil {
+			setRateLimitHeaders(c, tightest)
+		}
+		c.Next()
+	}
+}
+
+// allow evaluates the rule on its key, charging the request when allowed and charge is set
+func (l *RateLimiter) allow(c *gin.Context, rule RateLimitRule, key string, charge bool) (*rateLimitDecision, error) {
+	emission := rule.Rate.Period.Microseconds() / int64(rule.Rate.Limit)
+	if emission <= 0 {
+		emission = 1
+	}
+	chargeArg := 0
+	if charge {
+		chargeArg = 1
+	}
+
+	res, err := gcraScript.Run(c.Request.Context(), l.rdb, []string{key}, emission, rule.Rate.Limit, chargeArg).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 4 {
+		return nil, fmt.Errorf("unexpected rate limiter reply %v", res)
+	}
+
+	return &rateLimitDecision{
+		rule:       rule,
+		allowed:    res[0] == 1,
+		remaining:  res[1],
+		retryAfter: res[2],
+		reset:      res[3],
+	}, nil
+}
+
+func rejectRateLimited(c *gin.Context, d *rateLimitDecision) {
+	setRateLimitHeaders(c, d)
+	c.Header("Retry-After", strconv.FormatInt(d.retryAfter, 10))
+	metrics.RateLimitRejections.WithLabelValues(d.rule.Name).Inc()
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+}
+
+func setRateLimitHeaders(c *gin.Context, d *rateLimitDecision) {
+	c.Header("RateLimit-Limit", strconv.Itoa(d.rule.Rate.Limit))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(d.remaining, 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(d.reset, 10))
+}
@@ -0,0 +1,163 @@
+//go:build unit
+
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("100/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Rate{Limit: 100, Period: time.Minute}, rate)
+
+	_, err = ParseRate("100")
+	assert.Error(t, err)
+	_, err = ParseRate("0/1s")
+	assert.Error(t, err)
+	_, err = ParseRate("10/forever")
+	assert.Error(t, err)
+}
+
+func TestParseRouteRules(t *testing.T) {
+	rules, err := ParseRouteRules("GET  /api/v1/users/:id/posts=50/1s; POST /api/v1/posts=10/1m")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "route:GET /api/v1/users/:id/posts", rules[0].Name)
+	assert.Equal(t, Rate{Limit: 50, Period: time.Second}, rules[0].Rate)
+	assert.Equal(t, "route:POST /api/v1/posts", rules[1].Name)
+
+	_, err = ParseRouteRules("GET /api/v1/posts")
+	assert.Error(t, err)
+}
+
+func newRateLimitedRouter(limiter *RateLimiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Identity(testGateways), limiter.Handler())
+	router.GET("/api/v1/users/:id/posts", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestRateLimiter_Allowed(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	rate := Rate{Limit: 10, Period: time.Second}
+	limiter := NewRateLimiter(db, PerIPRule(rate))
+
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:ip:10.0.0.1}"}, int64(100000), 10, 0).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(0)})
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:ip:10.0.0.1}"}, int64(100000), 10, 1).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1)})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	newRateLimitedRouter(limiter).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "9", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRateLimiter_Rejected(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	rate := Rate{Limit: 5, Period: time.Minute}
+	route := "GET /api/v1/users/:id/posts"
+	limiter := NewRateLimiter(db, PerUserRule(rate), PerRouteRule(route, rate))
+
+	// The route rule rejects the request before the user rule is charged
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:user:42}"}, int64(12000000), 5, 0).
+		SetVal([]interface{}{int64(1), int64(3), int64(0), int64(24)})
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:route:" + route + ":user:42}"}, int64(12000000), 5, 0).
+		SetVal([]interface{}{int64(0), int64(0), int64(7), int64(60)})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+	req.RemoteAddr = testGatewayAddr
+	req.Header.Set(UserIDHeader, "42")
+	rr := httptest.NewRecorder()
+	newRateLimitedRouter(limiter).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "7", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRateLimiter_FailsOpenOnRedisError(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	rate := Rate{Limit: 10, Period: time.Second}
+	limiter := NewRateLimiter(db, PerIPRule(rate), PerUserRule(rate))
+
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:ip:10.0.0.1}"}, int64(100000), 10, 0).
+		SetErr(errors.New("connection refused"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	newRateLimitedRouter(limiter).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRateLimiter_ChargesOnlyWhenEveryRuleAllows(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	rate := Rate{Limit: 5, Period: time.Minute}
+	limiter := NewRateLimiter(db, PerIPRule(rate), PerUserRule(rate))
+
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:ip:192.168.1.1}"}, int64(12000000), 5, 0).
+		SetVal([]interface{}{int64(1), int64(4), int64(0), int64(0)})
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:user:42}"}, int64(12000000), 5, 0).
+		SetVal([]interface{}{int64(1), int64(2), int64(0), int64(24)})
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:ip:192.168.1.1}"}, int64(12000000), 5, 1).
+		SetVal([]interface{}{int64(1), int64(4), int64(0), int64(12)})
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:user:42}"}, int64(12000000), 5, 1).
+		SetVal([]interface{}{int64(1), int64(2), int64(0), int64(36)})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+	req.RemoteAddr = testGatewayAddr
+	req.Header.Set(UserIDHeader, "42")
+	rr := httptest.NewRecorder()
+	newRateLimitedRouter(limiter).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// The tightest rule sets the headers
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "36", rr.Header().Get("RateLimit-Reset"))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRateLimiter_SpoofedUserIDLimitedByIP(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	rate := Rate{Limit: 5, Period: time.Minute}
+	route := "GET /api/v1/users/:id/posts"
+	limiter := NewRateLimiter(db, PerUserRule(rate), PerRouteRule(route, rate))
+
+	// The client is not a gateway, its X-User-ID is ignored
+	rdbMock.ExpectEvalSha(gcraScript.Hash(), []string{"{ratelimit:route:" + route + ":ip:203.0.113.7}"}, int64(12000000), 5, 0).
+		SetVal([]interface{}{int64(0), int64(0), int64(7), int64(60)})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/1/posts", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set(UserIDHeader, "42")
+	rr := httptest.NewRecorder()
+	newRateLimitedRouter(limiter).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/dbrouter"
+)
+
+// ReadYourWrites attaches the authenticated user to the request context, so that the DB router sends
+// the user's reads to the primary right after the user's writes
+func ReadYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if userID, ok := AuthUserID(c); ok {
+			c.Request = c.Request.WithContext(dbrouter.WithUser(c.Request.Context(), userID))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+// ErrDBOverloaded is returned by reads that bypass an unavailable cache node when no DB slot frees up in time
+var ErrDBOverloaded = errors.New("database is overloaded while the cache is unavailable")
+
+// DegradedLimiter bounds the DB reads made in place of a cache node whose circuit is open,
+// so that a Redis outage does not turn every request into a Postgres query at once.
+// A nil DegradedLimiter does not limit.
+type DegradedLimiter struct {
+	sem  chan struct{}
+	wait time.Duration
+}
+
+// NewDegradedLimiter creates a limiter letting maxConcurrency reads run at once.
+// Reads waiting longer than wait for their turn fail with ErrDBOverloaded.
+func NewDegradedLimiter(maxConcurrency int, wait time.Duration) *DegradedLimiter {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	return &DegradedLimiter{
+		sem:  make(chan struct{}, maxConcurrency),
+		wait: wait,
+	}
+}
+
+// do runs read once a slot is free
+func (l *DegradedLimiter) do(ctx context.Context, read func(ctx context.Context) error) error {
+	if l == nil {
+		return read(ctx)
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.sem <- struct{}{}:
+	case <-timer.C:
+		metrics.DegradedDBReads.WithLabelValues("rejected").Inc()
+		return ErrDBOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.sem }()
+
+	metrics.DegradedDBReads.WithLabelValues("served").Inc()
+	return read(ctx)
+}
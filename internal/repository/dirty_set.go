@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+)
+
+// zremIfScoreScript removes a member of a sorted set if its score did not change since it was read.
+//
+// KEYS[1] sorted set
+// ARGV[1] member, ARGV[2] score read
+var zremIfScoreScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) == ARGV[2] then
+  return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// dirtyMember is a member read from a shard of a dirty set, which marks what a worker has to handle
+type dirtyMember struct {
+	// key is the shard the member was read from
+	key    string
+	member string
+	score  float64
+}
+
+// readDirtyShards reads at most limit members from the shards of a dirty set through the aggregator,
+// lowest scores first, taking from every shard in turn. Shards that cannot be read are left for the next
+// read, unless none can.
+func readDirtyShards(ctx context.Context, agg *aggregator.Aggregator, keys []string, limit int) ([]dirtyMember, error) {
+	res := agg.Pipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.ZRangeWithScores(ctx, key, 0, int64(limit-1))
+	})
+
+	lists := make([][]redis.Z, len(keys))
+	read := 0
+	var readErr error
+	for i, cmd := range res.Values {
+		if cmd == nil {
+			continue
+		}
+		members, err := cmd.(*redis.ZSliceCmd).Result()
+		if err != nil {
+			readErr = err
+			continue
+		}
+		lists[i] = members
+		read++
+	}
+	if read == 0 {
+		if readErr == nil && res.Partial() {
+			readErr = res.Failed[0]
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	var members []dirtyMember
+	for i := 0; len(members) < limit; i++ {
+		taken := false
+		for shard, list := range lists {
+			if i < len(list) && len(members) < limit {
+				member, _ := list[i].Member.(string)
+				members = append(members, dirtyMember{key: keys[shard], member: member, score: list[i].Score})
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// FeedCursor points at the last post of a feed page. The zero value starts from the newest post.
+type FeedCursor struct {
+	// Score is the post creation time in unix seconds, the score of timeline sorted sets
+	Score  int64
+	PostID int64
+}
+
+// IsZero reports whether the cursor starts from the newest post
+func (c FeedCursor) IsZero() bool {
+	return c == FeedCursor{}
+}
+
+// FeedRepository aggregates the timelines of followed users into a home feed
+type FeedRepository interface {
+	// ListFeed returns up to limit posts after cursor, ordered by (score, post id) descending
+	ListFeed(ctx context.Context, userID int64, cursor FeedCursor, limit int) ([]sqlc.Post, error)
+	// FanOutPost delivers a new post to the feeds of the author's followers
+	FanOutPost(ctx context.Context, post sqlc.Post) error
+}
@@ -0,0 +1,446 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	userFeedKeyPattern         = "{feed:%d}"
+	userFeedBuildingKeyPattern = "{feed:%d}:building"
+	// celebritiesKey holds the authors whose posts are merged into feeds at read time
+	celebritiesKey = "feed:celebrities"
+	// maxFeedSize bounds materialized feeds, older pages are read from the followees' timelines
+	maxFeedSize = 1000
+	// feedBuildingTTL covers the time between reading the followees' timelines and storing the feed
+	feedBuildingTTL = 30 * time.Second
+)
+
+// feedPushScript adds a post to a materialized feed. Feeds that do not exist are left alone unless
+// they are being built, so a feed never misses posts created while its first page was read.
+//
+// KEYS[1] feed, KEYS[2] building marker
+// ARGV[1] score, ARGV[2] post id, ARGV[3] max feed size, ARGV[4] ttl in seconds
+var feedPushScript = redis.NewScript(`
+local exists = redis.call('EXISTS', KEYS[1]) == 1
+if not exists and redis.call('EXISTS', KEYS[2]) == 0 then
+  return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -(tonumber(ARGV[3]) + 1))
+if not exists then
+  redis.call('EXPIRE', KEYS[1], ARGV[4])
+end
+return 1
+`)
+
+// CachedFeedRepository builds home feeds with a hybrid of fan-out on write and fan-in on read.
+// Posts of authors under the fan-out threshold are pushed into their followers' materialized
+// {feed:N} sorted sets. Posts of authors with more followers are merged in at read time from
+// their cached timelines, read with one pipeline per cluster node by the aggregator. Timelines
+// without enough cached posts are read from DB with a single batched query.
+type CachedFeedRepository struct {
+	posts           PostRepository
+	follows         FollowRepository
+	rdb             redis.Cmdable
+	agg             *aggregator.Aggregator
+	fanoutThreshold int64
+}
+
+// NewCachedFeedRepository creates a new instance of CachedFeedRepository.
+// Authors with at least fanoutThreshold followers are not fanned out on write.
+func NewCachedFeedRepository(posts PostRepository, follows FollowRepository, rdb redis.Cmdable, agg *aggregator.Aggregator, fanoutThreshold int64) FeedRepository {
+	return &CachedFeedRepository{
+		posts:           posts,
+		follows:         follows,
+		rdb:             rdb,
+		agg:             agg,
+		fanoutThreshold: fanoutThreshold,
+	}
+}
+
+// feedEntry is a post reference in feed order
+type feedEntry struct {
+	score  int64
+	postID int64
+}
+
+// before reports whether e comes before other in the feed
+func (e feedEntry) before(other feedEntry) bool {
+	if e.score != other.score {
+		return e.score > other.score
+	}
+	return e.postID > other.postID
+}
+
+// ListFeed merges the materialized feed with the celebrity followees' timelines after cursor.
+// Without a usable materialized feed every followee is read, and the first page rebuilds the feed.
+func (r *CachedFeedRepository) ListFeed(ctx context.Context, userID int64, cursor FeedCursor, limit int) ([]sqlc.Post, error) {
+	if limit <= 0 {
+		return []sqlc.Post{}, nil
+	}
+
+	follows, err := r.follows.ListAllFollowing(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list followees of user %d: %w", userID, err)
+	}
+	if len(follows) == 0 {
+		return []sqlc.Post{}, nil
+	}
+
+	regularIDs, celebrityIDs := r.splitCelebrities(ctx, follows)
+
+	var lists [][]feedEntry
+	timelineIDs := celebrityIDs
+	building := false
+
+	materialized, covered, exists := r.readMaterializedFeed(ctx, userID, cursor, limit)
+	if covered {
+		lists = append(lists, materialized)
+	} else {
+		timelineIDs = append(timelineIDs, regularIDs...)
+		// Only the newest page is a complete prefix of the feed, later pages are never materialized
+		building = !exists && cursor.IsZero() && len(regularIDs) > 0 && r.markFeedBuilding(ctx, userID)
+	}
+
+	warm, coldIDs := r.readTimelines(ctx, timelineIDs, cursor, limit)
+
+	isRegular := make(map[int64]bool, len(regularIDs))
+	for _, id := range regularIDs {
+		isRegular[id] = true
+	}
+	var regularLists [][]feedEntry
+	for followeeID, list := range warm {
+		lists = append(lists, list)
+		if isRegular[followeeID] {
+			regularLists = append(regularLists, list)
+		}
+	}
+
+	// Posts read from DB come with their bodies, cached entries are hydrated afterwards
+	postsByID := make(map[int64]sqlc.Post)
+	var regularColdIDs, celebrityColdIDs []int64
+	for _, id := range coldIDs {
+		if isRegular[id] {
+			regularColdIDs = append(regularColdIDs, id)
+		} else {
+			celebrityColdIDs = append(celebrityColdIDs, id)
+		}
+	}
+	if building {
+		// The feed needs the newest posts of regular followees alone, celebrities could crowd them out
+		for _, ids := range [][]int64{regularColdIDs, celebrityColdIDs} {
+			if len(ids) == 0 {
+				continue
+			}
+			cold, err := r.readColdTimelines(ctx, ids, cursor, limit, postsByID)
+			if err != nil {
+				return nil, err
+			}
+			lists = append(lists, cold)
+			if isRegular[ids[0]] {
+				regularLists = append(regularLists, cold)
+			}
+		}
+		r.materializeFeed(ctx, userID, mergeFeedEntries(regularLists, limit))
+	} else if len(coldIDs) > 0 {
+		cold, err := r.readColdTimelines(ctx, coldIDs, cursor, limit, postsByID)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, cold)
+	}
+
+	entries := mergeFeedEntries(lists, limit)
+
+	hydrateIDs := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		if _, ok := postsByID[e.postID]; !ok {
+			hydrateIDs = append(hydrateIDs, e.postID)
+		}
+	}
+	if len(hydrateIDs) > 0 {
+		hydrated, err := r.posts.GetPostsByIDs(ctx, hydrateIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hydrate %d feed posts: %w", len(hydrateIDs), err)
+		}
+		for _, p := range hydrated {
+			postsByID[p.ID] = p
+		}
+	}
+
+	feed := make([]sqlc.Post, 0, len(entries))
+	for _, e := range entries {
+		// Posts deleted since their timeline was cached are skipped
+		if p, ok := postsByID[e.postID]; ok {
+			feed = append(feed, p)
+		}
+	}
+
+	return feed, nil
+}
+
+// FanOutPost pushes a post into the existing feeds of the author's followers.
+// Authors at or above the fan-out threshold are recorded as celebrities instead.
+func (r *CachedFeedRepository) FanOutPost(ctx context.Context, post sqlc.Post) error {
+	followers, err := r.follows.CountFollowers(ctx, post.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to count followers of user %d: %w", post.UserID, err)
+	}
+
+	if followers >= r.fanoutThreshold {
+		if err := r.rdb.SAdd(ctx, celebritiesKey, post.UserID).Err(); err != nil {
+			return fmt.Errorf("failed to mark user %d as celebrity: %w", post.UserID, err)
+		}
+		metrics.FeedFanoutJobs.WithLabelValues("celebrity").Inc()
+		return nil
+	}
+
+	if err := r.rdb.SRem(ctx, celebritiesKey, post.UserID).Err(); err != nil {
+		return fmt.Errorf("failed to unmark user %d as celebrity: %w", post.UserID, err)
+	}
+	if followers == 0 {
+		metrics.FeedFanoutJobs.WithLabelValues("delivered").Inc()
+		return nil
+	}
+
+	follows, err := r.follows.ListAllFollowers(ctx, post.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to list followers of user %d: %w", post.UserID, err)
+	}
+
+	keys := make([]string, len(follows))
+	for i, f := range follows {
+		keys[i] = fmt.Sprintf(userFeedKeyPattern, f.FollowerID)
+	}
+	score := post.CreatedAt.Unix()
+	res := r.agg.Pipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		// Pushing the same post twice is harmless, so a failed job is simply run again
+		return feedPushScript.Eval(ctx, pipe, []string{key, key + ":building"}, score, post.ID, maxFeedSize, int(cacheTTL.Seconds()))
+	})
+
+	failed := 0
+	for _, cmd := range res.Values {
+		if cmd == nil {
+			failed++
+		} else if err := cmd.Err(); err != nil && err != redis.Nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to push post %d into %d of %d feeds", post.ID, failed, len(keys))
+	}
+
+	metrics.FeedFanoutJobs.WithLabelValues("delivered").Inc()
+	return nil
+}
+
+// splitCelebrities separates followees fanned out on write from those merged at read time.
+// When Redis cannot tell, followees are treated as regular and their timelines read on a feed miss.
+func (r *CachedFeedRepository) splitCelebrities(ctx context.Context, follows []sqlc.Follow) ([]int64, []int64) {
+	members := make([]interface{}, len(follows))
+	for i, f := range follows {
+		members[i] = f.FolloweeID
+	}
+
+	isCelebrity, err := r.rdb.SMIsMember(ctx, celebritiesKey, members...).Result()
+	if err != nil {
+		log.Printf("redis error on getting celebrities for feed: %v", err)
+		isCelebrity = nil
+	}
+
+	regularIDs := make([]int64, 0, len(follows))
+	celebrityIDs := make([]int64, 0)
+	for i, f := range follows {
+		if i < len(isCelebrity) && isCelebrity[i] {
+			celebrityIDs = append(celebrityIDs, f.FolloweeID)
+		} else {
+			regularIDs = append(regularIDs, f.FolloweeID)
+		}
+	}
+	return regularIDs, celebrityIDs
+}
+
+// readMaterializedFeed reads the user's feed after cursor. covered reports whether the feed holds
+// the whole page, exists whether the feed is materialized at all.
+func (r *CachedFeedRepository) readMaterializedFeed(ctx context.Context, userID int64, cursor FeedCursor, limit int) ([]feedEntry, bool, bool) {
+	feedKey := fmt.Sprintf(userFeedKeyPattern, userID)
+	fetch := int64(limit + 1)
+
+	pipe := r.rdb.Pipeline()
+	existsCmd := pipe.Exists(ctx, feedKey)
+	rangeCmd := pipe.ZRevRangeByScoreWithScores(ctx, feedKey, newestRange(cursor, fetch))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("redis error on getting feed of user %d: %v", userID, err)
+		// Treat the feed as existing so that it is not rebuilt while Redis misbehaves
+		return nil, false, true
+	}
+
+	entries, ok := usableEntries(rangeCmd.Val(), fetch, cursor, limit)
+	return entries, ok, existsCmd.Val() == 1
+}
+
+// markFeedBuilding lets fan-out create the user's feed while its first page is read
+func (r *CachedFeedRepository) markFeedBuilding(ctx context.Context, userID int64) bool {
+	buildingKey := fmt.Sprintf(userFeedBuildingKeyPattern, userID)
+	if err := r.rdb.Set(ctx, buildingKey, 1, feedBuildingTTL).Err(); err != nil {
+		log.Printf("failed to mark feed of user %d as building: %v", userID, err)
+		return false
+	}
+	return true
+}
+
+// materializeFeed stores the newest page of regular followees' posts as the user's feed. Posts
+// fanned out since markFeedBuilding are already in the set and are kept.
+func (r *CachedFeedRepository) materializeFeed(ctx context.Context, userID int64, entries []feedEntry) {
+	feedKey := fmt.Sprintf(userFeedKeyPattern, userID)
+
+	pipe := r.rdb.Pipeline()
+	if len(entries) > 0 {
+		members := make([]*redis.Z, len(entries))
+		for i, e := range entries {
+			members[i] = &redis.Z{Score: float64(e.score), Member: e.postID}
+		}
+		pipe.ZAdd(ctx, feedKey, members...)
+		pipe.ZRemRangeByRank(ctx, feedKey, 0, -(maxFeedSize + 1))
+		pipe.Expire(ctx, feedKey, cacheTTL)
+	}
+	pipe.Del(ctx, fmt.Sprintf(userFeedBuildingKeyPattern, userID))
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to materialize feed of user %d: %v", userID, err)
+	}
+}
+
+// readTimelines reads the newest limit entries after cursor from every followee's cached timeline.
+// A timeline is only used when it proves to hold at least limit entries, the others are returned as cold.
+// Cached timelines are assumed to hold the newest posts of their user without gaps.
+func (r *CachedFeedRepository) readTimelines(ctx context.Context, followeeIDs []int64, cursor FeedCursor, limit int) (map[int64][]feedEntry, []int64) {
+	if len(followeeIDs) == 0 {
+		return nil, nil
+	}
+
+	fetch := int64(limit + 1)
+	by := newestRange(cursor, fetch)
+
+	keys := make([]string, len(followeeIDs))
+	for i, id := range followeeIDs {
+		keys[i] = fmt.Sprintf(userPostsKeyPattern, id)
+	}
+	res := r.agg.ReadPipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.ZRevRangeByScoreWithScores(ctx, key, by)
+	})
+	metrics.FeedShardsTouched.Observe(float64(res.Shards))
+
+	// Timelines on failed nodes are read from DB like uncached ones
+	lists := make(map[int64][]feedEntry, len(followeeIDs))
+	coldIDs := make([]int64, 0)
+	for i, cmd := range res.Values {
+		zcmd, ok := cmd.(*redis.ZSliceCmd)
+		if !ok {
+			coldIDs = append(coldIDs, followeeIDs[i])
+			continue
+		}
+		zs, err := zcmd.Result()
+		if err != nil && err != redis.Nil {
+			log.Printf("redis error on getting timeline of user %d for feed: %v", followeeIDs[i], err)
+		}
+
+		entries, ok := usableEntries(zs, fetch, cursor, limit)
+		if !ok {
+			coldIDs = append(coldIDs, followeeIDs[i])
+			continue
+		}
+		lists[followeeIDs[i]] = entries
+	}
+
+	return lists, coldIDs
+}
+
+// readColdTimelines reads the newest limit posts after cursor of the given users from DB.
+// The posts are added to postsByID and returned as a single list in feed order.
+func (r *CachedFeedRepository) readColdTimelines(ctx context.Context, userIDs []int64, cursor FeedCursor, limit int, postsByID map[int64]sqlc.Post) ([]feedEntry, error) {
+	metrics.FeedColdTimelines.Add(float64(len(userIDs)))
+	params := sqlc.ListRecentPostsByUsersParams{
+		UserIds:     userIDs,
+		BeforeScore: math.MaxInt64,
+		BeforeID:    math.MaxInt64,
+		RowLimit:    int32(limit),
+	}
+	if !cursor.IsZero() {
+		params.BeforeScore, params.BeforeID = cursor.Score, cursor.PostID
+	}
+
+	posts, err := r.posts.ListRecentPostsByUsers(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recent posts of %d followees: %w", len(userIDs), err)
+	}
+
+	entries := make([]feedEntry, len(posts))
+	for i, p := range posts {
+		entries[i] = feedEntry{score: p.CreatedAt.Unix(), postID: p.ID}
+		postsByID[p.ID] = p
+	}
+	return entries, nil
+}
+
+// newestRange selects the newest fetch entries at or below the cursor score
+func newestRange(cursor FeedCursor, fetch int64) *redis.ZRangeBy {
+	by := &redis.ZRangeBy{Max: "+inf", Min: "-inf", Count: fetch}
+	if !cursor.IsZero() {
+		by.Max = strconv.FormatInt(cursor.Score, 10)
+	}
+	return by
+}
+
+// usableEntries keeps the entries of a sorted set range that come after cursor and are known to be
+// complete, in feed order. It reports whether at least limit of them remain.
+func usableEntries(zs []redis.Z, fetch int64, cursor FeedCursor, limit int) ([]feedEntry, bool) {
+	// Members sharing the lowest score may continue past the fetched range, so only a range
+	// cut short by the end of the set keeps its lowest score group
+	floor := math.Inf(-1)
+	if int64(len(zs)) == fetch {
+		floor = zs[len(zs)-1].Score
+	}
+	after := feedEntry{score: cursor.Score, postID: cursor.PostID}
+
+	entries := make([]feedEntry, 0, len(zs))
+	for _, z := range zs {
+		if z.Score <= floor {
+			continue
+		}
+		member, _ := z.Member.(string)
+		postID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		e := feedEntry{score: int64(z.Score), postID: postID}
+		if !cursor.IsZero() && !after.before(e) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) < limit {
+		return nil, false
+	}
+
+	// Redis orders members of equal score lexicographically, the feed orders them by id
+	sort.Slice(entries, func(a, b int) bool { return entries[a].before(entries[b]) })
+	return entries[:limit], true
+}
+
+// mergeFeedEntries k-way merges lists already in feed order, keeping the first limit entries
+func mergeFeedEntries(lists [][]feedEntry, limit int) []feedEntry {
+	return aggregator.Merge(lists, feedEntry.before, limit)
+}
@@ -0,0 +1,309 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func postIDs(posts []sqlc.Post) []int64 {
+	ids := make([]int64, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+// evalKeys matches an EVAL call on its keys, ignoring the script body and arguments
+func evalKeys(numKeys int) func(expected, actual []interface{}) error {
+	return func(expected, actual []interface{}) error {
+		n := 3 + numKeys
+		if len(expected) < n || len(actual) < n || expected[0] != actual[0] {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+		for i := 2; i < n; i++ {
+			if fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+				return fmt.Errorf("expected %v, got %v", expected, actual)
+			}
+		}
+		return nil
+	}
+}
+
+func TestCachedFeedRepository_ListFeed_BuildsFeed(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	postRepo := new(mocks.PostRepository)
+	followRepo := new(mocks.FollowRepository)
+	repo := NewCachedFeedRepository(postRepo, followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+	followRepo.On("ListAllFollowing", mock.Anything, int64(1)).Return([]sqlc.Follow{
+		{FollowerID: 1, FolloweeID: 2},
+		{FollowerID: 1, FolloweeID: 3},
+	}, nil)
+
+	feedKey := fmt.Sprintf(userFeedKeyPattern, 1)
+	buildingKey := fmt.Sprintf(userFeedBuildingKeyPattern, 1)
+	by := &redis.ZRangeBy{Max: "+inf", Min: "-inf", Count: 3}
+
+	rdbMock.ExpectSMIsMember(celebritiesKey, int64(2), int64(3)).SetVal([]bool{false, false})
+	rdbMock.ExpectExists(feedKey).SetVal(0)
+	rdbMock.ExpectZRevRangeByScoreWithScores(feedKey, by).SetVal([]redis.Z{})
+	rdbMock.ExpectSet(buildingKey, 1, feedBuildingTTL).SetVal("OK")
+	rdbMock.ExpectZRevRangeByScoreWithScores(fmt.Sprintf(userPostsKeyPattern, 2), by).SetVal([]redis.Z{
+		{Score: 300, Member: "20"},
+		{Score: 100, Member: "21"},
+		{Score: 50, Member: "22"},
+	})
+	rdbMock.ExpectZRevRangeByScoreWithScores(fmt.Sprintf(userPostsKeyPattern, 3), by).SetVal([]redis.Z{
+		{Score: 200, Member: "31"},
+		{Score: 200, Member: "30"},
+	})
+	rdbMock.ExpectZAdd(feedKey,
+		&redis.Z{Score: 300, Member: int64(20)},
+		&redis.Z{Score: 200, Member: int64(31)},
+	).SetVal(2)
+	rdbMock.ExpectZRemRangeByRank(feedKey, 0, -(maxFeedSize + 1)).SetVal(0)
+	rdbMock.ExpectExpire(feedKey, cacheTTL).SetVal(true)
+	rdbMock.ExpectDel(buildingKey).SetVal(1)
+
+	now := time.Now()
+	hydrated := []sqlc.Post{
+		{ID: 20, UserID: 2, CreatedAt: now},
+		{ID: 31, UserID: 3, CreatedAt: now},
+	}
+	postRepo.On("GetPostsByIDs", mock.Anything, []int64{20, 31}).Return(hydrated, nil)
+
+	feed, err := repo.ListFeed(context.Background(), 1, FeedCursor{}, 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20, 31}, postIDs(feed))
+	postRepo.AssertNotCalled(t, "ListRecentPostsByUsers", mock.Anything, mock.Anything)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFeedRepository_ListFeed_MaterializedWithCelebrities(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	postRepo := new(mocks.PostRepository)
+	followRepo := new(mocks.FollowRepository)
+	repo := NewCachedFeedRepository(postRepo, followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+	followRepo.On("ListAllFollowing", mock.Anything, int64(1)).Return([]sqlc.Follow{
+		{FollowerID: 1, FolloweeID: 2},
+		{FollowerID: 1, FolloweeID: 3},
+	}, nil)
+
+	feedKey := fmt.Sprintf(userFeedKeyPattern, 1)
+	by := &redis.ZRangeBy{Max: "+inf", Min: "-inf", Count: 3}
+
+	// User 3 is a celebrity, only their timeline is read besides the materialized feed
+	rdbMock.ExpectSMIsMember(celebritiesKey, int64(2), int64(3)).SetVal([]bool{false, true})
+	rdbMock.ExpectExists(feedKey).SetVal(1)
+	rdbMock.ExpectZRevRangeByScoreWithScores(feedKey, by).SetVal([]redis.Z{
+		{Score: 400, Member: "22"},
+		{Score: 100, Member: "21"},
+		{Score: 50, Member: "20"},
+	})
+	rdbMock.ExpectZRevRangeByScoreWithScores(fmt.Sprintf(userPostsKeyPattern, 3), by).SetVal([]redis.Z{
+		{Score: 200, Member: "31"},
+		{Score: 150, Member: "30"},
+		{Score: 10, Member: "29"},
+	})
+
+	hydrated := []sqlc.Post{{ID: 22, UserID: 2}, {ID: 31, UserID: 3}}
+	postRepo.On("GetPostsByIDs", mock.Anything, []int64{22, 31}).Return(hydrated, nil)
+
+	feed, err := repo.ListFeed(context.Background(), 1, FeedCursor{}, 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int64{22, 31}, postIDs(feed))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFeedRepository_ListFeed_ColdTimelines(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	postRepo := new(mocks.PostRepository)
+	followRepo := new(mocks.FollowRepository)
+	repo := NewCachedFeedRepository(postRepo, followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+	followRepo.On("ListAllFollowing", mock.Anything, int64(1)).Return([]sqlc.Follow{
+		{FollowerID: 1, FolloweeID: 2},
+		{FollowerID: 1, FolloweeID: 3},
+	}, nil)
+
+	feedKey := fmt.Sprintf(userFeedKeyPattern, 1)
+	cursor := FeedCursor{Score: 500, PostID: 40}
+	by := &redis.ZRangeBy{Max: "500", Min: "-inf", Count: 3}
+
+	rdbMock.ExpectSMIsMember(celebritiesKey, int64(2), int64(3)).SetVal([]bool{false, false})
+	// The materialized feed ends before the page does
+	rdbMock.ExpectExists(feedKey).SetVal(1)
+	rdbMock.ExpectZRevRangeByScoreWithScores(feedKey, by).SetVal([]redis.Z{{Score: 450, Member: "39"}})
+	// Post 41 comes before the cursor and the 400 score group may continue past the range,
+	// leaving a single usable post for user 2
+	rdbMock.ExpectZRevRangeByScoreWithScores(fmt.Sprintf(userPostsKeyPattern, 2), by).SetVal([]redis.Z{
+		{Score: 500, Member: "41"},
+		{Score: 500, Member: "39"},
+		{Score: 400, Member: "38"},
+	})
+	rdbMock.ExpectZRevRangeByScoreWithScores(fmt.Sprintf(userPostsKeyPattern, 3), by).RedisNil()
+
+	postRepo.On("ListRecentPostsByUsers", mock.Anything, sqlc.ListRecentPostsByUsersParams{
+		UserIds:     []int64{2, 3},
+		BeforeScore: 500,
+		BeforeID:    40,
+		RowLimit:    2,
+	}).Return([]sqlc.Post{
+		{ID: 39, UserID: 2, CreatedAt: time.Unix(500, 0)},
+		{ID: 37, UserID: 3, CreatedAt: time.Unix(450, 0)},
+	}, nil)
+
+	feed, err := repo.ListFeed(context.Background(), 1, cursor, 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int64{39, 37}, postIDs(feed))
+	postRepo.AssertExpectations(t)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFeedRepository_ListFeed_AfterOffsetPageCached(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	agg := aggregator.New(db, aggregator.Options{})
+	postRepo := new(mockPostRepository)
+	followRepo := new(mocks.FollowRepository)
+	cachedPosts := NewCachedPostRepository(postRepo, db, agg, CachedPostOptions{})
+	repo := NewCachedFeedRepository(cachedPosts, followRepo, db, agg, 100)
+
+	userPostsKey := fmt.Sprintf(userPostsKeyPattern, 2)
+	feedKey := fmt.Sprintf(userFeedKeyPattern, 1)
+
+	// A later page of user 2 is read from DB while the timeline is not cached
+	params := sqlc.ListPostsByUserParams{UserID: 2, Limit: 2, Offset: 10}
+	oldPosts := []sqlc.Post{
+		{ID: 5, UserID: 2, CreatedAt: time.Unix(100, 0)},
+		{ID: 4, UserID: 2, CreatedAt: time.Unix(90, 0)},
+	}
+	rdbMock.ExpectZRevRange(userPostsKey, 10, 11).SetVal([]string{})
+	postRepo.On("ListPostsByUser", mock.Anything, params).Return(oldPosts, nil).Once()
+	for _, p := range oldPosts {
+		postJSON, _ := json.Marshal(p)
+		rdbMock.ExpectSet(fmt.Sprintf(postKeyGenericPattern, p.ID), postJSON, cacheTTL).SetVal("OK")
+	}
+
+	rdbMock.ExpectZAdd(userPostsKey,
+		&redis.Z{Score: 100, Member: int64(5)},
+		&redis.Z{Score: 90, Member: int64(4)},
+	).SetVal(2)
+
+	_, err := cachedPosts.ListPostsByUser(context.Background(), params)
+	require.NoError(t, err)
+	assert.Error(t, rdbMock.ExpectationsWereMet(), "later page cached into the timeline")
+	rdbMock.ClearExpect()
+
+	// The feed finds no timeline for user 2 and reads its newest posts from DB
+	followRepo.On("ListAllFollowing", mock.Anything, int64(1)).Return([]sqlc.Follow{{FollowerID: 1, FolloweeID: 2}}, nil)
+	by := &redis.ZRangeBy{Max: "+inf", Min: "-inf", Count: 3}
+	rdbMock.ExpectSMIsMember(celebritiesKey, int64(2)).SetVal([]bool{false})
+	rdbMock.ExpectExists(feedKey).SetVal(1)
+	rdbMock.ExpectZRevRangeByScoreWithScores(feedKey, by).SetVal([]redis.Z{})
+	rdbMock.ExpectZRevRangeByScoreWithScores(userPostsKey, by).RedisNil()
+	postRepo.On("ListRecentPostsByUsers", mock.Anything, sqlc.ListRecentPostsByUsersParams{
+		UserIds:     []int64{2},
+		BeforeScore: math.MaxInt64,
+		BeforeID:    math.MaxInt64,
+		RowLimit:    2,
+	}).Return([]sqlc.Post{
+		{ID: 50, UserID: 2, CreatedAt: time.Unix(500, 0)},
+		{ID: 49, UserID: 2, CreatedAt: time.Unix(490, 0)},
+	}, nil).Once()
+
+	feed, err := repo.ListFeed(context.Background(), 1, FeedCursor{}, 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int64{50, 49}, postIDs(feed))
+	postRepo.AssertExpectations(t)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFeedRepository_ListFeed_NoFollowees(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	postRepo := new(mocks.PostRepository)
+	followRepo := new(mocks.FollowRepository)
+	repo := NewCachedFeedRepository(postRepo, followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+	followRepo.On("ListAllFollowing", mock.Anything, int64(1)).Return([]sqlc.Follow{}, nil)
+
+	feed, err := repo.ListFeed(context.Background(), 1, FeedCursor{}, 10)
+
+	require.NoError(t, err)
+	assert.Empty(t, feed)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFeedRepository_FanOutPost(t *testing.T) {
+	post := sqlc.Post{ID: 7, UserID: 1, CreatedAt: time.Unix(1700000000, 0)}
+
+	t.Run("pushes_to_followers", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		followRepo := new(mocks.FollowRepository)
+		repo := NewCachedFeedRepository(new(mocks.PostRepository), followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+		followRepo.On("CountFollowers", mock.Anything, int64(1)).Return(int64(2), nil)
+		followRepo.On("ListAllFollowers", mock.Anything, int64(1)).Return([]sqlc.Follow{
+			{FollowerID: 5, FolloweeID: 1},
+			{FollowerID: 6, FolloweeID: 1},
+		}, nil)
+
+		rdbMock.ExpectSRem(celebritiesKey, int64(1)).SetVal(0)
+		for _, followerID := range []int64{5, 6} {
+			feedKey := fmt.Sprintf(userFeedKeyPattern, followerID)
+			rdbMock.CustomMatch(evalKeys(2)).ExpectEval("", []string{feedKey, feedKey + ":building"},
+				nil, nil, nil, nil).SetVal(int64(1))
+		}
+
+		require.NoError(t, repo.FanOutPost(context.Background(), post))
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("celebrity", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		followRepo := new(mocks.FollowRepository)
+		repo := NewCachedFeedRepository(new(mocks.PostRepository), followRepo, db, aggregator.New(db, aggregator.Options{}), 100)
+
+		followRepo.On("CountFollowers", mock.Anything, int64(1)).Return(int64(100), nil)
+		rdbMock.ExpectSAdd(celebritiesKey, int64(1)).SetVal(1)
+
+		require.NoError(t, repo.FanOutPost(context.Background(), post))
+		followRepo.AssertNotCalled(t, "ListAllFollowers", mock.Anything, mock.Anything)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+}
+
+func TestMergeFeedEntries(t *testing.T) {
+	lists := [][]feedEntry{
+		{{score: 300, postID: 3}, {score: 100, postID: 1}},
+		{{score: 200, postID: 20}, {score: 200, postID: 2}},
+		{{score: 300, postID: 3}, {score: 250, postID: 25}},
+		{},
+	}
+
+	merged := mergeFeedEntries(lists, 4)
+
+	assert.Equal(t, []feedEntry{
+		{score: 300, postID: 3},
+		{score: 250, postID: 25},
+		{score: 200, postID: 20},
+		{score: 200, postID: 2},
+	}, merged)
+}
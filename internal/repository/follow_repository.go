@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// FollowRepository defines methods for follows table
+type FollowRepository interface {
+	// CreateFollow reports whether a new follow relationship was created
+	CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (bool, error)
+	// DeleteFollow reports whether an existing follow relationship was removed
+	DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (bool, error)
+	ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error)
+	ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error)
+	ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error)
+	ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error)
+	CountFollowers(ctx context.Context, userID int64) (int64, error)
+	CountFollowing(ctx context.Context, userID int64) (int64, error)
+}
+
+// DBFollowRepository takes sqlc.Querier to create an instance
+type DBFollowRepository struct {
+	q sqlc.Querier
+}
+
+// NewDBFollowRepository creates a new instance of DBFollowRepository
+func NewDBFollowRepository(querier sqlc.Querier) FollowRepository {
+	return &DBFollowRepository{q: querier}
+}
+
+// CreateFollow inserts a follow relationship, following twice is a no-op
+func (r *DBFollowRepository) CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (bool, error) {
+	rows, err := r.q.CreateFollow(ctx, arg)
+	return rows > 0, err
+}
+
+// DeleteFollow deletes a follow relationship
+func (r *DBFollowRepository) DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (bool, error) {
+	rows, err := r.q.DeleteFollow(ctx, arg)
+	return rows > 0, err
+}
+
+// ListFollowers retrieves a page of the user's followers, newest first
+func (r *DBFollowRepository) ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	return r.q.ListFollowers(ctx, arg)
+}
+
+// ListFollowing retrieves a page of the users the user follows, newest first
+func (r *DBFollowRepository) ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	return r.q.ListFollowing(ctx, arg)
+}
+
+// ListAllFollowers retrieves every follower of the user, newest first
+func (r *DBFollowRepository) ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error) {
+	return r.q.ListAllFollowers(ctx, followeeID)
+}
+
+// ListAllFollowing retrieves every user the user follows, newest first
+func (r *DBFollowRepository) ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error) {
+	return r.q.ListAllFollowing(ctx, followerID)
+}
+
+// CountFollowers counts the user's followers
+func (r *DBFollowRepository) CountFollowers(ctx context.Context, userID int64) (int64, error) {
+	return r.q.CountFollowers(ctx, userID)
+}
+
+// CountFollowing counts the users the user follows
+func (r *DBFollowRepository) CountFollowing(ctx context.Context, userID int64) (int64, error) {
+	return r.q.CountFollowing(ctx, userID)
+}
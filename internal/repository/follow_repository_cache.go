@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+const (
+	userFollowingKeyPattern    = "{user:%d}:following"
+	userFollowersKeyPattern    = "{user:%d}:followers"
+	userFollowCountsKeyPattern = "{user:%d}:follow_counts"
+	followersCountField        = "followers"
+	followingCountField        = "following"
+	// maxCachedFollowSetSize bounds the follow sets kept in Redis, bigger sets are paged from DB
+	maxCachedFollowSetSize = 10_000
+)
+
+// followEdgeScript applies a follow or unfollow to one side of the relationship.
+// The set and counter are only touched when already cached, so a partial set or a counter
+// starting from zero never gets created. Both keys share the user's hash tag.
+//
+// KEYS[1] follow set, KEYS[2] follow counts hash
+// ARGV[1] counter field, ARGV[2] delta (1 or -1), ARGV[3] member, ARGV[4] score
+var followEdgeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  if tonumber(ARGV[2]) > 0 then
+    redis.call('ZADD', KEYS[1], ARGV[4], ARGV[3])
+  else
+    redis.call('ZREM', KEYS[1], ARGV[3])
+  end
+end
+if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 then
+  redis.call('HINCRBY', KEYS[2], ARGV[1], ARGV[2])
+end
+return 1
+`)
+
+// followSide describes one direction of the follow graph as seen from a user
+type followSide struct {
+	setKeyPattern string
+	countField    string
+	count         func(ctx context.Context, userID int64) (int64, error)
+	listAll       func(ctx context.Context, userID int64) ([]sqlc.Follow, error)
+	// other returns the user on the opposite side of the relationship
+	other func(f sqlc.Follow) int64
+	// follow builds a relationship from the owner of the set and a member
+	follow func(userID int64, member int64, at time.Time) sqlc.Follow
+}
+
+// CachedFollowRepository is a cache decorator for FollowRepository.
+// Following and follower sets are sorted sets scored by follow time, kept with the counters
+// under the user's hash tag.
+type CachedFollowRepository struct {
+	nextRepo  FollowRepository
+	rdb       redis.Cmdable
+	followers followSide
+	following followSide
+}
+
+// NewCachedFollowRepository creates a new instance of CachedFollowRepository
+func NewCachedFollowRepository(next FollowRepository, rdb redis.Cmdable) FollowRepository {
+	return &CachedFollowRepository{
+		nextRepo: next,
+		rdb:      rdb,
+		followers: followSide{
+			setKeyPattern: userFollowersKeyPattern,
+			countField:    followersCountField,
+			count:         next.CountFollowers,
+			listAll:       next.ListAllFollowers,
+			other:         func(f sqlc.Follow) int64 { return f.FollowerID },
+			follow: func(userID int64, member int64, at time.Time) sqlc.Follow {
+				return sqlc.Follow{FollowerID: member, FolloweeID: userID, CreatedAt: at}
+			},
+		},
+		following: followSide{
+			setKeyPattern: userFollowingKeyPattern,
+			countField:    followingCountField,
+			count:         next.CountFollowing,
+			listAll:       next.ListAllFollowing,
+			other:         func(f sqlc.Follow) int64 { return f.FolloweeID },
+			follow: func(userID int64, member int64, at time.Time) sqlc.Follow {
+				return sqlc.Follow{FollowerID: userID, FolloweeID: member, CreatedAt: at}
+			},
+		},
+	}
+}
+
+// CreateFollow creates a follow relationship and updates both users' cached sets and counters
+func (r *CachedFollowRepository) CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (bool, error) {
+	created, err := r.nextRepo.CreateFollow(ctx, arg)
+	if err != nil || !created {
+		return created, err
+	}
+
+	score := time.Now().Unix()
+	r.applyEdge(ctx, r.following, arg.FollowerID, arg.FolloweeID, 1, score)
+	r.applyEdge(ctx, r.followers, arg.FolloweeID, arg.FollowerID, 1, score)
+	r.invalidateFeed(ctx, arg.FollowerID)
+
+	return true, nil
+}
+
+// DeleteFollow deletes a follow relationship and updates both users' cached sets and counters
+func (r *CachedFollowRepository) DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (bool, error) {
+	deleted, err := r.nextRepo.DeleteFollow(ctx, arg)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+
+	r.applyEdge(ctx, r.following, arg.FollowerID, arg.FolloweeID, -1, 0)
+	r.applyEdge(ctx, r.followers, arg.FolloweeID, arg.FollowerID, -1, 0)
+	r.invalidateFeed(ctx, arg.FollowerID)
+
+	return true, nil
+}
+
+// ListFollowers reads a page of followers from cache first then DB
+func (r *CachedFollowRepository) ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	return r.listPage(ctx, r.followers, arg.FolloweeID, int64(arg.Offset), int64(arg.Limit), func() ([]sqlc.Follow, error) {
+		return r.nextRepo.ListFollowers(ctx, arg)
+	})
+}
+
+// ListFollowing reads a page of followed users from cache first then DB
+func (r *CachedFollowRepository) ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	return r.listPage(ctx, r.following, arg.FollowerID, int64(arg.Offset), int64(arg.Limit), func() ([]sqlc.Follow, error) {
+		return r.nextRepo.ListFollowing(ctx, arg)
+	})
+}
+
+// ListAllFollowers reads every follower from cache first then DB
+func (r *CachedFollowRepository) ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error) {
+	return r.listPage(ctx, r.followers, followeeID, 0, -1, func() ([]sqlc.Follow, error) {
+		return r.nextRepo.ListAllFollowers(ctx, followeeID)
+	})
+}
+
+// ListAllFollowing reads every followed user from cache first then DB
+func (r *CachedFollowRepository) ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error) {
+	return r.listPage(ctx, r.following, followerID, 0, -1, func() ([]sqlc.Follow, error) {
+		return r.nextRepo.ListAllFollowing(ctx, followerID)
+	})
+}
+
+// CountFollowers reads the cached followers counter first then DB
+func (r *CachedFollowRepository) CountFollowers(ctx context.Context, userID int64) (int64, error) {
+	return r.count(ctx, r.followers, userID)
+}
+
+// CountFollowing reads the cached following counter first then DB
+func (r *CachedFollowRepository) CountFollowing(ctx context.Context, userID int64) (int64, error) {
+	return r.count(ctx, r.following, userID)
+}
+
+func (r *CachedFollowRepository) count(ctx context.Context, side followSide, userID int64) (int64, error) {
+	countsKey := fmt.Sprintf(userFollowCountsKeyPattern, userID)
+	count, err := r.rdb.HGet(ctx, countsKey, side.countField).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		log.Printf("redis error on getting %s count for user %d: %v", side.countField, userID, err)
+	}
+
+	count, err = side.count(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := r.rdb.Pipeline()
+	pipe.HSet(ctx, countsKey, side.countField, count)
+	pipe.Expire(ctx, countsKey, cacheTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to cache %s count for user %d: %v", side.countField, userID, err)
+	}
+
+	return count, nil
+}
+
+// listPage returns the [offset, offset+limit) slice of the user's follow set, limit < 0 means all.
+// A missing set is loaded from DB in full when it is small enough to cache, otherwise the page
+// is read from DB directly.
+func (r *CachedFollowRepository) listPage(ctx context.Context, side followSide, userID int64, offset int64, limit int64, dbPage func() ([]sqlc.Follow, error)) ([]sqlc.Follow, error) {
+	total, err := r.count(ctx, side, userID)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return []sqlc.Follow{}, nil
+	}
+
+	setKey := fmt.Sprintf(side.setKeyPattern, userID)
+	stop := int64(-1)
+	if limit >= 0 {
+		stop = offset + limit - 1
+	}
+
+	pipe := r.rdb.Pipeline()
+	existsCmd := pipe.Exists(ctx, setKey)
+	rangeCmd := pipe.ZRevRangeWithScores(ctx, setKey, offset, stop)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("redis error on getting %s set for user %d: %v", side.countField, userID, err)
+	} else if existsCmd.Val() == 1 {
+		return zsToFollows(side, userID, rangeCmd.Val()), nil
+	}
+
+	if total > maxCachedFollowSetSize {
+		return dbPage()
+	}
+
+	all, err := side.listAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.cacheFollowSet(ctx, side, userID, all); err != nil {
+		log.Printf("failed to cache %s set for user %d: %v", side.countField, userID, err)
+	}
+
+	if offset >= int64(len(all)) {
+		return []sqlc.Follow{}, nil
+	}
+	end := int64(len(all))
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return all[offset:end], nil
+}
+
+func (r *CachedFollowRepository) cacheFollowSet(ctx context.Context, side followSide, userID int64, follows []sqlc.Follow) error {
+	if len(follows) == 0 {
+		return nil
+	}
+
+	setKey := fmt.Sprintf(side.setKeyPattern, userID)
+	members := make([]*redis.Z, len(follows))
+	for i, f := range follows {
+		members[i] = &redis.Z{Score: float64(f.CreatedAt.Unix()), Member: side.other(f)}
+	}
+
+	pipe := r.rdb.Pipeline()
+	pipe.Del(ctx, setKey)
+	pipe.ZAdd(ctx, setKey, members...)
+	pipe.Expire(ctx, setKey, cacheTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for %s set of user %d: %w", side.countField, userID, err)
+	}
+
+	return nil
+}
+
+func (r *CachedFollowRepository) applyEdge(ctx context.Context, side followSide, userID int64, member int64, delta int, score int64) {
+	keys := []string{
+		fmt.Sprintf(side.setKeyPattern, userID),
+		fmt.Sprintf(userFollowCountsKeyPattern, userID),
+	}
+	if err := followEdgeScript.Run(ctx, r.rdb, keys, side.countField, delta, member, score).Err(); err != nil {
+		log.Printf("failed to update cached %s of user %d: %v", side.countField, userID, err)
+	}
+}
+
+// invalidateFeed drops the follower's materialized feed, it is rebuilt from the new followees on next read
+func (r *CachedFollowRepository) invalidateFeed(ctx context.Context, followerID int64) {
+	if err := r.rdb.Del(ctx, fmt.Sprintf(userFeedKeyPattern, followerID)).Err(); err != nil {
+		log.Printf("failed to invalidate feed of user %d: %v", followerID, err)
+	}
+}
+
+func zsToFollows(side followSide, userID int64, zs []redis.Z) []sqlc.Follow {
+	follows := make([]sqlc.Follow, 0, len(zs))
+	for _, z := range zs {
+		member, ok := z.Member.(string)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		follows = append(follows, side.follow(userID, id, time.Unix(int64(z.Score), 0)))
+	}
+	return follows
+}
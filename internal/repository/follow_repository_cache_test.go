@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// evalShaKeys matches an EVALSHA call on its script and keys, ignoring the script arguments
+func evalShaKeys(numKeys int) func(expected, actual []interface{}) error {
+	return func(expected, actual []interface{}) error {
+		n := 3 + numKeys
+		if len(expected) < n || len(actual) < n {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+		for i := 0; i < n; i++ {
+			if fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+				return fmt.Errorf("expected %v, got %v", expected, actual)
+			}
+		}
+		return nil
+	}
+}
+
+func TestCachedFollowRepository_CreateFollow(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.FollowRepository)
+	repo := NewCachedFollowRepository(mockRepo, db)
+
+	arg := sqlc.CreateFollowParams{FollowerID: 1, FolloweeID: 2}
+	mockRepo.On("CreateFollow", mock.Anything, arg).Return(true, nil)
+
+	rdbMock.CustomMatch(evalShaKeys(2)).ExpectEvalSha(followEdgeScript.Hash(),
+		[]string{fmt.Sprintf(userFollowingKeyPattern, 1), fmt.Sprintf(userFollowCountsKeyPattern, 1)}, nil, nil, nil, nil).SetVal(int64(1))
+	rdbMock.CustomMatch(evalShaKeys(2)).ExpectEvalSha(followEdgeScript.Hash(),
+		[]string{fmt.Sprintf(userFollowersKeyPattern, 2), fmt.Sprintf(userFollowCountsKeyPattern, 2)}, nil, nil, nil, nil).SetVal(int64(1))
+	rdbMock.ExpectDel(fmt.Sprintf(userFeedKeyPattern, 1)).SetVal(1)
+
+	created, err := repo.CreateFollow(context.Background(), arg)
+
+	require.NoError(t, err)
+	assert.True(t, created)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFollowRepository_CreateFollow_AlreadyFollowing(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.FollowRepository)
+	repo := NewCachedFollowRepository(mockRepo, db)
+
+	arg := sqlc.CreateFollowParams{FollowerID: 1, FolloweeID: 2}
+	mockRepo.On("CreateFollow", mock.Anything, arg).Return(false, nil)
+
+	created, err := repo.CreateFollow(context.Background(), arg)
+
+	require.NoError(t, err)
+	assert.False(t, created)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFollowRepository_ListFollowing_CacheHit(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.FollowRepository)
+	repo := NewCachedFollowRepository(mockRepo, db)
+
+	followingKey := fmt.Sprintf(userFollowingKeyPattern, 1)
+	followedAt := time.Unix(1700000000, 0)
+
+	rdbMock.ExpectHGet(fmt.Sprintf(userFollowCountsKeyPattern, 1), followingCountField).SetVal("2")
+	rdbMock.ExpectExists(followingKey).SetVal(1)
+	rdbMock.ExpectZRevRangeWithScores(followingKey, 0, 9).SetVal([]redis.Z{
+		{Score: float64(followedAt.Unix()), Member: "3"},
+		{Score: float64(followedAt.Unix()), Member: "2"},
+	})
+
+	follows, err := repo.ListFollowing(context.Background(), sqlc.ListFollowingParams{FollowerID: 1, Limit: 10, Offset: 0})
+
+	require.NoError(t, err)
+	assert.Equal(t, []sqlc.Follow{
+		{FollowerID: 1, FolloweeID: 3, CreatedAt: followedAt},
+		{FollowerID: 1, FolloweeID: 2, CreatedAt: followedAt},
+	}, follows)
+	mockRepo.AssertNotCalled(t, "ListFollowing", mock.Anything, mock.Anything)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFollowRepository_ListFollowers_CacheMiss(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.FollowRepository)
+	repo := NewCachedFollowRepository(mockRepo, db)
+
+	countsKey := fmt.Sprintf(userFollowCountsKeyPattern, 2)
+	followersKey := fmt.Sprintf(userFollowersKeyPattern, 2)
+	followedAt := time.Unix(1700000000, 0)
+	all := []sqlc.Follow{
+		{FollowerID: 5, FolloweeID: 2, CreatedAt: followedAt},
+		{FollowerID: 4, FolloweeID: 2, CreatedAt: followedAt.Add(-time.Minute)},
+		{FollowerID: 3, FolloweeID: 2, CreatedAt: followedAt.Add(-time.Hour)},
+	}
+
+	rdbMock.ExpectHGet(countsKey, followersCountField).RedisNil()
+	mockRepo.On("CountFollowers", mock.Anything, int64(2)).Return(int64(3), nil)
+	rdbMock.ExpectHSet(countsKey, followersCountField, int64(3)).SetVal(1)
+	rdbMock.ExpectExpire(countsKey, cacheTTL).SetVal(true)
+	rdbMock.ExpectExists(followersKey).SetVal(0)
+	rdbMock.ExpectZRevRangeWithScores(followersKey, 1, 2).SetVal([]redis.Z{})
+	mockRepo.On("ListAllFollowers", mock.Anything, int64(2)).Return(all, nil)
+	rdbMock.ExpectDel(followersKey).SetVal(0)
+	rdbMock.ExpectZAdd(followersKey,
+		&redis.Z{Score: float64(all[0].CreatedAt.Unix()), Member: int64(5)},
+		&redis.Z{Score: float64(all[1].CreatedAt.Unix()), Member: int64(4)},
+		&redis.Z{Score: float64(all[2].CreatedAt.Unix()), Member: int64(3)},
+	).SetVal(3)
+	rdbMock.ExpectExpire(followersKey, cacheTTL).SetVal(true)
+
+	follows, err := repo.ListFollowers(context.Background(), sqlc.ListFollowersParams{FolloweeID: 2, Limit: 2, Offset: 1})
+
+	require.NoError(t, err)
+	assert.Equal(t, all[1:3], follows)
+	mockRepo.AssertExpectations(t)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedFollowRepository_ListFollowing_NoFollows(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.FollowRepository)
+	repo := NewCachedFollowRepository(mockRepo, db)
+
+	rdbMock.ExpectHGet(fmt.Sprintf(userFollowCountsKeyPattern, 1), followingCountField).SetVal("0")
+
+	follows, err := repo.ListAllFollowing(context.Background(), 1)
+
+	require.NoError(t, err)
+	assert.Empty(t, follows)
+	mockRepo.AssertNotCalled(t, "ListAllFollowing", mock.Anything, mock.Anything)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,87 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBFollowRepository_CreateAndDeleteFollow(t *testing.T) {
+	followRepo := NewDBFollowRepository(testQueries)
+	ctx := context.Background()
+
+	follower := createTestUser(t, ctx)
+	followee := createTestUser(t, ctx)
+	arg := sqlc.CreateFollowParams{FollowerID: follower.ID, FolloweeID: followee.ID}
+
+	created, err := followRepo.CreateFollow(ctx, arg)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Following twice is a no-op
+	created, err = followRepo.CreateFollow(ctx, arg)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	following, err := followRepo.CountFollowing(ctx, follower.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), following)
+
+	followers, err := followRepo.CountFollowers(ctx, followee.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), followers)
+
+	deleted, err := followRepo.DeleteFollow(ctx, sqlc.DeleteFollowParams{FollowerID: follower.ID, FolloweeID: followee.ID})
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = followRepo.DeleteFollow(ctx, sqlc.DeleteFollowParams{FollowerID: follower.ID, FolloweeID: followee.ID})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestDBFollowRepository_CreateFollow_Self(t *testing.T) {
+	followRepo := NewDBFollowRepository(testQueries)
+	ctx := context.Background()
+
+	user := createTestUser(t, ctx)
+
+	_, err := followRepo.CreateFollow(ctx, sqlc.CreateFollowParams{FollowerID: user.ID, FolloweeID: user.ID})
+	require.Error(t, err)
+}
+
+func TestDBFollowRepository_ListFollowers(t *testing.T) {
+	followRepo := NewDBFollowRepository(testQueries)
+	ctx := context.Background()
+
+	followee := createTestUser(t, ctx)
+	var followerIDs []int64
+	for i := 0; i < 3; i++ {
+		follower := createTestUser(t, ctx)
+		_, err := followRepo.CreateFollow(ctx, sqlc.CreateFollowParams{FollowerID: follower.ID, FolloweeID: followee.ID})
+		require.NoError(t, err)
+		followerIDs = append(followerIDs, follower.ID)
+	}
+
+	page, err := followRepo.ListFollowers(ctx, sqlc.ListFollowersParams{FolloweeID: followee.ID, Limit: 2, Offset: 0})
+	require.NoError(t, err)
+	assert.Len(t, page, 2)
+
+	all, err := followRepo.ListAllFollowers(ctx, followee.ID)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	for _, f := range all {
+		assert.Equal(t, followee.ID, f.FolloweeID)
+		assert.Contains(t, followerIDs, f.FollowerID)
+	}
+
+	following, err := followRepo.ListAllFollowing(ctx, followerIDs[0])
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	assert.Equal(t, followee.ID, following[0].FolloweeID)
+}
@@ -4,7 +4,9 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -12,15 +14,21 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-var testQueries *sqlc.Queries
+var testQueries *shard.Router
 var testDb *pgxpool.Pool
+var testConnStr string
 
 func TestMain(m *testing.M) {
 	ctx := context.Background()
@@ -51,6 +59,8 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to get connection string: %s", err)
 	}
 
+	testConnStr = connStr
+
 	// Run migrations
 	migrator, err := migrate.New("file://../../db/migration", connStr)
 	if err != nil {
@@ -70,8 +80,149 @@ func TestMain(m *testing.M) {
 	testDb = dbPool
 	defer dbPool.Close()
 
-	testQueries = sqlc.New(dbPool)
+	// IDs are generated by the shard router, a single shard holds every row
+	ids, err := snowflake.NewGenerator(0)
+	if err != nil {
+		log.Fatalf("failed to create ID generator: %s", err)
+	}
+	testQueries, err = shard.New([]sqlc.Querier{sqlc.New(dbPool)}, []shard.Beginner{dbPool}, ids)
+	if err != nil {
+		log.Fatalf("failed to create shard router: %s", err)
+	}
 
 	code := m.Run()
 	os.Exit(code)
 }
+
+// migrateFreshDatabase creates an empty database next to the test one, returning its URL and migrator
+func migrateFreshDatabase(t *testing.T, ctx context.Context, name string) (string, *migrate.Migrate) {
+	_, err := testDb.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize())
+	require.NoError(t, err)
+
+	dbURL, err := url.Parse(testConnStr)
+	require.NoError(t, err)
+	dbURL.Path = "/" + name
+
+	migrator, err := migrate.New("file://../../db/migration", dbURL.String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = migrator.Close()
+	})
+	return dbURL.String(), migrator
+}
+
+func TestMigration_PartitionPosts(t *testing.T) {
+	ctx := context.Background()
+	dbURL, migrator := migrateFreshDatabase(t, ctx, "partition_posts")
+
+	// Posts of the unpartitioned table, spread over several months
+	require.NoError(t, migrator.Migrate(5))
+	pool, err := pgxpool.New(ctx, dbURL)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	now := time.Now().UTC()
+	createdAts := map[int64]time.Time{
+		1: now,
+		2: now.AddDate(0, -2, 0),
+		3: time.Date(2020, time.January, 15, 10, 0, 0, 0, time.UTC),
+	}
+	_, err = pool.Exec(ctx, `INSERT INTO users (id, first_name, last_name, email, hashed_password) VALUES (1, 'Part', 'User', 'part@example.com', 'hashed')`)
+	require.NoError(t, err)
+	for id, createdAt := range createdAts {
+		_, err = pool.Exec(ctx, `INSERT INTO posts (id, user_id, content, created_at) VALUES ($1, 1, 'old post', $2)`, id, createdAt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, migrator.Migrate(6))
+
+	// Every post moved to the partition of its month
+	for id, createdAt := range createdAts {
+		var partition string
+		require.NoError(t, pool.QueryRow(ctx, `SELECT tableoid::regclass::text FROM posts WHERE id = $1`, id).Scan(&partition))
+		assert.Equal(t, "posts_"+createdAt.Format("2006_01"), partition)
+	}
+
+	// The sqlc queries work on the partitioned table
+	q := sqlc.New(pool)
+	post, err := q.GetPost(ctx, 3)
+	require.NoError(t, err)
+	assert.True(t, createdAts[3].Equal(post.CreatedAt))
+
+	created, err := q.CreatePost(ctx, sqlc.CreatePostParams{ID: 4, UserID: 1, Content: "new post"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), created.ID)
+
+	rows, err := q.CreatePostsInBatch(ctx, []sqlc.CreatePostsInBatchParams{{ID: 5, UserID: 1, Content: "batch"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	posts, err := q.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: 1, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, posts, 5)
+	assert.Equal(t, int64(3), posts[4].ID)
+
+	byIDs, err := q.GetPostsByIDs(ctx, []int64{1, 2, 3})
+	require.NoError(t, err)
+	assert.Len(t, byIDs, 3)
+
+	// Posts beyond the created partitions wait in the default one until maintenance creates theirs
+	future := now.AddDate(2, 0, 0)
+	_, err = pool.Exec(ctx, `INSERT INTO posts (id, user_id, content, created_at) VALUES (6, 1, 'future post', $1)`, future)
+	require.NoError(t, err)
+	var partition string
+	require.NoError(t, pool.QueryRow(ctx, `SELECT tableoid::regclass::text FROM posts WHERE id = 6`).Scan(&partition))
+	assert.Equal(t, "posts_default", partition)
+
+	var partitions int
+	require.NoError(t, pool.QueryRow(ctx, `SELECT create_posts_partitions($1, $2)`, future, future.Add(time.Microsecond)).Scan(&partitions))
+	assert.Equal(t, 1, partitions)
+	require.NoError(t, pool.QueryRow(ctx, `SELECT tableoid::regclass::text FROM posts WHERE id = 6`).Scan(&partition))
+	assert.Equal(t, "posts_"+future.Format("2006_01"), partition)
+
+	// Rolling back keeps the posts
+	require.NoError(t, migrator.Migrate(5))
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, `SELECT COUNT(*) FROM posts`).Scan(&count))
+	assert.Equal(t, 6, count)
+}
+
+func TestMigration_CacheChanges(t *testing.T) {
+	ctx := context.Background()
+	dbURL, migrator := migrateFreshDatabase(t, ctx, "cache_changes")
+	require.NoError(t, migrator.Up())
+	pool, err := pgxpool.New(ctx, dbURL)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	q := sqlc.New(pool)
+	_, err = q.CreateUser(ctx, sqlc.CreateUserParams{ID: 1, FirstName: "Cdc", LastName: "User", Email: "cdc@example.com", HashedPassword: "hashed"})
+	require.NoError(t, err)
+	_, err = q.CreatePost(ctx, sqlc.CreatePostParams{ID: 10, UserID: 1, Content: "created"})
+	require.NoError(t, err)
+	// Batch inserts bypass the application's cache writes, their rows are logged too
+	_, err = q.CreatePostsInBatch(ctx, []sqlc.CreatePostsInBatchParams{{ID: 11, UserID: 1, Content: "copied"}})
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, `UPDATE posts SET content = 'edited' WHERE id = 10`)
+	require.NoError(t, err)
+	// Deleting the user cascades to their posts
+	require.NoError(t, q.DeleteUser(ctx, 1))
+
+	rows, err := pool.Query(ctx, `SELECT table_name, op, row_id, user_id FROM cache_changes ORDER BY id`)
+	require.NoError(t, err)
+	var changes []string
+	for rows.Next() {
+		var table, op string
+		var rowID, userID int64
+		require.NoError(t, rows.Scan(&table, &op, &rowID, &userID))
+		assert.Equal(t, int64(1), userID)
+		changes = append(changes, fmt.Sprintf("%s %s %d", table, op, rowID))
+	}
+	require.NoError(t, rows.Err())
+	assert.ElementsMatch(t, []string{
+		"posts I 10", "posts I 11", "posts U 10", "posts D 10", "posts D 11", "users D 1",
+	}, changes)
+
+	var consumer string
+	require.NoError(t, pool.QueryRow(ctx, `SELECT consumer FROM cache_change_consumers WHERE consumer = 'redis'`).Scan(&consumer))
+}
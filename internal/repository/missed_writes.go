@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+)
+
+// missedWrite is a post written while the cache node of its user had an open circuit
+type missedWrite struct {
+	userID int64
+	// seq tells a write recorded again during a replay from the one replayed
+	seq uint64
+}
+
+// MissedWrites remembers the posts written while the cache node of their user had an open circuit,
+// whose cache effects were skipped, and drops what they left stale once the node is back. Without it,
+// a timeline version kept from before the outage answers 304 for a changed timeline, and a deleted
+// post stays cached until its TTL. Writes are remembered by the process that made them, a restart
+// forgets them. A nil MissedWrites remembers nothing.
+type MissedWrites struct {
+	syncer PostCacheSyncer
+	agg    *aggregator.Aggregator
+
+	mu     sync.Mutex
+	writes map[int64]missedWrite // post id -> write
+	seq    uint64
+}
+
+// NewMissedWrites creates a new instance of MissedWrites, dropping the stale cache through syncer
+func NewMissedWrites(syncer PostCacheSyncer, agg *aggregator.Aggregator) *MissedWrites {
+	return &MissedWrites{
+		syncer: syncer,
+		agg:    agg,
+		writes: make(map[int64]missedWrite),
+	}
+}
+
+// Record remembers a post created, updated or deleted without its cache effects
+func (m *MissedWrites) Record(post sqlc.Post) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	m.writes[post.ID] = missedWrite{userID: post.UserID, seq: m.seq}
+}
+
+// Replay drops the cached timelines and versions of the users whose node is back, along with the cached
+// copies of their posts written meanwhile, so that they are read from DB again. Writes of nodes still
+// open are kept, and so are all of them when Redis fails, for the next replay.
+func (m *MissedWrites) Replay(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	replayed := make(map[int64]missedWrite)
+	for id, w := range m.writes {
+		if !m.agg.NodeOpen(fmt.Sprintf(userPostsKeyPattern, w.userID)) {
+			replayed[id] = w
+		}
+	}
+	m.mu.Unlock()
+	if len(replayed) == 0 {
+		return nil
+	}
+
+	refs := make([]PostRef, 0, len(replayed))
+	var userIDs []int64
+	for id, w := range replayed {
+		refs = append(refs, PostRef{ID: id, UserID: w.userID})
+		userIDs = append(userIDs, w.userID)
+	}
+	slices.SortFunc(refs, func(a, b PostRef) int { return cmp.Compare(a.ID, b.ID) })
+	slices.Sort(userIDs)
+	userIDs = slices.Compact(userIDs)
+
+	// Timelines are dropped whole, as the posts created meanwhile are missing from them
+	if err := m.syncer.RemoveUsers(ctx, userIDs); err != nil {
+		return fmt.Errorf("failed to replay missed writes of %d users: %w", len(userIDs), err)
+	}
+	if err := m.syncer.RemovePosts(ctx, refs); err != nil {
+		return fmt.Errorf("failed to replay %d missed post writes: %w", len(refs), err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, w := range replayed {
+		if m.writes[id].seq == w.seq {
+			delete(m.writes, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type FollowRepository struct {
+	mock.Mock
+}
+
+func (m *FollowRepository) CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (bool, error) {
+	args := m.Called(ctx, arg)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *FollowRepository) DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (bool, error) {
+	args := m.Called(ctx, arg)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *FollowRepository) ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowRepository) ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowRepository) ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, followeeID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowRepository) ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, followerID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowRepository) CountFollowers(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *FollowRepository) CountFollowing(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
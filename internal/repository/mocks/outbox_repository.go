@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type OutboxRepository struct {
+	mock.Mock
+}
+
+func (m *OutboxRepository) CreateEvent(ctx context.Context, arg sqlc.CreateOutboxEventParams) (sqlc.Outbox, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return sqlc.Outbox{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Outbox), args.Error(1)
+}
@@ -19,6 +19,16 @@ func (m *PostRepository) CreatePost(ctx context.Context, arg sqlc.CreatePostPara
 	return args.Get(0).(sqlc.Post), args.Error(1)
 }
 
+func (m *PostRepository) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
+func (m *PostRepository) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
 func (m *PostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
@@ -34,3 +44,24 @@ func (m *PostRepository) ListPostsByUser(ctx context.Context, arg sqlc.ListPosts
 	}
 	return args.Get(0).([]sqlc.Post), args.Error(1)
 }
+
+func (m *PostRepository) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *PostRepository) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *PostRepository) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
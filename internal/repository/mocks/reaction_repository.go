@@ -0,0 +1,43 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type ReactionRepository struct {
+	mock.Mock
+}
+
+func (m *ReactionRepository) SetReaction(ctx context.Context, post sqlc.Post, userID int64, reaction string) (string, error) {
+	args := m.Called(ctx, post, userID, reaction)
+	return args.String(0), args.Error(1)
+}
+
+func (m *ReactionRepository) DeleteReaction(ctx context.Context, post sqlc.Post, userID int64) (string, error) {
+	args := m.Called(ctx, post, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *ReactionRepository) HasReacted(ctx context.Context, userID, postID int64) (bool, error) {
+	args := m.Called(ctx, userID, postID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *ReactionRepository) ListReactedPostIDs(ctx context.Context, userID int64, limit int32) ([]int64, error) {
+	args := m.Called(ctx, userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *ReactionRepository) GetReactionCounts(ctx context.Context, post sqlc.Post) (map[string]int64, error) {
+	args := m.Called(ctx, post)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
@@ -0,0 +1,70 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type WebhookRepository struct {
+	mock.Mock
+}
+
+func (m *WebhookRepository) CreateWebhook(ctx context.Context, arg sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookRepository) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookRepository) ListWebhooks(ctx context.Context, arg sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookRepository) ListWebhooksForEvent(ctx context.Context, eventType string, userID int64) ([]sqlc.Webhook, error) {
+	args := m.Called(ctx, eventType, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookRepository) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookRepository) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *WebhookRepository) CreateDelivery(ctx context.Context, arg sqlc.CreateWebhookDeliveryParams) (bool, error) {
+	args := m.Called(ctx, arg)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *WebhookRepository) ListDeliveries(ctx context.Context, arg sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.WebhookDelivery), args.Error(1)
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// Post domain events
+const (
+	EventPostCreated = "post.created"
+	EventPostUpdated = "post.updated"
+	EventPostDeleted = "post.deleted"
+
+	// PostEventsStream is the Redis Stream the outbox relay publishes post events to
+	PostEventsStream = "events:posts"
+)
+
+// OutboxRepository writes domain events to the outbox. Used with the repositories of a TxManager, the
+// event is written in the transaction of the change it describes, and published by the outbox relay
+// once committed.
+type OutboxRepository interface {
+	CreateEvent(ctx context.Context, arg sqlc.CreateOutboxEventParams) (sqlc.Outbox, error)
+}
+
+// DBOutboxRepository takes sqlc.Querier to create an instance
+type DBOutboxRepository struct {
+	q sqlc.Querier
+}
+
+// NewDBOutboxRepository creates a new instance of DBOutboxRepository
+func NewDBOutboxRepository(querier sqlc.Querier) OutboxRepository {
+	return &DBOutboxRepository{q: querier}
+}
+
+// CreateEvent writes an event to the outbox of the shard of its user
+func (r *DBOutboxRepository) CreateEvent(ctx context.Context, arg sqlc.CreateOutboxEventParams) (sqlc.Outbox, error) {
+	return r.q.CreateOutboxEvent(ctx, arg)
+}
+
+// NewPostEvent builds the outbox event of a change of post, carrying the post as payload
+func NewPostEvent(eventType string, post sqlc.Post) (sqlc.CreateOutboxEventParams, error) {
+	payload, err := json.Marshal(post)
+	if err != nil {
+		return sqlc.CreateOutboxEventParams{}, fmt.Errorf("failed to marshal %s event of post %d: %w", eventType, post.ID, err)
+	}
+	return sqlc.CreateOutboxEventParams{
+		EventType:   eventType,
+		AggregateID: post.ID,
+		UserID:      post.UserID,
+		Payload:     payload,
+	}, nil
+}
@@ -0,0 +1,71 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBOutboxRepository_CreateEventWithinTx(t *testing.T) {
+	ctx := context.Background()
+	user := createTestUser(t, ctx)
+	txManager := NewTxManager(func(ctx context.Context) (Transaction, error) {
+		return testQueries.BeginTx(ctx)
+	}, func(q sqlc.Querier) TxRepositories {
+		return TxRepositories{Posts: NewDBPostRepository(q), Outbox: NewDBOutboxRepository(q)}
+	})
+
+	createPostWithEvent := func(ctx context.Context, repos TxRepositories) (sqlc.Post, error) {
+		post, err := repos.Posts.CreatePost(ctx, sqlc.CreatePostParams{UserID: user.ID, Content: "with event"})
+		if err != nil {
+			return sqlc.Post{}, err
+		}
+		event, err := NewPostEvent(EventPostCreated, post)
+		if err != nil {
+			return sqlc.Post{}, err
+		}
+		_, err = repos.Outbox.CreateEvent(ctx, event)
+		return post, err
+	}
+	countEvents := func(postID int64) int {
+		var count int
+		require.NoError(t, testDb.QueryRow(ctx, `SELECT COUNT(*) FROM outbox WHERE aggregate_id = $1`, postID).Scan(&count))
+		return count
+	}
+
+	t.Run("committed_with_post", func(t *testing.T) {
+		var post sqlc.Post
+		err := txManager.WithinTx(ctx, func(ctx context.Context, repos TxRepositories) error {
+			var err error
+			post, err = createPostWithEvent(ctx, repos)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, countEvents(post.ID))
+
+		var eventType string
+		var sent bool
+		require.NoError(t, testDb.QueryRow(ctx, `SELECT event_type, sent_at IS NOT NULL FROM outbox WHERE aggregate_id = $1`, post.ID).Scan(&eventType, &sent))
+		assert.Equal(t, EventPostCreated, eventType)
+		assert.False(t, sent)
+	})
+
+	t.Run("rolled_back_with_post", func(t *testing.T) {
+		var post sqlc.Post
+		err := txManager.WithinTx(ctx, func(ctx context.Context, repos TxRepositories) error {
+			var err error
+			if post, err = createPostWithEvent(ctx, repos); err != nil {
+				return err
+			}
+			return errors.New("abort")
+		})
+		require.Error(t, err)
+		assert.Zero(t, countEvents(post.ID))
+	})
+}
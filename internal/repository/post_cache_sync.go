@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// zaddIfExistsScript adds a member to a sorted set that is already cached, so that a partial posts
+// list never gets created.
+//
+// KEYS[1] sorted set
+// ARGV[1] score, ARGV[2] member
+var zaddIfExistsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+end
+return 1
+`)
+
+// PostRef identifies a post that is gone from DB, along with the user whose lists held it
+type PostRef struct {
+	ID     int64
+	UserID int64
+}
+
+// PostCacheSyncer brings the cached Posts and posts lists in line with DB after writes made outside of
+// CachedPostRepository. Applying the same rows twice leaves the cache as applying them once.
+type PostCacheSyncer interface {
+	// RefreshPosts writes the current rows of posts over their cached copies
+	RefreshPosts(ctx context.Context, posts []sqlc.Post) error
+	// RemovePosts drops deleted posts from the cache
+	RemovePosts(ctx context.Context, posts []PostRef) error
+	// RemoveUsers drops the posts lists of deleted users
+	RemoveUsers(ctx context.Context, userIDs []int64) error
+}
+
+// RedisPostCacheSyncer is the PostCacheSyncer of the Redis cache of CachedPostRepository
+type RedisPostCacheSyncer struct {
+	rdb redis.Cmdable
+}
+
+// NewPostCacheSyncer creates a new instance of RedisPostCacheSyncer
+func NewPostCacheSyncer(rdb redis.Cmdable) PostCacheSyncer {
+	return &RedisPostCacheSyncer{rdb: rdb}
+}
+
+// RefreshPosts writes the current rows of posts over their cached copies, stale ones included, which
+// keep their TTL. Posts and lists that are not cached are left alone rather than cached, and the
+// timeline version of every author changes.
+func (s *RedisPostCacheSyncer) RefreshPosts(ctx context.Context, posts []sqlc.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	users := make(map[int64]struct{})
+	for _, p := range posts {
+		postJSON, err := json.Marshal(p)
+		if err != nil {
+			log.Printf("failed to marshal post %d for cache refresh: %v", p.ID, err)
+			continue
+		}
+		pipe.SetXX(ctx, fmt.Sprintf(postKeyGenericPattern, p.ID), postJSON, cacheTTL)
+		pipe.SetXX(ctx, fmt.Sprintf(stalePostKeyPattern, p.ID), postJSON, redis.KeepTTL)
+		zaddIfExistsScript.Eval(ctx, pipe, []string{fmt.Sprintf(userPostsKeyPattern, p.UserID)}, float64(p.CreatedAt.Unix()), p.ID)
+		users[p.UserID] = struct{}{}
+	}
+	// A dropped version is seeded again with a new value on its next read
+	for userID := range users {
+		pipe.Del(ctx, fmt.Sprintf(userPostsVersionKeyPattern, userID))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for refreshing %d posts: %w", len(posts), err)
+	}
+	return nil
+}
+
+// RemovePosts drops deleted posts from the cache, their stale copies and their authors' lists included
+func (s *RedisPostCacheSyncer) RemovePosts(ctx context.Context, posts []PostRef) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	users := make(map[int64]struct{})
+	for _, p := range posts {
+		pipe.Del(ctx, fmt.Sprintf(postKeyGenericPattern, p.ID))
+		pipe.Del(ctx, fmt.Sprintf(stalePostKeyPattern, p.ID))
+		pipe.ZRem(ctx, fmt.Sprintf(userPostsKeyPattern, p.UserID), p.ID)
+		pipe.ZRem(ctx, fmt.Sprintf(staleUserPostsKeyPattern, p.UserID), p.ID)
+		users[p.UserID] = struct{}{}
+	}
+	for userID := range users {
+		pipe.Del(ctx, fmt.Sprintf(userPostsVersionKeyPattern, userID))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for removing %d posts: %w", len(posts), err)
+	}
+	return nil
+}
+
+// RemoveUsers drops the posts lists of deleted users
+func (s *RedisPostCacheSyncer) RemoveUsers(ctx context.Context, userIDs []int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	for _, userID := range userIDs {
+		// The keys share the user's hash tag
+		pipe.Del(ctx,
+			fmt.Sprintf(userPostsKeyPattern, userID),
+			fmt.Sprintf(userPostsVersionKeyPattern, userID),
+			fmt.Sprintf(staleUserPostsKeyPattern, userID),
+		)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for removing posts lists of %d users: %w", len(userIDs), err)
+	}
+	return nil
+}
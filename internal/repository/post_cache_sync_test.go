@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostCacheSyncer_RefreshPosts(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	syncer := NewPostCacheSyncer(db)
+
+	post := sqlc.Post{ID: 100, UserID: 1, Content: "edited elsewhere", CreatedAt: time.Now()}
+	postJSON, _ := json.Marshal(post)
+	userPostsKey := fmt.Sprintf(userPostsKeyPattern, post.UserID)
+
+	// Only what is cached already gets refreshed
+	rdbMock.ExpectSetXX(fmt.Sprintf(postKeyGenericPattern, post.ID), postJSON, cacheTTL).SetVal(true)
+	// The stale copy served while DB is unavailable is refreshed too
+	rdbMock.ExpectSetXX(fmt.Sprintf(stalePostKeyPattern, post.ID), postJSON, redis.KeepTTL).SetVal(true)
+	rdbMock.CustomMatch(evalKeys(1)).ExpectEval("", []string{userPostsKey}, float64(post.CreatedAt.Unix()), post.ID).SetVal(int64(1))
+	rdbMock.ExpectDel(fmt.Sprintf(userPostsVersionKeyPattern, post.UserID)).SetVal(1)
+
+	require.NoError(t, syncer.RefreshPosts(context.Background(), []sqlc.Post{post}))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestPostCacheSyncer_RemovePosts(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	syncer := NewPostCacheSyncer(db)
+
+	ref := PostRef{ID: 100, UserID: 1}
+	rdbMock.ExpectDel(fmt.Sprintf(postKeyGenericPattern, ref.ID)).SetVal(1)
+	rdbMock.ExpectDel(fmt.Sprintf(stalePostKeyPattern, ref.ID)).SetVal(1)
+	rdbMock.ExpectZRem(fmt.Sprintf(userPostsKeyPattern, ref.UserID), ref.ID).SetVal(1)
+	rdbMock.ExpectZRem(fmt.Sprintf(staleUserPostsKeyPattern, ref.UserID), ref.ID).SetVal(1)
+	rdbMock.ExpectDel(fmt.Sprintf(userPostsVersionKeyPattern, ref.UserID)).SetVal(1)
+
+	require.NoError(t, syncer.RemovePosts(context.Background(), []PostRef{ref}))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestPostCacheSyncer_RemoveUsers(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	syncer := NewPostCacheSyncer(db)
+
+	rdbMock.ExpectDel(
+		fmt.Sprintf(userPostsKeyPattern, 1),
+		fmt.Sprintf(userPostsVersionKeyPattern, 1),
+		fmt.Sprintf(staleUserPostsKeyPattern, 1),
+	).SetVal(3)
+
+	require.NoError(t, syncer.RemoveUsers(context.Background(), []int64{1}))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+const (
+	userPostCountKeyPattern = "{user:%d}:post_count"
+	// userPostCountEventKeyPattern marks an event as counted, sharing the hash tag of the counter
+	userPostCountEventKeyPattern = "{user:%d}:post_count:event:%d"
+
+	// postCountEventTTL is how long a counted event is remembered, longer than any redelivery
+	postCountEventTTL = 7 * 24 * time.Hour
+)
+
+// addPostCountScript adds to a post counter once per event. It returns 0 without counting the event
+// when the counter is missing, for it to be seeded from DB.
+//
+// KEYS[1] counter, KEYS[2] event marker
+// ARGV[1] delta, ARGV[2] marker TTL in seconds
+var addPostCountScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+if redis.call('SET', KEYS[2], 1, 'NX', 'EX', ARGV[2]) then
+  redis.call('INCRBY', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// seedPostCountScript seeds a missing post counter with the count of DB, which has the event already
+// as events are published once committed, and marks the event as counted. A counter seeded meanwhile
+// gets the delta of the event instead.
+//
+// KEYS[1] counter, KEYS[2] event marker
+// ARGV[1] delta, ARGV[2] marker TTL in seconds, ARGV[3] count of DB
+var seedPostCountScript = redis.NewScript(`
+if redis.call('SET', KEYS[2], 1, 'NX', 'EX', ARGV[2]) then
+  if not redis.call('SET', KEYS[1], ARGV[3], 'NX') then
+    redis.call('INCRBY', KEYS[1], ARGV[1])
+  end
+end
+return 1
+`)
+
+// PostCountRepository keeps the number of posts of every user, counted from the post events
+type PostCountRepository interface {
+	// AddPostCount adds delta to the post count of the user for the event eventID, once committed. An
+	// event that was counted already is ignored, and a count that is missing is read from DB instead.
+	AddPostCount(ctx context.Context, userID, eventID, delta int64) error
+	// GetPostCount returns the post count of the user, zero when none was counted
+	GetPostCount(ctx context.Context, userID int64) (int64, error)
+}
+
+// RedisPostCountRepository keeps the post counts in Redis, next to the user's other keys. A count is
+// seeded from DB on the first event of its user, so that the posts older than the events count too.
+// The events of the user still waiting to be counted when it is seeded are counted twice.
+type RedisPostCountRepository struct {
+	rdb redis.Cmdable
+	q   sqlc.Querier
+}
+
+// NewPostCountRepository creates a new instance of RedisPostCountRepository, seeding the counts from q
+func NewPostCountRepository(rdb redis.Cmdable, q sqlc.Querier) PostCountRepository {
+	return &RedisPostCountRepository{rdb: rdb, q: q}
+}
+
+func (r *RedisPostCountRepository) AddPostCount(ctx context.Context, userID, eventID, delta int64) error {
+	keys := []string{
+		fmt.Sprintf(userPostCountKeyPattern, userID),
+		fmt.Sprintf(userPostCountEventKeyPattern, userID, eventID),
+	}
+	added, err := addPostCountScript.Run(ctx, r.rdb, keys, delta, int64(postCountEventTTL.Seconds())).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to add to post count of user %d: %w", userID, err)
+	}
+	if added == 1 {
+		return nil
+	}
+
+	count, err := r.q.CountPostsByUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to count posts of user %d: %w", userID, err)
+	}
+	if err := seedPostCountScript.Run(ctx, r.rdb, keys, delta, int64(postCountEventTTL.Seconds()), count).Err(); err != nil {
+		return fmt.Errorf("failed to seed post count of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *RedisPostCountRepository) GetPostCount(ctx context.Context, userID int64) (int64, error) {
+	count, err := r.rdb.Get(ctx, fmt.Sprintf(userPostCountKeyPattern, userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePostCountQuerier serves the post count of DB
+type fakePostCountQuerier struct {
+	sqlc.Querier
+	count int64
+}
+
+func (q *fakePostCountQuerier) CountPostsByUser(context.Context, int64) (int64, error) {
+	return q.count, nil
+}
+
+func TestPostCountRepository_AddPostCount(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	repo := NewPostCountRepository(db, &fakePostCountQuerier{})
+
+	keys := []string{fmt.Sprintf(userPostCountKeyPattern, 1), fmt.Sprintf(userPostCountEventKeyPattern, 1, 42)}
+	rdbMock.CustomMatch(evalShaKeys(2)).ExpectEvalSha(addPostCountScript.Hash(), keys, int64(1), int64(postCountEventTTL.Seconds())).SetVal(int64(1))
+
+	require.NoError(t, repo.AddPostCount(context.Background(), 1, 42, 1))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestPostCountRepository_AddPostCount_Seeded(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	// The user wrote 3 posts before the stream, DB counts them along with the post of the event
+	repo := NewPostCountRepository(db, &fakePostCountQuerier{count: 4})
+
+	keys := []string{fmt.Sprintf(userPostCountKeyPattern, 1), fmt.Sprintf(userPostCountEventKeyPattern, 1, 42)}
+	rdbMock.CustomMatch(evalShaKeys(2)).ExpectEvalSha(addPostCountScript.Hash(), keys, int64(1), int64(postCountEventTTL.Seconds())).SetVal(int64(0))
+	rdbMock.ExpectEvalSha(seedPostCountScript.Hash(), keys, int64(1), int64(postCountEventTTL.Seconds()), int64(4)).SetVal(int64(1))
+
+	require.NoError(t, repo.AddPostCount(context.Background(), 1, 42, 1))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestPostCountRepository_AddPostCount_DeleteBeforeCreate(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	// The only post of the user is deleted before any of its events was counted
+	repo := NewPostCountRepository(db, &fakePostCountQuerier{count: 0})
+
+	keys := []string{fmt.Sprintf(userPostCountKeyPattern, 1), fmt.Sprintf(userPostCountEventKeyPattern, 1, 43)}
+	rdbMock.CustomMatch(evalShaKeys(2)).ExpectEvalSha(addPostCountScript.Hash(), keys, int64(-1), int64(postCountEventTTL.Seconds())).SetVal(int64(0))
+	// The counter is seeded with the count of DB, the deletion included, rather than going negative
+	rdbMock.ExpectEvalSha(seedPostCountScript.Hash(), keys, int64(-1), int64(postCountEventTTL.Seconds()), int64(0)).SetVal(int64(1))
+
+	require.NoError(t, repo.AddPostCount(context.Background(), 1, 43, -1))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestPostCountRepository_GetPostCount(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	repo := NewPostCountRepository(db, &fakePostCountQuerier{})
+
+	rdbMock.ExpectGet(fmt.Sprintf(userPostCountKeyPattern, 1)).SetVal("3")
+	rdbMock.ExpectGet(fmt.Sprintf(userPostCountKeyPattern, 2)).RedisNil()
+
+	count, err := repo.GetPostCount(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Nothing counted yet
+	count, err = repo.GetPostCount(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
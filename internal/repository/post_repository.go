@@ -8,8 +8,19 @@ import (
 
 type PostRepository interface {
 	CreatePost(ctx context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error)
+	// UpdatePost changes the content of a post of arg.UserID, pgx.ErrNoRows when the user has no such post
+	UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error)
+	// DeletePost deletes a post of arg.UserID and returns it, pgx.ErrNoRows when the user has no such post
+	DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error)
 	GetPost(ctx context.Context, id int64) (sqlc.Post, error)
 	ListPostsByUser(ctx context.Context, arg sqlc.ListPostsByUserParams) ([]sqlc.Post, error)
+	// GetPostsByIDs returns the posts found for ids in the order of ids, unknown ids are skipped
+	GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error)
+	// ListRecentPostsByUsers returns the newest posts across users, ordered by (created_at second, id) descending
+	ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error)
+	// GetTimelineVersion returns a value that changes whenever the user's timeline changes.
+	// Zero means the version is not tracked.
+	GetTimelineVersion(ctx context.Context, userID int64) (int64, error)
 }
 
 type DBPostRepository struct {
@@ -24,6 +35,14 @@ func (r *DBPostRepository) CreatePost(ctx context.Context, arg sqlc.CreatePostPa
 	return r.q.CreatePost(ctx, arg)
 }
 
+func (r *DBPostRepository) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	return r.q.UpdatePost(ctx, arg)
+}
+
+func (r *DBPostRepository) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	return r.q.DeletePost(ctx, arg)
+}
+
 func (r *DBPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
 	return r.q.GetPost(ctx, id)
 }
@@ -31,3 +50,36 @@ func (r *DBPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post, er
 func (r *DBPostRepository) ListPostsByUser(ctx context.Context, arg sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
 	return r.q.ListPostsByUser(ctx, arg)
 }
+
+func (r *DBPostRepository) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	posts, err := r.q.GetPostsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return orderPostsByIDs(posts, ids), nil
+}
+
+func (r *DBPostRepository) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	return r.q.ListRecentPostsByUsers(ctx, arg)
+}
+
+// GetTimelineVersion is not tracked by DB, the cache layer keeps timeline versions
+func (r *DBPostRepository) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	return 0, nil
+}
+
+// orderPostsByIDs arranges posts in the order of ids, skipping ids without a post
+func orderPostsByIDs(posts []sqlc.Post, ids []int64) []sqlc.Post {
+	byID := make(map[int64]sqlc.Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	ordered := make([]sqlc.Post, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
+}
@@ -3,71 +3,136 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
 	"github.com/n1207n/cache-query-aggregator/internal/metrics"
-	crc16_redis "github.com/sigurn/crc16"
 )
 
 const (
-	userPostsKeyPattern   = "{user:%d}:posts"
-	postKeyGenericPattern = "post:%d"
-	cacheTTL              = 1 * time.Hour
+	userPostsKeyPattern        = "{user:%d}:posts"
+	userPostsVersionKeyPattern = "{user:%d}:posts:version"
+	postKeyGenericPattern      = "post:%d"
+	cacheTTL                   = 1 * time.Hour
+	// timelineVersionTTL outlives cacheTTL so that versions stay stable across list re-caching
+	timelineVersionTTL = 24 * time.Hour
 )
 
-var crc16Table = crc16_redis.MakeTable(crc16_redis.CRC16_XMODEM)
-
 // CachedPostRepository is a cache decorator for PostRepository
 type CachedPostRepository struct {
-	nextRepo      PostRepository
-	rdb           redis.Cmdable
-	clusterClient *redis.ClusterClient
-	slotMap       map[uint16]string // slot -> node address
-	slotMapMux    sync.RWMutex
+	nextRepo PostRepository
+	rdb      redis.Cmdable
+	agg      *aggregator.Aggregator
+	syncer   PostCacheSyncer
+	opts     CachedPostOptions
+}
+
+// CachedPostOptions tunes how CachedPostRepository degrades when Redis or DB is unavailable
+type CachedPostOptions struct {
+	// Degraded bounds the DB reads made in place of cache nodes with an open circuit
+	Degraded *DegradedLimiter
+	// DBHealth, if set, stops DB reads while DB keeps failing
+	DBHealth *breaker.Breaker
+	// StaleTTL is how long last known good copies of Posts and timelines are kept to be served while
+	// DB is unavailable, zero disables them
+	StaleTTL time.Duration
+	// MissedWrites, if set, remembers the writes whose cache effects are skipped while the node of their
+	// user has an open circuit
+	MissedWrites *MissedWrites
 }
 
-// NewCachedPostRepository creates a new instance of CachedPostRepository
-func NewCachedPostRepository(next PostRepository, rdb redis.Cmdable) PostRepository {
-	repo := &CachedPostRepository{
+// NewCachedPostRepository creates a new instance of CachedPostRepository.
+// Multi-key reads are spread over the cluster nodes by agg. Cache nodes with an open circuit are
+// skipped, and the DB reads made in their place are bounded by opts.Degraded.
+func NewCachedPostRepository(next PostRepository, rdb redis.Cmdable, agg *aggregator.Aggregator, opts CachedPostOptions) PostRepository {
+	return &CachedPostRepository{
 		nextRepo: next,
 		rdb:      rdb,
+		agg:      agg,
+		syncer:   NewPostCacheSyncer(rdb),
+		opts:     opts,
 	}
+}
 
-	if clusterClient, ok := rdb.(*redis.ClusterClient); ok {
-		repo.clusterClient = clusterClient
-		if err := repo.refreshSlotCache(context.Background()); err != nil {
-			log.Printf("failed to initialize redis cluster slot cache: %v", err)
-		} else {
-			log.Println("Redis cluster slot cache initialized successfully.")
-		}
+// CreatePost creates a Post table record and pushes it into cache, once its transaction if any is committed
+func (r *CachedPostRepository) CreatePost(ctx context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error) {
+	post, err := r.nextRepo.CreatePost(ctx, arg)
+	if err != nil {
+		return sqlc.Post{}, err
 	}
 
-	return repo
+	// Posts and timelines of a user share the node of the user's hash tag
+	if r.agg.NodeOpen(fmt.Sprintf(userPostsKeyPattern, post.UserID)) {
+		r.opts.MissedWrites.Record(post)
+		return post, nil
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		if err := r.cachePost(ctx, &post); err != nil {
+			log.Printf("failed to cache created post %d: %v", post.ID, err)
+		}
+
+		if err := r.bumpTimelineVersion(ctx, post.UserID); err != nil {
+			log.Printf("failed to bump timeline version for user %d: %v", post.UserID, err)
+		}
+	})
+
+	return post, nil
 }
 
-// CreatePost creates a Post table record and pushes it into cache
-func (r *CachedPostRepository) CreatePost(ctx context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error) {
-	post, err := r.nextRepo.CreatePost(ctx, arg)
+// UpdatePost updates a Post table record and writes it over its cached copy, once its transaction if
+// any is committed
+func (r *CachedPostRepository) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	post, err := r.nextRepo.UpdatePost(ctx, arg)
 	if err != nil {
 		return sqlc.Post{}, err
 	}
+	if r.agg.NodeOpen(fmt.Sprintf(userPostsKeyPattern, post.UserID)) {
+		r.opts.MissedWrites.Record(post)
+		return post, nil
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		if err := r.syncer.RefreshPosts(ctx, []sqlc.Post{post}); err != nil {
+			log.Printf("failed to refresh updated post %d in cache: %v", post.ID, err)
+		}
+	})
+	return post, nil
+}
 
-	if err := r.cachePost(ctx, &post); err != nil {
-		log.Printf("failed to cache created post %d: %v", post.ID, err)
+// DeletePost deletes a Post table record and drops it from cache, once its transaction if any is committed
+func (r *CachedPostRepository) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	post, err := r.nextRepo.DeletePost(ctx, arg)
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	if r.agg.NodeOpen(fmt.Sprintf(userPostsKeyPattern, post.UserID)) {
+		r.opts.MissedWrites.Record(post)
+		return post, nil
 	}
 
+	AfterCommit(ctx, func(ctx context.Context) {
+		if err := r.syncer.RemovePosts(ctx, []PostRef{{ID: post.ID, UserID: post.UserID}}); err != nil {
+			log.Printf("failed to remove deleted post %d from cache: %v", post.ID, err)
+		}
+	})
 	return post, nil
 }
 
 // GetPost reads Post from cache first then DB
 func (r *CachedPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
 	postKey := fmt.Sprintf(postKeyGenericPattern, id)
+	if r.agg.NodeOpen(postKey) {
+		return r.getPostFromDB(ctx, id, true)
+	}
+
 	val, err := r.rdb.Get(ctx, postKey).Result()
 
 	if err == nil {
@@ -88,14 +153,34 @@ func (r *CachedPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post
 	// Cache miss
 	log.Printf("cache miss for post %d, fetching from db", id)
 	metrics.PostCacheMisses.Inc()
-	metrics.PostDBQueries.Inc()
-	post, err := r.nextRepo.GetPost(ctx, id)
+	return r.getPostFromDB(ctx, id, false)
+}
+
+// getPostFromDB reads a Post from DB, or its stale copy when DB is unavailable.
+// nodeOpen tells that the Post's cache node has an open circuit.
+func (r *CachedPostRepository) getPostFromDB(ctx context.Context, id int64, nodeOpen bool) (sqlc.Post, error) {
+	var post sqlc.Post
+	err := r.queryDB(ctx, nodeOpen, func(ctx context.Context) (err error) {
+		post, err = r.nextRepo.GetPost(ctx, id)
+		return err
+	})
+	if errors.Is(err, ErrDBUnavailable) {
+		if stale, ok := r.getStalePost(ctx, id); ok {
+			log.Printf("db unavailable, serving stale post %d", id)
+			RecordStale(ctx)
+			return stale, nil
+		}
+	}
 	if err != nil {
 		return sqlc.Post{}, err
 	}
 
-	if err := r.cachePost(ctx, &post); err != nil {
-		log.Printf("failed to cache post %d after db fetch: %v", post.ID, err)
+	if !nodeOpen {
+		AfterCommit(ctx, func(ctx context.Context) {
+			if err := r.cachePost(ctx, &post); err != nil {
+				log.Printf("failed to cache post %d after db fetch: %v", post.ID, err)
+			}
+		})
 	}
 
 	return post, nil
@@ -104,18 +189,40 @@ func (r *CachedPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post
 // ListPostsByUser queries a list of Posts from cache first then DB
 func (r *CachedPostRepository) ListPostsByUser(ctx context.Context, arg sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
 	userPostsKey := fmt.Sprintf(userPostsKeyPattern, arg.UserID)
+	if r.agg.NodeOpen(userPostsKey) {
+		return r.listPostsFromDB(ctx, arg, true)
+	}
+
 	start, stop := int64(arg.Offset), int64(arg.Offset+arg.Limit-1)
 	postIDStrs, err := r.rdb.ZRevRange(ctx, userPostsKey, start, stop).Result()
+	// The sorted set only holds the newest posts, a later page cut short may continue in DB
+	if arg.Offset > 0 && int64(len(postIDStrs)) < stop-start+1 {
+		postIDStrs = nil
+	}
 
 	if err == nil && len(postIDStrs) > 0 {
-		posts, missedIDs := r.getPostsFromCache(ctx, arg.UserID, postIDStrs)
-		if len(missedIDs) == 0 {
+		ids := make([]int64, 0, len(postIDStrs))
+		for _, idStr := range postIDStrs {
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			if nodeAddr, ok := r.agg.NodeForKey(fmt.Sprintf(postKeyGenericPattern, id)); ok {
+				metrics.RedisNodeReadsByUser.WithLabelValues(nodeAddr, strconv.FormatInt(arg.UserID, 10)).Inc()
+			}
+			ids = append(ids, id)
+		}
+
+		read := r.getPostsFromCache(ctx, ids)
+		if len(read.missedIDs) == 0 && len(read.unreachableIDs) == 0 {
 			log.Printf("full cache hit for user %d posts list (offset: %d, limit: %d)", arg.UserID, arg.Offset, arg.Limit)
 			metrics.PostCacheHits.Inc()
-			return posts, nil
+			return read.posts, nil
+		}
+		if len(read.missedIDs) == 0 {
+			// Every cached post was found but some nodes did not answer in time
+			log.Printf("cache nodes unavailable for %d posts of user %d", len(read.unreachableIDs), arg.UserID)
+			return r.resolveUnreachable(ctx, read, ids)
 		}
 		// Partial cache hit, a.k.a shard join
-		log.Printf("partial cache hit for user %d. Missed %d posts. Fetching full list from DB.", arg.UserID, len(missedIDs))
+		log.Printf("partial cache hit for user %d. Missed %d posts. Fetching full list from DB.", arg.UserID, len(read.missedIDs))
 		metrics.PostCacheShardJoins.Inc()
 	}
 
@@ -129,108 +236,373 @@ func (r *CachedPostRepository) ListPostsByUser(ctx context.Context, arg sqlc.Lis
 		metrics.PostCacheMisses.Inc()
 	}
 
-	metrics.PostDBQueries.Inc()
-	posts, err := r.nextRepo.ListPostsByUser(ctx, arg)
+	return r.listPostsFromDB(ctx, arg, false)
+}
+
+// listPostsFromDB reads a page of the user's Posts from DB, or its stale copy when DB is unavailable.
+// nodeOpen tells that the user's cache node has an open circuit.
+func (r *CachedPostRepository) listPostsFromDB(ctx context.Context, arg sqlc.ListPostsByUserParams, nodeOpen bool) ([]sqlc.Post, error) {
+	var posts []sqlc.Post
+	err := r.queryDB(ctx, nodeOpen, func(ctx context.Context) (err error) {
+		posts, err = r.nextRepo.ListPostsByUser(ctx, arg)
+		return err
+	})
+	if errors.Is(err, ErrDBUnavailable) {
+		if stale := r.getStalePostList(ctx, arg); len(stale) > 0 {
+			log.Printf("db unavailable, serving %d stale posts of user %d", len(stale), arg.UserID)
+			RecordStale(ctx)
+			return stale, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if len(posts) > 0 {
-		if err := r.cachePostList(ctx, arg.UserID, posts); err != nil {
-			log.Printf("failed to cache post list for user %d: %v", arg.UserID, err)
-		}
+	if !nodeOpen && len(posts) > 0 {
+		AfterCommit(ctx, func(ctx context.Context) {
+			if err := r.cachePostList(ctx, arg.UserID, posts, arg.Offset == 0); err != nil {
+				log.Printf("failed to cache post list for user %d: %v", arg.UserID, err)
+			}
+		})
 	}
 
 	return posts, nil
 }
 
-func (r *CachedPostRepository) getPostsFromCache(ctx context.Context, userID int64, postIDStrs []string) ([]sqlc.Post, []int64) {
-	if len(postIDStrs) == 0 {
+// GetPostsByIDs reads Posts from cache with one pipeline per cluster node, missed Posts come from DB
+func (r *CachedPostRepository) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	if len(ids) == 0 {
 		return []sqlc.Post{}, nil
 	}
 
-	type result struct {
-		post sqlc.Post
-		err  error
-		id   int64
+	read := r.getPostsFromCache(ctx, ids)
+	if len(read.missedIDs) == 0 && len(read.unreachableIDs) == 0 {
+		metrics.PostCacheHits.Inc()
+		return orderPostsByIDs(read.posts, ids), nil
+	}
+	if len(read.missedIDs) == 0 {
+		return r.resolveUnreachable(ctx, read, ids)
 	}
 
-	var wg sync.WaitGroup
-	ch := make(chan result, len(postIDStrs))
+	fetchIDs := read.missedIDs
+	if aggregator.ConsistencyFrom(ctx) == aggregator.Partial {
+		aggregator.RecordPartial(ctx, read.failed)
+	} else {
+		fetchIDs = append(fetchIDs, read.unreachableIDs...)
+	}
 
-	for _, idStr := range postIDStrs {
-		wg.Add(1)
-		id, _ := strconv.ParseInt(idStr, 10, 64)
+	metrics.PostCacheMisses.Inc()
+	dbPosts, err := r.getPostsFromDB(ctx, read, fetchIDs)
+	if err != nil {
+		return nil, err
+	}
 
-		go func(postID int64) {
-			defer wg.Done()
-			postKey := fmt.Sprintf(postKeyGenericPattern, postID)
-
-			if r.clusterClient != nil {
-				r.slotMapMux.RLock()
-				// CRC16 Checksum as per Redis spec for cluster key hashing.
-				slot := crc16_redis.Checksum([]byte(postKey), crc16Table) & 0x3FFF
-				if nodeAddr, ok := r.slotMap[slot]; ok {
-					metrics.RedisNodeReadsByUser.WithLabelValues(nodeAddr, strconv.FormatInt(userID, 10)).Inc()
-				}
-				r.slotMapMux.RUnlock()
-			}
+	// Posts of unavailable nodes are not written back, their node would not take them
+	missed := make(map[int64]struct{}, len(read.missedIDs))
+	for _, id := range read.missedIDs {
+		missed[id] = struct{}{}
+	}
+	toCache := make([]sqlc.Post, 0, len(read.missedIDs))
+	for _, p := range dbPosts {
+		if _, ok := missed[p.ID]; ok {
+			toCache = append(toCache, p)
+		}
+	}
+	AfterCommit(ctx, func(ctx context.Context) {
+		if err := r.cachePostBodies(ctx, toCache); err != nil {
+			log.Printf("failed to cache %d posts after db fetch: %v", len(toCache), err)
+		}
+	})
 
-			val, err := r.rdb.Get(ctx, postKey).Result()
-			if err != nil {
-				ch <- result{id: postID, err: err}
-				return
-			}
+	return orderPostsByIDs(append(read.posts, dbPosts...), ids), nil
+}
 
-			var post sqlc.Post
-			if err = json.Unmarshal([]byte(val), &post); err != nil {
-				ch <- result{id: postID, err: err}
-				return
-			}
-			ch <- result{id: postID, post: post, err: nil}
-		}(id)
+// ListRecentPostsByUsers always reads from DB, cached timelines cannot tell whether they hold every recent post
+func (r *CachedPostRepository) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	var posts []sqlc.Post
+	err := r.queryDB(ctx, false, func(ctx context.Context) (err error) {
+		posts, err = r.nextRepo.ListRecentPostsByUsers(ctx, arg)
+		return err
+	})
+	return posts, err
+}
+
+// GetTimelineVersion reads the user's timeline version counter.
+// A missing counter is seeded with the current time so that a recreated counter never repeats
+// a version handed out before it expired.
+func (r *CachedPostRepository) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	versionKey := fmt.Sprintf(userPostsVersionKeyPattern, userID)
+	if r.agg.NodeOpen(versionKey) {
+		// Untracked until the node is back
+		return 0, nil
 	}
 
-	wg.Wait()
-	close(ch)
+	version, err := r.rdb.Get(ctx, versionKey).Int64()
+	if err == nil {
+		return version, nil
+	}
+	if err != redis.Nil {
+		return 0, fmt.Errorf("failed to get timeline version for user %d: %w", userID, err)
+	}
 
-	posts := make([]sqlc.Post, 0, len(postIDStrs))
-	missedIDs := make([]int64, 0)
-	for res := range ch {
-		if res.err != nil {
-			missedIDs = append(missedIDs, res.id)
-			if res.err != redis.Nil {
-				log.Printf("failed to get or unmarshal post %d from cache: %v", res.id, res.err)
-			}
+	seed := time.Now().UnixNano()
+	ok, err := r.rdb.SetNX(ctx, versionKey, seed, timelineVersionTTL).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to seed timeline version for user %d: %w", userID, err)
+	}
+	if ok {
+		return seed, nil
+	}
+
+	// Someone else seeded it first
+	return r.rdb.Get(ctx, versionKey).Int64()
+}
+
+// bumpTimelineVersion changes the user's timeline version after a write
+func (r *CachedPostRepository) bumpTimelineVersion(ctx context.Context, userID int64) error {
+	versionKey := fmt.Sprintf(userPostsVersionKeyPattern, userID)
+
+	pipe := r.rdb.Pipeline()
+	pipe.SetNX(ctx, versionKey, time.Now().UnixNano(), timelineVersionTTL)
+	pipe.Incr(ctx, versionKey)
+	pipe.Expire(ctx, versionKey, timelineVersionTTL)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for timeline version of user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+// postCacheRead is the outcome of reading Posts from cache
+type postCacheRead struct {
+	// posts holds the Posts found, in the order of the requested ids
+	posts []sqlc.Post
+	// missedIDs are not cached
+	missedIDs []int64
+	// unreachableIDs live on nodes that failed or missed the shard deadline
+	unreachableIDs []int64
+	failed         []aggregator.ShardError
+}
+
+// cachedPost is the cache lookup of one Post
+type cachedPost struct {
+	post  sqlc.Post
+	found bool
+}
+
+// getPostsFromCache reads Posts with one pipeline per cluster node. A node slower than usual is
+// hedged on its replica, or on DB when it has none.
+func (r *CachedPostRepository) getPostsFromCache(ctx context.Context, ids []int64) postCacheRead {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf(postKeyGenericPattern, id)
+	}
+	queue := func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.Get(ctx, key)
+	}
+
+	res := aggregator.GatherHedged(ctx, r.agg, keys, func(ctx context.Context, shard aggregator.Shard) ([]cachedPost, error) {
+		cmds, err := aggregator.ExecPipeline(ctx, r.rdb, shard.Keys, queue)
+		return decodeCachedPosts(shard.Keys, cmds), err
+	}, func(ctx context.Context, shard aggregator.Shard) ([]cachedPost, error) {
+		if replica, ok := r.agg.Replica(shard.Node); ok {
+			cmds, err := aggregator.ExecPipeline(ctx, replica, shard.Keys, queue)
+			return decodeCachedPosts(shard.Keys, cmds), err
+		}
+
+		shardIDs := make([]int64, len(shard.Indexes))
+		for i, idx := range shard.Indexes {
+			shardIDs[i] = ids[idx]
+		}
+		var dbPosts []sqlc.Post
+		err := r.queryDB(ctx, false, func(ctx context.Context) (err error) {
+			dbPosts, err = r.nextRepo.GetPostsByIDs(ctx, shardIDs)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[int64]sqlc.Post, len(dbPosts))
+		for _, p := range dbPosts {
+			byID[p.ID] = p
+		}
+		lookups := make([]cachedPost, len(shardIDs))
+		for i, id := range shardIDs {
+			lookups[i].post, lookups[i].found = byID[id]
+		}
+		return lookups, nil
+	})
+
+	unreachable := make(map[string]struct{})
+	for _, f := range res.Failed {
+		for _, key := range f.Keys {
+			unreachable[key] = struct{}{}
+		}
+	}
+
+	read := postCacheRead{
+		posts:     make([]sqlc.Post, 0, len(ids)),
+		missedIDs: make([]int64, 0),
+		failed:    res.Failed,
+	}
+	for i, lookup := range res.Values {
+		if _, ok := unreachable[keys[i]]; ok {
+			read.unreachableIDs = append(read.unreachableIDs, ids[i])
+			continue
+		}
+		if !lookup.found {
+			read.missedIDs = append(read.missedIDs, ids[i])
 			continue
 		}
-		posts = append(posts, res.post)
+		read.posts = append(read.posts, lookup.post)
 	}
 
-	return posts, missedIDs
+	return read
+}
+
+// decodeCachedPosts decodes the Posts read by the GET commands of keys
+func decodeCachedPosts(keys []string, cmds []redis.Cmder) []cachedPost {
+	lookups := make([]cachedPost, len(cmds))
+	for i, cmd := range cmds {
+		if cmd == nil {
+			// Its shard never ran
+			continue
+		}
+		val, err := cmd.(*redis.StringCmd).Result()
+		if err != nil {
+			if err != redis.Nil {
+				log.Printf("failed to get %s from cache: %v", keys[i], err)
+			}
+			continue
+		}
+		if err := json.Unmarshal([]byte(val), &lookups[i].post); err != nil {
+			log.Printf("failed to unmarshal cached %s: %v", keys[i], err)
+			continue
+		}
+		lookups[i].found = true
+	}
+	return lookups
 }
 
-func (r *CachedPostRepository) refreshSlotCache(ctx context.Context) error {
-	r.slotMapMux.Lock()
-	defer r.slotMapMux.Unlock()
+// resolveUnreachable completes a cache read whose only missing Posts live on unavailable nodes.
+// Depending on the request's consistency they are read from DB or left out and reported.
+func (r *CachedPostRepository) resolveUnreachable(ctx context.Context, read postCacheRead, ids []int64) ([]sqlc.Post, error) {
+	if aggregator.ConsistencyFrom(ctx) == aggregator.Partial {
+		aggregator.RecordPartial(ctx, read.failed)
+		return read.posts, nil
+	}
 
-	slots, err := r.clusterClient.ClusterSlots(ctx).Result()
+	dbPosts, err := r.getPostsFromDB(ctx, read, read.unreachableIDs)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster slots: %w", err)
+		return nil, err
 	}
+	return orderPostsByIDs(append(read.posts, dbPosts...), ids), nil
+}
 
-	newSlotMap := make(map[uint16]string)
-	for _, slotRange := range slots {
-		for i := slotRange.Start; i <= slotRange.End; i++ {
-			// Map to the master node address
-			if len(slotRange.Nodes) > 0 {
-				newSlotMap[uint16(i)] = slotRange.Nodes[0].Addr
-			}
+// getPostsFromDB reads the Posts a cache read could not serve. When some of them live on a node with
+// an open circuit the DB read counts against the degraded limit. When DB is unavailable their stale
+// copies are returned instead.
+func (r *CachedPostRepository) getPostsFromDB(ctx context.Context, read postCacheRead, ids []int64) ([]sqlc.Post, error) {
+	limited := false
+	for _, f := range read.failed {
+		if errors.Is(f.Err, breaker.ErrOpen) {
+			limited = true
+			break
 		}
 	}
 
-	r.slotMap = newSlotMap
-	return nil
+	var posts []sqlc.Post
+	err := r.queryDB(ctx, limited, func(ctx context.Context) (err error) {
+		posts, err = r.nextRepo.GetPostsByIDs(ctx, ids)
+		return err
+	})
+	if errors.Is(err, ErrDBUnavailable) {
+		if stale := r.getStalePosts(ctx, ids); len(stale) > 0 {
+			log.Printf("db unavailable, serving %d of %d posts from stale copies", len(stale), len(ids))
+			RecordStale(ctx)
+			return stale, nil
+		}
+	}
+	return posts, err
+}
+
+// queryDB runs a DB read of the repository, within the degraded limit when limited
+func (r *CachedPostRepository) queryDB(ctx context.Context, limited bool, query func(ctx context.Context) error) error {
+	run := func(ctx context.Context) error {
+		return readDB(ctx, r.opts.DBHealth, func(ctx context.Context) error {
+			metrics.PostDBQueries.Inc()
+			return query(ctx)
+		})
+	}
+	if limited {
+		return r.opts.Degraded.do(ctx, run)
+	}
+	return run(ctx)
+}
+
+// getStalePost reads the last known good copy of a Post
+func (r *CachedPostRepository) getStalePost(ctx context.Context, id int64) (sqlc.Post, bool) {
+	if r.opts.StaleTTL <= 0 {
+		return sqlc.Post{}, false
+	}
+
+	staleKey := fmt.Sprintf(stalePostKeyPattern, id)
+	lookups := decodeCachedPosts([]string{staleKey}, []redis.Cmder{r.rdb.Get(ctx, staleKey)})
+	if !lookups[0].found {
+		return sqlc.Post{}, false
+	}
+	metrics.PostStaleServed.Inc()
+	return lookups[0].post, true
+}
+
+// getStalePosts reads the last known good copies of Posts with one pipeline per cluster node.
+// Posts without a copy are skipped.
+func (r *CachedPostRepository) getStalePosts(ctx context.Context, ids []int64) []sqlc.Post {
+	if r.opts.StaleTTL <= 0 || len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf(stalePostKeyPattern, id)
+	}
+	res := r.agg.Pipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.Get(ctx, key)
+	})
+
+	posts := make([]sqlc.Post, 0, len(ids))
+	for _, lookup := range decodeCachedPosts(keys, res.Values) {
+		if lookup.found {
+			posts = append(posts, lookup.post)
+		}
+	}
+	metrics.PostStaleServed.Add(float64(len(posts)))
+	return posts
+}
+
+// getStalePostList reads the last known good copy of a page of the user's Posts
+func (r *CachedPostRepository) getStalePostList(ctx context.Context, arg sqlc.ListPostsByUserParams) []sqlc.Post {
+	if r.opts.StaleTTL <= 0 {
+		return nil
+	}
+
+	staleListKey := fmt.Sprintf(staleUserPostsKeyPattern, arg.UserID)
+	start, stop := int64(arg.Offset), int64(arg.Offset+arg.Limit-1)
+	postIDStrs, err := r.rdb.ZRevRange(ctx, staleListKey, start, stop).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("redis error on getting stale post list for user %d: %v", arg.UserID, err)
+		}
+		return nil
+	}
+
+	ids := make([]int64, 0, len(postIDStrs))
+	for _, idStr := range postIDStrs {
+		id, _ := strconv.ParseInt(idStr, 10, 64)
+		ids = append(ids, id)
+	}
+	return r.getStalePosts(ctx, ids)
 }
 
 // cachePost caches a single Post object and add it into user's post list as sorted set
@@ -254,6 +626,16 @@ func (r *CachedPostRepository) cachePost(ctx context.Context, post *sqlc.Post) e
 	})
 	pipe.Expire(ctx, userPostsKey, cacheTTL)
 
+	if r.opts.StaleTTL > 0 {
+		staleListKey := fmt.Sprintf(staleUserPostsKeyPattern, post.UserID)
+		pipe.Set(ctx, fmt.Sprintf(stalePostKeyPattern, post.ID), postJSON, r.opts.StaleTTL)
+		pipe.ZAdd(ctx, staleListKey, &redis.Z{
+			Score:  float64(post.CreatedAt.Unix()),
+			Member: post.ID,
+		})
+		pipe.Expire(ctx, staleListKey, r.opts.StaleTTL)
+	}
+
 	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("pipeline execution failed for caching post %d: %w", post.ID, err)
 	}
@@ -261,8 +643,9 @@ func (r *CachedPostRepository) cachePost(ctx context.Context, post *sqlc.Post) e
 	return nil
 }
 
-// cachePostList caches multiple Posts and their ids
-func (r *CachedPostRepository) cachePostList(ctx context.Context, userID int64, posts []sqlc.Post) error {
+// cachePostList caches multiple Posts and their ids. Only a first page goes into the user's timeline, which
+// the feed reads as the newest posts of the user without gaps; later pages only go into the stale copy.
+func (r *CachedPostRepository) cachePostList(ctx context.Context, userID int64, posts []sqlc.Post, newest bool) error {
 	if len(posts) == 0 {
 		return nil
 	}
@@ -281,13 +664,23 @@ func (r *CachedPostRepository) cachePostList(ctx context.Context, userID int64,
 		// Set generic key for direct access
 		postKeyGeneric := fmt.Sprintf(postKeyGenericPattern, p.ID)
 		pipe.Set(ctx, postKeyGeneric, postJSON, cacheTTL)
+		if r.opts.StaleTTL > 0 {
+			pipe.Set(ctx, fmt.Sprintf(stalePostKeyPattern, p.ID), postJSON, r.opts.StaleTTL)
+		}
 
 		redisZMembers[i] = &redis.Z{Score: float64(p.CreatedAt.Unix()), Member: p.ID}
 	}
 
 	if len(redisZMembers) > 0 {
-		pipe.ZAdd(ctx, userPostsKey, redisZMembers...)
-		pipe.Expire(ctx, userPostsKey, cacheTTL)
+		if newest {
+			pipe.ZAdd(ctx, userPostsKey, redisZMembers...)
+			pipe.Expire(ctx, userPostsKey, cacheTTL)
+		}
+		if r.opts.StaleTTL > 0 {
+			staleListKey := fmt.Sprintf(staleUserPostsKeyPattern, userID)
+			pipe.ZAdd(ctx, staleListKey, redisZMembers...)
+			pipe.Expire(ctx, staleListKey, r.opts.StaleTTL)
+		}
 	}
 
 	if _, err := pipe.Exec(ctx); err != nil {
@@ -296,3 +689,29 @@ func (r *CachedPostRepository) cachePostList(ctx context.Context, userID int64,
 
 	return nil
 }
+
+// cachePostBodies caches Posts for direct access without touching the users' post lists
+func (r *CachedPostRepository) cachePostBodies(ctx context.Context, posts []sqlc.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	pipe := r.rdb.Pipeline()
+	for _, p := range posts {
+		postJSON, err := json.Marshal(p)
+		if err != nil {
+			log.Printf("failed to marshal post %d for batch cache: %v", p.ID, err)
+			continue
+		}
+		pipe.Set(ctx, fmt.Sprintf(postKeyGenericPattern, p.ID), postJSON, cacheTTL)
+		if r.opts.StaleTTL > 0 {
+			pipe.Set(ctx, fmt.Sprintf(stalePostKeyPattern, p.ID), postJSON, r.opts.StaleTTL)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for caching %d posts: %w", len(posts), err)
+	}
+
+	return nil
+}
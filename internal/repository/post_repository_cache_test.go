@@ -3,14 +3,16 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"slices"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redismock/v8"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -26,6 +28,16 @@ func (m *mockPostRepository) CreatePost(ctx context.Context, arg sqlc.CreatePost
 	return args.Get(0).(sqlc.Post), args.Error(1)
 }
 
+func (m *mockPostRepository) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
+func (m *mockPostRepository) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
 func (m *mockPostRepository) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(sqlc.Post), args.Error(1)
@@ -36,10 +48,33 @@ func (m *mockPostRepository) ListPostsByUser(ctx context.Context, arg sqlc.ListP
 	return args.Get(0).([]sqlc.Post), args.Error(1)
 }
 
+func (m *mockPostRepository) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *mockPostRepository) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *mockPostRepository) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// anyArgsAfterKey matches a command whose key equals the expectation and ignores the remaining arguments
+func anyArgsAfterKey(expected, actual []interface{}) error {
+	if len(expected) < 2 || len(actual) < 2 || expected[1] != actual[1] {
+		return fmt.Errorf("expected key %v, got %v", expected, actual)
+	}
+	return nil
+}
+
 func TestGetPost_CacheHit(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	post := sqlc.Post{ID: 1, UserID: 1, Content: "test content"}
 	postJSON, _ := json.Marshal(post)
@@ -58,7 +93,7 @@ func TestGetPost_CacheHit(t *testing.T) {
 func TestGetPost_CacheMiss(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	post := sqlc.Post{ID: 1, UserID: 1, Content: "test content", CreatedAt: time.Now()}
 	postKeyGeneric := fmt.Sprintf(postKeyGenericPattern, post.ID)
@@ -83,7 +118,7 @@ func TestGetPost_CacheMiss(t *testing.T) {
 func TestListPostsByUser_FullCacheHit(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	params := sqlc.ListPostsByUserParams{UserID: 1, Limit: 10, Offset: 0}
 	userPostsKey := fmt.Sprintf(userPostsKeyPattern, params.UserID)
@@ -111,8 +146,7 @@ func TestListPostsByUser_FullCacheHit(t *testing.T) {
 	result, err := repo.ListPostsByUser(context.Background(), params)
 
 	require.NoError(t, err)
-
-	slices.Reverse(result)
+	// Posts keep the order of the user's timeline
 	assert.Equal(t, posts, result)
 	mockRepo.AssertNotCalled(t, "ListPostsByUser")
 	require.NoError(t, rdbMock.ExpectationsWereMet())
@@ -121,7 +155,7 @@ func TestListPostsByUser_FullCacheHit(t *testing.T) {
 func TestListPostsByUser_PartialCacheHit(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	params := sqlc.ListPostsByUserParams{UserID: 1, Limit: 2, Offset: 0}
 	userPostsKey := fmt.Sprintf(userPostsKeyPattern, params.UserID)
@@ -163,7 +197,7 @@ func TestListPostsByUser_PartialCacheHit(t *testing.T) {
 func TestListPostsByUser_CacheMiss(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	params := sqlc.ListPostsByUserParams{UserID: 1, Limit: 10, Offset: 0}
 	userPostsKey := fmt.Sprintf(userPostsKeyPattern, params.UserID)
@@ -188,7 +222,7 @@ func TestListPostsByUser_CacheMiss(t *testing.T) {
 func TestCreatePost(t *testing.T) {
 	db, rdbMock := redismock.NewClientMock()
 	mockRepo := new(mockPostRepository)
-	repo := NewCachedPostRepository(mockRepo, db)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
 
 	createParams := sqlc.CreatePostParams{UserID: 1, Content: "new post"}
 	createdPost := sqlc.Post{ID: 100, UserID: 1, Content: "new post", CreatedAt: time.Now()}
@@ -199,9 +233,14 @@ func TestCreatePost(t *testing.T) {
 	userPostsKey := fmt.Sprintf(userPostsKeyPattern, createdPost.UserID)
 	postJSON, _ := json.Marshal(createdPost)
 
+	versionKey := fmt.Sprintf(userPostsVersionKeyPattern, createdPost.UserID)
+
 	rdbMock.ExpectSet(postKeyGeneric, postJSON, cacheTTL).SetVal("OK")
 	rdbMock.ExpectZAdd(userPostsKey, &redis.Z{Score: float64(createdPost.CreatedAt.Unix()), Member: createdPost.ID}).SetVal(1)
 	rdbMock.ExpectExpire(userPostsKey, cacheTTL).SetVal(true)
+	rdbMock.CustomMatch(anyArgsAfterKey).ExpectSetNX(versionKey, nil, timelineVersionTTL).SetVal(false)
+	rdbMock.ExpectIncr(versionKey).SetVal(2)
+	rdbMock.ExpectExpire(versionKey, timelineVersionTTL).SetVal(true)
 
 	result, err := repo.CreatePost(context.Background(), createParams)
 	require.NoError(t, err)
@@ -209,3 +248,405 @@ func TestCreatePost(t *testing.T) {
 	mockRepo.AssertCalled(t, "CreatePost", mock.Anything, createParams)
 	require.NoError(t, rdbMock.ExpectationsWereMet())
 }
+
+func TestUpdatePost(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockPostRepository)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+	params := sqlc.UpdatePostParams{ID: 100, UserID: 1, Content: "edited"}
+	updatedPost := sqlc.Post{ID: 100, UserID: 1, Content: "edited", CreatedAt: time.Now()}
+	mockRepo.On("UpdatePost", mock.Anything, params).Return(updatedPost, nil)
+	postJSON, _ := json.Marshal(updatedPost)
+
+	// The cached copy is overwritten, an uncached post stays uncached
+	rdbMock.ExpectSetXX(fmt.Sprintf(postKeyGenericPattern, updatedPost.ID), postJSON, cacheTTL).SetVal(true)
+	rdbMock.ExpectSetXX(fmt.Sprintf(stalePostKeyPattern, updatedPost.ID), postJSON, redis.KeepTTL).SetVal(false)
+	rdbMock.CustomMatch(evalKeys(1)).ExpectEval("", []string{fmt.Sprintf(userPostsKeyPattern, updatedPost.UserID)}, float64(updatedPost.CreatedAt.Unix()), updatedPost.ID).SetVal(int64(1))
+	rdbMock.ExpectDel(fmt.Sprintf(userPostsVersionKeyPattern, updatedPost.UserID)).SetVal(1)
+
+	result, err := repo.UpdatePost(context.Background(), params)
+	require.NoError(t, err)
+	assert.Equal(t, updatedPost, result)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestDeletePost(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockPostRepository)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+	params := sqlc.DeletePostParams{ID: 100, UserID: 1}
+	mockRepo.On("DeletePost", mock.Anything, params).Return(sqlc.Post{ID: 100, UserID: 1}, nil)
+
+	rdbMock.ExpectDel(fmt.Sprintf(postKeyGenericPattern, params.ID)).SetVal(1)
+	rdbMock.ExpectDel(fmt.Sprintf(stalePostKeyPattern, params.ID)).SetVal(1)
+	rdbMock.ExpectZRem(fmt.Sprintf(userPostsKeyPattern, params.UserID), params.ID).SetVal(1)
+	rdbMock.ExpectZRem(fmt.Sprintf(staleUserPostsKeyPattern, params.UserID), params.ID).SetVal(1)
+	rdbMock.ExpectDel(fmt.Sprintf(userPostsVersionKeyPattern, params.UserID)).SetVal(1)
+
+	_, err := repo.DeletePost(context.Background(), params)
+	require.NoError(t, err)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestGetTimelineVersion(t *testing.T) {
+	t.Run("existing", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		repo := NewCachedPostRepository(new(mockPostRepository), db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+		versionKey := fmt.Sprintf(userPostsVersionKeyPattern, 1)
+
+		rdbMock.ExpectGet(versionKey).SetVal("42")
+
+		version, err := repo.GetTimelineVersion(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), version)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("seeded_when_missing", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		repo := NewCachedPostRepository(new(mockPostRepository), db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+		versionKey := fmt.Sprintf(userPostsVersionKeyPattern, 1)
+
+		rdbMock.ExpectGet(versionKey).RedisNil()
+		rdbMock.CustomMatch(anyArgsAfterKey).ExpectSetNX(versionKey, nil, timelineVersionTTL).SetVal(true)
+
+		version, err := repo.GetTimelineVersion(context.Background(), 1)
+		require.NoError(t, err)
+		assert.NotZero(t, version)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("seeded_concurrently", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		repo := NewCachedPostRepository(new(mockPostRepository), db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+		versionKey := fmt.Sprintf(userPostsVersionKeyPattern, 1)
+
+		rdbMock.ExpectGet(versionKey).RedisNil()
+		rdbMock.CustomMatch(anyArgsAfterKey).ExpectSetNX(versionKey, nil, timelineVersionTTL).SetVal(false)
+		rdbMock.ExpectGet(versionKey).SetVal("7")
+
+		version, err := repo.GetTimelineVersion(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), version)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+}
+
+func TestGetPostsByIDs(t *testing.T) {
+	t.Run("cache_hit", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+		first := sqlc.Post{ID: 1, UserID: 1, Content: "first"}
+		firstJSON, _ := json.Marshal(first)
+		second := sqlc.Post{ID: 2, UserID: 2, Content: "second"}
+		secondJSON, _ := json.Marshal(second)
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 2)).SetVal(string(secondJSON))
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetVal(string(firstJSON))
+
+		posts, err := repo.GetPostsByIDs(context.Background(), []int64{2, 1})
+		require.NoError(t, err)
+		assert.Equal(t, []sqlc.Post{second, first}, posts)
+		mockRepo.AssertNotCalled(t, "GetPostsByIDs", mock.Anything, mock.Anything)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("cache_miss", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+		found := sqlc.Post{ID: 2, UserID: 1, Content: "from db"}
+		foundJSON, _ := json.Marshal(found)
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 2)).RedisNil()
+		mockRepo.On("GetPostsByIDs", mock.Anything, []int64{2}).Return([]sqlc.Post{found}, nil)
+		rdbMock.ExpectSet(fmt.Sprintf(postKeyGenericPattern, 2), foundJSON, cacheTTL).SetVal("OK")
+
+		posts, err := repo.GetPostsByIDs(context.Background(), []int64{2})
+		require.NoError(t, err)
+		assert.Equal(t, []sqlc.Post{found}, posts)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("unavailable_node_complete", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+		found := sqlc.Post{ID: 2, UserID: 1, Content: "from db"}
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 2)).SetErr(errors.New("i/o timeout"))
+		mockRepo.On("GetPostsByIDs", mock.Anything, []int64{2}).Return([]sqlc.Post{found}, nil)
+
+		posts, err := repo.GetPostsByIDs(context.Background(), []int64{2})
+		require.NoError(t, err)
+		assert.Equal(t, []sqlc.Post{found}, posts)
+		// Nothing is written back to the unavailable node
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("unavailable_node_partial", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 2)).SetErr(errors.New("i/o timeout"))
+
+		ctx := aggregator.WithConsistency(context.Background(), aggregator.Partial)
+		ctx, report := aggregator.WithPartialReport(ctx)
+		posts, err := repo.GetPostsByIDs(ctx, []int64{2})
+
+		require.NoError(t, err)
+		assert.Empty(t, posts)
+		assert.True(t, report.Partial())
+		assert.Equal(t, []string{"standalone"}, report.MissingShards())
+		mockRepo.AssertNotCalled(t, "GetPostsByIDs", mock.Anything, mock.Anything)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+}
+
+func TestListPostsByUser_UnavailableNode(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockPostRepository)
+	repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{})
+
+	params := sqlc.ListPostsByUserParams{UserID: 1, Limit: 2, Offset: 0}
+	post := sqlc.Post{ID: 1, UserID: 1, Content: "post 1"}
+
+	rdbMock.ExpectZRevRange(fmt.Sprintf(userPostsKeyPattern, params.UserID), 0, 1).SetVal([]string{"1"})
+	rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetErr(context.DeadlineExceeded)
+
+	// Only the unreachable posts are read from DB, not the whole list
+	mockRepo.On("GetPostsByIDs", mock.Anything, []int64{1}).Return([]sqlc.Post{post}, nil)
+
+	posts, err := repo.ListPostsByUser(context.Background(), params)
+
+	require.NoError(t, err)
+	assert.Equal(t, []sqlc.Post{post}, posts)
+	mockRepo.AssertNotCalled(t, "ListPostsByUser", mock.Anything, mock.Anything)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedPostRepository_OpenCircuit(t *testing.T) {
+	// openAggregator returns an aggregator whose standalone node has an open circuit
+	openAggregator := func(db redis.Cmdable) *aggregator.Aggregator {
+		breakers := breaker.NewGroup(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute})
+		breakers.Breaker(aggregator.StandaloneNode).Failure()
+		return aggregator.New(db, aggregator.Options{Breakers: breakers})
+	}
+
+	t.Run("get_post_skips_cache", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, openAggregator(db), CachedPostOptions{Degraded: NewDegradedLimiter(1, time.Second)})
+
+		post := sqlc.Post{ID: 1, UserID: 1, Content: "from db"}
+		mockRepo.On("GetPost", mock.Anything, int64(1)).Return(post, nil)
+
+		got, err := repo.GetPost(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, post, got)
+		// Neither read from nor written back to the node
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("get_posts_by_ids_reads_db", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, openAggregator(db), CachedPostOptions{Degraded: NewDegradedLimiter(1, time.Second)})
+
+		posts := []sqlc.Post{{ID: 1, UserID: 1}, {ID: 2, UserID: 1}}
+		mockRepo.On("GetPostsByIDs", mock.Anything, []int64{1, 2}).Return(posts, nil)
+
+		got, err := repo.GetPostsByIDs(context.Background(), []int64{1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, posts, got)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("db_overloaded", func(t *testing.T) {
+		db, _ := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		limiter := NewDegradedLimiter(1, 10*time.Millisecond)
+		repo := NewCachedPostRepository(mockRepo, db, openAggregator(db), CachedPostOptions{Degraded: limiter})
+
+		// Another degraded read holds the only slot
+		limiter.sem <- struct{}{}
+		defer func() { <-limiter.sem }()
+
+		_, err := repo.ListPostsByUser(context.Background(), sqlc.ListPostsByUserParams{UserID: 1, Limit: 10})
+		assert.ErrorIs(t, err, ErrDBOverloaded)
+		mockRepo.AssertNotCalled(t, "ListPostsByUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("writes_replayed_once_closed", func(t *testing.T) {
+		ctx := context.Background()
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		breakers := breaker.NewGroup(breaker.Settings{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+		agg := aggregator.New(db, aggregator.Options{Breakers: breakers})
+		missed := NewMissedWrites(NewPostCacheSyncer(db), agg)
+		repo := NewCachedPostRepository(mockRepo, db, agg, CachedPostOptions{MissedWrites: missed})
+
+		circuit := breakers.Breaker(aggregator.StandaloneNode)
+		circuit.Failure()
+		params := sqlc.DeletePostParams{ID: 100, UserID: 1}
+		mockRepo.On("DeletePost", mock.Anything, params).Return(sqlc.Post{ID: 100, UserID: 1}, nil)
+
+		// The delete leaves the node alone, and nothing is replayed while it is open
+		_, err := repo.DeletePost(ctx, params)
+		require.NoError(t, err)
+		require.NoError(t, missed.Replay(ctx))
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+
+		time.Sleep(30 * time.Millisecond)
+		require.True(t, circuit.Allow())
+		circuit.Success()
+		require.Equal(t, breaker.StateClosed, circuit.State())
+
+		listKey := fmt.Sprintf(userPostsKeyPattern, 1)
+		versionKey := fmt.Sprintf(userPostsVersionKeyPattern, 1)
+		rdbMock.ExpectDel(listKey, versionKey, fmt.Sprintf(staleUserPostsKeyPattern, 1)).SetVal(3)
+		rdbMock.ExpectDel(fmt.Sprintf(postKeyGenericPattern, 100)).SetVal(1)
+		rdbMock.ExpectDel(fmt.Sprintf(stalePostKeyPattern, 100)).SetVal(1)
+		rdbMock.ExpectZRem(listKey, int64(100)).SetVal(0)
+		rdbMock.ExpectZRem(fmt.Sprintf(staleUserPostsKeyPattern, 1), int64(100)).SetVal(0)
+		rdbMock.ExpectDel(versionKey).SetVal(0)
+		require.NoError(t, missed.Replay(ctx))
+
+		// The version from before the outage is gone, a new one is seeded
+		rdbMock.ExpectGet(versionKey).RedisNil()
+		rdbMock.CustomMatch(anyArgsAfterKey).ExpectSetNX(versionKey, nil, timelineVersionTTL).SetVal(true)
+		version, err := repo.GetTimelineVersion(ctx, 1)
+		require.NoError(t, err)
+		assert.NotZero(t, version)
+
+		// Replayed writes are forgotten
+		require.NoError(t, missed.Replay(ctx))
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+}
+
+func TestCachedPostRepository_ServeStale(t *testing.T) {
+	staleTTL := 24 * time.Hour
+
+	t.Run("caches_stale_copies", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{StaleTTL: staleTTL})
+
+		post := sqlc.Post{ID: 1, UserID: 1, Content: "test content", CreatedAt: time.Now()}
+		postJSON, _ := json.Marshal(post)
+		member := &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: post.ID}
+		userPostsKey := fmt.Sprintf(userPostsKeyPattern, post.UserID)
+		staleListKey := fmt.Sprintf(staleUserPostsKeyPattern, post.UserID)
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, post.ID)).SetErr(redis.Nil)
+		mockRepo.On("GetPost", mock.Anything, post.ID).Return(post, nil)
+		rdbMock.ExpectSet(fmt.Sprintf(postKeyGenericPattern, post.ID), postJSON, cacheTTL).SetVal("OK")
+		rdbMock.ExpectZAdd(userPostsKey, member).SetVal(1)
+		rdbMock.ExpectExpire(userPostsKey, cacheTTL).SetVal(true)
+		rdbMock.ExpectSet(fmt.Sprintf(stalePostKeyPattern, post.ID), postJSON, staleTTL).SetVal("OK")
+		rdbMock.ExpectZAdd(staleListKey, member).SetVal(1)
+		rdbMock.ExpectExpire(staleListKey, staleTTL).SetVal(true)
+
+		_, err := repo.GetPost(context.Background(), post.ID)
+		require.NoError(t, err)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("get_post", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{StaleTTL: staleTTL})
+
+		post := sqlc.Post{ID: 1, UserID: 1, Content: "last known good"}
+		postJSON, _ := json.Marshal(post)
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, post.ID)).SetErr(redis.Nil)
+		mockRepo.On("GetPost", mock.Anything, post.ID).Return(sqlc.Post{}, context.DeadlineExceeded)
+		rdbMock.ExpectGet(fmt.Sprintf(stalePostKeyPattern, post.ID)).SetVal(string(postJSON))
+
+		ctx, report := WithStaleReport(context.Background())
+		result, err := repo.GetPost(ctx, post.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, post, result)
+		assert.True(t, report.Stale())
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("list_posts_by_user", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{StaleTTL: staleTTL})
+
+		params := sqlc.ListPostsByUserParams{UserID: 1, Limit: 2, Offset: 0}
+		posts := []sqlc.Post{{ID: 2, UserID: 1, Content: "post 2"}, {ID: 1, UserID: 1, Content: "post 1"}}
+
+		rdbMock.ExpectZRevRange(fmt.Sprintf(userPostsKeyPattern, params.UserID), 0, 1).SetErr(redis.Nil)
+		mockRepo.On("ListPostsByUser", mock.Anything, params).Return([]sqlc.Post(nil), context.DeadlineExceeded)
+		rdbMock.ExpectZRevRange(fmt.Sprintf(staleUserPostsKeyPattern, params.UserID), 0, 1).SetVal([]string{"2", "1"})
+		for _, p := range posts {
+			postJSON, _ := json.Marshal(p)
+			rdbMock.ExpectGet(fmt.Sprintf(stalePostKeyPattern, p.ID)).SetVal(string(postJSON))
+		}
+
+		ctx, report := WithStaleReport(context.Background())
+		result, err := repo.ListPostsByUser(ctx, params)
+
+		require.NoError(t, err)
+		assert.Equal(t, posts, result)
+		assert.True(t, report.Stale())
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("db_health_stops_queries", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		dbHealth := breaker.New(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute}, nil)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{DBHealth: dbHealth})
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetErr(redis.Nil)
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetErr(redis.Nil)
+		mockRepo.On("GetPost", mock.Anything, int64(1)).Return(sqlc.Post{}, context.DeadlineExceeded)
+
+		_, err := repo.GetPost(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrDBUnavailable)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// The open circuit answers without querying DB
+		_, err = repo.GetPost(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrDBUnavailable)
+		mockRepo.AssertNumberOfCalls(t, "GetPost", 1)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("caller_deadline_is_not_db_failure", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		mockRepo := new(mockPostRepository)
+		dbHealth := breaker.New(breaker.Settings{FailureThreshold: 1, OpenTimeout: time.Minute}, nil)
+		repo := NewCachedPostRepository(mockRepo, db, aggregator.New(db, aggregator.Options{}), CachedPostOptions{DBHealth: dbHealth, StaleTTL: staleTTL})
+
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetErr(redis.Nil)
+		rdbMock.ExpectGet(fmt.Sprintf(postKeyGenericPattern, 1)).SetErr(redis.Nil)
+		mockRepo.On("GetPost", mock.Anything, int64(1)).Return(sqlc.Post{}, context.DeadlineExceeded).Once()
+		mockRepo.On("GetPost", mock.Anything, int64(1)).Return(sqlc.Post{ID: 1, UserID: 1}, nil).Once()
+
+		// The request ran out of time, DB did not: no stale copy is served and the circuit stays closed
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err := repo.GetPost(ctx, 1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NotErrorIs(t, err, ErrDBUnavailable)
+
+		_, err = repo.GetPost(context.Background(), 1)
+		require.NoError(t, err)
+		mockRepo.AssertNumberOfCalls(t, "GetPost", 2)
+	})
+}
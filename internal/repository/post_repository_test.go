@@ -5,6 +5,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
@@ -97,3 +98,52 @@ func TestDBPostRepository_ListPostsByUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, paginatedPosts, 2)
 }
+
+func TestDBPostRepository_ListRecentPostsByUsers(t *testing.T) {
+	ctx := context.Background()
+	postRepo := NewDBPostRepository(testQueries)
+
+	var userIDs []int64
+	var created []sqlc.Post
+	for i := 0; i < 3; i++ {
+		user := createTestUser(t, ctx)
+		userIDs = append(userIDs, user.ID)
+		for j := 0; j < 2; j++ {
+			post, err := postRepo.CreatePost(ctx, sqlc.CreatePostParams{
+				UserID:  user.ID,
+				Content: fmt.Sprintf("Post %d of user %d", j, user.ID),
+			})
+			require.NoError(t, err)
+			created = append(created, post)
+		}
+	}
+
+	params := sqlc.ListRecentPostsByUsersParams{
+		UserIds:     userIDs[:2],
+		BeforeScore: math.MaxInt64,
+		BeforeID:    math.MaxInt64,
+		RowLimit:    3,
+	}
+	firstPage, err := postRepo.ListRecentPostsByUsers(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, firstPage, 3)
+
+	// The rest of the page continues after the last post of the first one
+	last := firstPage[len(firstPage)-1]
+	params.BeforeScore, params.BeforeID = last.CreatedAt.Unix(), last.ID
+	secondPage, err := postRepo.ListRecentPostsByUsers(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, secondPage, 1)
+
+	for _, p := range append(firstPage, secondPage...) {
+		assert.Contains(t, userIDs[:2], p.UserID)
+	}
+
+	// GetPostsByIDs keeps the order of ids and skips unknown ones
+	ids := []int64{created[1].ID, -1, created[0].ID}
+	posts, err := postRepo.GetPostsByIDs(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, created[1].ID, posts[0].ID)
+	assert.Equal(t, created[0].ID, posts[1].ID)
+}
@@ -0,0 +1,410 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+)
+
+const (
+	// The counter keys of a post share the slot of its cached copy, post:%d, through their hash tag
+	postReactionsKeyPattern         = "{post:%d}:reactions"
+	postReactionsPendingKeyPattern  = "{post:%d}:reactions:pending"
+	postReactionsFlushingKeyPattern = "{post:%d}:reactions:flushing"
+	postReactionsSeqKeyPattern      = "{post:%d}:reactions:flush_seq"
+	// dirtyReactionsKeyPattern scores the posts of a shard whose counters changed since their last flush,
+	// members being "<post id>:<author id>". Every shard has its own hash tag, so that reactions do not
+	// all land on one node.
+	dirtyReactionsKeyPattern = "{reactions:dirty:%d}"
+
+	// flushSeqField holds the sequence number of a flush in its hash
+	flushSeqField = "_seq"
+
+	// reactionCountsTTL is how long the counts of a post stay in Redis without a reaction, they are
+	// seeded from DB again afterwards
+	reactionCountsTTL = 7 * 24 * time.Hour
+	// reactionFlushSeqTTL outlives reactionCountsTTL, a dropped sequence is seeded with the current time
+	// so that it never goes back
+	reactionFlushSeqTTL = 30 * 24 * time.Hour
+)
+
+// addReactionsScript adds to the counts of a post and to its deltas waiting for the flush. Counts that
+// are not cached are left alone, as they are seeded from DB with the pending deltas.
+//
+// KEYS[1] counts, KEYS[2] pending deltas
+// ARGV[1] counts TTL in seconds, then reaction and delta pairs
+var addReactionsScript = redis.NewScript(`
+local cached = redis.call('EXISTS', KEYS[1]) == 1
+for i = 2, #ARGV, 2 do
+  if cached then
+    redis.call('HINCRBY', KEYS[1], ARGV[i], ARGV[i + 1])
+  end
+  redis.call('HINCRBY', KEYS[2], ARGV[i], ARGV[i + 1])
+end
+if cached then
+  redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// checkpointReactionsScript moves the pending deltas of a post to a flush numbered with the next
+// sequence number, unless a flush that did not complete is still there. It returns the flush.
+//
+// KEYS[1] pending deltas, KEYS[2] flush, KEYS[3] flush sequence
+// ARGV[1] sequence seed, ARGV[2] sequence TTL in seconds
+var checkpointReactionsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+  if redis.call('EXISTS', KEYS[1]) == 0 then
+    return {}
+  end
+  redis.call('SET', KEYS[3], ARGV[1], 'NX')
+  local seq = redis.call('INCR', KEYS[3])
+  redis.call('EXPIRE', KEYS[3], ARGV[2])
+  redis.call('RENAME', KEYS[1], KEYS[2])
+  redis.call('HSET', KEYS[2], '_seq', seq)
+end
+return redis.call('HGETALL', KEYS[2])
+`)
+
+// finishReactionsScript drops a flush applied to DB and reports whether deltas are pending again.
+//
+// KEYS[1] flush, KEYS[2] pending deltas
+var finishReactionsScript = redis.NewScript(`
+redis.call('DEL', KEYS[1])
+return redis.call('EXISTS', KEYS[2])
+`)
+
+// seedReactionsScript caches the counts of a post read from DB, adding the deltas DB does not have yet.
+//
+// KEYS[1] counts, KEYS[2] pending deltas, KEYS[3] flush
+// ARGV[1] counts TTL in seconds, ARGV[2] last flush applied to DB, then reaction and count pairs
+var seedReactionsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+for i = 3, #ARGV, 2 do
+  redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+local flush = redis.call('HGETALL', KEYS[3])
+local seq = 0
+for i = 1, #flush, 2 do
+  if flush[i] == '_seq' then
+    seq = tonumber(flush[i + 1])
+  end
+end
+if seq > tonumber(ARGV[2]) then
+  for i = 1, #flush, 2 do
+    if flush[i] ~= '_seq' then
+      redis.call('HINCRBY', KEYS[1], flush[i], flush[i + 1])
+    end
+  end
+end
+local pending = redis.call('HGETALL', KEYS[2])
+for i = 1, #pending, 2 do
+  redis.call('HINCRBY', KEYS[1], pending[i], pending[i + 1])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return 1
+`)
+
+// ReactionCounter keeps the reaction counts of posts in Redis and flushes them to DB in batches.
+//
+// Every change is added with HINCRBY to the cached counts of the post, which reads are served from, and
+// to its pending deltas. A flush renames the pending deltas of a post to a numbered flush, adds them to
+// the counts of DB and only then drops them. A flush interrupted by a crash is applied again with the
+// same number, which DB skips when it has it already. The posts to flush are marked in dirtyShards
+// shards, which flushes gather through the aggregator.
+type ReactionCounter struct {
+	rdb         redis.Cmdable
+	agg         *aggregator.Aggregator
+	q           sqlc.Querier
+	dirtyShards int
+	now         func() time.Time
+}
+
+// NewReactionCounter creates a new ReactionCounter flushing the counts to the DB of q, marking the posts
+// to flush in dirtyShards shards
+func NewReactionCounter(rdb redis.Cmdable, agg *aggregator.Aggregator, q sqlc.Querier, dirtyShards int) *ReactionCounter {
+	if dirtyShards < 1 {
+		dirtyShards = 1
+	}
+	return &ReactionCounter{rdb: rdb, agg: agg, q: q, dirtyShards: dirtyShards, now: time.Now}
+}
+
+// Add adds deltas, by reaction, to the counts of the post
+func (c *ReactionCounter) Add(ctx context.Context, post sqlc.Post, deltas map[string]int64) error {
+	reactions := make([]string, 0, len(deltas))
+	for reaction, delta := range deltas {
+		if delta != 0 {
+			reactions = append(reactions, reaction)
+		}
+	}
+	sort.Strings(reactions)
+	args := []interface{}{int64(reactionCountsTTL.Seconds())}
+	for _, reaction := range reactions {
+		args = append(args, reaction, deltas[reaction])
+	}
+	if len(reactions) == 0 {
+		return nil
+	}
+
+	keys := []string{
+		fmt.Sprintf(postReactionsKeyPattern, post.ID),
+		fmt.Sprintf(postReactionsPendingKeyPattern, post.ID),
+	}
+	if err := addReactionsScript.Run(ctx, c.rdb, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed to add to reaction counts of post %d: %w", post.ID, err)
+	}
+	// The mark changes the score of the post after its deltas are added, so that a flush that read the
+	// score before does not unmark it
+	if err := c.rdb.ZIncrBy(ctx, c.dirtyReactionsKey(post.ID), 1, dirtyReactionsMember(post)).Err(); err != nil {
+		return fmt.Errorf("failed to mark reaction counts of post %d for flush: %w", post.ID, err)
+	}
+	return nil
+}
+
+// Counts returns the count of every reaction to the post. Counts that are not cached are seeded by the
+// next flush, they are returned as zero until then.
+func (c *ReactionCounter) Counts(ctx context.Context, post sqlc.Post) (map[string]int64, error) {
+	fields, err := c.rdb.HGetAll(ctx, fmt.Sprintf(postReactionsKeyPattern, post.ID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reaction counts of post %d: %w", post.ID, err)
+	}
+
+	counts := make(map[string]int64, len(ReactionTypes))
+	for _, reaction := range ReactionTypes {
+		counts[reaction] = 0
+	}
+	if len(fields) == 0 {
+		metrics.ReactionCountMisses.Inc()
+		if err := c.rdb.ZIncrBy(ctx, c.dirtyReactionsKey(post.ID), 1, dirtyReactionsMember(post)).Err(); err != nil {
+			return nil, fmt.Errorf("failed to mark reaction counts of post %d for seeding: %w", post.ID, err)
+		}
+		return counts, nil
+	}
+	for reaction, value := range fields {
+		if count, err := strconv.ParseInt(value, 10, 64); err == nil {
+			counts[reaction] = count
+		}
+	}
+	return counts, nil
+}
+
+// dirtyPost is a post read from the dirty set, along with its flush
+type dirtyPost struct {
+	post   sqlc.Post
+	dirty  dirtyMember
+	seq    int64
+	deltas map[string]int64
+}
+
+// Flush writes the deltas of at most limit posts to DB, and caches the counts of the posts read while
+// they were not cached. It takes the posts from every dirty shard in turn, and returns the number of
+// posts handled.
+func (c *ReactionCounter) Flush(ctx context.Context, limit int) (int, error) {
+	keys := make([]string, c.dirtyShards)
+	for i := range keys {
+		keys[i] = fmt.Sprintf(dirtyReactionsKeyPattern, i)
+	}
+	members, err := readDirtyShards(ctx, c.agg, keys, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read posts with reactions to flush: %w", err)
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	posts := make([]*dirtyPost, 0, len(members))
+	for _, m := range members {
+		post, ok := parseDirtyReactionsMember(m.member)
+		if !ok {
+			log.Printf("dropping malformed member %q of %s", m.member, m.key)
+			c.rdb.ZRem(ctx, m.key, m.member)
+			continue
+		}
+		posts = append(posts, &dirtyPost{post: post, dirty: m})
+	}
+
+	if err := c.checkpoint(ctx, posts); err != nil {
+		return 0, err
+	}
+
+	arg := sqlc.AddPostReactionCountsParams{}
+	for _, p := range posts {
+		for reaction, delta := range p.deltas {
+			arg.PostIds = append(arg.PostIds, p.post.ID)
+			arg.UserIds = append(arg.UserIds, p.post.UserID)
+			arg.Reactions = append(arg.Reactions, reaction)
+			arg.Deltas = append(arg.Deltas, delta)
+			arg.FlushSeqs = append(arg.FlushSeqs, p.seq)
+		}
+	}
+	if len(arg.PostIds) > 0 {
+		if _, err := c.q.AddPostReactionCounts(ctx, arg); err != nil {
+			metrics.ReactionFlushes.WithLabelValues("failed").Inc()
+			return 0, fmt.Errorf("failed to flush reaction counts of %d posts: %w", len(posts), err)
+		}
+	}
+
+	settled, err := c.finish(ctx, posts)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.seed(ctx, posts); err != nil {
+		return 0, err
+	}
+
+	// Posts marked again since they were read keep their mark
+	pipe := c.rdb.Pipeline()
+	for _, p := range settled {
+		zremIfScoreScript.Eval(ctx, pipe, []string{p.dirty.key}, p.dirty.member, strconv.FormatFloat(p.dirty.score, 'f', -1, 64))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to unmark %d flushed posts: %w", len(settled), err)
+	}
+
+	metrics.ReactionFlushes.WithLabelValues("flushed").Add(float64(len(posts)))
+	return len(posts), nil
+}
+
+// checkpoint moves the pending deltas of the posts to their flush
+func (c *ReactionCounter) checkpoint(ctx context.Context, posts []*dirtyPost) error {
+	seed := c.now().UnixMicro()
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.Cmd, len(posts))
+	for i, p := range posts {
+		keys := []string{
+			fmt.Sprintf(postReactionsPendingKeyPattern, p.post.ID),
+			fmt.Sprintf(postReactionsFlushingKeyPattern, p.post.ID),
+			fmt.Sprintf(postReactionsSeqKeyPattern, p.post.ID),
+		}
+		cmds[i] = checkpointReactionsScript.Eval(ctx, pipe, keys, seed, int64(reactionFlushSeqTTL.Seconds()))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to checkpoint reaction counts of %d posts: %w", len(posts), err)
+	}
+
+	for i, p := range posts {
+		fields, err := cmds[i].StringSlice()
+		if err != nil {
+			return fmt.Errorf("failed to read reaction flush of post %d: %w", p.post.ID, err)
+		}
+		p.deltas = make(map[string]int64, len(fields)/2)
+		for f := 0; f+1 < len(fields); f += 2 {
+			value, err := strconv.ParseInt(fields[f+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed %s of reaction flush of post %d: %w", fields[f], p.post.ID, err)
+			}
+			if fields[f] == flushSeqField {
+				p.seq = value
+			} else if value != 0 {
+				p.deltas[fields[f]] = value
+			}
+		}
+	}
+	return nil
+}
+
+// finish drops the flushes applied to DB. It returns the posts without deltas pending since.
+func (c *ReactionCounter) finish(ctx context.Context, posts []*dirtyPost) ([]*dirtyPost, error) {
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.Cmd, len(posts))
+	for i, p := range posts {
+		keys := []string{
+			fmt.Sprintf(postReactionsFlushingKeyPattern, p.post.ID),
+			fmt.Sprintf(postReactionsPendingKeyPattern, p.post.ID),
+		}
+		cmds[i] = finishReactionsScript.Eval(ctx, pipe, keys)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to finish reaction flush of %d posts: %w", len(posts), err)
+	}
+
+	settled := make([]*dirtyPost, 0, len(posts))
+	for i, p := range posts {
+		if pending, err := cmds[i].Int64(); err == nil && pending == 0 {
+			settled = append(settled, p)
+		}
+	}
+	return settled, nil
+}
+
+// seed caches the counts of the posts that are not cached, from DB and the deltas it does not have yet
+func (c *ReactionCounter) seed(ctx context.Context, posts []*dirtyPost) error {
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(posts))
+	for i, p := range posts {
+		cmds[i] = pipe.Exists(ctx, fmt.Sprintf(postReactionsKeyPattern, p.post.ID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to check reaction counts of %d posts: %w", len(posts), err)
+	}
+
+	for i, p := range posts {
+		if cmds[i].Val() == 1 {
+			continue
+		}
+		rows, err := c.q.GetPostReactionCounts(ctx, sqlc.GetPostReactionCountsParams{PostID: p.post.ID, UserID: p.post.UserID})
+		if err != nil {
+			return fmt.Errorf("failed to get reaction counts of post %d: %w", p.post.ID, err)
+		}
+
+		counts := make(map[string]int64, len(ReactionTypes))
+		for _, reaction := range ReactionTypes {
+			counts[reaction] = 0
+		}
+		var seq int64
+		for _, row := range rows {
+			counts[row.Reaction] = row.Count
+			seq = max(seq, row.FlushSeq)
+		}
+		args := []interface{}{int64(reactionCountsTTL.Seconds()), seq}
+		for _, reaction := range ReactionTypes {
+			args = append(args, reaction, counts[reaction])
+		}
+		keys := []string{
+			fmt.Sprintf(postReactionsKeyPattern, p.post.ID),
+			fmt.Sprintf(postReactionsPendingKeyPattern, p.post.ID),
+			fmt.Sprintf(postReactionsFlushingKeyPattern, p.post.ID),
+		}
+		if err := seedReactionsScript.Run(ctx, c.rdb, keys, args...).Err(); err != nil {
+			return fmt.Errorf("failed to seed reaction counts of post %d: %w", p.post.ID, err)
+		}
+	}
+	return nil
+}
+
+// dirtyReactionsKey returns the dirty shard the post is marked in
+func (c *ReactionCounter) dirtyReactionsKey(postID int64) string {
+	return fmt.Sprintf(dirtyReactionsKeyPattern, shard.Of(postID, c.dirtyShards))
+}
+
+func dirtyReactionsMember(post sqlc.Post) string {
+	return strconv.FormatInt(post.ID, 10) + ":" + strconv.FormatInt(post.UserID, 10)
+}
+
+func parseDirtyReactionsMember(member string) (sqlc.Post, bool) {
+	id, author, ok := strings.Cut(member, ":")
+	if !ok {
+		return sqlc.Post{}, false
+	}
+	postID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return sqlc.Post{}, false
+	}
+	userID, err := strconv.ParseInt(author, 10, 64)
+	if err != nil {
+		return sqlc.Post{}, false
+	}
+	return sqlc.Post{ID: postID, UserID: userID}, true
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReactionQuerier records the flushed reaction counts and serves the counts of DB
+type fakeReactionQuerier struct {
+	sqlc.Querier
+	counts  []sqlc.PostReactionCount
+	flushed []sqlc.AddPostReactionCountsParams
+}
+
+func (q *fakeReactionQuerier) AddPostReactionCounts(_ context.Context, arg sqlc.AddPostReactionCountsParams) (int64, error) {
+	q.flushed = append(q.flushed, arg)
+	return int64(len(arg.PostIds)), nil
+}
+
+func (q *fakeReactionQuerier) GetPostReactionCounts(context.Context, sqlc.GetPostReactionCountsParams) ([]sqlc.PostReactionCount, error) {
+	return q.counts, nil
+}
+
+func newTestReactionCounter(db redis.Cmdable, q sqlc.Querier, dirtyShards int) *ReactionCounter {
+	return NewReactionCounter(db, aggregator.New(db, aggregator.Options{}), q, dirtyShards)
+}
+
+func TestReactionCounter_Add(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	counter := newTestReactionCounter(db, &fakeReactionQuerier{}, 4)
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	keys := []string{fmt.Sprintf(postReactionsKeyPattern, post.ID), fmt.Sprintf(postReactionsPendingKeyPattern, post.ID)}
+	rdbMock.ExpectEvalSha(addReactionsScript.Hash(), keys, int64(reactionCountsTTL.Seconds()), ReactionLike, int64(-1), ReactionLove, int64(1)).SetVal(int64(1))
+	rdbMock.ExpectZIncrBy(fmt.Sprintf(dirtyReactionsKeyPattern, shard.Of(100, 4)), 1, "100:1").SetVal(1)
+
+	require.NoError(t, counter.Add(context.Background(), post, map[string]int64{ReactionLove: 1, ReactionLike: -1}))
+	// Nothing to add
+	require.NoError(t, counter.Add(context.Background(), post, map[string]int64{ReactionLike: 0}))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestReactionCounter_Counts(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	counter := newTestReactionCounter(db, &fakeReactionQuerier{}, 1)
+	post := sqlc.Post{ID: 100, UserID: 1}
+	key := fmt.Sprintf(postReactionsKeyPattern, post.ID)
+
+	rdbMock.ExpectHGetAll(key).SetVal(map[string]string{ReactionLike: "3", ReactionWow: "1"})
+	counts, err := counter.Counts(context.Background(), post)
+	require.NoError(t, err)
+	assert.Len(t, counts, len(ReactionTypes))
+	assert.Equal(t, int64(3), counts[ReactionLike])
+	assert.Equal(t, int64(1), counts[ReactionWow])
+	assert.Zero(t, counts[ReactionSad])
+
+	// Not cached: the post is marked for the flusher to seed
+	rdbMock.ExpectHGetAll(key).SetVal(map[string]string{})
+	rdbMock.ExpectZIncrBy(fmt.Sprintf(dirtyReactionsKeyPattern, 0), 1, "100:1").SetVal(1)
+	counts, err = counter.Counts(context.Background(), post)
+	require.NoError(t, err)
+	assert.Len(t, counts, len(ReactionTypes))
+	assert.Zero(t, counts[ReactionLike])
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestReactionCounter_Flush(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	q := &fakeReactionQuerier{counts: []sqlc.PostReactionCount{{PostID: 200, UserID: 2, Reaction: ReactionLike, Count: 5, FlushSeq: 7}}}
+	counter := newTestReactionCounter(db, q, 2)
+	now := time.Now()
+	counter.now = func() time.Time { return now }
+
+	first, second := fmt.Sprintf(dirtyReactionsKeyPattern, 0), fmt.Sprintf(dirtyReactionsKeyPattern, 1)
+	rdbMock.ExpectZRangeWithScores(first, 0, 9).SetVal([]redis.Z{{Member: "100:1", Score: 2}})
+	rdbMock.ExpectZRangeWithScores(second, 0, 9).SetVal([]redis.Z{{Member: "200:2", Score: 1}})
+	checkpointKeys := func(postID int64) []string {
+		return []string{
+			fmt.Sprintf(postReactionsPendingKeyPattern, postID),
+			fmt.Sprintf(postReactionsFlushingKeyPattern, postID),
+			fmt.Sprintf(postReactionsSeqKeyPattern, postID),
+		}
+	}
+	seqTTL := int64(reactionFlushSeqTTL.Seconds())
+	// Post 100 has deltas, post 200 was only read while it was not cached
+	rdbMock.CustomMatch(evalKeys(3)).ExpectEval("", checkpointKeys(100), now.UnixMicro(), seqTTL).SetVal([]interface{}{ReactionLike, "2", ReactionSad, "0", flushSeqField, "11"})
+	rdbMock.CustomMatch(evalKeys(3)).ExpectEval("", checkpointKeys(200), now.UnixMicro(), seqTTL).SetVal([]interface{}{})
+
+	finishKeys := func(postID int64) []string {
+		return []string{fmt.Sprintf(postReactionsFlushingKeyPattern, postID), fmt.Sprintf(postReactionsPendingKeyPattern, postID)}
+	}
+	// Post 100 was reacted to again during the flush
+	rdbMock.CustomMatch(evalKeys(2)).ExpectEval("", finishKeys(100)).SetVal(int64(1))
+	rdbMock.CustomMatch(evalKeys(2)).ExpectEval("", finishKeys(200)).SetVal(int64(0))
+
+	rdbMock.ExpectExists(fmt.Sprintf(postReactionsKeyPattern, 100)).SetVal(1)
+	rdbMock.ExpectExists(fmt.Sprintf(postReactionsKeyPattern, 200)).SetVal(0)
+	seedKeys := []string{
+		fmt.Sprintf(postReactionsKeyPattern, 200),
+		fmt.Sprintf(postReactionsPendingKeyPattern, 200),
+		fmt.Sprintf(postReactionsFlushingKeyPattern, 200),
+	}
+	seedArgs := []interface{}{int64(reactionCountsTTL.Seconds()), int64(7)}
+	for _, reaction := range ReactionTypes {
+		count := int64(0)
+		if reaction == ReactionLike {
+			count = 5
+		}
+		seedArgs = append(seedArgs, reaction, count)
+	}
+	rdbMock.ExpectEvalSha(seedReactionsScript.Hash(), seedKeys, seedArgs...).SetVal(int64(1))
+
+	// Only the settled post is unmarked, if it was not marked again
+	rdbMock.CustomMatch(evalKeys(1)).ExpectEval("", []string{second}, "200:2", "1").SetVal(int64(1))
+
+	flushed, err := counter.Flush(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+	require.Len(t, q.flushed, 1)
+	assert.Equal(t, sqlc.AddPostReactionCountsParams{
+		PostIds:   []int64{100},
+		UserIds:   []int64{1},
+		Reactions: []string{ReactionLike},
+		Deltas:    []int64{2},
+		FlushSeqs: []int64{11},
+	}, q.flushed[0])
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestReactionCounter_Flush_Empty(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	q := &fakeReactionQuerier{}
+	counter := newTestReactionCounter(db, q, 1)
+
+	rdbMock.ExpectZRangeWithScores(fmt.Sprintf(dirtyReactionsKeyPattern, 0), 0, 9).SetVal([]redis.Z{})
+
+	flushed, err := counter.Flush(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Zero(t, flushed)
+	assert.Empty(t, q.flushed)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
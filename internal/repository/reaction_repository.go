@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// Reactions a user can give to a post
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionHaha  = "haha"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+	ReactionAngry = "angry"
+)
+
+// ReactionTypes lists every reaction
+var ReactionTypes = []string{ReactionLike, ReactionLove, ReactionHaha, ReactionWow, ReactionSad, ReactionAngry}
+
+// setReactionAttempts bounds the attempts of SetReaction racing with the deletes of the same reaction
+const setReactionAttempts = 3
+
+// ReactionRepository keeps the reactions of users to posts, one per user and post
+type ReactionRepository interface {
+	// SetReaction sets the reaction of the user to the post and returns the reaction it replaces, empty
+	// when the user had not reacted. It runs in a transaction, which locks the reaction until it ends.
+	SetReaction(ctx context.Context, post sqlc.Post, userID int64, reaction string) (string, error)
+	// DeleteReaction removes the reaction of the user to the post and returns it, pgx.ErrNoRows when the
+	// user has not reacted
+	DeleteReaction(ctx context.Context, post sqlc.Post, userID int64) (string, error)
+	// HasReacted reports whether the user reacted to the post
+	HasReacted(ctx context.Context, userID, postID int64) (bool, error)
+	// ListReactedPostIDs returns at most limit ids of the posts the user reacted to
+	ListReactedPostIDs(ctx context.Context, userID int64, limit int32) ([]int64, error)
+	// GetReactionCounts returns the count of every reaction to the post
+	GetReactionCounts(ctx context.Context, post sqlc.Post) (map[string]int64, error)
+}
+
+type DBReactionRepository struct {
+	q sqlc.Querier
+}
+
+func NewDBReactionRepository(querier sqlc.Querier) ReactionRepository {
+	return &DBReactionRepository{q: querier}
+}
+
+// SetReaction inserts the reaction, or locks the existing one before replacing it, so that concurrent
+// changes of the same reaction each see the one they replace
+func (r *DBReactionRepository) SetReaction(ctx context.Context, post sqlc.Post, userID int64, reaction string) (string, error) {
+	for attempt := 0; attempt < setReactionAttempts; attempt++ {
+		rows, err := r.q.CreateReaction(ctx, sqlc.CreateReactionParams{UserID: userID, PostID: post.ID, Reaction: reaction})
+		if err != nil {
+			return "", err
+		}
+		if rows == 1 {
+			return "", nil
+		}
+
+		previous, err := r.q.GetReactionForUpdate(ctx, sqlc.GetReactionForUpdateParams{UserID: userID, PostID: post.ID})
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Deleted in between
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if previous != reaction {
+			if err := r.q.UpdateReaction(ctx, sqlc.UpdateReactionParams{UserID: userID, PostID: post.ID, Reaction: reaction}); err != nil {
+				return "", err
+			}
+		}
+		return previous, nil
+	}
+	return "", fmt.Errorf("reaction of user %d to post %d kept changing", userID, post.ID)
+}
+
+func (r *DBReactionRepository) DeleteReaction(ctx context.Context, post sqlc.Post, userID int64) (string, error) {
+	return r.q.DeleteReaction(ctx, sqlc.DeleteReactionParams{UserID: userID, PostID: post.ID})
+}
+
+func (r *DBReactionRepository) HasReacted(ctx context.Context, userID, postID int64) (bool, error) {
+	_, err := r.q.GetReaction(ctx, sqlc.GetReactionParams{UserID: userID, PostID: postID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *DBReactionRepository) ListReactedPostIDs(ctx context.Context, userID int64, limit int32) ([]int64, error) {
+	return r.q.ListReactedPostIDs(ctx, sqlc.ListReactedPostIDsParams{UserID: userID, Limit: limit})
+}
+
+// GetReactionCounts reads the counts flushed to DB, the deltas not flushed yet are left out
+func (r *DBReactionRepository) GetReactionCounts(ctx context.Context, post sqlc.Post) (map[string]int64, error) {
+	rows, err := r.q.GetPostReactionCounts(ctx, sqlc.GetPostReactionCountsParams{PostID: post.ID, UserID: post.UserID})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(ReactionTypes))
+	for _, reaction := range ReactionTypes {
+		counts[reaction] = 0
+	}
+	for _, row := range rows {
+		counts[row.Reaction] = row.Count
+	}
+	return counts, nil
+}
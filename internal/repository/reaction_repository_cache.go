@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// userReactedKeyPattern is the set of the posts a user reacted to. Post ids are integers, so that
+	// Redis keeps the set as a compact intset while it is small.
+	userReactedKeyPattern = "{user:%d}:reacted"
+	// reactedSetMarker is a member of every cached set, telling a set without posts from a missing one
+	reactedSetMarker = 0
+	// maxCachedReactedSetSize bounds the sets kept in Redis, the reactions of users with more are read
+	// from DB
+	maxCachedReactedSetSize = 10_000
+)
+
+// reactedSetScript adds a post to, or removes it from, the set of a user when it is cached, so that a
+// partial set never gets created.
+//
+// KEYS[1] reacted set
+// ARGV[1] delta (1 or -1), ARGV[2] post id
+var reactedSetScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  if tonumber(ARGV[1]) > 0 then
+    redis.call('SADD', KEYS[1], ARGV[2])
+  else
+    redis.call('SREM', KEYS[1], ARGV[2])
+  end
+end
+return 1
+`)
+
+// CachedReactionRepository is a cache decorator for ReactionRepository.
+// The counts of a post are kept by a ReactionCounter and the posts every user reacted to in a set under
+// the user's hash tag.
+type CachedReactionRepository struct {
+	nextRepo ReactionRepository
+	rdb      redis.Cmdable
+	counter  *ReactionCounter
+}
+
+// NewCachedReactionRepository creates a new instance of CachedReactionRepository
+func NewCachedReactionRepository(next ReactionRepository, rdb redis.Cmdable, counter *ReactionCounter) ReactionRepository {
+	return &CachedReactionRepository{nextRepo: next, rdb: rdb, counter: counter}
+}
+
+// SetReaction sets the reaction and moves the post's counts from the reaction it replaces, once its
+// transaction is committed
+func (r *CachedReactionRepository) SetReaction(ctx context.Context, post sqlc.Post, userID int64, reaction string) (string, error) {
+	previous, err := r.nextRepo.SetReaction(ctx, post, userID, reaction)
+	if err != nil || previous == reaction {
+		return previous, err
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		deltas := map[string]int64{reaction: 1}
+		if previous != "" {
+			deltas[previous] = -1
+		} else {
+			r.applyReacted(ctx, userID, post.ID, 1)
+		}
+		if err := r.counter.Add(ctx, post, deltas); err != nil {
+			log.Printf("failed to count reaction of user %d to post %d: %v", userID, post.ID, err)
+		}
+	})
+	return previous, nil
+}
+
+// DeleteReaction removes the reaction and takes it off the post's counts, once its transaction if any
+// is committed
+func (r *CachedReactionRepository) DeleteReaction(ctx context.Context, post sqlc.Post, userID int64) (string, error) {
+	previous, err := r.nextRepo.DeleteReaction(ctx, post, userID)
+	if err != nil {
+		return "", err
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		r.applyReacted(ctx, userID, post.ID, -1)
+		if err := r.counter.Add(ctx, post, map[string]int64{previous: -1}); err != nil {
+			log.Printf("failed to uncount reaction of user %d to post %d: %v", userID, post.ID, err)
+		}
+	})
+	return previous, nil
+}
+
+// HasReacted looks for the post in the user's cached set. A missing set is loaded from DB in full when it
+// is small enough to cache, otherwise the reaction is read from DB directly.
+func (r *CachedReactionRepository) HasReacted(ctx context.Context, userID, postID int64) (bool, error) {
+	setKey := fmt.Sprintf(userReactedKeyPattern, userID)
+	found, err := r.rdb.SMIsMember(ctx, setKey, reactedSetMarker, postID).Result()
+	if err == nil && found[0] {
+		metrics.ReactedCacheHits.Inc()
+		return found[1], nil
+	}
+	if err != nil {
+		log.Printf("redis error on getting reacted set of user %d: %v", userID, err)
+	}
+
+	metrics.ReactedCacheMisses.Inc()
+	postIDs, err := r.nextRepo.ListReactedPostIDs(ctx, userID, maxCachedReactedSetSize+1)
+	if err != nil {
+		return false, err
+	}
+	if len(postIDs) > maxCachedReactedSetSize {
+		return r.nextRepo.HasReacted(ctx, userID, postID)
+	}
+
+	if err := r.cacheReactedSet(ctx, userID, postIDs); err != nil {
+		log.Printf("failed to cache reacted set of user %d: %v", userID, err)
+	}
+	for _, id := range postIDs {
+		if id == postID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *CachedReactionRepository) ListReactedPostIDs(ctx context.Context, userID int64, limit int32) ([]int64, error) {
+	return r.nextRepo.ListReactedPostIDs(ctx, userID, limit)
+}
+
+// GetReactionCounts reads the counts kept in Redis, DB is never read
+func (r *CachedReactionRepository) GetReactionCounts(ctx context.Context, post sqlc.Post) (map[string]int64, error) {
+	return r.counter.Counts(ctx, post)
+}
+
+func (r *CachedReactionRepository) cacheReactedSet(ctx context.Context, userID int64, postIDs []int64) error {
+	setKey := fmt.Sprintf(userReactedKeyPattern, userID)
+	members := make([]interface{}, 0, len(postIDs)+1)
+	members = append(members, reactedSetMarker)
+	for _, id := range postIDs {
+		members = append(members, id)
+	}
+
+	pipe := r.rdb.Pipeline()
+	pipe.Del(ctx, setKey)
+	pipe.SAdd(ctx, setKey, members...)
+	pipe.Expire(ctx, setKey, cacheTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("pipeline execution failed for reacted set of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *CachedReactionRepository) applyReacted(ctx context.Context, userID, postID int64, delta int) {
+	if err := reactedSetScript.Run(ctx, r.rdb, []string{fmt.Sprintf(userReactedKeyPattern, userID)}, delta, postID).Err(); err != nil {
+		log.Printf("failed to update cached reacted set of user %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedReactionRepository_SetReaction(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.ReactionRepository)
+	repo := NewCachedReactionRepository(mockRepo, db, newTestReactionCounter(db, &fakeReactionQuerier{}, 1))
+	post := sqlc.Post{ID: 100, UserID: 1}
+	countKeys := []string{fmt.Sprintf(postReactionsKeyPattern, post.ID), fmt.Sprintf(postReactionsPendingKeyPattern, post.ID)}
+	ttl := int64(reactionCountsTTL.Seconds())
+
+	// First reaction of the user to the post
+	mockRepo.On("SetReaction", mock.Anything, post, int64(2), ReactionLike).Return("", nil).Once()
+	rdbMock.ExpectEvalSha(reactedSetScript.Hash(), []string{fmt.Sprintf(userReactedKeyPattern, 2)}, 1, post.ID).SetVal(int64(1))
+	rdbMock.ExpectEvalSha(addReactionsScript.Hash(), countKeys, ttl, ReactionLike, int64(1)).SetVal(int64(1))
+	rdbMock.ExpectZIncrBy(fmt.Sprintf(dirtyReactionsKeyPattern, 0), 1, "100:1").SetVal(1)
+
+	previous, err := repo.SetReaction(context.Background(), post, 2, ReactionLike)
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+
+	// Replacing the reaction moves the count
+	mockRepo.On("SetReaction", mock.Anything, post, int64(2), ReactionLove).Return(ReactionLike, nil).Once()
+	rdbMock.ExpectEvalSha(addReactionsScript.Hash(), countKeys, ttl, ReactionLike, int64(-1), ReactionLove, int64(1)).SetVal(int64(1))
+	rdbMock.ExpectZIncrBy(fmt.Sprintf(dirtyReactionsKeyPattern, 0), 1, "100:1").SetVal(2)
+
+	previous, err = repo.SetReaction(context.Background(), post, 2, ReactionLove)
+	require.NoError(t, err)
+	assert.Equal(t, ReactionLike, previous)
+
+	// Setting the same reaction again counts nothing
+	mockRepo.On("SetReaction", mock.Anything, post, int64(2), ReactionLove).Return(ReactionLove, nil).Once()
+
+	previous, err = repo.SetReaction(context.Background(), post, 2, ReactionLove)
+	require.NoError(t, err)
+	assert.Equal(t, ReactionLove, previous)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCachedReactionRepository_DeleteReaction(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.ReactionRepository)
+	repo := NewCachedReactionRepository(mockRepo, db, newTestReactionCounter(db, &fakeReactionQuerier{}, 1))
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	mockRepo.On("DeleteReaction", mock.Anything, post, int64(2)).Return(ReactionWow, nil)
+	rdbMock.ExpectEvalSha(reactedSetScript.Hash(), []string{fmt.Sprintf(userReactedKeyPattern, 2)}, -1, post.ID).SetVal(int64(1))
+	rdbMock.ExpectEvalSha(addReactionsScript.Hash(),
+		[]string{fmt.Sprintf(postReactionsKeyPattern, post.ID), fmt.Sprintf(postReactionsPendingKeyPattern, post.ID)},
+		int64(reactionCountsTTL.Seconds()), ReactionWow, int64(-1)).SetVal(int64(1))
+	rdbMock.ExpectZIncrBy(fmt.Sprintf(dirtyReactionsKeyPattern, 0), 1, "100:1").SetVal(1)
+
+	previous, err := repo.DeleteReaction(context.Background(), post, 2)
+	require.NoError(t, err)
+	assert.Equal(t, ReactionWow, previous)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReactionRepository_HasReacted_CacheHit(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.ReactionRepository)
+	repo := NewCachedReactionRepository(mockRepo, db, nil)
+
+	rdbMock.ExpectSMIsMember(fmt.Sprintf(userReactedKeyPattern, 2), reactedSetMarker, int64(100)).SetVal([]bool{true, true})
+
+	reacted, err := repo.HasReacted(context.Background(), 2, 100)
+	require.NoError(t, err)
+	assert.True(t, reacted)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertNotCalled(t, "ListReactedPostIDs", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCachedReactionRepository_HasReacted_CacheMiss(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.ReactionRepository)
+	repo := NewCachedReactionRepository(mockRepo, db, nil)
+	setKey := fmt.Sprintf(userReactedKeyPattern, 2)
+
+	rdbMock.ExpectSMIsMember(setKey, reactedSetMarker, int64(100)).SetVal([]bool{false, false})
+	mockRepo.On("ListReactedPostIDs", mock.Anything, int64(2), int32(maxCachedReactedSetSize+1)).Return([]int64{100, 101}, nil)
+	rdbMock.ExpectDel(setKey).SetVal(0)
+	rdbMock.ExpectSAdd(setKey, reactedSetMarker, int64(100), int64(101)).SetVal(3)
+	rdbMock.ExpectExpire(setKey, cacheTTL).SetVal(true)
+
+	reacted, err := repo.HasReacted(context.Background(), 2, 100)
+	require.NoError(t, err)
+	assert.True(t, reacted)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReactionRepository_HasReacted_TooManyToCache(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mocks.ReactionRepository)
+	repo := NewCachedReactionRepository(mockRepo, db, nil)
+
+	rdbMock.ExpectSMIsMember(fmt.Sprintf(userReactedKeyPattern, 2), reactedSetMarker, int64(100)).SetVal([]bool{false, false})
+	mockRepo.On("ListReactedPostIDs", mock.Anything, int64(2), int32(maxCachedReactedSetSize+1)).
+		Return(make([]int64, maxCachedReactedSetSize+1), nil)
+	mockRepo.On("HasReacted", mock.Anything, int64(2), int64(100)).Return(false, nil)
+
+	reacted, err := repo.HasReacted(context.Background(), 2, 100)
+	require.NoError(t, err)
+	assert.False(t, reacted)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,76 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBReactionRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewDBReactionRepository(testQueries)
+	author := createTestUser(t, ctx)
+	user := createTestUser(t, ctx)
+	post, err := NewDBPostRepository(testQueries).CreatePost(ctx, sqlc.CreatePostParams{UserID: author.ID, Content: "react to me"})
+	require.NoError(t, err)
+
+	t.Run("sets_and_replaces_reaction", func(t *testing.T) {
+		previous, err := repo.SetReaction(ctx, post, user.ID, ReactionLike)
+		require.NoError(t, err)
+		assert.Empty(t, previous)
+
+		previous, err = repo.SetReaction(ctx, post, user.ID, ReactionLove)
+		require.NoError(t, err)
+		assert.Equal(t, ReactionLike, previous)
+
+		reacted, err := repo.HasReacted(ctx, user.ID, post.ID)
+		require.NoError(t, err)
+		assert.True(t, reacted)
+
+		postIDs, err := repo.ListReactedPostIDs(ctx, user.ID, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{post.ID}, postIDs)
+	})
+
+	t.Run("deletes_reaction", func(t *testing.T) {
+		previous, err := repo.DeleteReaction(ctx, post, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ReactionLove, previous)
+
+		_, err = repo.DeleteReaction(ctx, post, user.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		reacted, err := repo.HasReacted(ctx, user.ID, post.ID)
+		require.NoError(t, err)
+		assert.False(t, reacted)
+	})
+
+	t.Run("applies_a_flush_once", func(t *testing.T) {
+		flush := func(seq int64) {
+			_, err := testQueries.AddPostReactionCounts(ctx, sqlc.AddPostReactionCountsParams{
+				PostIds:   []int64{post.ID, post.ID},
+				UserIds:   []int64{author.ID, author.ID},
+				Reactions: []string{ReactionLike, ReactionWow},
+				Deltas:    []int64{3, 1},
+				FlushSeqs: []int64{seq, seq},
+			})
+			require.NoError(t, err)
+		}
+		flush(1)
+		// Replayed after a crash
+		flush(1)
+		flush(2)
+
+		counts, err := repo.GetReactionCounts(ctx, post)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), counts[ReactionLike])
+		assert.Equal(t, int64(2), counts[ReactionWow])
+		assert.Zero(t, counts[ReactionSad])
+	})
+}
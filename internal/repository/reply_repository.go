@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// ReplyDeletion describes how a deleted reply left its thread
+type ReplyDeletion struct {
+	// Tombstone is the reply kept with its content cleared, as replies hang from it
+	Tombstone *sqlc.Reply
+	// DeletedIDs are the replies removed: the reply, then the tombstones above it left without replies
+	DeletedIDs []int64
+	// ParentID is the reply that lost a reply, zero when the removals reached the post
+	ParentID int64
+}
+
+// ReplyRepository keeps the threads of replies to posts. The replies of a thread live with the post at
+// its root, so every method takes that post.
+type ReplyRepository interface {
+	// CreateReply adds a reply of the user to the post, or to the reply parentID of its thread when
+	// parentID is not the post. pgx.ErrNoRows is returned when the post or the parent reply is gone.
+	// It runs in a transaction, which locks the post against its deletion until it ends.
+	CreateReply(ctx context.Context, post sqlc.Post, parentID, userID int64, content string) (sqlc.Reply, error)
+	// DeleteReply deletes the reply of the user, pgx.ErrNoRows when the user has no such reply. A reply
+	// with replies is kept as a tombstone. It runs in a transaction.
+	DeleteReply(ctx context.Context, post sqlc.Post, replyID, userID int64) (ReplyDeletion, error)
+	// ListThread returns a page of the replies of the thread of the post, oldest first, tombstones included
+	ListThread(ctx context.Context, post sqlc.Post, limit, offset int32) ([]sqlc.Reply, error)
+	// GetReplyCount returns the replies of the thread of the post, tombstones excluded
+	GetReplyCount(ctx context.Context, post sqlc.Post) (int64, error)
+	// DeleteThread deletes every reply of the thread of the post, along with its reply count
+	DeleteThread(ctx context.Context, post sqlc.Post) error
+}
+
+type DBReplyRepository struct {
+	q sqlc.Querier
+}
+
+func NewDBReplyRepository(querier sqlc.Querier) ReplyRepository {
+	return &DBReplyRepository{q: querier}
+}
+
+// CreateReply locks the post first, so that a reply never outlives a thread deleted in between, then
+// counts the reply on its parent, which fails when the parent was deleted
+func (r *DBReplyRepository) CreateReply(ctx context.Context, post sqlc.Post, parentID, userID int64, content string) (sqlc.Reply, error) {
+	if _, err := r.q.LockPost(ctx, sqlc.LockPostParams{ID: post.ID, UserID: post.UserID}); err != nil {
+		return sqlc.Reply{}, err
+	}
+	if parentID != post.ID {
+		rows, err := r.q.IncrementReplyCount(ctx, sqlc.IncrementReplyCountParams{ID: parentID, RootID: post.ID, RootUserID: post.UserID})
+		if err != nil {
+			return sqlc.Reply{}, err
+		}
+		if rows == 0 {
+			return sqlc.Reply{}, pgx.ErrNoRows
+		}
+	}
+
+	reply, err := r.q.CreateReply(ctx, sqlc.CreateReplyParams{
+		RootID:     post.ID,
+		RootUserID: post.UserID,
+		ParentID:   parentID,
+		UserID:     userID,
+		Content:    content,
+	})
+	if err != nil {
+		return sqlc.Reply{}, err
+	}
+	if err := r.q.AddPostReplyCount(ctx, sqlc.AddPostReplyCountParams{PostID: post.ID, UserID: post.UserID, Count: 1}); err != nil {
+		return sqlc.Reply{}, err
+	}
+	return reply, nil
+}
+
+// DeleteReply locks the reply, then either keeps it as a tombstone or removes it along with the
+// tombstones above it that only held it. Replies are locked before their parents and the reply count
+// of the post last, in the order CreateReply locks them, so that the two never deadlock.
+func (r *DBReplyRepository) DeleteReply(ctx context.Context, post sqlc.Post, replyID, userID int64) (ReplyDeletion, error) {
+	reply, err := r.q.GetReplyForUpdate(ctx, sqlc.GetReplyForUpdateParams{ID: replyID, RootID: post.ID, RootUserID: post.UserID})
+	if err != nil {
+		return ReplyDeletion{}, err
+	}
+	if reply.UserID != userID || reply.DeletedAt.Valid {
+		return ReplyDeletion{}, pgx.ErrNoRows
+	}
+
+	deletion, err := r.removeReply(ctx, post, reply)
+	if err != nil {
+		return ReplyDeletion{}, err
+	}
+	if err := r.q.AddPostReplyCount(ctx, sqlc.AddPostReplyCountParams{PostID: post.ID, UserID: post.UserID, Count: -1}); err != nil {
+		return ReplyDeletion{}, err
+	}
+	return deletion, nil
+}
+
+// removeReply tombstones a reply with replies, or deletes it and prunes the tombstones it leaves empty
+func (r *DBReplyRepository) removeReply(ctx context.Context, post sqlc.Post, reply sqlc.Reply) (ReplyDeletion, error) {
+	if reply.ReplyCount > 0 {
+		tombstone, err := r.q.TombstoneReply(ctx, sqlc.TombstoneReplyParams{ID: reply.ID, RootUserID: post.UserID})
+		if err != nil {
+			return ReplyDeletion{}, err
+		}
+		return ReplyDeletion{Tombstone: &tombstone}, nil
+	}
+
+	var deletion ReplyDeletion
+	for {
+		if err := r.q.DeleteReply(ctx, sqlc.DeleteReplyParams{ID: reply.ID, RootUserID: post.UserID}); err != nil {
+			return ReplyDeletion{}, err
+		}
+		deletion.DeletedIDs = append(deletion.DeletedIDs, reply.ID)
+		if reply.ParentID == post.ID {
+			return deletion, nil
+		}
+
+		parent, err := r.q.DecrementReplyCount(ctx, sqlc.DecrementReplyCountParams{ID: reply.ParentID, RootUserID: post.UserID})
+		if err != nil {
+			return ReplyDeletion{}, err
+		}
+		if !parent.DeletedAt.Valid || parent.ReplyCount > 0 {
+			deletion.ParentID = parent.ID
+			return deletion, nil
+		}
+		reply = parent
+	}
+}
+
+func (r *DBReplyRepository) ListThread(ctx context.Context, post sqlc.Post, limit, offset int32) ([]sqlc.Reply, error) {
+	return r.q.ListThreadReplies(ctx, sqlc.ListThreadRepliesParams{RootID: post.ID, RootUserID: post.UserID, Limit: limit, Offset: offset})
+}
+
+// GetReplyCount returns zero for a post never replied to
+func (r *DBReplyRepository) GetReplyCount(ctx context.Context, post sqlc.Post) (int64, error) {
+	count, err := r.q.GetPostReplyCount(ctx, sqlc.GetPostReplyCountParams{PostID: post.ID, UserID: post.UserID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (r *DBReplyRepository) DeleteThread(ctx context.Context, post sqlc.Post) error {
+	if _, err := r.q.DeleteThread(ctx, sqlc.DeleteThreadParams{RootID: post.ID, RootUserID: post.UserID}); err != nil {
+		return err
+	}
+	return r.q.DeletePostReplyCount(ctx, sqlc.DeletePostReplyCountParams{PostID: post.ID, UserID: post.UserID})
+}
@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// threadRepliesKeyPattern is the sorted set of the reply ids of a thread, scored by creation time.
+	// The keys of a thread share the hash tag of its root post.
+	threadRepliesKeyPattern = "{post:%d}:replies"
+	// threadBodiesKeyPattern is the hash of the replies of a thread by id
+	threadBodiesKeyPattern = "{post:%d}:replies:bodies"
+	// threadCountsKeyPattern is the hash of the reply counts of the replies of a thread by id, and of the
+	// thread itself under threadCountField. It exists for every cached thread, empty ones included.
+	threadCountsKeyPattern = "{post:%d}:replies:counts"
+	threadCountField       = "thread"
+	// threadFillKeyPattern holds the token of the read loading a missing thread from DB. Writes to a
+	// missing thread delete it, so that a thread read before them is not cached.
+	threadFillKeyPattern = "{post:%d}:replies:fill"
+	// threadFillTTL outlasts the read of a thread from DB
+	threadFillTTL = 30 * time.Second
+	// maxCachedThreadReplies bounds the threads kept in Redis, the replies of larger ones are read from DB
+	maxCachedThreadReplies = 10_000
+)
+
+// addReplyScript adds a reply to its thread when the thread is cached, so that a partial thread never
+// gets created, and cancels the fill of a missing one. A thread growing past maxCachedThreadReplies is
+// dropped.
+//
+// KEYS[1] replies, KEYS[2] bodies, KEYS[3] counts, KEYS[4] fill
+// ARGV[1] reply id, ARGV[2] score, ARGV[3] reply, ARGV[4] parent reply id (0 for the post),
+// ARGV[5] maxCachedThreadReplies
+var addReplyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+  redis.call('DEL', KEYS[4])
+  return 0
+end
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[5]) then
+  redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4])
+  return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('HSET', KEYS[3], ARGV[1], 0)
+if ARGV[4] ~= '0' then
+  redis.call('HINCRBY', KEYS[3], ARGV[4], 1)
+end
+redis.call('HINCRBY', KEYS[3], '` + threadCountField + `', 1)
+local ttl = redis.call('PTTL', KEYS[3])
+if ttl > 0 then
+  redis.call('PEXPIRE', KEYS[1], ttl)
+  redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return 1
+`)
+
+// removeReplyScript applies a ReplyDeletion to its thread when the thread is cached, and cancels the
+// fill of a missing one
+//
+// KEYS[1] replies, KEYS[2] bodies, KEYS[3] counts, KEYS[4] fill
+// ARGV[1] tombstone id (0 for none), ARGV[2] tombstone, ARGV[3] parent reply id that lost a reply
+// (0 for none), ARGV[4...] deleted reply ids
+var removeReplyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+  redis.call('DEL', KEYS[4])
+  return 0
+end
+redis.call('HINCRBY', KEYS[3], '` + threadCountField + `', -1)
+if ARGV[1] ~= '0' then
+  redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+end
+if ARGV[3] ~= '0' then
+  redis.call('HINCRBY', KEYS[3], ARGV[3], -1)
+end
+for i = 4, #ARGV do
+  redis.call('ZREM', KEYS[1], ARGV[i])
+  redis.call('HDEL', KEYS[2], ARGV[i])
+  redis.call('HDEL', KEYS[3], ARGV[i])
+end
+return 1
+`)
+
+// fillThreadScript caches a thread read from DB, unless a write cancelled the fill since the read began
+// or another read cached the thread meanwhile
+//
+// KEYS[1] replies, KEYS[2] bodies, KEYS[3] counts, KEYS[4] fill
+// ARGV[1] fill token, ARGV[2] TTL in milliseconds, ARGV[3] thread count,
+// ARGV[4...] reply id, score, reply and reply count of every reply
+var fillThreadScript = redis.NewScript(`
+if redis.call('GET', KEYS[4]) ~= ARGV[1] then
+  return 0
+end
+redis.call('DEL', KEYS[4])
+if redis.call('EXISTS', KEYS[3]) == 1 then
+  return 0
+end
+for i = 4, #ARGV, 4 do
+  redis.call('ZADD', KEYS[1], ARGV[i + 1], ARGV[i])
+  redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 2])
+  redis.call('HSET', KEYS[3], ARGV[i], ARGV[i + 3])
+end
+redis.call('HSET', KEYS[3], '` + threadCountField + `', ARGV[3])
+for i = 1, 3 do
+  redis.call('PEXPIRE', KEYS[i], ARGV[2])
+end
+return 1
+`)
+
+// CachedReplyRepository is a cache decorator for ReplyRepository.
+// A thread is cached whole: its reply ids in a sorted set, the replies and their reply counts in hashes,
+// all under the hash tag of the root post. Writes are applied to cached threads once committed, and
+// missing threads are loaded from DB on read, unless a write lands while they are read.
+type CachedReplyRepository struct {
+	nextRepo ReplyRepository
+	rdb      redis.Cmdable
+	// fillToken tells the reads loading the same thread apart
+	fillToken func() (string, error)
+}
+
+// NewCachedReplyRepository creates a new instance of CachedReplyRepository
+func NewCachedReplyRepository(next ReplyRepository, rdb redis.Cmdable) ReplyRepository {
+	return &CachedReplyRepository{nextRepo: next, rdb: rdb, fillToken: randomFillToken}
+}
+
+// CreateReply creates the reply and adds it to the cached thread, once its transaction is committed
+func (r *CachedReplyRepository) CreateReply(ctx context.Context, post sqlc.Post, parentID, userID int64, content string) (sqlc.Reply, error) {
+	reply, err := r.nextRepo.CreateReply(ctx, post, parentID, userID, content)
+	if err != nil {
+		return sqlc.Reply{}, err
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		body, err := json.Marshal(reply)
+		if err != nil {
+			log.Printf("failed to marshal reply %d: %v", reply.ID, err)
+			return
+		}
+		var parent int64
+		if reply.ParentID != post.ID {
+			parent = reply.ParentID
+		}
+		err = addReplyScript.Run(ctx, r.rdb, threadKeys(post.ID),
+			reply.ID, replyScore(reply), body, parent, maxCachedThreadReplies).Err()
+		if err != nil {
+			log.Printf("failed to add reply %d to cached thread of post %d: %v", reply.ID, post.ID, err)
+		}
+	})
+	return reply, nil
+}
+
+// DeleteReply deletes the reply and applies the deletion to the cached thread, once its transaction
+// is committed
+func (r *CachedReplyRepository) DeleteReply(ctx context.Context, post sqlc.Post, replyID, userID int64) (ReplyDeletion, error) {
+	deletion, err := r.nextRepo.DeleteReply(ctx, post, replyID, userID)
+	if err != nil {
+		return ReplyDeletion{}, err
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		args := []interface{}{0, "", deletion.ParentID}
+		if deletion.Tombstone != nil {
+			body, err := json.Marshal(deletion.Tombstone)
+			if err != nil {
+				log.Printf("failed to marshal reply %d: %v", deletion.Tombstone.ID, err)
+				r.dropThread(ctx, post.ID)
+				return
+			}
+			args[0], args[1] = deletion.Tombstone.ID, body
+		}
+		for _, id := range deletion.DeletedIDs {
+			args = append(args, id)
+		}
+		if err := removeReplyScript.Run(ctx, r.rdb, threadKeys(post.ID), args...).Err(); err != nil {
+			log.Printf("failed to remove reply %d from cached thread of post %d: %v", replyID, post.ID, err)
+		}
+	})
+	return deletion, nil
+}
+
+// ListThread reads the page from the cached thread. A missing thread is loaded from DB in full when it
+// is small enough to cache, otherwise the page is read from DB directly.
+func (r *CachedReplyRepository) ListThread(ctx context.Context, post sqlc.Post, limit, offset int32) ([]sqlc.Reply, error) {
+	replies, ok, err := r.listCachedThread(ctx, post.ID, limit, offset)
+	if err != nil {
+		log.Printf("redis error on getting thread of post %d: %v", post.ID, err)
+	}
+	if ok {
+		metrics.ReplyThreadCacheHits.Inc()
+		return replies, nil
+	}
+
+	metrics.ReplyThreadCacheMisses.Inc()
+	// The fill begins before the read, so that the writes committed after it cancel it
+	token, err := r.beginFill(ctx, post.ID)
+	if err != nil {
+		log.Printf("failed to begin filling thread of post %d: %v", post.ID, err)
+	}
+	thread, err := r.nextRepo.ListThread(ctx, post, maxCachedThreadReplies+1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(thread) > maxCachedThreadReplies {
+		return r.nextRepo.ListThread(ctx, post, limit, offset)
+	}
+
+	if token != "" {
+		AfterCommit(ctx, func(ctx context.Context) {
+			if err := r.cacheThread(ctx, post.ID, token, thread); err != nil {
+				log.Printf("failed to cache thread of post %d: %v", post.ID, err)
+			}
+		})
+	}
+	if int(offset) >= len(thread) {
+		return []sqlc.Reply{}, nil
+	}
+	return thread[offset:min(int(offset)+int(limit), len(thread))], nil
+}
+
+// GetReplyCount reads the count of the cached thread, or DB when the thread is not cached
+func (r *CachedReplyRepository) GetReplyCount(ctx context.Context, post sqlc.Post) (int64, error) {
+	count, err := r.rdb.HGet(ctx, fmt.Sprintf(threadCountsKeyPattern, post.ID), threadCountField).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		log.Printf("redis error on getting reply count of post %d: %v", post.ID, err)
+	}
+	return r.nextRepo.GetReplyCount(ctx, post)
+}
+
+// DeleteThread deletes the thread and drops it from cache, once its transaction is committed
+func (r *CachedReplyRepository) DeleteThread(ctx context.Context, post sqlc.Post) error {
+	if err := r.nextRepo.DeleteThread(ctx, post); err != nil {
+		return err
+	}
+
+	AfterCommit(ctx, func(ctx context.Context) {
+		r.dropThread(ctx, post.ID)
+	})
+	return nil
+}
+
+// listCachedThread reads a page of the thread of the post from cache, ok is false when the thread is
+// not cached or a reply of the page is missing
+func (r *CachedReplyRepository) listCachedThread(ctx context.Context, postID int64, limit, offset int32) ([]sqlc.Reply, bool, error) {
+	countsKey := fmt.Sprintf(threadCountsKeyPattern, postID)
+	var idsCmd *redis.StringSliceCmd
+	// The count tells a cached thread from a missing one, redis.Nil is returned for the latter
+	_, err := r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HGet(ctx, countsKey, threadCountField)
+		idsCmd = pipe.ZRange(ctx, fmt.Sprintf(threadRepliesKeyPattern, postID), int64(offset), int64(offset+limit-1))
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	ids := idsCmd.Val()
+	if len(ids) == 0 {
+		return []sqlc.Reply{}, true, nil
+	}
+
+	var bodiesCmd, countsCmd *redis.SliceCmd
+	_, err = r.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		bodiesCmd = pipe.HMGet(ctx, fmt.Sprintf(threadBodiesKeyPattern, postID), ids...)
+		countsCmd = pipe.HMGet(ctx, countsKey, ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	replies := make([]sqlc.Reply, 0, len(ids))
+	counts := countsCmd.Val()
+	for i, body := range bodiesCmd.Val() {
+		s, ok := body.(string)
+		if !ok {
+			return nil, false, nil
+		}
+		var reply sqlc.Reply
+		if err := json.Unmarshal([]byte(s), &reply); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal cached reply %s: %w", ids[i], err)
+		}
+		if s, ok := counts[i].(string); ok {
+			count, _ := strconv.ParseInt(s, 10, 32)
+			reply.ReplyCount = int32(count)
+		}
+		replies = append(replies, reply)
+	}
+	return replies, true, nil
+}
+
+// beginFill hands a token to the read loading the missing thread of the post
+func (r *CachedReplyRepository) beginFill(ctx context.Context, postID int64) (string, error) {
+	token, err := r.fillToken()
+	if err != nil {
+		return "", err
+	}
+	if err := r.rdb.Set(ctx, fmt.Sprintf(threadFillKeyPattern, postID), token, threadFillTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// cacheThread caches the thread of the post read from DB with the fill token, when the thread is still
+// missing and no write happened since the read began
+func (r *CachedReplyRepository) cacheThread(ctx context.Context, postID int64, token string, thread []sqlc.Reply) error {
+	args := make([]interface{}, 0, 3+4*len(thread))
+	args = append(args, token, cacheTTL.Milliseconds(), 0)
+	var live int64
+	for _, reply := range thread {
+		body, err := json.Marshal(reply)
+		if err != nil {
+			return fmt.Errorf("failed to marshal reply %d: %w", reply.ID, err)
+		}
+		args = append(args, reply.ID, replyScore(reply), body, reply.ReplyCount)
+		if !reply.DeletedAt.Valid {
+			live++
+		}
+	}
+	args[2] = live
+
+	if err := fillThreadScript.Run(ctx, r.rdb, threadKeys(postID), args...).Err(); err != nil {
+		return fmt.Errorf("script execution failed for thread of post %d: %w", postID, err)
+	}
+	return nil
+}
+
+func (r *CachedReplyRepository) dropThread(ctx context.Context, postID int64) {
+	if err := r.rdb.Del(ctx, threadKeys(postID)...).Err(); err != nil {
+		log.Printf("failed to drop cached thread of post %d: %v", postID, err)
+	}
+}
+
+// threadKeys returns the replies, bodies, counts and fill keys of the thread of the post
+func threadKeys(postID int64) []string {
+	return []string{
+		fmt.Sprintf(threadRepliesKeyPattern, postID),
+		fmt.Sprintf(threadBodiesKeyPattern, postID),
+		fmt.Sprintf(threadCountsKeyPattern, postID),
+		fmt.Sprintf(threadFillKeyPattern, postID),
+	}
+}
+
+func randomFillToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate fill token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// replyScore orders the replies of a thread by creation time. Ids of replies created in the same
+// microsecond break the tie, compared as strings like the ids of the same length Snowflake generates.
+func replyScore(reply sqlc.Reply) float64 {
+	return float64(reply.CreatedAt.UnixMicro())
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mockReplyRepository is kept here as ReplyDeletion would make the mocks package import repository
+type mockReplyRepository struct {
+	mock.Mock
+}
+
+func (m *mockReplyRepository) CreateReply(ctx context.Context, post sqlc.Post, parentID, userID int64, content string) (sqlc.Reply, error) {
+	args := m.Called(ctx, post, parentID, userID, content)
+	return args.Get(0).(sqlc.Reply), args.Error(1)
+}
+
+func (m *mockReplyRepository) DeleteReply(ctx context.Context, post sqlc.Post, replyID, userID int64) (ReplyDeletion, error) {
+	args := m.Called(ctx, post, replyID, userID)
+	return args.Get(0).(ReplyDeletion), args.Error(1)
+}
+
+func (m *mockReplyRepository) ListThread(ctx context.Context, post sqlc.Post, limit, offset int32) ([]sqlc.Reply, error) {
+	args := m.Called(ctx, post, limit, offset)
+	return args.Get(0).([]sqlc.Reply), args.Error(1)
+}
+
+func (m *mockReplyRepository) GetReplyCount(ctx context.Context, post sqlc.Post) (int64, error) {
+	args := m.Called(ctx, post)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockReplyRepository) DeleteThread(ctx context.Context, post sqlc.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
+}
+
+func testReply(id, parentID int64, createdAt time.Time) sqlc.Reply {
+	return sqlc.Reply{
+		ID: id, RootID: 100, RootUserID: 1, ParentID: parentID, UserID: 2,
+		Content: fmt.Sprintf("reply %d", id), CreatedAt: createdAt, UpdatedAt: createdAt,
+	}
+}
+
+func TestCachedReplyRepository_CreateReply(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+	reply := testReply(201, 200, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	body, _ := json.Marshal(reply)
+
+	mockRepo.On("CreateReply", mock.Anything, post, int64(200), int64(2), reply.Content).Return(reply, nil)
+	rdbMock.ExpectEvalSha(addReplyScript.Hash(), threadKeys(post.ID),
+		reply.ID, replyScore(reply), body, int64(200), maxCachedThreadReplies).SetVal(int64(1))
+
+	created, err := repo.CreateReply(context.Background(), post, 200, 2, reply.Content)
+	require.NoError(t, err)
+	assert.Equal(t, reply, created)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReplyRepository_CreateReply_RolledBack(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+	reply := testReply(201, post.ID, time.Now())
+
+	mockRepo.On("CreateReply", mock.Anything, post, post.ID, int64(2), reply.Content).Return(reply, nil)
+	manager := NewTxManager(func(ctx context.Context) (Transaction, error) { return &fakeTransaction{}, nil },
+		func(q sqlc.Querier) TxRepositories { return TxRepositories{Replies: repo} })
+
+	err := manager.WithinTx(context.Background(), func(ctx context.Context, repos TxRepositories) error {
+		if _, err := repos.Replies.CreateReply(ctx, post, post.ID, 2, reply.Content); err != nil {
+			return err
+		}
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	// No cache write for a reply that was never committed
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReplyRepository_DeleteReply(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	// A reply with replies is kept as a tombstone
+	tombstone := testReply(201, post.ID, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	tombstone.Content, tombstone.ReplyCount = "", 1
+	tombstone.DeletedAt = pgtype.Timestamptz{Time: tombstone.CreatedAt.Add(time.Hour), Valid: true}
+	body, _ := json.Marshal(tombstone)
+	mockRepo.On("DeleteReply", mock.Anything, post, int64(201), int64(2)).Return(ReplyDeletion{Tombstone: &tombstone}, nil)
+	rdbMock.ExpectEvalSha(removeReplyScript.Hash(), threadKeys(post.ID), int64(201), body, int64(0)).SetVal(int64(1))
+
+	_, err := repo.DeleteReply(context.Background(), post, 201, 2)
+	require.NoError(t, err)
+
+	// Deleting the last reply of a tombstone prunes it too
+	mockRepo.On("DeleteReply", mock.Anything, post, int64(202), int64(2)).Return(ReplyDeletion{DeletedIDs: []int64{202, 201}}, nil)
+	rdbMock.ExpectEvalSha(removeReplyScript.Hash(), threadKeys(post.ID), 0, "", int64(0), int64(202), int64(201)).SetVal(int64(1))
+
+	deletion, err := repo.DeleteReply(context.Background(), post, 202, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{202, 201}, deletion.DeletedIDs)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReplyRepository_ListThread_CacheHit(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+	createdAt := time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC)
+	first, second := testReply(201, post.ID, createdAt), testReply(202, 201, createdAt.Add(time.Minute))
+	firstBody, _ := json.Marshal(first)
+	secondBody, _ := json.Marshal(second)
+	keys := threadKeys(post.ID)
+
+	rdbMock.ExpectHGet(keys[2], threadCountField).SetVal("2")
+	rdbMock.ExpectZRange(keys[0], 0, 1).SetVal([]string{"201", "202"})
+	rdbMock.ExpectHMGet(keys[1], "201", "202").SetVal([]interface{}{string(firstBody), string(secondBody)})
+	rdbMock.ExpectHMGet(keys[2], "201", "202").SetVal([]interface{}{"1", "0"})
+
+	replies, err := repo.ListThread(context.Background(), post, 2, 0)
+	require.NoError(t, err)
+	// Reply counts are read from the counts hash, not the cached replies
+	first.ReplyCount = 1
+	assert.Equal(t, []sqlc.Reply{first, second}, replies)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertNotCalled(t, "ListThread", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCachedReplyRepository_ListThread_CacheMiss(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db).(*CachedReplyRepository)
+	repo.fillToken = func() (string, error) { return "token", nil }
+	post := sqlc.Post{ID: 100, UserID: 1}
+	createdAt := time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC)
+	tombstone, reply := testReply(201, post.ID, createdAt), testReply(202, 201, createdAt.Add(time.Minute))
+	tombstone.Content, tombstone.ReplyCount = "", 1
+	tombstone.DeletedAt = pgtype.Timestamptz{Time: createdAt.Add(time.Hour), Valid: true}
+	tombstoneBody, _ := json.Marshal(tombstone)
+	replyBody, _ := json.Marshal(reply)
+	keys := threadKeys(post.ID)
+
+	// redismock ends the pipeline at the missing count
+	rdbMock.ExpectHGet(keys[2], threadCountField).RedisNil()
+	rdbMock.ExpectSet(keys[3], "token", threadFillTTL).SetVal("OK")
+	mockRepo.On("ListThread", mock.Anything, post, int32(maxCachedThreadReplies+1), int32(0)).Return([]sqlc.Reply{tombstone, reply}, nil).
+		Run(func(mock.Arguments) {
+			// The fill begins before the read, for the writes committed meanwhile to cancel it
+			assert.NoError(t, rdbMock.ExpectationsWereMet())
+			// The tombstone is left out of the thread's count
+			rdbMock.ExpectEvalSha(fillThreadScript.Hash(), keys, "token", cacheTTL.Milliseconds(), int64(1),
+				tombstone.ID, replyScore(tombstone), tombstoneBody, int32(1),
+				reply.ID, replyScore(reply), replyBody, int32(0)).SetVal(int64(1))
+		})
+
+	replies, err := repo.ListThread(context.Background(), post, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []sqlc.Reply{reply}, replies)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCachedReplyRepository_ListThread_FillNotBegun(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db).(*CachedReplyRepository)
+	repo.fillToken = func() (string, error) { return "token", nil }
+	post := sqlc.Post{ID: 100, UserID: 1}
+	reply := testReply(201, post.ID, time.Now())
+	keys := threadKeys(post.ID)
+
+	rdbMock.ExpectHGet(keys[2], threadCountField).RedisNil()
+	rdbMock.ExpectSet(keys[3], "token", threadFillTTL).SetErr(errors.New("connection refused"))
+	mockRepo.On("ListThread", mock.Anything, post, int32(maxCachedThreadReplies+1), int32(0)).Return([]sqlc.Reply{reply}, nil)
+
+	// Without a token the thread is served from DB, and not cached as a write could not cancel the fill
+	replies, err := repo.ListThread(context.Background(), post, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []sqlc.Reply{reply}, replies)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCachedReplyRepository_ListThread_TooLargeToCache(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db).(*CachedReplyRepository)
+	repo.fillToken = func() (string, error) { return "token", nil }
+	post := sqlc.Post{ID: 100, UserID: 1}
+	keys := threadKeys(post.ID)
+	thread := make([]sqlc.Reply, maxCachedThreadReplies+1)
+	page := []sqlc.Reply{testReply(201, post.ID, time.Now())}
+
+	rdbMock.ExpectHGet(keys[2], threadCountField).RedisNil()
+	rdbMock.ExpectSet(keys[3], "token", threadFillTTL).SetVal("OK")
+	mockRepo.On("ListThread", mock.Anything, post, int32(maxCachedThreadReplies+1), int32(0)).Return(thread, nil)
+	mockRepo.On("ListThread", mock.Anything, post, int32(20), int32(20)).Return(page, nil)
+
+	replies, err := repo.ListThread(context.Background(), post, 20, 20)
+	require.NoError(t, err)
+	assert.Equal(t, page, replies)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCachedReplyRepository_GetReplyCount(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+	countsKey := fmt.Sprintf(threadCountsKeyPattern, post.ID)
+
+	rdbMock.ExpectHGet(countsKey, threadCountField).SetVal("7")
+	count, err := repo.GetReplyCount(context.Background(), post)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+
+	rdbMock.ExpectHGet(countsKey, threadCountField).RedisNil()
+	mockRepo.On("GetReplyCount", mock.Anything, post).Return(int64(3), nil)
+	count, err = repo.GetReplyCount(context.Background(), post)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestCachedReplyRepository_DeleteThread(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	mockRepo := new(mockReplyRepository)
+	repo := NewCachedReplyRepository(mockRepo, db)
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	mockRepo.On("DeleteThread", mock.Anything, post).Return(nil)
+	rdbMock.ExpectDel(threadKeys(post.ID)...).SetVal(3)
+
+	require.NoError(t, repo.DeleteThread(context.Background(), post))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,89 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBReplyRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewDBReplyRepository(testQueries)
+	author := createTestUser(t, ctx)
+	user := createTestUser(t, ctx)
+	post, err := NewDBPostRepository(testQueries).CreatePost(ctx, sqlc.CreatePostParams{UserID: author.ID, Content: "reply to me"})
+	require.NoError(t, err)
+
+	listThread := func(t *testing.T) []sqlc.Reply {
+		replies, err := repo.ListThread(ctx, post, 10, 0)
+		require.NoError(t, err)
+		return replies
+	}
+	replyCount := func(t *testing.T) int64 {
+		count, err := repo.GetReplyCount(ctx, post)
+		require.NoError(t, err)
+		return count
+	}
+
+	var top, nested sqlc.Reply
+	t.Run("creates_replies", func(t *testing.T) {
+		assert.Zero(t, replyCount(t))
+
+		top, err = repo.CreateReply(ctx, post, post.ID, user.ID, "top")
+		require.NoError(t, err)
+		nested, err = repo.CreateReply(ctx, post, top.ID, author.ID, "nested")
+		require.NoError(t, err)
+		assert.Equal(t, top.ID, nested.ParentID)
+
+		replies := listThread(t)
+		require.Len(t, replies, 2)
+		assert.Equal(t, top.ID, replies[0].ID)
+		assert.Equal(t, int32(1), replies[0].ReplyCount)
+		assert.Equal(t, int64(2), replyCount(t))
+
+		_, err = repo.CreateReply(ctx, post, 1, user.ID, "to nowhere")
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("keeps_tombstone_of_reply_with_replies", func(t *testing.T) {
+		_, err := repo.DeleteReply(ctx, post, top.ID, author.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows, "not the author of the reply")
+
+		deletion, err := repo.DeleteReply(ctx, post, top.ID, user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, deletion.Tombstone)
+		assert.Empty(t, deletion.Tombstone.Content)
+		assert.True(t, deletion.Tombstone.DeletedAt.Valid)
+		assert.Len(t, listThread(t), 2)
+		assert.Equal(t, int64(1), replyCount(t))
+
+		_, err = repo.DeleteReply(ctx, post, top.ID, user.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows, "already deleted")
+		_, err = repo.CreateReply(ctx, post, top.ID, user.ID, "to a tombstone")
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("prunes_tombstone_left_without_replies", func(t *testing.T) {
+		deletion, err := repo.DeleteReply(ctx, post, nested.ID, author.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{nested.ID, top.ID}, deletion.DeletedIDs)
+		assert.Zero(t, deletion.ParentID)
+		assert.Empty(t, listThread(t))
+		assert.Zero(t, replyCount(t))
+	})
+
+	t.Run("deletes_thread", func(t *testing.T) {
+		_, err := repo.CreateReply(ctx, post, post.ID, user.ID, "again")
+		require.NoError(t, err)
+
+		require.NoError(t, repo.DeleteThread(ctx, post))
+		assert.Empty(t, listThread(t))
+		assert.Zero(t, replyCount(t))
+	})
+}
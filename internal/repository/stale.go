@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/internal/breaker"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// Last known good copies, kept longer than the regular cache and only read while DB is unavailable
+	stalePostKeyPattern      = "stale:post:%d"
+	staleUserPostsKeyPattern = "{user:%d}:posts:stale"
+)
+
+// ErrDBUnavailable is returned when DB cannot be reached and no stale copy could stand in for it
+var ErrDBUnavailable = errors.New("database is unavailable")
+
+// isDBUnavailable reports whether err comes from DB being unreachable or too slow, rather than
+// from the query itself. The deadline of the caller is told apart by readDB.
+func isDBUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrDBUnavailable) || errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr)
+}
+
+// readDB runs a DB read unless the DB health circuit, if any, is open. Unavailability errors are
+// wrapped with ErrDBUnavailable and count against the circuit. A read failing as ctx is done, such as
+// when the request deadline is over, tells nothing about DB and counts as cancelled.
+func readDB(ctx context.Context, health *breaker.Breaker, query func(ctx context.Context) error) error {
+	if health != nil && !health.Allow() {
+		return ErrDBUnavailable
+	}
+
+	err := query(ctx)
+	abandoned := err != nil && ctx.Err() != nil
+	unavailable := !abandoned && isDBUnavailable(err)
+	if health != nil {
+		switch {
+		case abandoned || errors.Is(err, context.Canceled):
+			health.Cancel()
+		case unavailable:
+			health.Failure()
+		default:
+			health.Success()
+		}
+	}
+	if unavailable {
+		return fmt.Errorf("%w: %w", ErrDBUnavailable, err)
+	}
+	return err
+}
+
+type staleReportKey struct{}
+
+// StaleReport records whether a request was answered with stale copies
+type StaleReport struct {
+	stale atomic.Bool
+}
+
+// WithStaleReport attaches a new StaleReport to the context
+func WithStaleReport(ctx context.Context) (context.Context, *StaleReport) {
+	report := &StaleReport{}
+	return context.WithValue(ctx, staleReportKey{}, report), report
+}
+
+// Stale reports whether some data was served from stale copies
+func (r *StaleReport) Stale() bool {
+	return r != nil && r.stale.Load()
+}
+
+// RecordStale marks the request of ctx as answered with stale copies
+func RecordStale(ctx context.Context) {
+	if report, ok := ctx.Value(staleReportKey{}).(*StaleReport); ok {
+		report.stale.Store(true)
+	}
+}
+
+// NewDBHealth creates the circuit tracking DB availability, exported as the db_circuit_state gauge
+func NewDBHealth(settings breaker.Settings) *breaker.Breaker {
+	return breaker.New(settings, func(state breaker.State) {
+		metrics.DBCircuitState.Set(float64(state))
+	})
+}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+)
+
+const (
+	// trendingBucketKeyPattern scores the engagement of the posts of a shard in a time bucket, by shard
+	// and bucket start. Every shard has its own hash tag, so that shards spread over the cluster slots.
+	trendingBucketKeyPattern = "{trending:%d}:bucket:%d"
+	// trendingWindowKeyPattern is the decayed union of the buckets of a shard in a window, by shard and
+	// window. It shares the hash tag of the buckets it is built from.
+	trendingWindowKeyPattern = "{trending:%d}:window:%s"
+
+	// trendingWindowSize bounds the posts kept in a window of a shard
+	trendingWindowSize = 1000
+	// MaxTrendingPosts bounds the trending posts returned at a time
+	MaxTrendingPosts = 100
+)
+
+// TrendingWindows are the windows trending posts are ranked over, by name
+var TrendingWindows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"24h": 24 * time.Hour,
+}
+
+// refreshTrendingScript builds a window of a shard from its buckets, weighted by their decay, and keeps
+// its top posts.
+//
+// KEYS[1] window, KEYS[2..] buckets
+// ARGV[1] posts kept, ARGV[2] window TTL in seconds, ARGV[3..] weight of every bucket
+var refreshTrendingScript = redis.NewScript(`
+local args = {'ZUNIONSTORE', KEYS[1], #KEYS - 1}
+for i = 2, #KEYS do
+  args[#args + 1] = KEYS[i]
+end
+args[#args + 1] = 'WEIGHTS'
+for i = 3, #ARGV do
+  args[#args + 1] = ARGV[i]
+end
+redis.call(unpack(args))
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[1]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// TrendingOptions tunes RedisTrendingRepository
+type TrendingOptions struct {
+	// Shards is the number of shards the engagement of posts is spread over
+	Shards int
+	// BucketSize is the time covered by a bucket
+	BucketSize time.Duration
+	// CacheTTL is how long a ranking is served from memory
+	CacheTTL time.Duration
+}
+
+// TrendingEntry is a trending post and its decayed engagement score
+type TrendingEntry struct {
+	PostID int64
+	Score  float64
+}
+
+// TrendingRepository ranks posts by their engagement over sliding time windows
+type TrendingRepository interface {
+	// AddEngagement adds weight to the engagement of the post now
+	AddEngagement(ctx context.Context, postID int64, weight float64) error
+	// Refresh rebuilds every window of every shard from the buckets it covers
+	Refresh(ctx context.Context) error
+	// ListTrending returns at most limit posts with the highest engagement in the window, highest first
+	ListTrending(ctx context.Context, window string, limit int) ([]TrendingEntry, error)
+}
+
+// RedisTrendingRepository keeps the engagement of posts in time-bucketed sorted sets, sharded by post
+// id. Refresh unions the buckets of every window, decayed by age so that the score halves every
+// quarter of the window. Reads gather the top of the window of every shard through the aggregator,
+// merge them and keep the ranking in memory for CacheTTL.
+type RedisTrendingRepository struct {
+	rdb  redis.Cmdable
+	agg  *aggregator.Aggregator
+	opts TrendingOptions
+	now  func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]trendingRanking
+}
+
+// trendingRanking is a ranking of a window cached in memory
+type trendingRanking struct {
+	entries []TrendingEntry
+	expires time.Time
+}
+
+// NewTrendingRepository creates a new instance of RedisTrendingRepository
+func NewTrendingRepository(rdb redis.Cmdable, agg *aggregator.Aggregator, opts TrendingOptions) TrendingRepository {
+	if opts.Shards < 1 {
+		opts.Shards = 1
+	}
+	if opts.BucketSize <= 0 {
+		opts.BucketSize = 5 * time.Minute
+	}
+	return &RedisTrendingRepository{
+		rdb:   rdb,
+		agg:   agg,
+		opts:  opts,
+		now:   time.Now,
+		cache: make(map[string]trendingRanking),
+	}
+}
+
+func (r *RedisTrendingRepository) AddEngagement(ctx context.Context, postID int64, weight float64) error {
+	bucket := r.now().Truncate(r.opts.BucketSize).Unix()
+	key := fmt.Sprintf(trendingBucketKeyPattern, r.shardOf(postID), bucket)
+
+	pipe := r.rdb.Pipeline()
+	pipe.ZIncrBy(ctx, key, weight, strconv.FormatInt(postID, 10))
+	pipe.Expire(ctx, key, r.bucketTTL())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to add engagement of post %d: %w", postID, err)
+	}
+	return nil
+}
+
+// Refresh rebuilds the windows with one pipeline per cluster node. Windows are replaced atomically, so
+// readers never see one half built.
+func (r *RedisTrendingRepository) Refresh(ctx context.Context) error {
+	type window struct {
+		shard int
+		size  time.Duration
+	}
+	windows := make(map[string]window, r.opts.Shards*len(TrendingWindows))
+	keys := make([]string, 0, r.opts.Shards*len(TrendingWindows))
+	for shard := 0; shard < r.opts.Shards; shard++ {
+		for _, name := range slices.Sorted(maps.Keys(TrendingWindows)) {
+			key := fmt.Sprintf(trendingWindowKeyPattern, shard, name)
+			windows[key] = window{shard: shard, size: TrendingWindows[name]}
+			keys = append(keys, key)
+		}
+	}
+
+	now := r.now()
+	res := r.agg.Pipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		w := windows[key]
+		bucketKeys, weights := r.windowBuckets(w.shard, w.size, now)
+		args := append([]interface{}{trendingWindowSize, int64(r.bucketTTL().Seconds())}, weights...)
+		return refreshTrendingScript.Eval(ctx, pipe, append([]string{key}, bucketKeys...), args...)
+	})
+
+	failed := 0
+	for _, cmd := range res.Values {
+		if cmd == nil || cmd.Err() != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		metrics.TrendingRefreshes.WithLabelValues("failed").Inc()
+		return fmt.Errorf("failed to refresh %d of %d trending windows", failed, len(keys))
+	}
+	metrics.TrendingRefreshes.WithLabelValues("refreshed").Inc()
+	return nil
+}
+
+// ListTrending serves the ranking of the window from memory, or gathers it from every shard. A ranking
+// missing unavailable shards is only returned to partial reads, and never kept in memory.
+func (r *RedisTrendingRepository) ListTrending(ctx context.Context, window string, limit int) ([]TrendingEntry, error) {
+	if _, ok := TrendingWindows[window]; !ok {
+		return nil, fmt.Errorf("unknown trending window %q", window)
+	}
+
+	r.mu.Lock()
+	cached, ok := r.cache[window]
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		metrics.TrendingReads.WithLabelValues("memory").Inc()
+		return firstTrending(cached.entries, limit), nil
+	}
+
+	keys := make([]string, r.opts.Shards)
+	for shard := range keys {
+		keys[shard] = fmt.Sprintf(trendingWindowKeyPattern, shard, window)
+	}
+	res := r.agg.ReadPipeline(ctx, keys, func(ctx context.Context, pipe redis.Pipeliner, key string) redis.Cmder {
+		return pipe.ZRevRangeWithScores(ctx, key, 0, MaxTrendingPosts-1)
+	})
+	if res.Partial() {
+		if aggregator.ConsistencyFrom(ctx) != aggregator.Partial {
+			return nil, fmt.Errorf("failed to read trending posts of %d of %d shards: %w", len(res.Failed), res.Shards, res.Failed[0])
+		}
+		aggregator.RecordPartial(ctx, res.Failed)
+	}
+
+	lists := make([][]TrendingEntry, 0, len(keys))
+	for i, cmd := range res.Values {
+		if cmd == nil {
+			continue
+		}
+		members, err := cmd.(*redis.ZSliceCmd).Result()
+		if err != nil && err != redis.Nil {
+			if res.Partial() {
+				// Left out with the failed shards
+				continue
+			}
+			return nil, fmt.Errorf("failed to read trending posts of %s: %w", keys[i], err)
+		}
+		list := make([]TrendingEntry, 0, len(members))
+		for _, z := range members {
+			member, _ := z.Member.(string)
+			if postID, err := strconv.ParseInt(member, 10, 64); err == nil {
+				list = append(list, TrendingEntry{PostID: postID, Score: z.Score})
+			}
+		}
+		lists = append(lists, list)
+	}
+	entries := aggregator.Merge(lists, trendingBefore, MaxTrendingPosts)
+	metrics.TrendingReads.WithLabelValues("redis").Inc()
+
+	if !res.Partial() && r.opts.CacheTTL > 0 {
+		r.mu.Lock()
+		r.cache[window] = trendingRanking{entries: entries, expires: r.now().Add(r.opts.CacheTTL)}
+		r.mu.Unlock()
+	}
+	return firstTrending(entries, limit), nil
+}
+
+// windowBuckets returns the buckets of the shard covered by the window ending now, newest first, and
+// their decay weights
+func (r *RedisTrendingRepository) windowBuckets(shard int, window time.Duration, now time.Time) ([]string, []interface{}) {
+	halfLife := window / 4
+	current := now.Truncate(r.opts.BucketSize)
+	n := max(int(window/r.opts.BucketSize), 1)
+	keys := make([]string, n)
+	weights := make([]interface{}, n)
+	for i := range keys {
+		start := current.Add(-time.Duration(i) * r.opts.BucketSize)
+		keys[i] = fmt.Sprintf(trendingBucketKeyPattern, shard, start.Unix())
+		weights[i] = math.Pow(0.5, float64(now.Sub(start))/float64(halfLife))
+	}
+	return keys, weights
+}
+
+// bucketTTL keeps a bucket for as long as the longest window covers it
+func (r *RedisTrendingRepository) bucketTTL() time.Duration {
+	var longest time.Duration
+	for _, size := range TrendingWindows {
+		longest = max(longest, size)
+	}
+	return longest + r.opts.BucketSize
+}
+
+// shardOf hashes the post id like the database shards do, as snowflake IDs modulo the shards mostly
+// land on the same shard
+func (r *RedisTrendingRepository) shardOf(postID int64) int {
+	return shard.Of(postID, r.opts.Shards)
+}
+
+// trendingBefore orders entries by score, highest first, then by post id, newest first
+func trendingBefore(a, b TrendingEntry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.PostID > b.PostID
+}
+
+func firstTrending(entries []TrendingEntry, limit int) []TrendingEntry {
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return append([]TrendingEntry(nil), entries...)
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrendingRepository(db redis.Cmdable, opts TrendingOptions, now time.Time) *RedisTrendingRepository {
+	repo := NewTrendingRepository(db, aggregator.New(db, aggregator.Options{}), opts).(*RedisTrendingRepository)
+	repo.now = func() time.Time { return now }
+	return repo
+}
+
+func TestRedisTrendingRepository_AddEngagement(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	now := time.Date(2026, time.October, 18, 14, 27, 0, 0, time.UTC)
+	repo := newTestTrendingRepository(db, TrendingOptions{Shards: 4}, now)
+
+	key := fmt.Sprintf(trendingBucketKeyPattern, 3, time.Date(2026, time.October, 18, 14, 25, 0, 0, time.UTC).Unix())
+	rdbMock.ExpectZIncrBy(key, 5, "102").SetVal(5)
+	rdbMock.ExpectExpire(key, 24*time.Hour+5*time.Minute).SetVal(true)
+
+	require.NoError(t, repo.AddEngagement(context.Background(), 102, 5))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRedisTrendingRepository_ShardOf(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	repo := newTestTrendingRepository(db, TrendingOptions{Shards: 4}, time.Now())
+
+	// Snowflake IDs of posts created in different milliseconds by one node share their low bits, a
+	// millisecond timestamp above a node and a zero sequence, they must still spread over the shards
+	counts := make([]int, 4)
+	for ms := int64(0); ms < 4000; ms++ {
+		counts[repo.shardOf(ms<<22|1<<12)]++
+	}
+	for shard, count := range counts {
+		assert.InDelta(t, 1000, count, 150, "shard %d", shard)
+	}
+}
+
+func TestRedisTrendingRepository_Refresh(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	now := time.Date(2026, time.October, 18, 14, 30, 0, 0, time.UTC)
+	repo := newTestTrendingRepository(db, TrendingOptions{Shards: 1, BucketSize: time.Hour}, now)
+	ttl := int64((25 * time.Hour).Seconds())
+
+	// The hour bucket is half an hour old, two half-lives of the hour window
+	oneHour := fmt.Sprintf(trendingWindowKeyPattern, 0, "1h")
+	bucket := fmt.Sprintf(trendingBucketKeyPattern, 0, time.Date(2026, time.October, 18, 14, 0, 0, 0, time.UTC).Unix())
+	rdbMock.CustomMatch(evalKeys(2)).ExpectEval("", []string{oneHour, bucket}, trendingWindowSize, ttl, 0.25).SetVal(int64(1))
+	for _, name := range []string{"24h", "6h"} {
+		key := fmt.Sprintf(trendingWindowKeyPattern, 0, name)
+		buckets, weights := repo.windowBuckets(0, TrendingWindows[name], now)
+		args := append([]interface{}{trendingWindowSize, ttl}, weights...)
+		rdbMock.CustomMatch(evalKeys(1+len(buckets))).ExpectEval("", append([]string{key}, buckets...), args...).SetVal(int64(1))
+	}
+
+	require.NoError(t, repo.Refresh(context.Background()))
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRedisTrendingRepository_ListTrending(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	now := time.Date(2026, time.October, 18, 14, 30, 0, 0, time.UTC)
+	repo := newTestTrendingRepository(db, TrendingOptions{Shards: 2, CacheTTL: 15 * time.Second}, now)
+
+	rdbMock.ExpectZRevRangeWithScores(fmt.Sprintf(trendingWindowKeyPattern, 0, "1h"), 0, MaxTrendingPosts-1).SetVal([]redis.Z{
+		{Member: "100", Score: 9},
+		{Member: "102", Score: 2},
+	})
+	rdbMock.ExpectZRevRangeWithScores(fmt.Sprintf(trendingWindowKeyPattern, 1, "1h"), 0, MaxTrendingPosts-1).SetVal([]redis.Z{
+		{Member: "101", Score: 9},
+		{Member: "103", Score: 4},
+	})
+
+	entries, err := repo.ListTrending(context.Background(), "1h", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []TrendingEntry{{PostID: 101, Score: 9}, {PostID: 100, Score: 9}, {PostID: 103, Score: 4}}, entries)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+
+	// Served from memory until the ranking expires
+	entries, err = repo.ListTrending(context.Background(), "1h", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []TrendingEntry{{PostID: 101, Score: 9}}, entries)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+
+	_, err = repo.ListTrending(context.Background(), "1w", 1)
+	assert.Error(t, err)
+}
+
+func TestRedisTrendingRepository_ListTrending_ShardDown(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	now := time.Date(2026, time.October, 18, 14, 30, 0, 0, time.UTC)
+	repo := newTestTrendingRepository(db, TrendingOptions{Shards: 1, CacheTTL: 15 * time.Second}, now)
+	key := fmt.Sprintf(trendingWindowKeyPattern, 0, "6h")
+
+	rdbMock.ExpectZRevRangeWithScores(key, 0, MaxTrendingPosts-1).SetErr(errors.New("connection refused"))
+	_, err := repo.ListTrending(context.Background(), "6h", 10)
+	assert.Error(t, err)
+
+	// A partial read leaves the shard out and is not kept in memory
+	ctx, report := aggregator.WithPartialReport(aggregator.WithConsistency(context.Background(), aggregator.Partial))
+	rdbMock.ExpectZRevRangeWithScores(key, 0, MaxTrendingPosts-1).SetErr(errors.New("connection refused"))
+	entries, err := repo.ListTrending(ctx, "6h", 10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.True(t, report.Partial())
+
+	rdbMock.ExpectZRevRangeWithScores(key, 0, MaxTrendingPosts-1).SetVal([]redis.Z{{Member: "100", Score: 1}})
+	entries, err = repo.ListTrending(context.Background(), "6h", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []TrendingEntry{{PostID: 100, Score: 1}}, entries)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
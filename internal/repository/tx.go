@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// Transaction is a sqlc.Querier whose queries run in one database transaction
+type Transaction interface {
+	sqlc.Querier
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// TxBeginner begins a Transaction
+type TxBeginner func(ctx context.Context) (Transaction, error)
+
+// TxRepositories are the repositories of a unit of work, running their queries in its transaction
+type TxRepositories struct {
+	Users     UserRepository
+	Posts     PostRepository
+	Outbox    OutboxRepository
+	Reactions ReactionRepository
+	Replies   ReplyRepository
+}
+
+// TxManager runs units of work spanning several repositories atomically
+type TxManager interface {
+	// WithinTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+	// Cache writes of the repositories are deferred until the transaction is committed.
+	WithinTx(ctx context.Context, fn func(ctx context.Context, repos TxRepositories) error) error
+}
+
+type txManager struct {
+	begin TxBeginner
+	repos func(q sqlc.Querier) TxRepositories
+}
+
+// NewTxManager creates a new TxManager beginning transactions with begin.
+// repos builds the repositories, cache decorators included, over the queries of a transaction.
+func NewTxManager(begin TxBeginner, repos func(q sqlc.Querier) TxRepositories) TxManager {
+	return &txManager{begin: begin, repos: repos}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context, repos TxRepositories) error) error {
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	hooks := &afterCommitHooks{}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback(ctx, tx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, afterCommitKey{}, hooks), m.repos(tx)); err != nil {
+		rollback(ctx, tx)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		rollback(ctx, tx)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Hooks see the caller's context, so that their own cache writes are not deferred again
+	hooks.run(ctx)
+	return nil
+}
+
+// rollback rolls tx back even when ctx is done, as its connection must be released
+func rollback(ctx context.Context, tx Transaction) {
+	if err := tx.Rollback(context.WithoutCancel(ctx)); err != nil {
+		log.Printf("failed to roll back transaction: %v", err)
+	}
+}
+
+type afterCommitKey struct{}
+
+// afterCommitHooks collects the side effects of a transaction
+type afterCommitHooks struct {
+	mu    sync.Mutex
+	hooks []func(ctx context.Context)
+}
+
+func (h *afterCommitHooks) add(fn func(ctx context.Context)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, fn)
+}
+
+func (h *afterCommitHooks) run(ctx context.Context) {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.hooks = nil
+	h.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(ctx)
+	}
+}
+
+// AfterCommit runs fn once the transaction of ctx is committed, and never if it is rolled back.
+// Outside of a transaction fn runs right away. Cache writes go through it so that a rolled back
+// transaction never leaves cache entries for rows that do not exist.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if hooks, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks); ok {
+		hooks.add(fn)
+		return
+	}
+	fn(ctx)
+}
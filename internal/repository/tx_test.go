@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransaction creates posts in memory and records how it ended
+type fakeTransaction struct {
+	sqlc.Querier
+	createdAt  time.Time
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTransaction) CreatePost(_ context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error) {
+	return sqlc.Post{ID: arg.ID, UserID: arg.UserID, Content: arg.Content, CreatedAt: tx.createdAt}, nil
+}
+
+func (tx *fakeTransaction) Commit(context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTransaction) Rollback(context.Context) error {
+	tx.rolledBack = true
+	return nil
+}
+
+func TestTxManager_WithinTx(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	agg := aggregator.New(db, aggregator.Options{})
+	createdAt := time.Now()
+
+	var tx *fakeTransaction
+	manager := NewTxManager(func(context.Context) (Transaction, error) {
+		tx = &fakeTransaction{createdAt: createdAt}
+		return tx, nil
+	}, func(q sqlc.Querier) TxRepositories {
+		return TxRepositories{
+			Users: NewDBUserRepository(q),
+			Posts: NewCachedPostRepository(NewDBPostRepository(q), db, agg, CachedPostOptions{}),
+		}
+	})
+
+	expectCachePost := func(post sqlc.Post) {
+		postJSON, _ := json.Marshal(post)
+		userPostsKey := fmt.Sprintf(userPostsKeyPattern, post.UserID)
+		versionKey := fmt.Sprintf(userPostsVersionKeyPattern, post.UserID)
+		rdbMock.ExpectSet(fmt.Sprintf(postKeyGenericPattern, post.ID), postJSON, cacheTTL).SetVal("OK")
+		rdbMock.ExpectZAdd(userPostsKey, &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: post.ID}).SetVal(1)
+		rdbMock.ExpectExpire(userPostsKey, cacheTTL).SetVal(true)
+		rdbMock.CustomMatch(anyArgsAfterKey).ExpectSetNX(versionKey, nil, timelineVersionTTL).SetVal(false)
+		rdbMock.ExpectIncr(versionKey).SetVal(2)
+		rdbMock.ExpectExpire(versionKey, timelineVersionTTL).SetVal(true)
+	}
+
+	t.Run("cache_is_written_after_commit", func(t *testing.T) {
+		post := sqlc.Post{ID: 100, UserID: 1, Content: "committed", CreatedAt: createdAt}
+		expectCachePost(post)
+
+		err := manager.WithinTx(context.Background(), func(ctx context.Context, repos TxRepositories) error {
+			_, err := repos.Posts.CreatePost(ctx, sqlc.CreatePostParams{ID: post.ID, UserID: post.UserID, Content: post.Content})
+			require.NoError(t, err)
+			assert.Error(t, rdbMock.ExpectationsWereMet(), "cache written before commit")
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, tx.committed)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("rollback_leaves_no_cache_entry", func(t *testing.T) {
+		post := sqlc.Post{ID: 101, UserID: 1, Content: "rolled back", CreatedAt: createdAt}
+		expectCachePost(post)
+		defer rdbMock.ClearExpect()
+
+		failure := errors.New("second step failed")
+		err := manager.WithinTx(context.Background(), func(ctx context.Context, repos TxRepositories) error {
+			_, err := repos.Posts.CreatePost(ctx, sqlc.CreatePostParams{ID: post.ID, UserID: post.UserID, Content: post.Content})
+			require.NoError(t, err)
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.True(t, tx.rolledBack)
+		assert.False(t, tx.committed)
+		// None of the cache writes of the post happened
+		assert.Error(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("panic_rolls_back", func(t *testing.T) {
+		assert.Panics(t, func() {
+			_ = manager.WithinTx(context.Background(), func(context.Context, TxRepositories) error {
+				panic("boom")
+			})
+		})
+		assert.True(t, tx.rolledBack)
+	})
+}
+
+func TestAfterCommit_OutsideTx(t *testing.T) {
+	ran := false
+	AfterCommit(context.Background(), func(context.Context) { ran = true })
+	assert.True(t, ran)
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+)
+
+// Kinds of viewed things, counted under the hash tag of their own kind
+const (
+	ViewKindPost     = "post"
+	ViewKindTimeline = "user"
+)
+
+// Granularities of the unique view rollups
+const (
+	ViewsByHour = "hour"
+	ViewsByDay  = "day"
+)
+
+const (
+	// viewsKeyPattern is the HyperLogLog of the viewers since the last rollup, by kind and id. The
+	// rollups share its hash tag, so that a rollup merges within a slot.
+	viewsKeyPattern = "{%s:%d}:views"
+	// viewsRollupKeyPattern is the HyperLogLog of the viewers of a bucket, by kind, id, granularity
+	// and bucket start
+	viewsRollupKeyPattern = "{%s:%d}:views:%s:%s"
+	// dirtyViewsKeyPattern scores the viewed things of a shard whose viewers were not rolled up yet,
+	// members being "<kind>:<id>". Every shard has its own hash tag, so that views do not all land on
+	// one node.
+	dirtyViewsKeyPattern = "{views:dirty:%d}"
+
+	hourBucketLayout = "2006010215"
+	dayBucketLayout  = "20060102"
+)
+
+// rollupViewsScript merges the viewers since the last rollup into the hourly and daily rollups and
+// clears them. Merging is idempotent, so a rollup interrupted by a crash is safely run again.
+//
+// KEYS[1] viewers, KEYS[2] hourly rollup, KEYS[3] daily rollup
+// ARGV[1] hourly TTL in seconds, ARGV[2] daily TTL in seconds
+var rollupViewsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+redis.call('PFMERGE', KEYS[2], KEYS[2], KEYS[1])
+redis.call('EXPIRE', KEYS[2], ARGV[1])
+redis.call('PFMERGE', KEYS[3], KEYS[3], KEYS[1])
+redis.call('EXPIRE', KEYS[3], ARGV[2])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// ViewOptions tunes how long the unique view rollups are kept, and how the viewed things are marked
+type ViewOptions struct {
+	// HourlyRetention is how long an hourly rollup is kept
+	HourlyRetention time.Duration
+	// DailyRetention is how long a daily rollup is kept
+	DailyRetention time.Duration
+	// DirtyShards is the number of shards the viewed things to roll up are marked in
+	DirtyShards int
+}
+
+// ViewBucket is the approximate number of distinct viewers in the hour or day starting at Start
+type ViewBucket struct {
+	Start   time.Time
+	Viewers int64
+}
+
+// ViewRepository counts the distinct users viewing posts and timelines with HyperLogLogs
+type ViewRepository interface {
+	// RecordView adds the viewer to the viewers of the post or timeline, by kind and id, and reports
+	// whether the viewer is new since the last rollup
+	RecordView(ctx context.Context, kind string, id, viewerID int64) (bool, error)
+	// CountViews returns the distinct viewers of the post or timeline in the last buckets hours or days,
+	// newest first, along with the distinct viewers across all of them
+	CountViews(ctx context.Context, kind string, id int64, granularity string, buckets int) ([]ViewBucket, int64, error)
+	// Rollup merges the viewers of at most limit posts and timelines into their rollups and returns
+	// the number of them handled
+	Rollup(ctx context.Context, limit int) (int, error)
+}
+
+// RedisViewRepository keeps the viewers in Redis, next to the other keys of the post or user. Views are
+// attributed to the hour and day they are rolled up in, which trails the view by at most a rollup
+// interval. Rollups gather the viewed things of every dirty shard through the aggregator.
+type RedisViewRepository struct {
+	rdb  redis.Cmdable
+	agg  *aggregator.Aggregator
+	opts ViewOptions
+	now  func() time.Time
+}
+
+// NewViewRepository creates a new instance of RedisViewRepository
+func NewViewRepository(rdb redis.Cmdable, agg *aggregator.Aggregator, opts ViewOptions) ViewRepository {
+	if opts.HourlyRetention <= 0 {
+		opts.HourlyRetention = 7 * 24 * time.Hour
+	}
+	if opts.DailyRetention <= 0 {
+		opts.DailyRetention = 90 * 24 * time.Hour
+	}
+	if opts.DirtyShards < 1 {
+		opts.DirtyShards = 1
+	}
+	return &RedisViewRepository{rdb: rdb, agg: agg, opts: opts, now: time.Now}
+}
+
+// RecordView reports a new viewer when PFADD changes the HyperLogLog, so a viewer seen before may rarely
+// be reported as new, and the other way around
+func (r *RedisViewRepository) RecordView(ctx context.Context, kind string, id, viewerID int64) (bool, error) {
+	pipe := r.rdb.Pipeline()
+	added := pipe.PFAdd(ctx, fmt.Sprintf(viewsKeyPattern, kind, id), viewerID)
+	// The mark changes the score after the viewer is added, so that a rollup that read the score before
+	// does not unmark it
+	pipe.ZIncrBy(ctx, r.dirtyViewsKey(id), 1, dirtyViewsMember(kind, id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record view of %s %d: %w", kind, id, err)
+	}
+	metrics.ViewsRecorded.WithLabelValues(kind).Inc()
+	return added.Val() == 1, nil
+}
+
+// CountViews counts the rollups of every bucket, the current one along with the viewers not rolled up yet
+func (r *RedisViewRepository) CountViews(ctx context.Context, kind string, id int64, granularity string, buckets int) ([]ViewBucket, int64, error) {
+	step, layout, err := viewsBucketOf(granularity)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := r.now().UTC()
+	start := now.Truncate(step)
+	live := fmt.Sprintf(viewsKeyPattern, kind, id)
+	keys := make([]string, buckets)
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, buckets)
+	for i := range keys {
+		bucket := start.Add(-time.Duration(i) * step)
+		keys[i] = fmt.Sprintf(viewsRollupKeyPattern, kind, id, granularity, bucket.Format(layout))
+		if i == 0 {
+			cmds[i] = pipe.PFCount(ctx, keys[i], live)
+		} else {
+			cmds[i] = pipe.PFCount(ctx, keys[i])
+		}
+	}
+	total := pipe.PFCount(ctx, append(keys, live)...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("failed to count views of %s %d: %w", kind, id, err)
+	}
+
+	counts := make([]ViewBucket, buckets)
+	for i, cmd := range cmds {
+		counts[i] = ViewBucket{Start: start.Add(-time.Duration(i) * step), Viewers: cmd.Val()}
+	}
+	return counts, total.Val(), nil
+}
+
+// Rollup takes the viewed things from every dirty shard in turn
+func (r *RedisViewRepository) Rollup(ctx context.Context, limit int) (int, error) {
+	keys := make([]string, r.opts.DirtyShards)
+	for i := range keys {
+		keys[i] = fmt.Sprintf(dirtyViewsKeyPattern, i)
+	}
+	members, err := readDirtyShards(ctx, r.agg, keys, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read viewed posts and timelines to roll up: %w", err)
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	now := r.now().UTC()
+	hour, day := now.Format(hourBucketLayout), now.Format(dayBucketLayout)
+	pipe := r.rdb.Pipeline()
+	for _, m := range members {
+		kind, id, ok := parseDirtyViewsMember(m.member)
+		if !ok {
+			log.Printf("dropping malformed member %q of %s", m.member, m.key)
+			pipe.ZRem(ctx, m.key, m.member)
+			continue
+		}
+		keys := []string{
+			fmt.Sprintf(viewsKeyPattern, kind, id),
+			fmt.Sprintf(viewsRollupKeyPattern, kind, id, ViewsByHour, hour),
+			fmt.Sprintf(viewsRollupKeyPattern, kind, id, ViewsByDay, day),
+		}
+		rollupViewsScript.Eval(ctx, pipe, keys, int64(r.opts.HourlyRetention.Seconds()), int64(r.opts.DailyRetention.Seconds()))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		metrics.ViewRollups.WithLabelValues("failed").Inc()
+		return 0, fmt.Errorf("failed to roll up views of %d posts and timelines: %w", len(members), err)
+	}
+
+	// Things viewed again since they were read keep their mark
+	pipe = r.rdb.Pipeline()
+	for _, m := range members {
+		zremIfScoreScript.Eval(ctx, pipe, []string{m.key}, m.member, strconv.FormatFloat(m.score, 'f', -1, 64))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to unmark %d rolled up posts and timelines: %w", len(members), err)
+	}
+
+	metrics.ViewRollups.WithLabelValues("rolled_up").Add(float64(len(members)))
+	return len(members), nil
+}
+
+// dirtyViewsKey returns the dirty shard the viewed thing is marked in
+func (r *RedisViewRepository) dirtyViewsKey(id int64) string {
+	return fmt.Sprintf(dirtyViewsKeyPattern, shard.Of(id, r.opts.DirtyShards))
+}
+
+// viewsBucketOf returns the length and the key layout of the buckets of the granularity
+func viewsBucketOf(granularity string) (time.Duration, string, error) {
+	switch granularity {
+	case ViewsByHour:
+		return time.Hour, hourBucketLayout, nil
+	case ViewsByDay:
+		return 24 * time.Hour, dayBucketLayout, nil
+	default:
+		return 0, "", fmt.Errorf("unknown view granularity %q", granularity)
+	}
+}
+
+func dirtyViewsMember(kind string, id int64) string {
+	return kind + ":" + strconv.FormatInt(id, 10)
+}
+
+func parseDirtyViewsMember(member string) (string, int64, bool) {
+	kind, rawID, ok := strings.Cut(member, ":")
+	if !ok || (kind != ViewKindPost && kind != ViewKindTimeline) {
+		return "", 0, false
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return kind, id, true
+}
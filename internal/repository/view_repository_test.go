@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/aggregator"
+	"github.com/n1207n/cache-query-aggregator/internal/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestViewRepository(db redis.Cmdable, opts ViewOptions, now time.Time) *RedisViewRepository {
+	repo := NewViewRepository(db, aggregator.New(db, aggregator.Options{}), opts).(*RedisViewRepository)
+	repo.now = func() time.Time { return now }
+	return repo
+}
+
+func TestRedisViewRepository_RecordView(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	repo := newTestViewRepository(db, ViewOptions{DirtyShards: 4}, time.Now())
+
+	views := fmt.Sprintf(viewsKeyPattern, ViewKindPost, 100)
+	dirty := fmt.Sprintf(dirtyViewsKeyPattern, shard.Of(100, 4))
+	rdbMock.ExpectPFAdd(views, int64(5)).SetVal(1)
+	rdbMock.ExpectZIncrBy(dirty, 1, "post:100").SetVal(1)
+	// Seen before, the HyperLogLog does not change
+	rdbMock.ExpectPFAdd(views, int64(5)).SetVal(0)
+	rdbMock.ExpectZIncrBy(dirty, 1, "post:100").SetVal(2)
+
+	added, err := repo.RecordView(context.Background(), ViewKindPost, 100, 5)
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = repo.RecordView(context.Background(), ViewKindPost, 100, 5)
+	require.NoError(t, err)
+	assert.False(t, added)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+func TestRedisViewRepository_CountViews(t *testing.T) {
+	db, rdbMock := redismock.NewClientMock()
+	now := time.Date(2026, time.October, 18, 14, 25, 0, 0, time.UTC)
+	repo := newTestViewRepository(db, ViewOptions{}, now)
+
+	live := fmt.Sprintf(viewsKeyPattern, ViewKindTimeline, 1)
+	current := fmt.Sprintf(viewsRollupKeyPattern, ViewKindTimeline, 1, ViewsByHour, "2026101814")
+	previous := fmt.Sprintf(viewsRollupKeyPattern, ViewKindTimeline, 1, ViewsByHour, "2026101813")
+	// The current hour counts the viewers not rolled up yet
+	rdbMock.ExpectPFCount(current, live).SetVal(3)
+	rdbMock.ExpectPFCount(previous).SetVal(4)
+	rdbMock.ExpectPFCount(current, previous, live).SetVal(6)
+
+	buckets, total, err := repo.CountViews(context.Background(), ViewKindTimeline, 1, ViewsByHour, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []ViewBucket{
+		{Start: time.Date(2026, time.October, 18, 14, 0, 0, 0, time.UTC), Viewers: 3},
+		{Start: time.Date(2026, time.October, 18, 13, 0, 0, 0, time.UTC), Viewers: 4},
+	}, buckets)
+	assert.Equal(t, int64(6), total)
+	require.NoError(t, rdbMock.ExpectationsWereMet())
+
+	_, _, err = repo.CountViews(context.Background(), ViewKindTimeline, 1, "week", 2)
+	assert.Error(t, err)
+}
+
+func TestRedisViewRepository_Rollup(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 14, 25, 0, 0, time.UTC)
+	ttls := []interface{}{int64((7 * 24 * time.Hour).Seconds()), int64((90 * 24 * time.Hour).Seconds())}
+	first, second := fmt.Sprintf(dirtyViewsKeyPattern, 0), fmt.Sprintf(dirtyViewsKeyPattern, 1)
+	expectRollup := func(rdbMock redismock.ClientMock, kind string, id int64) {
+		keys := []string{
+			fmt.Sprintf(viewsKeyPattern, kind, id),
+			fmt.Sprintf(viewsRollupKeyPattern, kind, id, ViewsByHour, "2026101814"),
+			fmt.Sprintf(viewsRollupKeyPattern, kind, id, ViewsByDay, "20261018"),
+		}
+		rdbMock.CustomMatch(evalKeys(3)).ExpectEval("", keys, ttls...).SetVal(int64(1))
+	}
+
+	t.Run("takes_from_every_shard_in_turn", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		repo := newTestViewRepository(db, ViewOptions{DirtyShards: 2}, now)
+
+		rdbMock.ExpectZRangeWithScores(first, 0, 1).SetVal([]redis.Z{
+			{Member: "post:100", Score: 3},
+			{Member: "post:300", Score: 2},
+		})
+		rdbMock.ExpectZRangeWithScores(second, 0, 1).SetVal([]redis.Z{{Member: "user:1", Score: 1}})
+		expectRollup(rdbMock, ViewKindPost, 100)
+		expectRollup(rdbMock, ViewKindTimeline, 1)
+		// Viewed again since the read, post:100 keeps its mark
+		rdbMock.CustomMatch(evalKeys(1)).ExpectEval("", []string{first}, "post:100", "3").SetVal(int64(0))
+		rdbMock.CustomMatch(evalKeys(1)).ExpectEval("", []string{second}, "user:1", "1").SetVal(int64(1))
+
+		rolledUp, err := repo.Rollup(context.Background(), 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, rolledUp)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("no_shard_readable", func(t *testing.T) {
+		db, rdbMock := redismock.NewClientMock()
+		repo := newTestViewRepository(db, ViewOptions{DirtyShards: 1}, now)
+
+		rdbMock.ExpectZRangeWithScores(first, 0, 9).SetErr(errors.New("LOADING"))
+
+		_, err := repo.Rollup(context.Background(), 10)
+		assert.Error(t, err)
+		require.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+}
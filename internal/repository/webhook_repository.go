@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookRepository defines methods for webhooks and webhook_deliveries tables
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, arg sqlc.CreateWebhookParams) (sqlc.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error)
+	ListWebhooks(ctx context.Context, arg sqlc.ListWebhooksParams) ([]sqlc.Webhook, error)
+	// ListWebhooksForEvent lists the enabled webhooks subscribed to an event of the user
+	ListWebhooksForEvent(ctx context.Context, eventType string, userID int64) ([]sqlc.Webhook, error)
+	EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error)
+	// DeleteWebhook reports whether the webhook existed
+	DeleteWebhook(ctx context.Context, id int64) (bool, error)
+	// CreateDelivery queues the delivery of an event to a webhook, reporting whether it was not queued yet
+	CreateDelivery(ctx context.Context, arg sqlc.CreateWebhookDeliveryParams) (bool, error)
+	ListDeliveries(ctx context.Context, arg sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error)
+}
+
+// DBWebhookRepository takes sqlc.Querier to create an instance
+type DBWebhookRepository struct {
+	q sqlc.Querier
+}
+
+// NewDBWebhookRepository creates a new instance of DBWebhookRepository
+func NewDBWebhookRepository(querier sqlc.Querier) WebhookRepository {
+	return &DBWebhookRepository{q: querier}
+}
+
+// CreateWebhook inserts a webhook
+func (r *DBWebhookRepository) CreateWebhook(ctx context.Context, arg sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	return r.q.CreateWebhook(ctx, arg)
+}
+
+// GetWebhook retrieves a webhook by its ID
+func (r *DBWebhookRepository) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.q.GetWebhook(ctx, id)
+}
+
+// ListWebhooks retrieves a page of the webhooks, oldest first
+func (r *DBWebhookRepository) ListWebhooks(ctx context.Context, arg sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	return r.q.ListWebhooks(ctx, arg)
+}
+
+// ListWebhooksForEvent retrieves the enabled webhooks subscribed to the event type, for every user or the given one
+func (r *DBWebhookRepository) ListWebhooksForEvent(ctx context.Context, eventType string, userID int64) ([]sqlc.Webhook, error) {
+	return r.q.ListWebhooksForEvent(ctx, sqlc.ListWebhooksForEventParams{EventType: eventType, UserID: userID})
+}
+
+// EnableWebhook enables a webhook again, resetting its failure count
+func (r *DBWebhookRepository) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.q.EnableWebhook(ctx, id)
+}
+
+// DeleteWebhook deletes a webhook along with its deliveries
+func (r *DBWebhookRepository) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	rows, err := r.q.DeleteWebhook(ctx, id)
+	return rows > 0, err
+}
+
+// CreateDelivery queues a delivery, queuing the same event twice is a no-op
+func (r *DBWebhookRepository) CreateDelivery(ctx context.Context, arg sqlc.CreateWebhookDeliveryParams) (bool, error) {
+	rows, err := r.q.CreateWebhookDelivery(ctx, arg)
+	return rows > 0, err
+}
+
+// ListDeliveries retrieves a page of the deliveries of a webhook, newest first
+func (r *DBWebhookRepository) ListDeliveries(ctx context.Context, arg sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	return r.q.ListWebhookDeliveries(ctx, arg)
+}
@@ -0,0 +1,120 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBWebhookRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewDBWebhookRepository(testQueries)
+	user := createTestUser(t, ctx)
+	other := createTestUser(t, ctx)
+
+	everyUser, err := repo.CreateWebhook(ctx, sqlc.CreateWebhookParams{
+		Url:        "https://example.com/every-user",
+		EventTypes: []string{EventPostCreated, EventPostDeleted},
+		UserIds:    []int64{},
+		Secret:     "a-long-enough-secret",
+		OwnerID:    pgtype.Int8{Int64: user.ID, Valid: true},
+	})
+	require.NoError(t, err)
+	oneUser, err := repo.CreateWebhook(ctx, sqlc.CreateWebhookParams{
+		Url:        "https://example.com/one-user",
+		EventTypes: []string{EventPostCreated},
+		UserIds:    []int64{user.ID},
+		Secret:     "a-long-enough-secret",
+		OwnerID:    pgtype.Int8{Int64: other.ID, Valid: true},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteWebhook(ctx, everyUser.ID)
+		_, _ = repo.DeleteWebhook(ctx, oneUser.ID)
+	})
+	assert.Equal(t, []int64{user.ID}, oneUser.UserIds)
+	assert.False(t, oneUser.DisabledAt.Valid)
+
+	ids := func(webhooks []sqlc.Webhook) []int64 {
+		var ids []int64
+		for _, w := range webhooks {
+			if w.ID == everyUser.ID || w.ID == oneUser.ID {
+				ids = append(ids, w.ID)
+			}
+		}
+		return ids
+	}
+
+	t.Run("lists_webhooks_of_owner", func(t *testing.T) {
+		webhooks, err := repo.ListWebhooks(ctx, sqlc.ListWebhooksParams{OwnerID: everyUser.OwnerID, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{everyUser.ID}, ids(webhooks))
+
+		webhooks, err = repo.ListWebhooks(ctx, sqlc.ListWebhooksParams{OwnerID: oneUser.OwnerID, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{oneUser.ID}, ids(webhooks))
+	})
+
+	t.Run("lists_webhooks_for_event", func(t *testing.T) {
+		webhooks, err := repo.ListWebhooksForEvent(ctx, EventPostCreated, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{everyUser.ID, oneUser.ID}, ids(webhooks))
+
+		webhooks, err = repo.ListWebhooksForEvent(ctx, EventPostCreated, other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{everyUser.ID}, ids(webhooks))
+
+		webhooks, err = repo.ListWebhooksForEvent(ctx, EventPostUpdated, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, ids(webhooks))
+	})
+
+	t.Run("skips_disabled_webhooks", func(t *testing.T) {
+		_, err := testDb.Exec(ctx, `UPDATE webhooks SET disabled_at = NOW(), consecutive_failures = 20 WHERE id = $1`, oneUser.ID)
+		require.NoError(t, err)
+
+		webhooks, err := repo.ListWebhooksForEvent(ctx, EventPostCreated, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{everyUser.ID}, ids(webhooks))
+
+		enabled, err := repo.EnableWebhook(ctx, oneUser.ID)
+		require.NoError(t, err)
+		assert.False(t, enabled.DisabledAt.Valid)
+		assert.Zero(t, enabled.ConsecutiveFailures)
+	})
+
+	t.Run("queues_a_delivery_once", func(t *testing.T) {
+		delivery := sqlc.CreateWebhookDeliveryParams{WebhookID: everyUser.ID, EventID: 1, EventType: EventPostCreated, Payload: []byte(`{"id":1}`)}
+		queued, err := repo.CreateDelivery(ctx, delivery)
+		require.NoError(t, err)
+		assert.True(t, queued)
+		queued, err = repo.CreateDelivery(ctx, delivery)
+		require.NoError(t, err)
+		assert.False(t, queued)
+
+		deliveries, err := repo.ListDeliveries(ctx, sqlc.ListWebhookDeliveriesParams{WebhookID: everyUser.ID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, WebhookDeliveryPending, deliveries[0].Status)
+		assert.Zero(t, deliveries[0].Attempts)
+	})
+
+	t.Run("delete_removes_deliveries", func(t *testing.T) {
+		deleted, err := repo.DeleteWebhook(ctx, everyUser.ID)
+		require.NoError(t, err)
+		assert.True(t, deleted)
+
+		var count int
+		require.NoError(t, testDb.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = $1`, everyUser.ID).Scan(&count))
+		assert.Zero(t, count)
+		deleted, err = repo.DeleteWebhook(ctx, everyUser.ID)
+		require.NoError(t, err)
+		assert.False(t, deleted)
+	})
+}
@@ -0,0 +1,15 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupAnalyticsRoutes configures the routes for the unique views of posts and timelines.
+func SetupAnalyticsRoutes(apiGroup *gin.RouterGroup, analyticsHandler *handler.AnalyticsHandler) {
+	analyticsRoutes := apiGroup.Group("/analytics")
+	{
+		analyticsRoutes.GET("/posts/:id/views", analyticsHandler.GetPostViews)
+		analyticsRoutes.GET("/users/:id/views", analyticsHandler.GetTimelineViews)
+	}
+}
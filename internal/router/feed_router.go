@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupFeedRoutes configures the home feed routes within a given router group.
+func SetupFeedRoutes(apiGroup *gin.RouterGroup, feedHandler *handler.FeedHandler) {
+	userFeedRoutes := apiGroup.Group("/users/:id")
+	{
+		userFeedRoutes.GET("/feed", feedHandler.GetFeed)
+	}
+}
@@ -0,0 +1,17 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupFollowRoutes configures the routes for follow graph actions within a given router group.
+func SetupFollowRoutes(apiGroup *gin.RouterGroup, followHandler *handler.FollowHandler) {
+	userFollowRoutes := apiGroup.Group("/users/:id")
+	{
+		userFollowRoutes.POST("/following", followHandler.Follow)
+		userFollowRoutes.DELETE("/following/:followee_id", followHandler.Unfollow)
+		userFollowRoutes.GET("/following", followHandler.ListFollowing)
+		userFollowRoutes.GET("/followers", followHandler.ListFollowers)
+	}
+}
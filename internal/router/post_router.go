@@ -9,11 +9,28 @@ func SetupPostRoutes(apiGroup *gin.RouterGroup, postHandler *handler.PostHandler
 	postRoutes := apiGroup.Group("/posts")
 	{
 		postRoutes.POST("", postHandler.CreatePost)
+		postRoutes.GET("/:id", postHandler.GetPost)
 	}
 
 	// It's common to nest resource routes, e.g., getting posts by a user.
 	userSpecificRoutes := apiGroup.Group("/users/:id/")
 	{
 		userSpecificRoutes.GET("/posts", postHandler.ListPostsByUser)
+		userSpecificRoutes.PATCH("/posts/:post_id", postHandler.UpdatePost)
+		userSpecificRoutes.DELETE("/posts/:post_id", postHandler.DeletePost)
 	}
 }
+
+// SetupTimelineStreamRoutes configures the long-lived routes streaming new posts to clients.
+func SetupTimelineStreamRoutes(apiGroup *gin.RouterGroup, streamHandler *handler.TimelineStreamHandler) {
+	userSpecificRoutes := apiGroup.Group("/users/:id/")
+	{
+		userSpecificRoutes.GET("/posts/stream", streamHandler.StreamSSE)
+		userSpecificRoutes.GET("/posts/ws", streamHandler.StreamWebSocket)
+	}
+}
+
+// SetupTrendingRoutes configures the trending posts routes. The static route takes precedence over /posts/:id.
+func SetupTrendingRoutes(apiGroup *gin.RouterGroup, trendingHandler *handler.TrendingHandler) {
+	apiGroup.GET("/posts/trending", trendingHandler.ListTrending)
+}
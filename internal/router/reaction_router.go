@@ -0,0 +1,15 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupReactionRoutes configures the routes for the reactions of users to posts.
+func SetupReactionRoutes(apiGroup *gin.RouterGroup, reactionHandler *handler.ReactionHandler) {
+	userReactionRoutes := apiGroup.Group("/users/:id")
+	{
+		userReactionRoutes.PUT("/reactions/:post_id", reactionHandler.React)
+		userReactionRoutes.DELETE("/reactions/:post_id", reactionHandler.Unreact)
+	}
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupReplyRoutes configures the routes for the threads of replies to posts.
+func SetupReplyRoutes(apiGroup *gin.RouterGroup, replyHandler *handler.ReplyHandler) {
+	replyRoutes := apiGroup.Group("/posts/:id/replies")
+	{
+		replyRoutes.POST("", replyHandler.CreateReply)
+		replyRoutes.GET("", replyHandler.ListThread)
+		replyRoutes.DELETE("/:reply_id", replyHandler.DeleteReply)
+	}
+}
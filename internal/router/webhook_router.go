@@ -0,0 +1,19 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/n1207n/cache-query-aggregator/internal/handler"
+)
+
+// SetupWebhookRoutes configures the routes for webhook subscriptions within a given router group.
+func SetupWebhookRoutes(apiGroup *gin.RouterGroup, webhookHandler *handler.WebhookHandler) {
+	webhookRoutes := apiGroup.Group("/webhooks")
+	{
+		webhookRoutes.POST("", webhookHandler.CreateWebhook)
+		webhookRoutes.GET("", webhookHandler.ListWebhooks)
+		webhookRoutes.GET("/:id", webhookHandler.GetWebhook)
+		webhookRoutes.DELETE("/:id", webhookHandler.DeleteWebhook)
+		webhookRoutes.POST("/:id/enable", webhookHandler.EnableWebhook)
+		webhookRoutes.GET("/:id/deliveries", webhookHandler.ListDeliveries)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// FeedService defines the interface for home feed business logic.
+type FeedService interface {
+	ListFeed(ctx context.Context, userID int64, cursor repository.FeedCursor, limit int) ([]sqlc.Post, error)
+}
+
+type feedServiceImpl struct {
+	feedRepo repository.FeedRepository
+}
+
+// NewFeedService creates a new instance of FeedService.
+func NewFeedService(feedRepo repository.FeedRepository) FeedService {
+	return &feedServiceImpl{
+		feedRepo: feedRepo,
+	}
+}
+
+// ListFeed retrieves a page of the posts of the users the user follows, newest first.
+func (s *feedServiceImpl) ListFeed(ctx context.Context, userID int64, cursor repository.FeedCursor, limit int) ([]sqlc.Post, error) {
+	return s.feedRepo.ListFeed(ctx, userID, cursor, limit)
+}
@@ -0,0 +1,48 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mockFeedRepository mocks FeedRepository interface
+type mockFeedRepository struct {
+	mock.Mock
+}
+
+func (m *mockFeedRepository) ListFeed(ctx context.Context, userID int64, cursor repository.FeedCursor, limit int) ([]sqlc.Post, error) {
+	args := m.Called(ctx, userID, cursor, limit)
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *mockFeedRepository) FanOutPost(ctx context.Context, post sqlc.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
+}
+
+func TestFeedServiceImpl_ListFeed(t *testing.T) {
+	mockRepo := new(mockFeedRepository)
+	feedService := NewFeedService(mockRepo)
+
+	ctx := context.Background()
+	cursor := repository.FeedCursor{Score: 1700000000, PostID: 10}
+	expectedPosts := []sqlc.Post{
+		{ID: 9, UserID: 2, Content: "Post 9"},
+		{ID: 8, UserID: 3, Content: "Post 8"},
+	}
+
+	mockRepo.On("ListFeed", ctx, int64(1), cursor, 20).Return(expectedPosts, nil)
+
+	posts, err := feedService.ListFeed(ctx, 1, cursor, 20)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPosts, posts)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// ErrSelfFollow is returned when a user tries to follow themselves.
+var ErrSelfFollow = errors.New("users cannot follow themselves")
+
+// FollowService defines the interface for follow graph business logic.
+type FollowService interface {
+	Follow(ctx context.Context, followerID int64, followeeID int64) (bool, error)
+	Unfollow(ctx context.Context, followerID int64, followeeID int64) (bool, error)
+	ListFollowers(ctx context.Context, params sqlc.ListFollowersParams) ([]sqlc.Follow, error)
+	ListFollowing(ctx context.Context, params sqlc.ListFollowingParams) ([]sqlc.Follow, error)
+	CountFollowers(ctx context.Context, userID int64) (int64, error)
+	CountFollowing(ctx context.Context, userID int64) (int64, error)
+}
+
+type followServiceImpl struct {
+	followRepo repository.FollowRepository
+}
+
+// NewFollowService creates a new instance of FollowService.
+func NewFollowService(followRepo repository.FollowRepository) FollowService {
+	return &followServiceImpl{
+		followRepo: followRepo,
+	}
+}
+
+// Follow makes followerID follow followeeID, reporting whether the relationship is new.
+func (s *followServiceImpl) Follow(ctx context.Context, followerID int64, followeeID int64) (bool, error) {
+	if followerID == followeeID {
+		return false, ErrSelfFollow
+	}
+	return s.followRepo.CreateFollow(ctx, sqlc.CreateFollowParams{FollowerID: followerID, FolloweeID: followeeID})
+}
+
+// Unfollow removes the relationship, reporting whether it existed.
+func (s *followServiceImpl) Unfollow(ctx context.Context, followerID int64, followeeID int64) (bool, error) {
+	return s.followRepo.DeleteFollow(ctx, sqlc.DeleteFollowParams{FollowerID: followerID, FolloweeID: followeeID})
+}
+
+// ListFollowers retrieves a page of the user's followers.
+func (s *followServiceImpl) ListFollowers(ctx context.Context, params sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	return s.followRepo.ListFollowers(ctx, params)
+}
+
+// ListFollowing retrieves a page of the users the user follows.
+func (s *followServiceImpl) ListFollowing(ctx context.Context, params sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	return s.followRepo.ListFollowing(ctx, params)
+}
+
+// CountFollowers counts the user's followers.
+func (s *followServiceImpl) CountFollowers(ctx context.Context, userID int64) (int64, error) {
+	return s.followRepo.CountFollowers(ctx, userID)
+}
+
+// CountFollowing counts the users the user follows.
+func (s *followServiceImpl) CountFollowing(ctx context.Context, userID int64) (int64, error) {
+	return s.followRepo.CountFollowing(ctx, userID)
+}
@@ -0,0 +1,72 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowServiceImpl_Follow(t *testing.T) {
+	mockRepo := new(mocks.FollowRepository)
+	followService := NewFollowService(mockRepo)
+
+	ctx := context.Background()
+	params := sqlc.CreateFollowParams{FollowerID: 1, FolloweeID: 2}
+
+	mockRepo.On("CreateFollow", ctx, params).Return(true, nil)
+
+	created, err := followService.Follow(ctx, 1, 2)
+
+	assert.NoError(t, err)
+	assert.True(t, created)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestFollowServiceImpl_Follow_Self(t *testing.T) {
+	mockRepo := new(mocks.FollowRepository)
+	followService := NewFollowService(mockRepo)
+
+	created, err := followService.Follow(context.Background(), 1, 1)
+
+	assert.ErrorIs(t, err, ErrSelfFollow)
+	assert.False(t, created)
+	mockRepo.AssertNotCalled(t, "CreateFollow")
+}
+
+func TestFollowServiceImpl_Unfollow(t *testing.T) {
+	mockRepo := new(mocks.FollowRepository)
+	followService := NewFollowService(mockRepo)
+
+	ctx := context.Background()
+	params := sqlc.DeleteFollowParams{FollowerID: 1, FolloweeID: 2}
+
+	mockRepo.On("DeleteFollow", ctx, params).Return(false, nil)
+
+	deleted, err := followService.Unfollow(ctx, 1, 2)
+
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestFollowServiceImpl_ListFollowers(t *testing.T) {
+	mockRepo := new(mocks.FollowRepository)
+	followService := NewFollowService(mockRepo)
+
+	ctx := context.Background()
+	params := sqlc.ListFollowersParams{FolloweeID: 2, Limit: 10, Offset: 0}
+	expected := []sqlc.Follow{{FollowerID: 1, FolloweeID: 2}, {FollowerID: 3, FolloweeID: 2}}
+
+	mockRepo.On("ListFollowers", ctx, params).Return(expected, nil)
+
+	follows, err := followService.ListFollowers(ctx, params)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, follows)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,21 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/mock"
+)
+
+type FeedService struct {
+	mock.Mock
+}
+
+func (m *FeedService) ListFeed(ctx context.Context, userID int64, cursor repository.FeedCursor, limit int) ([]sqlc.Post, error) {
+	args := m.Called(ctx, userID, cursor, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type FollowService struct {
+	mock.Mock
+}
+
+func (m *FollowService) Follow(ctx context.Context, followerID int64, followeeID int64) (bool, error) {
+	args := m.Called(ctx, followerID, followeeID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *FollowService) Unfollow(ctx context.Context, followerID int64, followeeID int64) (bool, error) {
+	args := m.Called(ctx, followerID, followeeID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *FollowService) ListFollowers(ctx context.Context, params sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowService) ListFollowing(ctx context.Context, params sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Follow), args.Error(1)
+}
+
+func (m *FollowService) CountFollowers(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *FollowService) CountFollowing(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
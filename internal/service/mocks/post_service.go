@@ -19,6 +19,30 @@ func (m *PostService) CreatePost(ctx context.Context, params sqlc.CreatePostPara
 	return args.Get(0).(sqlc.Post), args.Error(1)
 }
 
+func (m *PostService) UpdatePost(ctx context.Context, params sqlc.UpdatePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return sqlc.Post{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
+func (m *PostService) DeletePost(ctx context.Context, params sqlc.DeletePostParams) (sqlc.Post, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return sqlc.Post{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
+func (m *PostService) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return sqlc.Post{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Post), args.Error(1)
+}
+
 func (m *PostService) ListPostsByUser(ctx context.Context, params sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
 	args := m.Called(ctx, params)
 	if args.Get(0) == nil {
@@ -26,3 +50,8 @@ func (m *PostService) ListPostsByUser(ctx context.Context, params sqlc.ListPosts
 	}
 	return args.Get(0).([]sqlc.Post), args.Error(1)
 }
+
+func (m *PostService) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"github.com/stretchr/testify/mock"
+)
+
+type ReactionService struct {
+	mock.Mock
+}
+
+func (m *ReactionService) React(ctx context.Context, userID, postID int64, reaction string) (string, error) {
+	args := m.Called(ctx, userID, postID, reaction)
+	return args.String(0), args.Error(1)
+}
+
+func (m *ReactionService) Unreact(ctx context.Context, userID, postID int64) error {
+	args := m.Called(ctx, userID, postID)
+	return args.Error(0)
+}
+
+func (m *ReactionService) GetPostReactions(ctx context.Context, post sqlc.Post, viewerID int64) (service.PostReactions, error) {
+	args := m.Called(ctx, post, viewerID)
+	if args.Get(0) == nil {
+		return service.PostReactions{}, args.Error(1)
+	}
+	return args.Get(0).(service.PostReactions), args.Error(1)
+}
@@ -0,0 +1,28 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"github.com/stretchr/testify/mock"
+)
+
+type ReplyService struct {
+	mock.Mock
+}
+
+func (m *ReplyService) CreateReply(ctx context.Context, postID, parentID, userID int64, content string) (sqlc.Reply, error) {
+	args := m.Called(ctx, postID, parentID, userID, content)
+	return args.Get(0).(sqlc.Reply), args.Error(1)
+}
+
+func (m *ReplyService) DeleteReply(ctx context.Context, postID, replyID, userID int64) error {
+	args := m.Called(ctx, postID, replyID, userID)
+	return args.Error(0)
+}
+
+func (m *ReplyService) ListThread(ctx context.Context, postID int64, limit, offset int32) (service.Thread, error) {
+	args := m.Called(ctx, postID, limit, offset)
+	return args.Get(0).(service.Thread), args.Error(1)
+}
@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"github.com/stretchr/testify/mock"
+)
+
+type TrendingService struct {
+	mock.Mock
+}
+
+func (m *TrendingService) ListTrending(ctx context.Context, window string, limit int) ([]service.TrendingPost, error) {
+	args := m.Called(ctx, window, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.TrendingPost), args.Error(1)
+}
@@ -19,6 +19,11 @@ func (m *UserService) CreateUser(ctx context.Context, params sqlc.CreateUserPara
 	return args.Get(0).(sqlc.User), args.Error(1)
 }
 
+func (m *UserService) CreateUserWithPost(ctx context.Context, params sqlc.CreateUserParams, content string) (sqlc.User, sqlc.Post, error) {
+	args := m.Called(ctx, params, content)
+	return args.Get(0).(sqlc.User), args.Get(1).(sqlc.Post), args.Error(2)
+}
+
 func (m *UserService) GetUserByID(ctx context.Context, id int64) (sqlc.User, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
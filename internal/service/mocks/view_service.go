@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/internal/service"
+	"github.com/stretchr/testify/mock"
+)
+
+type ViewService struct {
+	mock.Mock
+}
+
+func (m *ViewService) RecordPostView(ctx context.Context, postID, viewerID int64) error {
+	args := m.Called(ctx, postID, viewerID)
+	return args.Error(0)
+}
+
+func (m *ViewService) RecordTimelineView(ctx context.Context, userID, viewerID int64) error {
+	args := m.Called(ctx, userID, viewerID)
+	return args.Error(0)
+}
+
+func (m *ViewService) GetPostViews(ctx context.Context, postID int64, granularity string, buckets int) (service.UniqueViews, error) {
+	args := m.Called(ctx, postID, granularity, buckets)
+	if args.Get(0) == nil {
+		return service.UniqueViews{}, args.Error(1)
+	}
+	return args.Get(0).(service.UniqueViews), args.Error(1)
+}
+
+func (m *ViewService) GetTimelineViews(ctx context.Context, userID int64, granularity string, buckets int) (service.UniqueViews, error) {
+	args := m.Called(ctx, userID, granularity, buckets)
+	if args.Get(0) == nil {
+		return service.UniqueViews{}, args.Error(1)
+	}
+	return args.Get(0).(service.UniqueViews), args.Error(1)
+}
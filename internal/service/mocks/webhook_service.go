@@ -0,0 +1,57 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/mock"
+)
+
+type WebhookService struct {
+	mock.Mock
+}
+
+func (m *WebhookService) CreateWebhook(ctx context.Context, params sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookService) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookService) ListWebhooks(ctx context.Context, params sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookService) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return sqlc.Webhook{}, args.Error(1)
+	}
+	return args.Get(0).(sqlc.Webhook), args.Error(1)
+}
+
+func (m *WebhookService) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *WebhookService) ListDeliveries(ctx context.Context, params sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]sqlc.WebhookDelivery), args.Error(1)
+}
@@ -9,23 +9,106 @@ import (
 
 type PostService interface {
 	CreatePost(ctx context.Context, params sqlc.CreatePostParams) (sqlc.Post, error)
+	UpdatePost(ctx context.Context, params sqlc.UpdatePostParams) (sqlc.Post, error)
+	DeletePost(ctx context.Context, params sqlc.DeletePostParams) (sqlc.Post, error)
+	GetPost(ctx context.Context, id int64) (sqlc.Post, error)
 	ListPostsByUser(ctx context.Context, params sqlc.ListPostsByUserParams) ([]sqlc.Post, error)
+	GetTimelineVersion(ctx context.Context, userID int64) (int64, error)
 }
 
 type postServiceImpl struct {
-	postRepo repository.PostRepository
+	postRepo  repository.PostRepository
+	txManager repository.TxManager
 }
 
-func NewPostService(postRepo repository.PostRepository) PostService {
+func NewPostService(postRepo repository.PostRepository, txManager repository.TxManager) PostService {
 	return &postServiceImpl{
-		postRepo: postRepo,
+		postRepo:  postRepo,
+		txManager: txManager,
 	}
 }
 
+// CreatePost creates the post along with its post.created event in the outbox, in one transaction.
+// Followers get the post from the event, so never one whose transaction rolled back.
 func (s *postServiceImpl) CreatePost(ctx context.Context, params sqlc.CreatePostParams) (sqlc.Post, error) {
-	return s.postRepo.CreatePost(ctx, params)
+	var post sqlc.Post
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		var err error
+		post, err = createPostWithEvent(ctx, repos, params)
+		return err
+	})
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	return post, nil
+}
+
+// UpdatePost updates the post along with its post.updated event in the outbox, in one transaction
+func (s *postServiceImpl) UpdatePost(ctx context.Context, params sqlc.UpdatePostParams) (sqlc.Post, error) {
+	var post sqlc.Post
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		var err error
+		if post, err = repos.Posts.UpdatePost(ctx, params); err != nil {
+			return err
+		}
+		return createPostEvent(ctx, repos, repository.EventPostUpdated, post)
+	})
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	return post, nil
+}
+
+// DeletePost deletes the post along with its thread of replies and its post.deleted event in the
+// outbox, in one transaction. Feeds holding the post skip it from then on.
+func (s *postServiceImpl) DeletePost(ctx context.Context, params sqlc.DeletePostParams) (sqlc.Post, error) {
+	var post sqlc.Post
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		var err error
+		if post, err = repos.Posts.DeletePost(ctx, params); err != nil {
+			return err
+		}
+		if err := repos.Replies.DeleteThread(ctx, post); err != nil {
+			return err
+		}
+		return createPostEvent(ctx, repos, repository.EventPostDeleted, post)
+	})
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	return post, nil
+}
+
+func (s *postServiceImpl) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
+	return s.postRepo.GetPost(ctx, id)
 }
 
 func (s *postServiceImpl) ListPostsByUser(ctx context.Context, params sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
 	return s.postRepo.ListPostsByUser(ctx, params)
 }
+
+func (s *postServiceImpl) GetTimelineVersion(ctx context.Context, userID int64) (int64, error) {
+	return s.postRepo.GetTimelineVersion(ctx, userID)
+}
+
+// createPostWithEvent creates a post and its post.created event with the repositories of a transaction
+func createPostWithEvent(ctx context.Context, repos repository.TxRepositories, params sqlc.CreatePostParams) (sqlc.Post, error) {
+	post, err := repos.Posts.CreatePost(ctx, params)
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	if err := createPostEvent(ctx, repos, repository.EventPostCreated, post); err != nil {
+		return sqlc.Post{}, err
+	}
+	return post, nil
+}
+
+// createPostEvent writes the event of a change of post to the outbox of the transaction
+func createPostEvent(ctx context.Context, repos repository.TxRepositories, eventType string, post sqlc.Post) error {
+	event, err := repository.NewPostEvent(eventType, post)
+	if err != nil {
+		return err
+	}
+	_, err = repos.Outbox.CreateEvent(ctx, event)
+	return err
+}
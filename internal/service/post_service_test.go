@@ -4,17 +4,23 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
 	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestPostServiceImpl_CreatePost(t *testing.T) {
 	mockRepo := new(mocks.PostRepository)
-	postService := NewPostService(mockRepo)
+	mockOutbox := new(mocks.OutboxRepository)
+	txManager := &fakeTxManager{repos: repository.TxRepositories{Posts: mockRepo, Outbox: mockOutbox}}
+	postService := NewPostService(new(mocks.PostRepository), txManager)
 
 	ctx := context.Background()
 	params := sqlc.CreatePostParams{
@@ -29,18 +35,100 @@ func TestPostServiceImpl_CreatePost(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	mockRepo.On("CreatePost", ctx, params).Return(expectedPost, nil)
+	t.Run("writes_event_in_transaction", func(t *testing.T) {
+		event, err := repository.NewPostEvent(repository.EventPostCreated, expectedPost)
+		assert.NoError(t, err)
+		mockRepo.On("CreatePost", ctx, params).Return(expectedPost, nil).Once()
+		mockOutbox.On("CreateEvent", ctx, event).Return(sqlc.Outbox{ID: 7}, nil).Once()
 
-	post, err := postService.CreatePost(ctx, params)
+		post, err := postService.CreatePost(ctx, params)
 
+		assert.NoError(t, err)
+		assert.Equal(t, expectedPost, post)
+		assert.True(t, txManager.committed)
+		mockRepo.AssertExpectations(t)
+		mockOutbox.AssertExpectations(t)
+	})
+
+	t.Run("event_failure_rolls_back", func(t *testing.T) {
+		txManager.committed = false
+		mockRepo.On("CreatePost", ctx, params).Return(expectedPost, nil).Once()
+		mockOutbox.On("CreateEvent", ctx, mock.Anything).Return(nil, errors.New("db error")).Once()
+
+		_, err := postService.CreatePost(ctx, params)
+
+		assert.Error(t, err)
+		assert.True(t, txManager.rolledBack)
+		assert.False(t, txManager.committed)
+	})
+}
+
+func TestPostServiceImpl_UpdatePost(t *testing.T) {
+	mockRepo := new(mocks.PostRepository)
+	mockOutbox := new(mocks.OutboxRepository)
+	txManager := &fakeTxManager{repos: repository.TxRepositories{Posts: mockRepo, Outbox: mockOutbox}}
+	postService := NewPostService(new(mocks.PostRepository), txManager)
+
+	ctx := context.Background()
+	params := sqlc.UpdatePostParams{ID: 1, UserID: 2, Content: "edited"}
+	updated := sqlc.Post{ID: 1, UserID: 2, Content: "edited", UpdatedAt: time.Now()}
+
+	t.Run("writes_event_in_transaction", func(t *testing.T) {
+		event, err := repository.NewPostEvent(repository.EventPostUpdated, updated)
+		assert.NoError(t, err)
+		mockRepo.On("UpdatePost", ctx, params).Return(updated, nil).Once()
+		mockOutbox.On("CreateEvent", ctx, event).Return(sqlc.Outbox{ID: 7}, nil).Once()
+
+		post, err := postService.UpdatePost(ctx, params)
+
+		assert.NoError(t, err)
+		assert.Equal(t, updated, post)
+		assert.True(t, txManager.committed)
+		mockOutbox.AssertExpectations(t)
+	})
+
+	t.Run("not_found_writes_no_event", func(t *testing.T) {
+		txManager.committed = false
+		mockRepo.On("UpdatePost", ctx, params).Return(sqlc.Post{}, pgx.ErrNoRows).Once()
+
+		_, err := postService.UpdatePost(ctx, params)
+
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		assert.True(t, txManager.rolledBack)
+		mockOutbox.AssertNumberOfCalls(t, "CreateEvent", 1)
+	})
+}
+
+func TestPostServiceImpl_DeletePost(t *testing.T) {
+	mockRepo := new(mocks.PostRepository)
+	mockOutbox := new(mocks.OutboxRepository)
+	mockReplies := new(mockReplyRepository)
+	txManager := &fakeTxManager{repos: repository.TxRepositories{Posts: mockRepo, Outbox: mockOutbox, Replies: mockReplies}}
+	postService := NewPostService(new(mocks.PostRepository), txManager)
+
+	ctx := context.Background()
+	params := sqlc.DeletePostParams{ID: 1, UserID: 2}
+	deleted := sqlc.Post{ID: 1, UserID: 2, Content: "gone"}
+	event, err := repository.NewPostEvent(repository.EventPostDeleted, deleted)
 	assert.NoError(t, err)
-	assert.Equal(t, expectedPost, post)
+	mockRepo.On("DeletePost", ctx, params).Return(deleted, nil).Once()
+	// The thread of the post goes with it
+	mockReplies.On("DeleteThread", ctx, deleted).Return(nil).Once()
+	mockOutbox.On("CreateEvent", ctx, event).Return(sqlc.Outbox{ID: 8}, nil).Once()
+
+	post, err := postService.DeletePost(ctx, params)
+
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, post)
+	assert.True(t, txManager.committed)
 	mockRepo.AssertExpectations(t)
+	mockReplies.AssertExpectations(t)
+	mockOutbox.AssertExpectations(t)
 }
 
 func TestPostServiceImpl_ListPostsByUser(t *testing.T) {
 	mockRepo := new(mocks.PostRepository)
-	postService := NewPostService(mockRepo)
+	postService := NewPostService(mockRepo, &fakeTxManager{})
 
 	ctx := context.Background()
 	params := sqlc.ListPostsByUserParams{
@@ -62,3 +150,33 @@ func TestPostServiceImpl_ListPostsByUser(t *testing.T) {
 	assert.Len(t, posts, 2)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestPostServiceImpl_GetPost(t *testing.T) {
+	mockRepo := new(mocks.PostRepository)
+	postService := NewPostService(mockRepo, &fakeTxManager{})
+
+	ctx := context.Background()
+	expectedPost := sqlc.Post{ID: 1, UserID: 1, Content: "Post 1"}
+
+	mockRepo.On("GetPost", ctx, expectedPost.ID).Return(expectedPost, nil)
+
+	post, err := postService.GetPost(ctx, expectedPost.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPost, post)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPostServiceImpl_GetTimelineVersion(t *testing.T) {
+	mockRepo := new(mocks.PostRepository)
+	postService := NewPostService(mockRepo, &fakeTxManager{})
+
+	ctx := context.Background()
+	mockRepo.On("GetTimelineVersion", ctx, int64(1)).Return(int64(42), nil)
+
+	version, err := postService.GetTimelineVersion(ctx, 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), version)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// ErrInvalidReaction is returned for a reaction that is not one of repository.ReactionTypes.
+var ErrInvalidReaction = errors.New("invalid reaction")
+
+// PostReactions are the reactions to a post as seen by a user.
+type PostReactions struct {
+	Counts map[string]int64
+	// Reacted tells whether the viewing user reacted to the post
+	Reacted bool
+}
+
+// ReactionService defines the interface for the reactions of users to posts.
+type ReactionService interface {
+	// React sets the reaction of the user to the post and returns the reaction it replaces, empty when the
+	// user had not reacted. pgx.ErrNoRows is returned when the post does not exist.
+	React(ctx context.Context, userID, postID int64, reaction string) (string, error)
+	// Unreact removes the reaction of the user to the post, pgx.ErrNoRows when there is none.
+	Unreact(ctx context.Context, userID, postID int64) error
+	// GetPostReactions returns the reaction counts of the post, and whether viewerID reacted to it when
+	// it is not zero.
+	GetPostReactions(ctx context.Context, post sqlc.Post, viewerID int64) (PostReactions, error)
+}
+
+type reactionServiceImpl struct {
+	postRepo     repository.PostRepository
+	reactionRepo repository.ReactionRepository
+	trendingRepo repository.TrendingRepository
+	txManager    repository.TxManager
+}
+
+// NewReactionService creates a new instance of ReactionService.
+func NewReactionService(postRepo repository.PostRepository, reactionRepo repository.ReactionRepository,
+	trendingRepo repository.TrendingRepository, txManager repository.TxManager) ReactionService {
+	return &reactionServiceImpl{
+		postRepo:     postRepo,
+		reactionRepo: reactionRepo,
+		trendingRepo: trendingRepo,
+		txManager:    txManager,
+	}
+}
+
+// React sets the reaction in a transaction, so that concurrent reactions of the user to the post each
+// move the counts from the reaction they replace. A first reaction of the user counts towards the
+// trending posts, a changed one does not; the reaction stands when it cannot be counted.
+func (s *reactionServiceImpl) React(ctx context.Context, userID, postID int64, reaction string) (string, error) {
+	if !slices.Contains(repository.ReactionTypes, reaction) {
+		return "", ErrInvalidReaction
+	}
+	post, err := s.postRepo.GetPost(ctx, postID)
+	if err != nil {
+		return "", err
+	}
+
+	var previous string
+	err = s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		var err error
+		previous, err = repos.Reactions.SetReaction(ctx, post, userID, reaction)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if previous == "" {
+		if err := s.trendingRepo.AddEngagement(ctx, postID, ReactionEngagement); err != nil {
+			log.Printf("failed to count reaction to post %d towards trending posts: %v", postID, err)
+		}
+	}
+	return previous, nil
+}
+
+// Unreact removes the reaction. The post is read first, as its counts live under its author.
+func (s *reactionServiceImpl) Unreact(ctx context.Context, userID, postID int64) error {
+	post, err := s.postRepo.GetPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	_, err = s.reactionRepo.DeleteReaction(ctx, post, userID)
+	return err
+}
+
+func (s *reactionServiceImpl) GetPostReactions(ctx context.Context, post sqlc.Post, viewerID int64) (PostReactions, error) {
+	counts, err := s.reactionRepo.GetReactionCounts(ctx, post)
+	if err != nil {
+		return PostReactions{}, err
+	}
+	reactions := PostReactions{Counts: counts}
+	if viewerID != 0 {
+		if reactions.Reacted, err = s.reactionRepo.HasReacted(ctx, viewerID, post.ID); err != nil {
+			return PostReactions{}, err
+		}
+	}
+	return reactions, nil
+}
@@ -0,0 +1,120 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReactionServiceImpl_React(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	t.Run("sets_reaction_in_transaction", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockReactions := new(mocks.ReactionRepository)
+		mockTrending := new(mockTrendingRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Reactions: mockReactions}}
+		reactionService := NewReactionService(mockPosts, new(mocks.ReactionRepository), mockTrending, txManager)
+
+		mockPosts.On("GetPost", ctx, post.ID).Return(post, nil).Once()
+		mockReactions.On("SetReaction", ctx, post, int64(2), repository.ReactionLove).Return(repository.ReactionLike, nil).Once()
+
+		previous, err := reactionService.React(ctx, 2, post.ID, repository.ReactionLove)
+
+		require.NoError(t, err)
+		assert.Equal(t, repository.ReactionLike, previous)
+		assert.True(t, txManager.committed)
+		mockReactions.AssertExpectations(t)
+		// A changed reaction is no new engagement
+		mockTrending.AssertNotCalled(t, "AddEngagement")
+	})
+
+	t.Run("first_reaction_counts_towards_trending", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockReactions := new(mocks.ReactionRepository)
+		mockTrending := new(mockTrendingRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Reactions: mockReactions}}
+		reactionService := NewReactionService(mockPosts, new(mocks.ReactionRepository), mockTrending, txManager)
+
+		mockPosts.On("GetPost", ctx, post.ID).Return(post, nil).Once()
+		mockReactions.On("SetReaction", ctx, post, int64(2), repository.ReactionLike).Return("", nil).Once()
+		mockTrending.On("AddEngagement", ctx, post.ID, float64(ReactionEngagement)).Return(errors.New("connection refused")).Once()
+
+		previous, err := reactionService.React(ctx, 2, post.ID, repository.ReactionLike)
+
+		// The reaction stands when it cannot be counted
+		require.NoError(t, err)
+		assert.Empty(t, previous)
+		mockTrending.AssertExpectations(t)
+	})
+
+	t.Run("invalid_reaction", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		reactionService := NewReactionService(mockPosts, new(mocks.ReactionRepository), new(mockTrendingRepository), &fakeTxManager{})
+
+		_, err := reactionService.React(ctx, 2, post.ID, "meh")
+
+		assert.ErrorIs(t, err, ErrInvalidReaction)
+		mockPosts.AssertNotCalled(t, "GetPost")
+	})
+
+	t.Run("post_not_found", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		txManager := &fakeTxManager{}
+		reactionService := NewReactionService(mockPosts, new(mocks.ReactionRepository), new(mockTrendingRepository), txManager)
+
+		mockPosts.On("GetPost", ctx, int64(404)).Return(nil, pgx.ErrNoRows).Once()
+
+		_, err := reactionService.React(ctx, 2, 404, repository.ReactionLike)
+
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		assert.False(t, txManager.committed)
+	})
+}
+
+func TestReactionServiceImpl_Unreact(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+	mockPosts := new(mocks.PostRepository)
+	mockReactions := new(mocks.ReactionRepository)
+	reactionService := NewReactionService(mockPosts, mockReactions, new(mockTrendingRepository), &fakeTxManager{})
+
+	mockPosts.On("GetPost", ctx, post.ID).Return(post, nil)
+	mockReactions.On("DeleteReaction", ctx, post, int64(2)).Return(repository.ReactionLike, nil).Once()
+	mockReactions.On("DeleteReaction", ctx, post, int64(3)).Return("", pgx.ErrNoRows).Once()
+
+	assert.NoError(t, reactionService.Unreact(ctx, 2, post.ID))
+	assert.ErrorIs(t, reactionService.Unreact(ctx, 3, post.ID), pgx.ErrNoRows)
+	mockReactions.AssertExpectations(t)
+}
+
+func TestReactionServiceImpl_GetPostReactions(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+	counts := map[string]int64{repository.ReactionLike: 2}
+	mockReactions := new(mocks.ReactionRepository)
+	reactionService := NewReactionService(new(mocks.PostRepository), mockReactions, new(mockTrendingRepository), &fakeTxManager{})
+
+	mockReactions.On("GetReactionCounts", ctx, post).Return(counts, nil)
+	mockReactions.On("HasReacted", ctx, int64(2), post.ID).Return(true, nil).Once()
+
+	reactions, err := reactionService.GetPostReactions(ctx, post, 2)
+	require.NoError(t, err)
+	assert.Equal(t, PostReactions{Counts: counts, Reacted: true}, reactions)
+
+	// Anonymous viewers are not looked up
+	reactions, err = reactionService.GetPostReactions(ctx, post, 0)
+	require.NoError(t, err)
+	assert.Equal(t, PostReactions{Counts: counts}, reactions)
+	mockReactions.AssertExpectations(t)
+}
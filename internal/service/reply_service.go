@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// Thread is a page of the replies to a post, oldest first.
+type Thread struct {
+	Post    sqlc.Post
+	Replies []sqlc.Reply
+	// ReplyCount counts the replies of the whole thread, deleted ones excluded
+	ReplyCount int64
+}
+
+// ReplyService defines the interface for the threads of replies to posts.
+type ReplyService interface {
+	// CreateReply adds a reply of the user to the post, or to the reply parentID of its thread when it is
+	// not zero. pgx.ErrNoRows is returned when the post or the parent reply does not exist.
+	CreateReply(ctx context.Context, postID, parentID, userID int64, content string) (sqlc.Reply, error)
+	// DeleteReply deletes the reply of the user to the post, pgx.ErrNoRows when there is none.
+	DeleteReply(ctx context.Context, postID, replyID, userID int64) error
+	// ListThread returns a page of the thread of the post, pgx.ErrNoRows when the post does not exist.
+	ListThread(ctx context.Context, postID int64, limit, offset int32) (Thread, error)
+}
+
+type replyServiceImpl struct {
+	postRepo  repository.PostRepository
+	replyRepo repository.ReplyRepository
+	txManager repository.TxManager
+}
+
+// NewReplyService creates a new instance of ReplyService.
+func NewReplyService(postRepo repository.PostRepository, replyRepo repository.ReplyRepository, txManager repository.TxManager) ReplyService {
+	return &replyServiceImpl{postRepo: postRepo, replyRepo: replyRepo, txManager: txManager}
+}
+
+// CreateReply creates the reply in a transaction, holding off the deletion of the post until the reply
+// is counted on its parent.
+func (s *replyServiceImpl) CreateReply(ctx context.Context, postID, parentID, userID int64, content string) (sqlc.Reply, error) {
+	post, err := s.postRepo.GetPost(ctx, postID)
+	if err != nil {
+		return sqlc.Reply{}, err
+	}
+	if parentID == 0 {
+		parentID = post.ID
+	}
+
+	var reply sqlc.Reply
+	err = s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		var err error
+		reply, err = repos.Replies.CreateReply(ctx, post, parentID, userID, content)
+		return err
+	})
+	if err != nil {
+		return sqlc.Reply{}, err
+	}
+	return reply, nil
+}
+
+// DeleteReply deletes the reply in a transaction, as the tombstones it leaves without replies go with it.
+func (s *replyServiceImpl) DeleteReply(ctx context.Context, postID, replyID, userID int64) error {
+	post, err := s.postRepo.GetPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	return s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) error {
+		_, err := repos.Replies.DeleteReply(ctx, post, replyID, userID)
+		return err
+	})
+}
+
+func (s *replyServiceImpl) ListThread(ctx context.Context, postID int64, limit, offset int32) (Thread, error) {
+	post, err := s.postRepo.GetPost(ctx, postID)
+	if err != nil {
+		return Thread{}, err
+	}
+	replies, err := s.replyRepo.ListThread(ctx, post, limit, offset)
+	if err != nil {
+		return Thread{}, err
+	}
+	count, err := s.replyRepo.GetReplyCount(ctx, post)
+	if err != nil {
+		return Thread{}, err
+	}
+	return Thread{Post: post, Replies: replies, ReplyCount: count}, nil
+}
@@ -0,0 +1,135 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mockReplyRepository is kept here as repository.ReplyDeletion would make the mocks package import repository
+type mockReplyRepository struct {
+	mock.Mock
+}
+
+func (m *mockReplyRepository) CreateReply(ctx context.Context, post sqlc.Post, parentID, userID int64, content string) (sqlc.Reply, error) {
+	args := m.Called(ctx, post, parentID, userID, content)
+	return args.Get(0).(sqlc.Reply), args.Error(1)
+}
+
+func (m *mockReplyRepository) DeleteReply(ctx context.Context, post sqlc.Post, replyID, userID int64) (repository.ReplyDeletion, error) {
+	args := m.Called(ctx, post, replyID, userID)
+	return args.Get(0).(repository.ReplyDeletion), args.Error(1)
+}
+
+func (m *mockReplyRepository) ListThread(ctx context.Context, post sqlc.Post, limit, offset int32) ([]sqlc.Reply, error) {
+	args := m.Called(ctx, post, limit, offset)
+	return args.Get(0).([]sqlc.Reply), args.Error(1)
+}
+
+func (m *mockReplyRepository) GetReplyCount(ctx context.Context, post sqlc.Post) (int64, error) {
+	args := m.Called(ctx, post)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockReplyRepository) DeleteThread(ctx context.Context, post sqlc.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
+}
+
+func TestReplyServiceImpl_CreateReply(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+
+	t.Run("replies_to_post_in_transaction", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockReplies := new(mockReplyRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Replies: mockReplies}}
+		replyService := NewReplyService(mockPosts, new(mockReplyRepository), txManager)
+		reply := sqlc.Reply{ID: 201, RootID: post.ID, ParentID: post.ID, UserID: 2, Content: "hello"}
+
+		mockPosts.On("GetPost", ctx, post.ID).Return(post, nil).Once()
+		mockReplies.On("CreateReply", ctx, post, post.ID, int64(2), "hello").Return(reply, nil).Once()
+
+		created, err := replyService.CreateReply(ctx, post.ID, 0, 2, "hello")
+
+		require.NoError(t, err)
+		assert.Equal(t, reply, created)
+		assert.True(t, txManager.committed)
+		mockReplies.AssertExpectations(t)
+	})
+
+	t.Run("deleted_parent", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockReplies := new(mockReplyRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Replies: mockReplies}}
+		replyService := NewReplyService(mockPosts, new(mockReplyRepository), txManager)
+
+		mockPosts.On("GetPost", ctx, post.ID).Return(post, nil).Once()
+		mockReplies.On("CreateReply", ctx, post, int64(201), int64(2), "hello").Return(sqlc.Reply{}, pgx.ErrNoRows).Once()
+
+		_, err := replyService.CreateReply(ctx, post.ID, 201, 2, "hello")
+
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		assert.True(t, txManager.rolledBack)
+	})
+
+	t.Run("post_not_found", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockReplies := new(mockReplyRepository)
+		replyService := NewReplyService(mockPosts, mockReplies, &fakeTxManager{})
+
+		mockPosts.On("GetPost", ctx, post.ID).Return(sqlc.Post{}, pgx.ErrNoRows).Once()
+
+		_, err := replyService.CreateReply(ctx, post.ID, 0, 2, "hello")
+
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		mockReplies.AssertNotCalled(t, "CreateReply")
+	})
+}
+
+func TestReplyServiceImpl_DeleteReply(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+	mockPosts := new(mocks.PostRepository)
+	mockReplies := new(mockReplyRepository)
+	txManager := &fakeTxManager{repos: repository.TxRepositories{Replies: mockReplies}}
+	replyService := NewReplyService(mockPosts, new(mockReplyRepository), txManager)
+
+	mockPosts.On("GetPost", ctx, post.ID).Return(post, nil)
+	mockReplies.On("DeleteReply", ctx, post, int64(201), int64(2)).Return(repository.ReplyDeletion{DeletedIDs: []int64{201}}, nil).Once()
+
+	require.NoError(t, replyService.DeleteReply(ctx, post.ID, 201, 2))
+	assert.True(t, txManager.committed)
+
+	mockReplies.On("DeleteReply", ctx, post, int64(201), int64(3)).Return(repository.ReplyDeletion{}, pgx.ErrNoRows).Once()
+	assert.ErrorIs(t, replyService.DeleteReply(ctx, post.ID, 201, 3), pgx.ErrNoRows)
+	mockReplies.AssertExpectations(t)
+}
+
+func TestReplyServiceImpl_ListThread(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 100, UserID: 1}
+	mockPosts := new(mocks.PostRepository)
+	mockReplies := new(mockReplyRepository)
+	replyService := NewReplyService(mockPosts, mockReplies, &fakeTxManager{})
+	replies := []sqlc.Reply{{ID: 201, RootID: post.ID, ParentID: post.ID}}
+
+	mockPosts.On("GetPost", ctx, post.ID).Return(post, nil).Once()
+	mockReplies.On("ListThread", ctx, post, int32(20), int32(0)).Return(replies, nil).Once()
+	mockReplies.On("GetReplyCount", ctx, post).Return(int64(1), nil).Once()
+
+	thread, err := replyService.ListThread(ctx, post.ID, 20, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, Thread{Post: post, Replies: replies, ReplyCount: 1}, thread)
+	mockReplies.AssertExpectations(t)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// ErrInvalidTrendingWindow is returned for a window that is not one of repository.TrendingWindows.
+var ErrInvalidTrendingWindow = errors.New("invalid trending window")
+
+// Engagement weights of the interactions with a post counted towards the trending posts
+const (
+	ViewEngagement     = 1
+	ReactionEngagement = 5
+)
+
+// TrendingPost is a post and its engagement score in a trending window.
+type TrendingPost struct {
+	Post  sqlc.Post
+	Score float64
+}
+
+// TrendingService defines the interface for the posts with the most engagement.
+type TrendingService interface {
+	// ListTrending returns at most limit posts with the highest engagement in the window, highest first.
+	ListTrending(ctx context.Context, window string, limit int) ([]TrendingPost, error)
+}
+
+type trendingServiceImpl struct {
+	postRepo     repository.PostRepository
+	trendingRepo repository.TrendingRepository
+}
+
+// NewTrendingService creates a new instance of TrendingService.
+func NewTrendingService(postRepo repository.PostRepository, trendingRepo repository.TrendingRepository) TrendingService {
+	return &trendingServiceImpl{postRepo: postRepo, trendingRepo: trendingRepo}
+}
+
+// ListTrending hydrates the ranking through the post cache. Posts deleted since they were ranked are
+// skipped, so fewer than limit posts may be returned.
+func (s *trendingServiceImpl) ListTrending(ctx context.Context, window string, limit int) ([]TrendingPost, error) {
+	if _, ok := repository.TrendingWindows[window]; !ok {
+		return nil, fmt.Errorf("%w: window must be 1h, 6h or 24h", ErrInvalidTrendingWindow)
+	}
+
+	entries, err := s.trendingRepo.ListTrending(ctx, window, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(entries))
+	scores := make(map[int64]float64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.PostID
+		scores[entry.PostID] = entry.Score
+	}
+	posts, err := s.postRepo.GetPostsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	trending := make([]TrendingPost, 0, len(posts))
+	for _, post := range posts {
+		trending = append(trending, TrendingPost{Post: post, Score: scores[post.ID]})
+	}
+	return trending, nil
+}
@@ -0,0 +1,83 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mockTrendingRepository mocks the TrendingRepository interface
+type mockTrendingRepository struct {
+	mock.Mock
+}
+
+func (m *mockTrendingRepository) AddEngagement(ctx context.Context, postID int64, weight float64) error {
+	return m.Called(ctx, postID, weight).Error(0)
+}
+
+func (m *mockTrendingRepository) Refresh(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func (m *mockTrendingRepository) ListTrending(ctx context.Context, window string, limit int) ([]repository.TrendingEntry, error) {
+	args := m.Called(ctx, window, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repository.TrendingEntry), args.Error(1)
+}
+
+func TestTrendingServiceImpl_ListTrending(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("hydrates_ranking", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockTrending := new(mockTrendingRepository)
+		trendingService := NewTrendingService(mockPosts, mockTrending)
+
+		mockTrending.On("ListTrending", ctx, "1h", 3).Return([]repository.TrendingEntry{
+			{PostID: 101, Score: 9},
+			{PostID: 100, Score: 7},
+			{PostID: 103, Score: 4},
+		}, nil).Once()
+		// Post 100 was deleted since it was ranked
+		mockPosts.On("GetPostsByIDs", ctx, []int64{101, 100, 103}).Return([]sqlc.Post{{ID: 101}, {ID: 103}}, nil).Once()
+
+		posts, err := trendingService.ListTrending(ctx, "1h", 3)
+		require.NoError(t, err)
+		assert.Equal(t, []TrendingPost{{Post: sqlc.Post{ID: 101}, Score: 9}, {Post: sqlc.Post{ID: 103}, Score: 4}}, posts)
+		mockPosts.AssertExpectations(t)
+	})
+
+	t.Run("invalid_window", func(t *testing.T) {
+		mockTrending := new(mockTrendingRepository)
+		trendingService := NewTrendingService(new(mocks.PostRepository), mockTrending)
+
+		_, err := trendingService.ListTrending(ctx, "1w", 3)
+
+		assert.ErrorIs(t, err, ErrInvalidTrendingWindow)
+		mockTrending.AssertNotCalled(t, "ListTrending")
+	})
+
+	t.Run("ranking_unavailable", func(t *testing.T) {
+		mockPosts := new(mocks.PostRepository)
+		mockTrending := new(mockTrendingRepository)
+		trendingService := NewTrendingService(mockPosts, mockTrending)
+
+		mockTrending.On("ListTrending", ctx, "24h", 3).Return(nil, errors.New("connection refused")).Once()
+
+		_, err := trendingService.ListTrending(ctx, "24h", 3)
+
+		assert.Error(t, err)
+		mockPosts.AssertNotCalled(t, "GetPostsByIDs")
+	})
+}
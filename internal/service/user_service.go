@@ -10,17 +10,21 @@ import (
 // UserService defines the interface for user-related business logic.
 type UserService interface {
 	CreateUser(ctx context.Context, params sqlc.CreateUserParams) (sqlc.User, error)
+	// CreateUserWithPost creates a user along with their first post, both or neither
+	CreateUserWithPost(ctx context.Context, params sqlc.CreateUserParams, content string) (sqlc.User, sqlc.Post, error)
 	GetUserByID(ctx context.Context, id int64) (sqlc.User, error)
 }
 
 type userServiceImpl struct {
-	userRepo repository.UserRepository
+	userRepo  repository.UserRepository
+	txManager repository.TxManager
 }
 
 // NewUserService creates a new instance of UserService.
-func NewUserService(userRepo repository.UserRepository) UserService {
+func NewUserService(userRepo repository.UserRepository, txManager repository.TxManager) UserService {
 	return &userServiceImpl{
-		userRepo: userRepo,
+		userRepo:  userRepo,
+		txManager: txManager,
 	}
 }
 
@@ -29,6 +33,24 @@ func (s *userServiceImpl) CreateUser(ctx context.Context, params sqlc.CreateUser
 	return s.userRepo.CreateUser(ctx, params)
 }
 
+// CreateUserWithPost creates a new user and their first post in one transaction.
+func (s *userServiceImpl) CreateUserWithPost(ctx context.Context, params sqlc.CreateUserParams, content string) (sqlc.User, sqlc.Post, error) {
+	var user sqlc.User
+	var post sqlc.Post
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context, repos repository.TxRepositories) (err error) {
+		user, err = repos.Users.CreateUser(ctx, params)
+		if err != nil {
+			return err
+		}
+		post, err = createPostWithEvent(ctx, repos, sqlc.CreatePostParams{UserID: user.ID, Content: content})
+		return err
+	})
+	if err != nil {
+		return sqlc.User{}, sqlc.Post{}, err
+	}
+	return user, post, nil
+}
+
 // GetUserByID retrieves a user by their ID.
 func (s *userServiceImpl) GetUserByID(ctx context.Context, id int64) (sqlc.User, error) {
 	return s.userRepo.GetUserByID(ctx, id)
@@ -4,17 +4,36 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
 	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
+// fakeTxManager runs units of work on repos without a database, recording how they ended
+type fakeTxManager struct {
+	repos      repository.TxRepositories
+	committed  bool
+	rolledBack bool
+}
+
+func (m *fakeTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context, repos repository.TxRepositories) error) error {
+	if err := fn(ctx, m.repos); err != nil {
+		m.rolledBack = true
+		return err
+	}
+	m.committed = true
+	return nil
+}
+
 func TestUserServiceImpl_CreateUser(t *testing.T) {
 	mockRepo := new(mocks.UserRepository)
-	userService := NewUserService(mockRepo)
+	userService := NewUserService(mockRepo, &fakeTxManager{})
 
 	ctx := context.Background()
 	params := sqlc.CreateUserParams{
@@ -44,7 +63,7 @@ func TestUserServiceImpl_CreateUser(t *testing.T) {
 
 func TestUserServiceImpl_GetUserByID(t *testing.T) {
 	mockRepo := new(mocks.UserRepository)
-	userService := NewUserService(mockRepo)
+	userService := NewUserService(mockRepo, &fakeTxManager{})
 
 	ctx := context.Background()
 	userID := int64(1)
@@ -63,3 +82,53 @@ func TestUserServiceImpl_GetUserByID(t *testing.T) {
 	assert.Equal(t, expectedUser, user)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestUserServiceImpl_CreateUserWithPost(t *testing.T) {
+	ctx := context.Background()
+	params := sqlc.CreateUserParams{
+		FirstName:      "John",
+		LastName:       "Doe",
+		Email:          "john.doe@example.com",
+		HashedPassword: "password123",
+	}
+	createdUser := sqlc.User{ID: 1, FirstName: params.FirstName, LastName: params.LastName, Email: params.Email}
+	postParams := sqlc.CreatePostParams{UserID: createdUser.ID, Content: "hello"}
+
+	t.Run("commits_both", func(t *testing.T) {
+		mockUserRepo, mockPostRepo, mockOutbox := new(mocks.UserRepository), new(mocks.PostRepository), new(mocks.OutboxRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Users: mockUserRepo, Posts: mockPostRepo, Outbox: mockOutbox}}
+		userService := NewUserService(new(mocks.UserRepository), txManager)
+
+		createdPost := sqlc.Post{ID: 10, UserID: createdUser.ID, Content: "hello"}
+		mockUserRepo.On("CreateUser", mock.Anything, params).Return(createdUser, nil)
+		mockPostRepo.On("CreatePost", mock.Anything, postParams).Return(createdPost, nil)
+		mockOutbox.On("CreateEvent", mock.Anything, mock.MatchedBy(func(arg sqlc.CreateOutboxEventParams) bool {
+			return arg.EventType == repository.EventPostCreated && arg.AggregateID == createdPost.ID
+		})).Return(sqlc.Outbox{}, nil)
+
+		user, post, err := userService.CreateUserWithPost(ctx, params, "hello")
+
+		assert.NoError(t, err)
+		assert.Equal(t, createdUser, user)
+		assert.Equal(t, createdPost, post)
+		assert.True(t, txManager.committed)
+		mockUserRepo.AssertExpectations(t)
+		mockPostRepo.AssertExpectations(t)
+		mockOutbox.AssertExpectations(t)
+	})
+
+	t.Run("post_failure_rolls_back", func(t *testing.T) {
+		mockUserRepo, mockPostRepo := new(mocks.UserRepository), new(mocks.PostRepository)
+		txManager := &fakeTxManager{repos: repository.TxRepositories{Users: mockUserRepo, Posts: mockPostRepo}}
+		userService := NewUserService(new(mocks.UserRepository), txManager)
+
+		mockUserRepo.On("CreateUser", mock.Anything, params).Return(createdUser, nil)
+		mockPostRepo.On("CreatePost", mock.Anything, postParams).Return(nil, errors.New("db error"))
+
+		_, _, err := userService.CreateUserWithPost(ctx, params, "hello")
+
+		assert.Error(t, err)
+		assert.True(t, txManager.rolledBack)
+		assert.False(t, txManager.committed)
+	})
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// ErrInvalidViewRange is returned for an unknown granularity or a number of buckets out of range.
+var ErrInvalidViewRange = errors.New("invalid view range")
+
+// Bounds of the buckets returned at a time, by granularity
+const (
+	MaxHourlyViewBuckets = 7 * 24
+	MaxDailyViewBuckets  = 90
+)
+
+// UniqueViews are the approximate distinct viewers of a post or timeline.
+type UniqueViews struct {
+	// Buckets are the distinct viewers of every hour or day, newest first
+	Buckets []repository.ViewBucket
+	// Total is the distinct viewers across all buckets
+	Total int64
+}
+
+// ViewService defines the interface for the unique views of posts and timelines.
+type ViewService interface {
+	// RecordPostView records a view of the post by the user viewerID.
+	RecordPostView(ctx context.Context, postID, viewerID int64) error
+	// RecordTimelineView records a view of the posts of userID by the user viewerID.
+	RecordTimelineView(ctx context.Context, userID, viewerID int64) error
+	// GetPostViews returns the unique viewers of the post in the last buckets hours or days.
+	GetPostViews(ctx context.Context, postID int64, granularity string, buckets int) (UniqueViews, error)
+	// GetTimelineViews returns the unique viewers of the posts of userID in the last buckets hours or days.
+	GetTimelineViews(ctx context.Context, userID int64, granularity string, buckets int) (UniqueViews, error)
+}
+
+type viewServiceImpl struct {
+	viewRepo     repository.ViewRepository
+	trendingRepo repository.TrendingRepository
+}
+
+// NewViewService creates a new instance of ViewService.
+func NewViewService(viewRepo repository.ViewRepository, trendingRepo repository.TrendingRepository) ViewService {
+	return &viewServiceImpl{viewRepo: viewRepo, trendingRepo: trendingRepo}
+}
+
+// RecordPostView records the viewer and counts the view towards the trending posts when the viewer is
+// new since the last rollup, so that reloading a post does not make it trend.
+func (s *viewServiceImpl) RecordPostView(ctx context.Context, postID, viewerID int64) error {
+	added, err := s.viewRepo.RecordView(ctx, repository.ViewKindPost, postID, viewerID)
+	if err != nil || !added {
+		return err
+	}
+	return s.trendingRepo.AddEngagement(ctx, postID, ViewEngagement)
+}
+
+func (s *viewServiceImpl) RecordTimelineView(ctx context.Context, userID, viewerID int64) error {
+	_, err := s.viewRepo.RecordView(ctx, repository.ViewKindTimeline, userID, viewerID)
+	return err
+}
+
+func (s *viewServiceImpl) GetPostViews(ctx context.Context, postID int64, granularity string, buckets int) (UniqueViews, error) {
+	return s.getViews(ctx, repository.ViewKindPost, postID, granularity, buckets)
+}
+
+func (s *viewServiceImpl) GetTimelineViews(ctx context.Context, userID int64, granularity string, buckets int) (UniqueViews, error) {
+	return s.getViews(ctx, repository.ViewKindTimeline, userID, granularity, buckets)
+}
+
+func (s *viewServiceImpl) getViews(ctx context.Context, kind string, id int64, granularity string, buckets int) (UniqueViews, error) {
+	var maxBuckets int
+	switch granularity {
+	case repository.ViewsByHour:
+		maxBuckets = MaxHourlyViewBuckets
+	case repository.ViewsByDay:
+		maxBuckets = MaxDailyViewBuckets
+	default:
+		return UniqueViews{}, fmt.Errorf("%w: granularity must be hour or day", ErrInvalidViewRange)
+	}
+	if buckets < 1 || buckets > maxBuckets {
+		return UniqueViews{}, fmt.Errorf("%w: %s buckets must be between 1 and %d", ErrInvalidViewRange, granularity, maxBuckets)
+	}
+
+	counts, total, err := s.viewRepo.CountViews(ctx, kind, id, granularity, buckets)
+	if err != nil {
+		return UniqueViews{}, err
+	}
+	return UniqueViews{Buckets: counts, Total: total}, nil
+}
@@ -0,0 +1,86 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mockViewRepository mocks the ViewRepository interface
+type mockViewRepository struct {
+	mock.Mock
+}
+
+func (m *mockViewRepository) RecordView(ctx context.Context, kind string, id, viewerID int64) (bool, error) {
+	args := m.Called(ctx, kind, id, viewerID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockViewRepository) CountViews(ctx context.Context, kind string, id int64, granularity string, buckets int) ([]repository.ViewBucket, int64, error) {
+	args := m.Called(ctx, kind, id, granularity, buckets)
+	if args.Get(0) == nil {
+		return nil, 0, args.Error(2)
+	}
+	return args.Get(0).([]repository.ViewBucket), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *mockViewRepository) Rollup(ctx context.Context, limit int) (int, error) {
+	args := m.Called(ctx, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func TestViewServiceImpl_RecordViews(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(mockViewRepository)
+	mockTrending := new(mockTrendingRepository)
+	viewService := NewViewService(mockRepo, mockTrending)
+
+	mockRepo.On("RecordView", ctx, repository.ViewKindPost, int64(100), int64(5)).Return(true, nil).Once()
+	mockRepo.On("RecordView", ctx, repository.ViewKindPost, int64(100), int64(5)).Return(false, nil).Once()
+	mockRepo.On("RecordView", ctx, repository.ViewKindPost, int64(101), int64(5)).Return(false, errors.New("redis down")).Once()
+	mockRepo.On("RecordView", ctx, repository.ViewKindTimeline, int64(1), int64(5)).Return(true, nil).Once()
+	// Only new viewers of posts count towards the trending posts
+	mockTrending.On("AddEngagement", ctx, int64(100), float64(ViewEngagement)).Return(nil).Once()
+
+	assert.NoError(t, viewService.RecordPostView(ctx, 100, 5))
+	assert.NoError(t, viewService.RecordPostView(ctx, 100, 5))
+	assert.Error(t, viewService.RecordPostView(ctx, 101, 5))
+	assert.NoError(t, viewService.RecordTimelineView(ctx, 1, 5))
+	mockRepo.AssertExpectations(t)
+	mockTrending.AssertExpectations(t)
+}
+
+func TestViewServiceImpl_GetPostViews(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(mockViewRepository)
+	viewService := NewViewService(mockRepo, new(mockTrendingRepository))
+
+	buckets := []repository.ViewBucket{{Start: time.Now().Truncate(24 * time.Hour), Viewers: 7}}
+	mockRepo.On("CountViews", ctx, repository.ViewKindPost, int64(100), repository.ViewsByDay, 1).Return(buckets, int64(7), nil).Once()
+
+	views, err := viewService.GetPostViews(ctx, 100, repository.ViewsByDay, 1)
+	require.NoError(t, err)
+	assert.Equal(t, UniqueViews{Buckets: buckets, Total: 7}, views)
+
+	for _, tc := range []struct {
+		granularity string
+		buckets     int
+	}{
+		{"week", 1},
+		{repository.ViewsByHour, 0},
+		{repository.ViewsByHour, MaxHourlyViewBuckets + 1},
+		{repository.ViewsByDay, MaxDailyViewBuckets + 1},
+	} {
+		_, err := viewService.GetPostViews(ctx, 100, tc.granularity, tc.buckets)
+		assert.ErrorIs(t, err, ErrInvalidViewRange, "%s x %d", tc.granularity, tc.buckets)
+	}
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/util"
+)
+
+// ErrInvalidWebhook is returned when a webhook is created with an invalid URL, event type or secret.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// webhookSecretPrefix marks the secrets generated for webhooks created without one
+const webhookSecretPrefix = "whsec_"
+
+// minWebhookSecretLength is the shortest secret accepted to sign deliveries
+const minWebhookSecretLength = 16
+
+// webhookEventTypes are the events webhooks can subscribe to
+var webhookEventTypes = []string{
+	repository.EventPostCreated,
+	repository.EventPostUpdated,
+	repository.EventPostDeleted,
+}
+
+// WebhookService defines the interface for webhook subscriptions business logic.
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, params sqlc.CreateWebhookParams) (sqlc.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error)
+	ListWebhooks(ctx context.Context, params sqlc.ListWebhooksParams) ([]sqlc.Webhook, error)
+	EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) (bool, error)
+	ListDeliveries(ctx context.Context, params sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error)
+}
+
+type webhookServiceImpl struct {
+	webhookRepo         repository.WebhookRepository
+	allowPrivateTargets bool
+}
+
+// NewWebhookService creates a new instance of WebhookService. Unless allowPrivateTargets is set, webhooks
+// pointing at loopback, link-local, private or unspecified hosts are rejected.
+func NewWebhookService(webhookRepo repository.WebhookRepository, allowPrivateTargets bool) WebhookService {
+	return &webhookServiceImpl{
+		webhookRepo:         webhookRepo,
+		allowPrivateTargets: allowPrivateTargets,
+	}
+}
+
+// CreateWebhook validates and creates a webhook. A secret is generated when none is given.
+func (s *webhookServiceImpl) CreateWebhook(ctx context.Context, params sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	target, err := url.Parse(params.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return sqlc.Webhook{}, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	if !s.allowPrivateTargets {
+		if err := checkPublicHost(target.Hostname()); err != nil {
+			return sqlc.Webhook{}, fmt.Errorf("%w: url must point at a public host: %v", ErrInvalidWebhook, err)
+		}
+	}
+	if len(params.EventTypes) == 0 {
+		return sqlc.Webhook{}, fmt.Errorf("%w: at least one event type is required", ErrInvalidWebhook)
+	}
+	for _, eventType := range params.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			return sqlc.Webhook{}, fmt.Errorf("%w: unknown event type %q, expected one of %v", ErrInvalidWebhook, eventType, webhookEventTypes)
+		}
+	}
+	params.EventTypes = compact(params.EventTypes)
+	params.UserIds = compact(params.UserIds)
+	if params.UserIds == nil {
+		params.UserIds = []int64{}
+	}
+
+	if params.Secret == "" {
+		if params.Secret, err = newWebhookSecret(); err != nil {
+			return sqlc.Webhook{}, err
+		}
+	} else if len(params.Secret) < minWebhookSecretLength {
+		return sqlc.Webhook{}, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidWebhook, minWebhookSecretLength)
+	}
+	return s.webhookRepo.CreateWebhook(ctx, params)
+}
+
+// GetWebhook retrieves a webhook by its ID.
+func (s *webhookServiceImpl) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return s.webhookRepo.GetWebhook(ctx, id)
+}
+
+// ListWebhooks retrieves a page of the webhooks.
+func (s *webhookServiceImpl) ListWebhooks(ctx context.Context, params sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	return s.webhookRepo.ListWebhooks(ctx, params)
+}
+
+// EnableWebhook enables a webhook disabled after repeated failures, its pending deliveries resume.
+func (s *webhookServiceImpl) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return s.webhookRepo.EnableWebhook(ctx, id)
+}
+
+// DeleteWebhook deletes a webhook and its delivery log, reporting whether it existed.
+func (s *webhookServiceImpl) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	return s.webhookRepo.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries retrieves a page of the delivery log of a webhook.
+func (s *webhookServiceImpl) ListDeliveries(ctx context.Context, params sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	return s.webhookRepo.ListDeliveries(ctx, params)
+}
+
+// checkPublicHost rejects IP literals of non-public addresses and local names. Other names are checked by
+// the delivery worker once resolved, as they may resolve differently by then.
+func checkPublicHost(host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return util.CheckPublicAddress(addr)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", util.ErrNonPublicAddress, host)
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return webhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+// compact returns the values without duplicates, sorted
+func compact[T int64 | string](values []T) []T {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return slices.Compact(values)
+}
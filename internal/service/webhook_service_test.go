@@ -0,0 +1,113 @@
+//go:build unit
+
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWebhookServiceImpl_CreateWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("generates_secret", func(t *testing.T) {
+		mockRepo := new(mocks.WebhookRepository)
+		webhookService := NewWebhookService(mockRepo, false)
+
+		var created sqlc.CreateWebhookParams
+		mockRepo.On("CreateWebhook", ctx, mock.Anything).Run(func(args mock.Arguments) {
+			created = args.Get(1).(sqlc.CreateWebhookParams)
+		}).Return(sqlc.Webhook{ID: 1}, nil)
+
+		_, err := webhookService.CreateWebhook(ctx, sqlc.CreateWebhookParams{
+			Url:        "https://example.com/hooks",
+			EventTypes: []string{"post.updated", "post.created", "post.created"},
+			UserIds:    []int64{3, 1, 3},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"post.created", "post.updated"}, created.EventTypes)
+		assert.Equal(t, []int64{1, 3}, created.UserIds)
+		assert.True(t, strings.HasPrefix(created.Secret, webhookSecretPrefix))
+		assert.Len(t, created.Secret, len(webhookSecretPrefix)+64)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("keeps_given_secret_and_every_user", func(t *testing.T) {
+		mockRepo := new(mocks.WebhookRepository)
+		webhookService := NewWebhookService(mockRepo, false)
+
+		params := sqlc.CreateWebhookParams{
+			Url:        "http://hooks.internal:8080/posts",
+			EventTypes: []string{"post.deleted"},
+			UserIds:    []int64{},
+			Secret:     "a-long-enough-secret",
+		}
+		mockRepo.On("CreateWebhook", ctx, params).Return(sqlc.Webhook{ID: 1}, nil)
+
+		_, err := webhookService.CreateWebhook(ctx, sqlc.CreateWebhookParams{
+			Url:        params.Url,
+			EventTypes: params.EventTypes,
+			Secret:     params.Secret,
+		})
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	invalid := map[string]sqlc.CreateWebhookParams{
+		"relative_url":       {Url: "/hooks", EventTypes: []string{"post.created"}},
+		"unsupported_scheme": {Url: "ftp://example.com", EventTypes: []string{"post.created"}},
+		"no_event_type":      {Url: "https://example.com"},
+		"unknown_event_type": {Url: "https://example.com", EventTypes: []string{"user.created"}},
+		"short_secret":       {Url: "https://example.com", EventTypes: []string{"post.created"}, Secret: "short"},
+		"loopback_host":      {Url: "http://127.0.0.1:8080/hooks", EventTypes: []string{"post.created"}},
+		"localhost_name":     {Url: "http://localhost/hooks", EventTypes: []string{"post.created"}},
+		"link_local_host":    {Url: "http://169.254.169.254/latest/meta-data", EventTypes: []string{"post.created"}},
+		"private_host":       {Url: "https://10.0.0.5/hooks", EventTypes: []string{"post.created"}},
+		"unspecified_host":   {Url: "http://[::]/hooks", EventTypes: []string{"post.created"}},
+		"mapped_loopback":    {Url: "http://[::ffff:127.0.0.1]/hooks", EventTypes: []string{"post.created"}},
+	}
+	for name, params := range invalid {
+		t.Run(name, func(t *testing.T) {
+			mockRepo := new(mocks.WebhookRepository)
+			webhookService := NewWebhookService(mockRepo, false)
+
+			_, err := webhookService.CreateWebhook(ctx, params)
+
+			assert.ErrorIs(t, err, ErrInvalidWebhook)
+			mockRepo.AssertNotCalled(t, "CreateWebhook")
+		})
+	}
+
+	t.Run("private_host_allowed", func(t *testing.T) {
+		mockRepo := new(mocks.WebhookRepository)
+		webhookService := NewWebhookService(mockRepo, true)
+		mockRepo.On("CreateWebhook", ctx, mock.Anything).Return(sqlc.Webhook{ID: 1}, nil)
+
+		_, err := webhookService.CreateWebhook(ctx, sqlc.CreateWebhookParams{Url: "http://127.0.0.1:8080/hooks", EventTypes: []string{"post.created"}})
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestWebhookServiceImpl_DeleteWebhook(t *testing.T) {
+	mockRepo := new(mocks.WebhookRepository)
+	webhookService := NewWebhookService(mockRepo, false)
+
+	ctx := context.Background()
+	mockRepo.On("DeleteWebhook", ctx, int64(1)).Return(true, nil)
+
+	deleted, err := webhookService.DeleteWebhook(ctx, 1)
+
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,471 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// PostgreSQL error codes of the constraints enforced across shards
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// Users
+
+func (r *Router) CreateUser(ctx context.Context, arg sqlc.CreateUserParams) (sqlc.User, error) {
+	// Emails are unique per shard only, look for the email on the others first
+	if _, err := r.GetUserByEmail(ctx, arg.Email); err == nil {
+		return sqlc.User{}, &pgconn.PgError{
+			Severity:       "ERROR",
+			Code:           uniqueViolation,
+			Message:        `duplicate key value violates unique constraint "users_email_key"`,
+			TableName:      "users",
+			ConstraintName: "users_email_key",
+		}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.User{}, err
+	}
+
+	if arg.ID == 0 {
+		arg.ID = r.ids.Next()
+	}
+	return r.writeForUser(arg.ID).CreateUser(ctx, arg)
+}
+
+func (r *Router) GetUserByID(ctx context.Context, id int64) (sqlc.User, error) {
+	return r.forUser(id).GetUserByID(ctx, id)
+}
+
+func (r *Router) GetUserByEmail(ctx context.Context, email string) (sqlc.User, error) {
+	users, err := gather(ctx, r.all(), r.reader, func(ctx context.Context, q sqlc.Querier, _ int) (*sqlc.User, error) {
+		user, err := q.GetUserByEmail(ctx, email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return &user, err
+	})
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	for _, user := range users {
+		if user != nil {
+			return *user, nil
+		}
+	}
+	return sqlc.User{}, pgx.ErrNoRows
+}
+
+// ListUsers merges the newest users of every shard, each shard returning the whole prefix of the page
+func (r *Router) ListUsers(ctx context.Context, arg sqlc.ListUsersParams) ([]sqlc.User, error) {
+	perShard := sqlc.ListUsersParams{Limit: arg.Limit + arg.Offset, Offset: 0}
+	pages, err := gather(ctx, r.all(), r.reader, func(ctx context.Context, q sqlc.Querier, _ int) ([]sqlc.User, error) {
+		return q.ListUsers(ctx, perShard)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var users []sqlc.User
+	for _, shardUsers := range pages {
+		users = append(users, shardUsers...)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID > users[j].ID
+	})
+	return page(users, int(arg.Offset), int(arg.Limit)), nil
+}
+
+func (r *Router) UpdateUser(ctx context.Context, arg sqlc.UpdateUserParams) (sqlc.User, error) {
+	return r.writeForUser(arg.ID).UpdateUser(ctx, arg)
+}
+
+// DeleteUser deletes the user along with their posts, and their follows on every shard
+func (r *Router) DeleteUser(ctx context.Context, id int64) error {
+	if err := r.writeForUser(id).DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	return r.DeleteFollowsByUser(ctx, id)
+}
+
+// Posts
+
+func (r *Router) CreatePost(ctx context.Context, arg sqlc.CreatePostParams) (sqlc.Post, error) {
+	if arg.ID == 0 {
+		arg.ID = r.ids.Next()
+	}
+	return r.writeForUser(arg.UserID).CreatePost(ctx, arg)
+}
+
+func (r *Router) CreatePostsInBatch(ctx context.Context, arg []sqlc.CreatePostsInBatchParams) (int64, error) {
+	batches := make(map[int][]sqlc.CreatePostsInBatchParams)
+	for _, post := range arg {
+		if post.ID == 0 {
+			post.ID = r.ids.Next()
+		}
+		i := r.ShardOf(post.UserID)
+		batches[i] = append(batches[i], post)
+	}
+
+	shards := make([]int, 0, len(batches))
+	for i := range batches {
+		shards = append(shards, i)
+	}
+	counts, err := gather(ctx, shards, r.writer, func(ctx context.Context, q sqlc.Querier, shard int) (int64, error) {
+		return q.CreatePostsInBatch(ctx, batches[shard])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	for _, n := range counts {
+		rows += n
+	}
+	return rows, nil
+}
+
+func (r *Router) UpdatePost(ctx context.Context, arg sqlc.UpdatePostParams) (sqlc.Post, error) {
+	return r.writeForUser(arg.UserID).UpdatePost(ctx, arg)
+}
+
+func (r *Router) DeletePost(ctx context.Context, arg sqlc.DeletePostParams) (sqlc.Post, error) {
+	return r.writeForUser(arg.UserID).DeletePost(ctx, arg)
+}
+
+// LockPost locks the post against its deletion, as a write does
+func (r *Router) LockPost(ctx context.Context, arg sqlc.LockPostParams) (int64, error) {
+	return r.writeForUser(arg.UserID).LockPost(ctx, arg)
+}
+
+// GetPost looks for the post on every shard, as post IDs do not tell their author
+func (r *Router) GetPost(ctx context.Context, id int64) (sqlc.Post, error) {
+	posts, err := r.GetPostsByIDs(ctx, []int64{id})
+	if err != nil {
+		return sqlc.Post{}, err
+	}
+	if len(posts) == 0 {
+		return sqlc.Post{}, pgx.ErrNoRows
+	}
+	return posts[0], nil
+}
+
+func (r *Router) GetPostsByIDs(ctx context.Context, ids []int64) ([]sqlc.Post, error) {
+	found, err := gather(ctx, r.all(), r.reader, func(ctx context.Context, q sqlc.Querier, _ int) ([]sqlc.Post, error) {
+		return q.GetPostsByIDs(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	posts := []sqlc.Post{}
+	for _, shardPosts := range found {
+		posts = append(posts, shardPosts...)
+	}
+	return posts, nil
+}
+
+func (r *Router) ListPostsByUser(ctx context.Context, arg sqlc.ListPostsByUserParams) ([]sqlc.Post, error) {
+	return r.forUser(arg.UserID).ListPostsByUser(ctx, arg)
+}
+
+func (r *Router) CountPostsByUser(ctx context.Context, userID int64) (int64, error) {
+	return r.forUser(userID).CountPostsByUser(ctx, userID)
+}
+
+// ListRecentPostsByUsers queries the shards of the users only, merging their pages by score and id
+func (r *Router) ListRecentPostsByUsers(ctx context.Context, arg sqlc.ListRecentPostsByUsersParams) ([]sqlc.Post, error) {
+	groups := r.byShard(arg.UserIds)
+	shards := make([]int, 0, len(groups))
+	for i := range groups {
+		shards = append(shards, i)
+	}
+
+	pages, err := gather(ctx, shards, r.reader, func(ctx context.Context, q sqlc.Querier, shard int) ([]sqlc.Post, error) {
+		shardArg := arg
+		shardArg.UserIds = groups[shard]
+		return q.ListRecentPostsByUsers(ctx, shardArg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	posts := []sqlc.Post{}
+	for _, shardPosts := range pages {
+		posts = append(posts, shardPosts...)
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		si, sj := posts[i].CreatedAt.Unix(), posts[j].CreatedAt.Unix()
+		if si != sj {
+			return si > sj
+		}
+		return posts[i].ID > posts[j].ID
+	})
+	return page(posts, 0, int(arg.RowLimit)), nil
+}
+
+// Follows are stored on the shard of the follower and on the shard of the followee, so that
+// following and followers lists are read from a single shard
+
+// CreateFollow checks both users exist, as foreign keys cannot span shards, then writes the follow
+// on both shards. A failure on the followee's shard undoes the follow on the follower's.
+func (r *Router) CreateFollow(ctx context.Context, arg sqlc.CreateFollowParams) (int64, error) {
+	for _, id := range []int64{arg.FollowerID, arg.FolloweeID} {
+		if _, err := r.GetUserByID(ctx, id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &pgconn.PgError{
+					Severity:  "ERROR",
+					Code:      foreignKeyViolation,
+					Message:   fmt.Sprintf("user %d does not exist", id),
+					TableName: "follows",
+				}
+			}
+			return 0, err
+		}
+	}
+
+	follower := r.writeForUser(arg.FollowerID)
+	rows, err := follower.CreateFollow(ctx, arg)
+	if err != nil || r.ShardOf(arg.FollowerID) == r.ShardOf(arg.FolloweeID) {
+		return rows, err
+	}
+
+	if _, err := r.writeForUser(arg.FolloweeID).CreateFollow(ctx, arg); err != nil {
+		if rows > 0 {
+			undo := sqlc.DeleteFollowParams{FollowerID: arg.FollowerID, FolloweeID: arg.FolloweeID}
+			if _, undoErr := follower.DeleteFollow(context.WithoutCancel(ctx), undo); undoErr != nil {
+				log.Printf("failed to undo follow %d -> %d on the follower's shard: %v", arg.FollowerID, arg.FolloweeID, undoErr)
+			}
+		}
+		return 0, err
+	}
+	return rows, nil
+}
+
+// DeleteFollow deletes the follow from both shards, retrying it deletes what a failure left behind
+func (r *Router) DeleteFollow(ctx context.Context, arg sqlc.DeleteFollowParams) (int64, error) {
+	rows, err := r.writeForUser(arg.FollowerID).DeleteFollow(ctx, arg)
+	if err != nil || r.ShardOf(arg.FollowerID) == r.ShardOf(arg.FolloweeID) {
+		return rows, err
+	}
+
+	if _, err := r.writeForUser(arg.FolloweeID).DeleteFollow(ctx, arg); err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+func (r *Router) DeleteFollowsByUser(ctx context.Context, followerID int64) error {
+	_, err := gather(ctx, r.all(), r.writer, func(ctx context.Context, q sqlc.Querier, _ int) (struct{}, error) {
+		return struct{}{}, q.DeleteFollowsByUser(ctx, followerID)
+	})
+	return err
+}
+
+func (r *Router) ListFollowers(ctx context.Context, arg sqlc.ListFollowersParams) ([]sqlc.Follow, error) {
+	return r.forUser(arg.FolloweeID).ListFollowers(ctx, arg)
+}
+
+func (r *Router) ListFollowing(ctx context.Context, arg sqlc.ListFollowingParams) ([]sqlc.Follow, error) {
+	return r.forUser(arg.FollowerID).ListFollowing(ctx, arg)
+}
+
+func (r *Router) ListAllFollowers(ctx context.Context, followeeID int64) ([]sqlc.Follow, error) {
+	return r.forUser(followeeID).ListAllFollowers(ctx, followeeID)
+}
+
+func (r *Router) ListAllFollowing(ctx context.Context, followerID int64) ([]sqlc.Follow, error) {
+	return r.forUser(followerID).ListAllFollowing(ctx, followerID)
+}
+
+func (r *Router) CountFollowers(ctx context.Context, followeeID int64) (int64, error) {
+	return r.forUser(followeeID).CountFollowers(ctx, followeeID)
+}
+
+func (r *Router) CountFollowing(ctx context.Context, followerID int64) (int64, error) {
+	return r.forUser(followerID).CountFollowing(ctx, followerID)
+}
+
+// Outbox
+
+// CreateOutboxEvent writes the event on the shard of its user, next to the change it describes
+func (r *Router) CreateOutboxEvent(ctx context.Context, arg sqlc.CreateOutboxEventParams) (sqlc.Outbox, error) {
+	if arg.ID == 0 {
+		arg.ID = r.ids.Next()
+	}
+	return r.writeForUser(arg.UserID).CreateOutboxEvent(ctx, arg)
+}
+
+// Reactions are stored on the shard of the reacting user, and the reaction counts of a post on the
+// shard of its author
+
+func (r *Router) CreateReaction(ctx context.Context, arg sqlc.CreateReactionParams) (int64, error) {
+	return r.writeForUser(arg.UserID).CreateReaction(ctx, arg)
+}
+
+func (r *Router) GetReaction(ctx context.Context, arg sqlc.GetReactionParams) (string, error) {
+	return r.forUser(arg.UserID).GetReaction(ctx, arg)
+}
+
+// GetReactionForUpdate locks the reaction, as a write does
+func (r *Router) GetReactionForUpdate(ctx context.Context, arg sqlc.GetReactionForUpdateParams) (string, error) {
+	return r.writeForUser(arg.UserID).GetReactionForUpdate(ctx, arg)
+}
+
+func (r *Router) UpdateReaction(ctx context.Context, arg sqlc.UpdateReactionParams) error {
+	return r.writeForUser(arg.UserID).UpdateReaction(ctx, arg)
+}
+
+func (r *Router) DeleteReaction(ctx context.Context, arg sqlc.DeleteReactionParams) (string, error) {
+	return r.writeForUser(arg.UserID).DeleteReaction(ctx, arg)
+}
+
+func (r *Router) ListReactedPostIDs(ctx context.Context, arg sqlc.ListReactedPostIDsParams) ([]int64, error) {
+	return r.forUser(arg.UserID).ListReactedPostIDs(ctx, arg)
+}
+
+func (r *Router) GetPostReactionCounts(ctx context.Context, arg sqlc.GetPostReactionCountsParams) ([]sqlc.PostReactionCount, error) {
+	return r.forUser(arg.UserID).GetPostReactionCounts(ctx, arg)
+}
+
+// AddPostReactionCounts splits the counts by the shard of the posts' authors
+func (r *Router) AddPostReactionCounts(ctx context.Context, arg sqlc.AddPostReactionCountsParams) (int64, error) {
+	batches := make(map[int]*sqlc.AddPostReactionCountsParams)
+	for n, userID := range arg.UserIds {
+		i := r.ShardOf(userID)
+		batch, ok := batches[i]
+		if !ok {
+			batch = &sqlc.AddPostReactionCountsParams{}
+			batches[i] = batch
+		}
+		batch.PostIds = append(batch.PostIds, arg.PostIds[n])
+		batch.UserIds = append(batch.UserIds, userID)
+		batch.Reactions = append(batch.Reactions, arg.Reactions[n])
+		batch.Deltas = append(batch.Deltas, arg.Deltas[n])
+		batch.FlushSeqs = append(batch.FlushSeqs, arg.FlushSeqs[n])
+	}
+
+	shards := make([]int, 0, len(batches))
+	for i := range batches {
+		shards = append(shards, i)
+	}
+	counts, err := gather(ctx, shards, r.writer, func(ctx context.Context, q sqlc.Querier, shard int) (int64, error) {
+		return q.AddPostReactionCounts(ctx, *batches[shard])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	for _, n := range counts {
+		rows += n
+	}
+	return rows, nil
+}
+
+// Replies are stored on the shard of the author of the post at the root of their thread, along with
+// the reply counts of the post
+
+func (r *Router) CreateReply(ctx context.Context, arg sqlc.CreateReplyParams) (sqlc.Reply, error) {
+	if arg.ID == 0 {
+		arg.ID = r.ids.Next()
+	}
+	return r.writeForUser(arg.RootUserID).CreateReply(ctx, arg)
+}
+
+// GetReplyForUpdate locks the reply, as a write does
+func (r *Router) GetReplyForUpdate(ctx context.Context, arg sqlc.GetReplyForUpdateParams) (sqlc.Reply, error) {
+	return r.writeForUser(arg.RootUserID).GetReplyForUpdate(ctx, arg)
+}
+
+func (r *Router) IncrementReplyCount(ctx context.Context, arg sqlc.IncrementReplyCountParams) (int64, error) {
+	return r.writeForUser(arg.RootUserID).IncrementReplyCount(ctx, arg)
+}
+
+func (r *Router) DecrementReplyCount(ctx context.Context, arg sqlc.DecrementReplyCountParams) (sqlc.Reply, error) {
+	return r.writeForUser(arg.RootUserID).DecrementReplyCount(ctx, arg)
+}
+
+func (r *Router) TombstoneReply(ctx context.Context, arg sqlc.TombstoneReplyParams) (sqlc.Reply, error) {
+	return r.writeForUser(arg.RootUserID).TombstoneReply(ctx, arg)
+}
+
+func (r *Router) DeleteReply(ctx context.Context, arg sqlc.DeleteReplyParams) error {
+	return r.writeForUser(arg.RootUserID).DeleteReply(ctx, arg)
+}
+
+func (r *Router) ListThreadReplies(ctx context.Context, arg sqlc.ListThreadRepliesParams) ([]sqlc.Reply, error) {
+	return r.forUser(arg.RootUserID).ListThreadReplies(ctx, arg)
+}
+
+func (r *Router) DeleteThread(ctx context.Context, arg sqlc.DeleteThreadParams) (int64, error) {
+	return r.writeForUser(arg.RootUserID).DeleteThread(ctx, arg)
+}
+
+func (r *Router) AddPostReplyCount(ctx context.Context, arg sqlc.AddPostReplyCountParams) error {
+	return r.writeForUser(arg.UserID).AddPostReplyCount(ctx, arg)
+}
+
+func (r *Router) GetPostReplyCount(ctx context.Context, arg sqlc.GetPostReplyCountParams) (int64, error) {
+	return r.forUser(arg.UserID).GetPostReplyCount(ctx, arg)
+}
+
+func (r *Router) DeletePostReplyCount(ctx context.Context, arg sqlc.DeletePostReplyCountParams) error {
+	return r.writeForUser(arg.UserID).DeletePostReplyCount(ctx, arg)
+}
+
+// Webhooks live on the catalog shard
+
+func (r *Router) CreateWebhook(ctx context.Context, arg sqlc.CreateWebhookParams) (sqlc.Webhook, error) {
+	return r.writeForCatalog().CreateWebhook(ctx, arg)
+}
+
+func (r *Router) GetWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.forCatalog().GetWebhook(ctx, id)
+}
+
+func (r *Router) ListWebhooks(ctx context.Context, arg sqlc.ListWebhooksParams) ([]sqlc.Webhook, error) {
+	return r.forCatalog().ListWebhooks(ctx, arg)
+}
+
+func (r *Router) ListWebhooksForEvent(ctx context.Context, arg sqlc.ListWebhooksForEventParams) ([]sqlc.Webhook, error) {
+	return r.forCatalog().ListWebhooksForEvent(ctx, arg)
+}
+
+func (r *Router) EnableWebhook(ctx context.Context, id int64) (sqlc.Webhook, error) {
+	return r.writeForCatalog().EnableWebhook(ctx, id)
+}
+
+func (r *Router) DeleteWebhook(ctx context.Context, id int64) (int64, error) {
+	return r.writeForCatalog().DeleteWebhook(ctx, id)
+}
+
+func (r *Router) CreateWebhookDelivery(ctx context.Context, arg sqlc.CreateWebhookDeliveryParams) (int64, error) {
+	return r.writeForCatalog().CreateWebhookDelivery(ctx, arg)
+}
+
+func (r *Router) ListWebhookDeliveries(ctx context.Context, arg sqlc.ListWebhookDeliveriesParams) ([]sqlc.WebhookDelivery, error) {
+	return r.forCatalog().ListWebhookDeliveries(ctx, arg)
+}
+
+// page returns at most limit items from offset
+func page[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,161 @@
+// Package shard spreads the data over several Postgres databases by user.
+// A user, their posts and their follows live on the shard picked by the hash of the user id,
+// user-scoped queries go to that shard only and the others run scatter-gather over every shard.
+// Data belonging to no user, such as webhooks, lives on the catalog shard.
+package shard
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+)
+
+// CatalogShard is the index of the shard holding the data that belongs to no user
+const CatalogShard = 0
+
+// Router is a sqlc.Querier routing every query to the shards owning its users.
+// The order of the shards is the shard map: changing it moves users to other shards.
+type Router struct {
+	shards    []sqlc.Querier
+	primaries []Beginner
+	ids       *snowflake.Generator
+
+	// tx is set on the copy of the Router bound to a transaction, txShards then run on it
+	tx       *Tx
+	txShards []sqlc.Querier
+}
+
+var _ sqlc.Querier = (*Router)(nil)
+
+// New creates a new Router over shards, giving new users and posts IDs from ids.
+// primaries begin the transactions of the shards, in the same order. They may be nil when
+// transactions are not used.
+func New(shards []sqlc.Querier, primaries []Beginner, ids *snowflake.Generator) (*Router, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no database shard is configured")
+	}
+	if primaries != nil && len(primaries) != len(shards) {
+		return nil, fmt.Errorf("%d shard primaries are configured for %d shards", len(primaries), len(shards))
+	}
+	return &Router{shards: shards, primaries: primaries, ids: ids}, nil
+}
+
+// ShardOf returns the index of the shard owning the user
+func (r *Router) ShardOf(userID int64) int {
+	return Of(userID, len(r.shards))
+}
+
+// Of returns the index of the shard of id among shards. It hashes the id, as the low bits of snowflake
+// IDs are a node and a sequence and their high bits a timestamp shared by the IDs generated together.
+func Of(id int64, shards int) int {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(id))
+	h := fnv.New64a()
+	_, _ = h.Write(b[:])
+	return int(h.Sum64() % uint64(shards))
+}
+
+// forUser returns the queries reading from the shard owning the user
+func (r *Router) forUser(userID int64) sqlc.Querier {
+	i := r.ShardOf(userID)
+	metrics.DBShardQueries.WithLabelValues(strconv.Itoa(i)).Inc()
+	return r.reader(i)
+}
+
+// writeForUser returns the queries writing to the shard owning the user
+func (r *Router) writeForUser(userID int64) sqlc.Querier {
+	i := r.ShardOf(userID)
+	metrics.DBShardQueries.WithLabelValues(strconv.Itoa(i)).Inc()
+	return r.writer(i)
+}
+
+// forCatalog returns the queries reading from the catalog shard
+func (r *Router) forCatalog() sqlc.Querier {
+	metrics.DBShardQueries.WithLabelValues(strconv.Itoa(CatalogShard)).Inc()
+	return r.reader(CatalogShard)
+}
+
+// writeForCatalog returns the queries writing to the catalog shard
+func (r *Router) writeForCatalog() sqlc.Querier {
+	metrics.DBShardQueries.WithLabelValues(strconv.Itoa(CatalogShard)).Inc()
+	return r.writer(CatalogShard)
+}
+
+// reader returns the queries reading from the shard. Within a transaction the shard it runs on
+// is read through it, so that its own writes are seen.
+func (r *Router) reader(i int) sqlc.Querier {
+	if r.tx != nil && r.tx.on(i) {
+		return r.txShards[i]
+	}
+	return r.shards[i]
+}
+
+// writer returns the queries writing to the shard. Within a transaction writes go through it,
+// binding it to the shard of its first write.
+func (r *Router) writer(i int) sqlc.Querier {
+	if r.tx != nil {
+		return r.txShards[i]
+	}
+	return r.shards[i]
+}
+
+// byShard groups the user ids by the shard owning them
+func (r *Router) byShard(userIDs []int64) map[int][]int64 {
+	groups := make(map[int][]int64)
+	for _, id := range userIDs {
+		i := r.ShardOf(id)
+		groups[i] = append(groups[i], id)
+	}
+	return groups
+}
+
+// all returns the index of every shard
+func (r *Router) all() []int {
+	shards := make([]int, len(r.shards))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+// gather runs query on the shards concurrently, returning their results in the order of shards.
+// on picks the queries of a shard, either reader or writer.
+// The first failing shard cancels the others and fails the whole query.
+func gather[T any](ctx context.Context, shards []int, on func(i int) sqlc.Querier, query func(ctx context.Context, q sqlc.Querier, shard int) (T, error)) ([]T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]T, len(shards))
+	var wg sync.WaitGroup
+	var failed sync.Once
+	var firstErr error
+	for n, i := range shards {
+		metrics.DBShardQueries.WithLabelValues(strconv.Itoa(i)).Inc()
+		wg.Add(1)
+		go func(n, i int) {
+			defer wg.Done()
+			result, err := query(ctx, on(i), i)
+			if err != nil {
+				failed.Do(func() {
+					firstErr = fmt.Errorf("shard %d: %w", i, err)
+					cancel()
+				})
+				return
+			}
+			results[n] = result
+		}(n, i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
@@ -0,0 +1,287 @@
+//go:build integration
+
+package shard
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+	"github.com/n1207n/cache-query-aggregator/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const testShards = 2
+
+var testRouter *Router
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run starts one migrated Postgres container per shard
+func run(m *testing.M) int {
+	ctx := context.Background()
+
+	shards := make([]sqlc.Querier, 0, testShards)
+	primaries := make([]Beginner, 0, testShards)
+	for i := 0; i < testShards; i++ {
+		pgContainer, err := postgres.RunContainer(ctx,
+			testcontainers.WithImage("postgres:16-alpine"),
+			postgres.WithDatabase(fmt.Sprintf("shard%d", i)),
+			postgres.WithUsername("user"),
+			postgres.WithPassword("password"),
+			testcontainers.WithWaitStrategy(
+				wait.ForLog("database system is ready to accept connections").
+					WithOccurrence(2).
+					WithStartupTimeout(5*time.Minute),
+			),
+		)
+		if err != nil {
+			log.Fatalf("failed to start postgres container of shard %d: %s", i, err)
+		}
+		defer func() {
+			if err := pgContainer.Terminate(ctx); err != nil {
+				log.Printf("failed to terminate postgres container: %s", err)
+			}
+		}()
+
+		connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+		if err != nil {
+			log.Fatalf("failed to get connection string: %s", err)
+		}
+
+		migrator, err := migrate.New("file://../../db/migration", connStr)
+		if err != nil {
+			log.Fatalf("failed to create migrator: %s", err)
+		}
+		if err := migrator.Up(); err != nil && err != migrate.ErrNoChange {
+			log.Fatalf("failed to run migrations on shard %d: %s", i, err)
+		}
+
+		dbPool, err := pgxpool.New(ctx, connStr)
+		if err != nil {
+			log.Fatalf("failed to connect to shard %d: %s", i, err)
+		}
+		defer dbPool.Close()
+		shards = append(shards, sqlc.New(dbPool))
+		primaries = append(primaries, dbPool)
+	}
+
+	ids, err := snowflake.NewGenerator(1)
+	if err != nil {
+		log.Fatalf("failed to create ID generator: %s", err)
+	}
+	testRouter, err = New(shards, primaries, ids)
+	if err != nil {
+		log.Fatalf("failed to create shard router: %s", err)
+	}
+
+	return m.Run()
+}
+
+// createUsers creates users until every shard holds at least one
+func createUsers(t *testing.T, ctx context.Context) map[int]sqlc.User {
+	users := make(map[int]sqlc.User)
+	for len(users) < testShards {
+		user, err := testRouter.CreateUser(ctx, sqlc.CreateUserParams{
+			FirstName:      "Shard",
+			LastName:       "User",
+			Email:          "shard." + util.RandomString(8) + "@example.com",
+			HashedPassword: "hashed",
+		})
+		require.NoError(t, err)
+		users[testRouter.ShardOf(user.ID)] = user
+	}
+	return users
+}
+
+func TestRouter_Users(t *testing.T) {
+	ctx := context.Background()
+	users := createUsers(t, ctx)
+
+	for shard, user := range users {
+		fetched, err := testRouter.GetUserByID(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.Email, fetched.Email)
+
+		// The user exists on its own shard only
+		_, err = testRouter.shards[(shard+1)%testShards].GetUserByID(ctx, user.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		byEmail, err := testRouter.GetUserByEmail(ctx, user.Email)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, byEmail.ID)
+	}
+
+	// Emails stay unique across shards
+	_, err := testRouter.CreateUser(ctx, sqlc.CreateUserParams{
+		FirstName: "Again", LastName: "User", Email: users[0].Email, HashedPassword: "hashed",
+	})
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, uniqueViolation, pgErr.Code)
+
+	listed, err := testRouter.ListUsers(ctx, sqlc.ListUsersParams{Limit: 100})
+	require.NoError(t, err)
+	listedIDs := make(map[int64]struct{})
+	for i, user := range listed {
+		listedIDs[user.ID] = struct{}{}
+		if i > 0 {
+			assert.False(t, user.CreatedAt.After(listed[i-1].CreatedAt))
+		}
+	}
+	for _, user := range users {
+		assert.Contains(t, listedIDs, user.ID)
+	}
+}
+
+func TestRouter_Posts(t *testing.T) {
+	ctx := context.Background()
+	users := createUsers(t, ctx)
+
+	var ids []int64
+	var userIDs []int64
+	for _, user := range users {
+		post, err := testRouter.CreatePost(ctx, sqlc.CreatePostParams{UserID: user.ID, Content: "hello from my shard"})
+		require.NoError(t, err)
+		assert.NotZero(t, post.ID)
+		ids = append(ids, post.ID)
+		userIDs = append(userIDs, user.ID)
+
+		fetched, err := testRouter.GetPost(ctx, post.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, fetched.UserID)
+	}
+
+	rows, err := testRouter.CreatePostsInBatch(ctx, []sqlc.CreatePostsInBatchParams{
+		{UserID: users[0].ID, Content: "batch 1"},
+		{UserID: users[1].ID, Content: "batch 2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rows)
+
+	posts, err := testRouter.GetPostsByIDs(ctx, ids)
+	require.NoError(t, err)
+	assert.Len(t, posts, len(ids))
+
+	for _, user := range users {
+		userPosts, err := testRouter.ListPostsByUser(ctx, sqlc.ListPostsByUserParams{UserID: user.ID, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, userPosts, 2)
+	}
+
+	recent, err := testRouter.ListRecentPostsByUsers(ctx, sqlc.ListRecentPostsByUsersParams{
+		UserIds:     userIDs,
+		BeforeScore: time.Now().Add(time.Hour).Unix(),
+		BeforeID:    0,
+		RowLimit:    3,
+	})
+	require.NoError(t, err)
+	assert.Len(t, recent, 3)
+
+	_, err = testRouter.GetPost(ctx, -1)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestRouter_Follows(t *testing.T) {
+	ctx := context.Background()
+	users := createUsers(t, ctx)
+	follower, followee := users[0], users[1]
+
+	rows, err := testRouter.CreateFollow(ctx, sqlc.CreateFollowParams{FollowerID: follower.ID, FolloweeID: followee.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	following, err := testRouter.ListAllFollowing(ctx, follower.ID)
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	assert.Equal(t, followee.ID, following[0].FolloweeID)
+
+	followers, err := testRouter.CountFollowers(ctx, followee.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), followers)
+
+	// Following a user missing from every shard looks like a foreign key violation
+	_, err = testRouter.CreateFollow(ctx, sqlc.CreateFollowParams{FollowerID: follower.ID, FolloweeID: -1})
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, foreignKeyViolation, pgErr.Code)
+
+	// Deleting the followee removes the follow from the follower's shard too
+	require.NoError(t, testRouter.DeleteUser(ctx, followee.ID))
+	following, err = testRouter.ListAllFollowing(ctx, follower.ID)
+	require.NoError(t, err)
+	assert.Empty(t, following)
+}
+
+func TestRouter_Tx(t *testing.T) {
+	ctx := context.Background()
+	users := createUsers(t, ctx)
+
+	newUser := func() sqlc.CreateUserParams {
+		return sqlc.CreateUserParams{
+			FirstName:      "Tx",
+			LastName:       "User",
+			Email:          "tx." + util.RandomString(8) + "@example.com",
+			HashedPassword: "hashed",
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		tx, err := testRouter.BeginTx(ctx)
+		require.NoError(t, err)
+		user, err := tx.CreateUser(ctx, newUser())
+		require.NoError(t, err)
+		post, err := tx.CreatePost(ctx, sqlc.CreatePostParams{UserID: user.ID, Content: "first post"})
+		require.NoError(t, err)
+
+		// Uncommitted rows are seen within the transaction only
+		_, err = tx.GetPost(ctx, post.ID)
+		require.NoError(t, err)
+		_, err = testRouter.GetUserByID(ctx, user.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+		require.NoError(t, tx.Commit(ctx))
+		fetched, err := testRouter.GetPost(ctx, post.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, fetched.UserID)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := testRouter.BeginTx(ctx)
+		require.NoError(t, err)
+		user, err := tx.CreateUser(ctx, newUser())
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback(ctx))
+
+		_, err = testRouter.GetUserByID(ctx, user.ID)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("cross_shard_write", func(t *testing.T) {
+		tx, err := testRouter.BeginTx(ctx)
+		require.NoError(t, err)
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		_, err = tx.CreatePost(ctx, sqlc.CreatePostParams{UserID: users[0].ID, Content: "shard 0"})
+		require.NoError(t, err)
+		_, err = tx.CreatePost(ctx, sqlc.CreatePostParams{UserID: users[1].ID, Content: "shard 1"})
+		assert.ErrorIs(t, err, ErrCrossShardTx)
+	})
+}
@@ -0,0 +1,36 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/n1207n/cache-query-aggregator/internal/snowflake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardOf(t *testing.T) {
+	ids, err := snowflake.NewGenerator(1)
+	require.NoError(t, err)
+
+	// Snowflake IDs generated in a burst share their high bits, they must still spread evenly
+	counts := make([]int, 4)
+	for i := 0; i < 4000; i++ {
+		id := ids.Next()
+		shard := Of(id, len(counts))
+		assert.Equal(t, shard, Of(id, len(counts)))
+		counts[shard]++
+	}
+	for shard, count := range counts {
+		assert.InDelta(t, 1000, count, 150, "shard %d", shard)
+	}
+
+	assert.Equal(t, 0, Of(42, 1))
+}
+
+func TestPage(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	assert.Equal(t, []int{1, 2}, page(items, 0, 2))
+	assert.Equal(t, []int{4, 5}, page(items, 3, 10))
+	assert.Equal(t, []int{}, page(items, 5, 2))
+}
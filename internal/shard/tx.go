@@ -0,0 +1,167 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// ErrCrossShardTx is returned by a write of a transaction to another shard than the one it runs on
+var ErrCrossShardTx = errors.New("transaction cannot write to several shards")
+
+// Beginner begins transactions on a shard primary, as *pgxpool.Pool does
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TxQuerier is implemented by the Beginners whose transactions run through their own queries, such as
+// the routers of the shards with replicas, which learn about the writes of the committed transactions
+type TxQuerier interface {
+	// TxQueries returns the queries running on db, a transaction begun by the Beginner, along with the
+	// function to call once it committed
+	TxQueries(db sqlc.DBTX) (sqlc.Querier, func())
+}
+
+// Tx is a transaction over the shards. As transactions cannot span databases, it runs on the shard
+// of its first write and fails writes to other shards with ErrCrossShardTx. Reads of other shards
+// run outside of it.
+type Tx struct {
+	*Router
+
+	mu    sync.Mutex
+	shard int
+	dbTx  pgx.Tx
+	// committed holds the function of every shard to call once a transaction on it committed
+	committed []func()
+}
+
+// BeginTx starts a transaction. Nothing runs on the database before its first write.
+func (r *Router) BeginTx(ctx context.Context) (*Tx, error) {
+	if r.primaries == nil {
+		return nil, fmt.Errorf("no shard primary is configured to begin transactions on")
+	}
+	if r.tx != nil {
+		return nil, fmt.Errorf("transaction already begun")
+	}
+
+	t := &Tx{shard: -1, committed: make([]func(), len(r.shards))}
+	bound := &Router{shards: r.shards, primaries: r.primaries, ids: r.ids, tx: t}
+	bound.txShards = make([]sqlc.Querier, len(r.shards))
+	for i := range r.shards {
+		db := &txDB{t: t, shard: i}
+		if q, ok := r.primaries[i].(TxQuerier); ok {
+			bound.txShards[i], t.committed[i] = q.TxQueries(db)
+		} else {
+			bound.txShards[i] = sqlc.New(db)
+		}
+	}
+	t.Router = bound
+	return t, nil
+}
+
+// Commit commits the transaction, if it wrote anything
+func (t *Tx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dbTx == nil {
+		return nil
+	}
+	if err := t.dbTx.Commit(ctx); err != nil {
+		return fmt.Errorf("shard %d: %w", t.shard, err)
+	}
+	if committed := t.committed[t.shard]; committed != nil {
+		committed()
+	}
+	return nil
+}
+
+// Rollback rolls the transaction back, if it wrote anything
+func (t *Tx) Rollback(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dbTx == nil {
+		return nil
+	}
+	if err := t.dbTx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		return fmt.Errorf("shard %d: %w", t.shard, err)
+	}
+	return nil
+}
+
+// on reports whether the transaction runs on the shard
+func (t *Tx) on(shard int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dbTx != nil && t.shard == shard
+}
+
+// begin returns the database transaction on the shard, beginning it on the first write
+func (t *Tx) begin(ctx context.Context, shard int) (pgx.Tx, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dbTx != nil {
+		if t.shard != shard {
+			return nil, fmt.Errorf("%w: running on shard %d, writing to shard %d", ErrCrossShardTx, t.shard, shard)
+		}
+		return t.dbTx, nil
+	}
+
+	tx, err := t.primaries[shard].Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction on shard %d: %w", shard, err)
+	}
+	t.shard, t.dbTx = shard, tx
+	return tx, nil
+}
+
+// txDB is the sqlc.DBTX of a transaction on one shard
+type txDB struct {
+	t     *Tx
+	shard int
+}
+
+func (db *txDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx, err := db.t.begin(ctx, db.shard)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return tx.Exec(ctx, sql, args...)
+}
+
+func (db *txDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	tx, err := db.t.begin(ctx, db.shard)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Query(ctx, sql, args...)
+}
+
+func (db *txDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	tx, err := db.t.begin(ctx, db.shard)
+	if err != nil {
+		return errRow{err: err}
+	}
+	return tx.QueryRow(ctx, sql, args...)
+}
+
+func (db *txDB) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	tx, err := db.t.begin(ctx, db.shard)
+	if err != nil {
+		return 0, err
+	}
+	return tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// errRow is a pgx.Row failing with err
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...interface{}) error {
+	return r.err
+}
@@ -0,0 +1,77 @@
+// Package snowflake generates globally unique, roughly time ordered 64 bit IDs.
+// An ID is made of the milliseconds since Epoch (41 bits), the generating node (10 bits) and a
+// per millisecond sequence (12 bits), so that IDs never collide across nodes or database shards.
+package snowflake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+
+	// MaxNode is the largest node id
+	MaxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+)
+
+// Epoch is the start of the ID timestamps, leaving room for 69 years of IDs
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator generates IDs for one node. Every process generating IDs needs its own node id.
+type Generator struct {
+	node int64
+	now  func() time.Time
+
+	mu       sync.Mutex
+	last     int64 // milliseconds since Epoch of the last ID
+	sequence int64
+}
+
+// NewGenerator creates a new Generator for node, which must be within [0, MaxNode]
+func NewGenerator(node int64) (*Generator, error) {
+	if node < 0 || node > MaxNode {
+		return nil, fmt.Errorf("snowflake node %d out of range [0, %d]", node, MaxNode)
+	}
+	return &Generator{node: node, now: time.Now}, nil
+}
+
+// Next returns a new ID. Up to 4096 IDs are generated per millisecond, beyond that and while the
+// clock moves backwards Next waits for the next millisecond.
+func (g *Generator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.millis()
+	for ms < g.last {
+		time.Sleep(time.Duration(g.last-ms) * time.Millisecond)
+		ms = g.millis()
+	}
+
+	if ms == g.last {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			for ms <= g.last {
+				time.Sleep(time.Millisecond / 10)
+				ms = g.millis()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = ms
+
+	return ms<<(nodeBits+sequenceBits) | g.node<<sequenceBits | g.sequence
+}
+
+func (g *Generator) millis() int64 {
+	return g.now().Sub(Epoch).Milliseconds()
+}
+
+// Time returns when id was generated
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>(nodeBits+sequenceBits)) * time.Millisecond)
+}
@@ -0,0 +1,52 @@
+package snowflake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerator(t *testing.T) {
+	t.Run("rejects_out_of_range_node", func(t *testing.T) {
+		_, err := NewGenerator(MaxNode + 1)
+		assert.Error(t, err)
+		_, err = NewGenerator(-1)
+		assert.Error(t, err)
+	})
+
+	t.Run("ids_are_unique_and_ordered", func(t *testing.T) {
+		g, err := NewGenerator(3)
+		require.NoError(t, err)
+		now := Epoch.Add(time.Hour)
+		g.now = func() time.Time { return now }
+
+		seen := make(map[int64]struct{})
+		var last int64
+		// A whole millisecond worth of IDs, then one more in the next millisecond
+		for i := 0; i < maxSequence+2; i++ {
+			if i == maxSequence+1 {
+				now = now.Add(time.Millisecond)
+			}
+			id := g.Next()
+			assert.Greater(t, id, last)
+			seen[id] = struct{}{}
+			last = id
+		}
+		assert.Len(t, seen, maxSequence+2)
+		assert.Equal(t, now, Time(last))
+	})
+
+	t.Run("nodes_do_not_collide", func(t *testing.T) {
+		now := Epoch.Add(time.Hour)
+		a, err := NewGenerator(1)
+		require.NoError(t, err)
+		b, err := NewGenerator(2)
+		require.NoError(t, err)
+		a.now = func() time.Time { return now }
+		b.now = func() time.Time { return now }
+
+		assert.NotEqual(t, a.Next(), b.Next())
+	})
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	nonPublic := map[string]string{
+		"this_network":          "0.1.2.3",
+		"unspecified":           "0.0.0.0",
+		"private":               "10.1.2.3",
+		"carrier_grade_nat":     "100.64.0.1",
+		"carrier_grade_nat_end": "100.127.255.254",
+		"loopback":              "127.0.0.1",
+		"cloud_metadata":        "169.254.169.254",
+		"private_172":           "172.16.0.1",
+		"ietf_assignments":      "192.0.0.8",
+		"documentation":         "192.0.2.1",
+		"private_192":           "192.168.1.1",
+		"benchmarking":          "198.18.0.1",
+		"benchmarking_end":      "198.19.255.254",
+		"multicast":             "224.0.0.1",
+		"broadcast":             "255.255.255.255",
+		"ipv4_mapped_loopback":  "::ffff:127.0.0.1",
+		"ipv6_unspecified":      "::",
+		"ipv6_loopback":         "::1",
+		"nat64_private":         "64:ff9b::a00:1",
+		"nat64_metadata":        "64:ff9b::a9fe:a9fe",
+		"teredo":                "2001::1",
+		"ipv6_documentation":    "2001:db8::1",
+		"6to4":                  "2002:a00:1::1",
+		"unique_local":          "fd00::1",
+		"ipv6_link_local":       "fe80::1",
+		"ipv6_multicast":        "ff02::1",
+	}
+	for name, addr := range nonPublic {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, CheckPublicAddress(netip.MustParseAddr(addr)), ErrNonPublicAddress)
+		})
+	}
+
+	public := map[string]string{
+		"ipv4":                   "93.184.216.34",
+		"before_carrier_nat":     "100.63.255.255",
+		"after_carrier_nat":      "100.128.0.0",
+		"after_benchmarking":     "198.20.0.1",
+		"ipv4_mapped":            "::ffff:93.184.216.34",
+		"ipv6":                   "2606:2800:220:1:248:1893:25c8:1946",
+		"after_nat64":            "64:ff9b:0:0:0:1::1",
+		"ietf_assignments_after": "192.0.1.1",
+	}
+	for name, addr := range public {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, CheckPublicAddress(netip.MustParseAddr(addr)))
+		})
+	}
+
+	assert.ErrorIs(t, CheckPublicAddress(netip.Addr{}), ErrNonPublicAddress)
+}
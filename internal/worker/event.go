@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+)
+
+// Event is a domain event of the outbox, as published to a Redis Stream by OutboxRelay
+type Event struct {
+	// ID is the outbox id of the event, the same on every delivery
+	ID          int64
+	Type        string
+	AggregateID int64
+	UserID      int64
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+// Post decodes the payload of a post event
+func (e Event) Post() (sqlc.Post, error) {
+	var post sqlc.Post
+	if err := json.Unmarshal(e.Payload, &post); err != nil {
+		return sqlc.Post{}, fmt.Errorf("failed to decode post of event %d: %w", e.ID, err)
+	}
+	return post, nil
+}
+
+// values are the fields of the stream entry of the event
+func (e Event) values() []interface{} {
+	return []interface{}{
+		"event_id", strconv.FormatInt(e.ID, 10),
+		"type", e.Type,
+		"aggregate_id", strconv.FormatInt(e.AggregateID, 10),
+		"user_id", strconv.FormatInt(e.UserID, 10),
+		"payload", string(e.Payload),
+		"created_at", e.CreatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// parseEvent reads the event of a stream entry written by OutboxRelay
+func parseEvent(msg redis.XMessage) (Event, error) {
+	field := func(name string) (string, error) {
+		v, ok := msg.Values[name].(string)
+		if !ok {
+			return "", fmt.Errorf("entry %s has no %s", msg.ID, name)
+		}
+		return v, nil
+	}
+	id := func(name string) (int64, error) {
+		v, err := field(name)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(v, 10, 64)
+	}
+
+	var e Event
+	var err error
+	if e.ID, err = id("event_id"); err != nil {
+		return Event{}, err
+	}
+	if e.Type, err = field("type"); err != nil {
+		return Event{}, err
+	}
+	if e.AggregateID, err = id("aggregate_id"); err != nil {
+		return Event{}, err
+	}
+	if e.UserID, err = id("user_id"); err != nil {
+		return Event{}, err
+	}
+	payload, err := field("payload")
+	if err != nil {
+		return Event{}, err
+	}
+	e.Payload = []byte(payload)
+	createdAt, err := field("created_at")
+	if err != nil {
+		return Event{}, err
+	}
+	if e.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// entryTime returns when a stream entry was added, from the milliseconds part of its id
+func entryTime(id string) (time.Time, bool) {
+	ms, _, _ := strings.Cut(id, "-")
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
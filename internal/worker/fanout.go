@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// FeedFanoutGroup is the consumer group of FeedFanout on the post events stream
+const FeedFanoutGroup = "feed-fanout"
+
+// FeedFanout delivers the new posts of the post events to the followers' feeds, as an EventHandler of a
+// StreamConsumer. A failed fan-out is delivered again, to this consumer or another once claimed, and
+// pushing a post into a feed twice is harmless.
+type FeedFanout struct {
+	feedRepo repository.FeedRepository
+}
+
+// NewFeedFanout creates a new FeedFanout
+func NewFeedFanout(feedRepo repository.FeedRepository) *FeedFanout {
+	return &FeedFanout{feedRepo: feedRepo}
+}
+
+func (f *FeedFanout) HandleEvent(ctx context.Context, event Event) error {
+	if event.Type != repository.EventPostCreated {
+		return nil
+	}
+	post, err := event.Post()
+	if err != nil {
+		return err
+	}
+	return f.feedRepo.FanOutPost(ctx, post)
+}
@@ -0,0 +1,72 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mockFeedRepository mocks FeedRepository interface
+type mockFeedRepository struct {
+	mock.Mock
+}
+
+func (m *mockFeedRepository) ListFeed(ctx context.Context, userID int64, cursor repository.FeedCursor, limit int) ([]sqlc.Post, error) {
+	args := m.Called(ctx, userID, cursor, limit)
+	return args.Get(0).([]sqlc.Post), args.Error(1)
+}
+
+func (m *mockFeedRepository) FanOutPost(ctx context.Context, post sqlc.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
+}
+
+func TestFeedFanout_HandleEvent(t *testing.T) {
+	ctx := context.Background()
+	post := sqlc.Post{ID: 10, UserID: 100, Content: "hello", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	created, err := repository.NewPostEvent(repository.EventPostCreated, post)
+	require.NoError(t, err)
+	event := Event{ID: 1, Type: created.EventType, AggregateID: post.ID, UserID: post.UserID, Payload: created.Payload}
+
+	t.Run("fans_out_new_posts", func(t *testing.T) {
+		feedRepo := new(mockFeedRepository)
+		feedRepo.On("FanOutPost", ctx, post).Return(nil).Once()
+
+		require.NoError(t, NewFeedFanout(feedRepo).HandleEvent(ctx, event))
+		feedRepo.AssertExpectations(t)
+	})
+
+	t.Run("failure_is_delivered_again", func(t *testing.T) {
+		feedRepo := new(mockFeedRepository)
+		feedRepo.On("FanOutPost", ctx, mock.Anything).Return(errors.New("redis down")).Once()
+
+		assert.Error(t, NewFeedFanout(feedRepo).HandleEvent(ctx, event))
+	})
+
+	t.Run("skips_other_events", func(t *testing.T) {
+		feedRepo := new(mockFeedRepository)
+		deleted := event
+		deleted.Type = repository.EventPostDeleted
+
+		require.NoError(t, NewFeedFanout(feedRepo).HandleEvent(ctx, deleted))
+		feedRepo.AssertNotCalled(t, "FanOutPost", mock.Anything, mock.Anything)
+	})
+
+	t.Run("malformed_payload", func(t *testing.T) {
+		feedRepo := new(mockFeedRepository)
+		malformed := event
+		malformed.Payload = []byte("{")
+
+		assert.Error(t, NewFeedFanout(feedRepo).HandleEvent(ctx, malformed))
+		feedRepo.AssertNotCalled(t, "FanOutPost", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,296 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+const (
+	// changesChannel is notified by the triggers of the cache changes migration
+	changesChannel = "cache_changes"
+	// changesConsumer is the row of the Redis cache in cache_change_consumers
+	changesConsumer = "redis"
+
+	// lockChangesConsumer takes the row of the consumer, skipped while another instance applies changes
+	lockChangesConsumer = `SELECT consumer FROM cache_change_consumers WHERE consumer = $1 FOR UPDATE SKIP LOCKED`
+	// listChanges reads the oldest changes left. Ids are taken before their transactions commit, so a
+	// change may show up behind changes with greater ids already applied: no position is kept past them.
+	listChanges = `SELECT id, table_name, op, row_id, user_id, changed_at FROM cache_changes ORDER BY id LIMIT $1`
+	// pruneChanges drops the applied changes, the only consumer being the Redis cache
+	pruneChanges = `DELETE FROM cache_changes WHERE id = ANY($1::bigint[])`
+
+	// invalidationTimeout bounds a single batch of changes
+	invalidationTimeout = 30 * time.Second
+)
+
+// change is a row of cache_changes
+type change struct {
+	id        int64
+	table     string
+	op        string
+	rowID     int64
+	userID    int64
+	changedAt time.Time
+}
+
+// InvalidationOptions tunes CacheInvalidator
+type InvalidationOptions struct {
+	// BatchSize is the number of changes applied in one transaction
+	BatchSize int
+	// PollInterval is the interval between checks for changes when no notification arrives
+	PollInterval time.Duration
+}
+
+// CacheInvalidator applies the changes of posts and users logged by the triggers of every shard to the
+// Redis cache, so that writes bypassing CachedPostRepository do not leave it stale. Changes are read in
+// id order and deleted in the transaction of the batch: a batch that fails is applied again, and a
+// restarted instance resumes with the changes left.
+type CacheInvalidator struct {
+	dbs    []*pgxpool.Pool
+	syncer repository.PostCacheSyncer
+	opts   InvalidationOptions
+	now    func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCacheInvalidator creates a new CacheInvalidator over the shard primaries of dbs
+func NewCacheInvalidator(dbs []*pgxpool.Pool, syncer repository.PostCacheSyncer, opts InvalidationOptions) *CacheInvalidator {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	return &CacheInvalidator{
+		dbs:    dbs,
+		syncer: syncer,
+		opts:   opts,
+		now:    time.Now,
+	}
+}
+
+// Start applies the changes of every shard until Stop, woken up by notifications or else every poll interval
+func (w *CacheInvalidator) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	for i, db := range w.dbs {
+		wake := make(chan struct{}, 1)
+		w.wg.Add(2)
+		go func(i int, db *pgxpool.Pool) {
+			defer w.wg.Done()
+			w.listen(ctx, i, db, wake)
+		}(i, db)
+		go func(i int, db *pgxpool.Pool) {
+			defer w.wg.Done()
+			w.run(ctx, i, db, wake)
+		}(i, db)
+	}
+}
+
+// Stop stops applying changes, the pending ones are applied at the next start
+func (w *CacheInvalidator) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+}
+
+func (w *CacheInvalidator) run(ctx context.Context, shard int, db *pgxpool.Pool, wake <-chan struct{}) {
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		w.drain(ctx, shard, db)
+		select {
+		case <-wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listen wakes the shard up on every notification of its triggers. A lost connection is retried after
+// a poll interval, polling keeps the changes flowing meanwhile.
+func (w *CacheInvalidator) listen(ctx context.Context, shard int, db *pgxpool.Pool, wake chan<- struct{}) {
+	for {
+		err := listenChanges(ctx, db, wake)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("failed to listen to cache changes of shard %d: %v", shard, err)
+		select {
+		case <-time.After(w.opts.PollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func listenChanges(ctx context.Context, db *pgxpool.Pool, wake chan<- struct{}) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		return err
+	}
+	for {
+		if _, err := conn.Conn().WaitForNotification(ctx); err != nil {
+			return err
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+			// A wake-up is already pending
+		}
+	}
+}
+
+// drain applies the pending changes of the shard batch by batch
+func (w *CacheInvalidator) drain(ctx context.Context, shard int, db *pgxpool.Pool) {
+	for ctx.Err() == nil {
+		applied, err := w.applyInTx(ctx, shard, db)
+		if err != nil {
+			log.Printf("failed to apply cache changes of shard %d: %v", shard, err)
+			metrics.CacheInvalidationBatches.WithLabelValues("failed").Inc()
+			return
+		}
+		if applied < w.opts.BatchSize {
+			return
+		}
+	}
+}
+
+func (w *CacheInvalidator) applyInTx(ctx context.Context, shard int, db *pgxpool.Pool) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, invalidationTimeout)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(context.WithoutCancel(ctx)) }()
+
+	applied, err := w.apply(ctx, shard, tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit position: %w", err)
+	}
+	return applied, nil
+}
+
+// apply applies the oldest batch of changes left and deletes them.
+// The cache gets the current rows of the changed posts rather than the changes themselves, so that
+// applying a batch again, or out of order with the application's own cache writes, is harmless.
+func (w *CacheInvalidator) apply(ctx context.Context, shard int, db sqlc.DBTX) (int, error) {
+	shardLabel := strconv.Itoa(shard)
+
+	var consumer string
+	if err := db.QueryRow(ctx, lockChangesConsumer, changesConsumer).Scan(&consumer); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Another instance is applying the changes of the shard
+			return 0, nil
+		}
+		return 0, fmt.Errorf("lock consumer: %w", err)
+	}
+
+	changes, err := listCacheChanges(ctx, db, w.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(changes) == 0 {
+		metrics.CacheInvalidationLag.WithLabelValues(shardLabel).Set(0)
+		return 0, nil
+	}
+
+	authors := make(map[int64]int64) // post id -> user id
+	var postIDs, deletedUsers []int64
+	changeIDs := make([]int64, len(changes))
+	for i, c := range changes {
+		changeIDs[i] = c.id
+		switch c.table {
+		case "posts":
+			if _, ok := authors[c.rowID]; !ok {
+				postIDs = append(postIDs, c.rowID)
+			}
+			authors[c.rowID] = c.userID
+		case "users":
+			deletedUsers = append(deletedUsers, c.userID)
+		}
+	}
+
+	var posts []sqlc.Post
+	if len(postIDs) > 0 {
+		if posts, err = sqlc.New(db).GetPostsByIDs(ctx, postIDs); err != nil {
+			return 0, fmt.Errorf("get changed posts: %w", err)
+		}
+	}
+	existing := make(map[int64]struct{}, len(posts))
+	for _, p := range posts {
+		existing[p.ID] = struct{}{}
+	}
+	var removed []repository.PostRef
+	for _, id := range postIDs {
+		if _, ok := existing[id]; !ok {
+			removed = append(removed, repository.PostRef{ID: id, UserID: authors[id]})
+		}
+	}
+
+	if err := w.syncer.RefreshPosts(ctx, posts); err != nil {
+		return 0, err
+	}
+	if err := w.syncer.RemovePosts(ctx, removed); err != nil {
+		return 0, err
+	}
+	if err := w.syncer.RemoveUsers(ctx, deletedUsers); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec(ctx, pruneChanges, changeIDs); err != nil {
+		return 0, fmt.Errorf("prune changes: %w", err)
+	}
+
+	for _, c := range changes {
+		metrics.CacheInvalidationChanges.WithLabelValues(c.table, c.op).Inc()
+	}
+	metrics.CacheInvalidationLag.WithLabelValues(shardLabel).Set(w.now().Sub(changes[len(changes)-1].changedAt).Seconds())
+	metrics.CacheInvalidationBatches.WithLabelValues("success").Inc()
+	return len(changes), nil
+}
+
+func listCacheChanges(ctx context.Context, db sqlc.DBTX, limit int) ([]change, error) {
+	rows, err := db.Query(ctx, listChanges, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.id, &c.table, &c.op, &c.rowID, &c.userID, &c.changedAt); err != nil {
+			return nil, fmt.Errorf("scan change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list changes: %w", err)
+	}
+	return changes, nil
+}
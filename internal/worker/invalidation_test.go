@@ -0,0 +1,248 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChangesDB serves cache_changes and posts from memory, recording the prunes
+type fakeChangesDB struct {
+	locked  bool
+	changes []change
+	posts   map[int64]sqlc.Post
+	pruned  [][]int64
+}
+
+func (db *fakeChangesDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if sql == pruneChanges {
+		ids := args[0].([]int64)
+		db.pruned = append(db.pruned, ids)
+		var left []change
+		for _, c := range db.changes {
+			pruned := false
+			for _, id := range ids {
+				pruned = pruned || c.id == id
+			}
+			if !pruned {
+				left = append(left, c)
+			}
+		}
+		db.changes = left
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeChangesDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows := &fakeRows{}
+	switch {
+	case sql == listChanges:
+		for _, c := range db.changes {
+			if len(rows.values) < args[0].(int) {
+				rows.values = append(rows.values, []interface{}{c.id, c.table, c.op, c.rowID, c.userID, c.changedAt})
+			}
+		}
+	case strings.Contains(sql, "-- name: GetPostsByIDs"):
+		for _, id := range args[0].([]int64) {
+			if p, ok := db.posts[id]; ok {
+				rows.values = append(rows.values, []interface{}{p.ID, p.UserID, p.Content, p.CreatedAt, p.UpdatedAt})
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query")
+	}
+	return rows, nil
+}
+
+func (db *fakeChangesDB) QueryRow(_ context.Context, sql string, _ ...interface{}) pgx.Row {
+	if sql == lockChangesConsumer && !db.locked {
+		return fakeConsumerRow{consumer: changesConsumer}
+	}
+	return fakeConsumerRow{err: pgx.ErrNoRows}
+}
+
+func (db *fakeChangesDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+type fakePositionRow struct {
+	position int64
+	err      error
+}
+
+func (r fakePositionRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int64) = r.position
+	return nil
+}
+
+type fakeConsumerRow struct {
+	consumer string
+	err      error
+}
+
+func (r fakeConsumerRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*string) = r.consumer
+	return nil
+}
+
+type fakeRows struct {
+	pgx.Rows
+	values [][]interface{}
+	next   int
+}
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next <= len(r.values)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	for i, value := range r.values[r.next-1] {
+		switch d := dest[i].(type) {
+		case *int64:
+			*d = value.(int64)
+		case *int32:
+			*d = value.(int32)
+		case *[]byte:
+			*d = value.([]byte)
+		case *string:
+			*d = value.(string)
+		case *time.Time:
+			*d = value.(time.Time)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+
+// fakeSyncer records what the invalidator applied
+type fakeSyncer struct {
+	err          error
+	refreshed    []sqlc.Post
+	removed      []repository.PostRef
+	removedUsers []int64
+}
+
+func (s *fakeSyncer) RefreshPosts(_ context.Context, posts []sqlc.Post) error {
+	s.refreshed = append(s.refreshed, posts...)
+	return s.err
+}
+
+func (s *fakeSyncer) RemovePosts(_ context.Context, posts []repository.PostRef) error {
+	s.removed = append(s.removed, posts...)
+	return s.err
+}
+
+func (s *fakeSyncer) RemoveUsers(_ context.Context, userIDs []int64) error {
+	s.removedUsers = append(s.removedUsers, userIDs...)
+	return s.err
+}
+
+func TestCacheInvalidator_Apply(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	newDB := func() *fakeChangesDB {
+		return &fakeChangesDB{
+			changes: []change{
+				{id: 1, table: "posts", op: "I", rowID: 10, userID: 1, changedAt: now},
+				{id: 2, table: "posts", op: "U", rowID: 10, userID: 1, changedAt: now},
+				{id: 3, table: "posts", op: "D", rowID: 11, userID: 2, changedAt: now},
+				{id: 4, table: "users", op: "D", rowID: 3, userID: 3, changedAt: now},
+			},
+			posts: map[int64]sqlc.Post{10: {ID: 10, UserID: 1, Content: "edited"}},
+		}
+	}
+
+	t.Run("applies_current_rows_and_prunes_them", func(t *testing.T) {
+		db, syncer := newDB(), &fakeSyncer{}
+		w := NewCacheInvalidator(nil, syncer, InvalidationOptions{BatchSize: 10})
+
+		applied, err := w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 4, applied)
+
+		// Post 10 changed twice and is refreshed once, post 11 is gone from DB
+		require.Len(t, syncer.refreshed, 1)
+		assert.Equal(t, "edited", syncer.refreshed[0].Content)
+		assert.Equal(t, []repository.PostRef{{ID: 11, UserID: 2}}, syncer.removed)
+		assert.Equal(t, []int64{3}, syncer.removedUsers)
+		assert.Equal(t, [][]int64{{1, 2, 3, 4}}, db.pruned)
+
+		// Nothing is left to apply
+		applied, err = w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Zero(t, applied)
+	})
+
+	t.Run("applies_in_batches", func(t *testing.T) {
+		db, syncer := newDB(), &fakeSyncer{}
+		w := NewCacheInvalidator(nil, syncer, InvalidationOptions{BatchSize: 3})
+
+		applied, err := w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 3, applied)
+		applied, err = w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 1, applied)
+		assert.Equal(t, [][]int64{{1, 2, 3}, {4}}, db.pruned)
+	})
+
+	t.Run("applies_changes_committed_late", func(t *testing.T) {
+		db, syncer := newDB(), &fakeSyncer{}
+		// Change 5 commits while the transaction of change 4 is still open
+		late := db.changes[3]
+		db.changes = append(db.changes[:3], change{id: 5, table: "posts", op: "U", rowID: 10, userID: 1, changedAt: now})
+		w := NewCacheInvalidator(nil, syncer, InvalidationOptions{BatchSize: 10})
+
+		applied, err := w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 4, applied)
+
+		db.changes = append(db.changes, late)
+		applied, err = w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 1, applied)
+		assert.Equal(t, []int64{3}, syncer.removedUsers)
+		assert.Equal(t, [][]int64{{1, 2, 3, 5}, {4}}, db.pruned)
+	})
+
+	t.Run("cache_failure_keeps_changes", func(t *testing.T) {
+		db, syncer := newDB(), &fakeSyncer{err: errors.New("redis down")}
+		w := NewCacheInvalidator(nil, syncer, InvalidationOptions{BatchSize: 10})
+
+		_, err := w.apply(ctx, 0, db)
+		assert.Error(t, err)
+		assert.Empty(t, db.pruned)
+		assert.Len(t, db.changes, 4)
+	})
+
+	t.Run("consumer_locked_by_another_instance", func(t *testing.T) {
+		db, syncer := newDB(), &fakeSyncer{}
+		db.locked = true
+		w := NewCacheInvalidator(nil, syncer, InvalidationOptions{BatchSize: 10})
+
+		applied, err := w.apply(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Zero(t, applied)
+		assert.Empty(t, syncer.refreshed)
+	})
+}
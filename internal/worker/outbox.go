@@ -0,0 +1,282 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// claimOutboxEvents locks the events due for publishing in id order, skipping those another instance is
+	// publishing and those of an aggregate whose earlier event waits for a retry
+	claimOutboxEvents = `SELECT id, event_type, aggregate_id, user_id, payload, created_at, attempts FROM outbox
+WHERE sent_at IS NULL AND dead_lettered_at IS NULL AND next_attempt_at <= NOW()
+AND NOT EXISTS (SELECT 1 FROM outbox earlier WHERE earlier.aggregate_id = outbox.aggregate_id AND earlier.id < outbox.id
+AND earlier.sent_at IS NULL AND earlier.dead_lettered_at IS NULL AND earlier.next_attempt_at > NOW())
+ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`
+	markOutboxSent  = `UPDATE outbox SET sent_at = NOW(), attempts = attempts + 1 WHERE id = ANY($1)`
+	retryOutboxSend = `UPDATE outbox SET attempts = attempts + 1, last_error = $2,
+next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond' WHERE id = $1`
+	deadLetterOutbox = `UPDATE outbox SET attempts = attempts + 1, last_error = $2, dead_lettered_at = NOW() WHERE id = $1`
+	pruneOutbox      = `DELETE FROM outbox WHERE sent_at < NOW() - $1 * INTERVAL '1 millisecond'`
+
+	// outboxTimeout bounds a single batch of events
+	outboxTimeout = 30 * time.Second
+	// outboxMaxBackoff caps the delay between the attempts of an event
+	outboxMaxBackoff = 5 * time.Minute
+	// outboxPruneInterval is the interval between the prunes of the sent events
+	outboxPruneInterval = time.Hour
+)
+
+// OutboxOptions tunes OutboxRelay
+type OutboxOptions struct {
+	// Stream is the Redis Stream the events are published to
+	Stream string
+	// StreamMaxLen trims the stream to about this many entries, zero keeps every entry
+	StreamMaxLen int64
+	// BatchSize is the number of events published in one transaction
+	BatchSize int
+	// PollInterval is the interval between checks for events to publish
+	PollInterval time.Duration
+	// MaxAttempts is the number of failed attempts after which an event is dead-lettered
+	MaxAttempts int
+	// BaseBackoff is the delay before the second attempt, doubled on every further attempt
+	BaseBackoff time.Duration
+	// Retention is how long sent events are kept in the outbox
+	Retention time.Duration
+}
+
+// OutboxRelay publishes the events of the outbox of every shard to a Redis Stream. An event is marked
+// sent in the transaction that claimed it, once the stream took it: events are published at least once,
+// and consumers tell duplicates apart by the event_id field. Failed events are retried with exponential
+// backoff, and dead-lettered in the outbox after MaxAttempts.
+//
+// The events of an aggregate are published in id order: a later event is not published before an earlier
+// one is, unless the earlier one was dead-lettered. Relays of several instances claiming the same shard at
+// once may still publish the events of an aggregate concurrently.
+type OutboxRelay struct {
+	dbs  []*pgxpool.Pool
+	rdb  redis.Cmdable
+	opts OutboxOptions
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// outboxEvent is a claimed row of the outbox
+type outboxEvent struct {
+	Event
+	attempts int32
+}
+
+// NewOutboxRelay creates a new OutboxRelay over the shard primaries of dbs
+func NewOutboxRelay(dbs []*pgxpool.Pool, rdb redis.Cmdable, opts OutboxOptions) *OutboxRelay {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &OutboxRelay{dbs: dbs, rdb: rdb, opts: opts}
+}
+
+// Start publishes the events of every shard every poll interval until Stop
+func (r *OutboxRelay) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	for i, db := range r.dbs {
+		r.wg.Add(1)
+		go func(i int, db *pgxpool.Pool) {
+			defer r.wg.Done()
+			r.run(ctx, i, db)
+		}(i, db)
+	}
+}
+
+// Stop stops publishing, the pending events are published at the next start
+func (r *OutboxRelay) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+func (r *OutboxRelay) run(ctx context.Context, shard int, db *pgxpool.Pool) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		r.drain(ctx, shard, db)
+		if r.opts.Retention > 0 && time.Since(lastPrune) >= outboxPruneInterval {
+			if _, err := db.Exec(ctx, pruneOutbox, r.opts.Retention.Milliseconds()); err != nil {
+				log.Printf("failed to prune sent outbox events of shard %d: %v", shard, err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain publishes the due events of the shard batch by batch
+func (r *OutboxRelay) drain(ctx context.Context, shard int, db *pgxpool.Pool) {
+	for ctx.Err() == nil {
+		claimed, err := r.relayInTx(ctx, shard, db)
+		if err != nil {
+			log.Printf("failed to relay outbox events of shard %d: %v", shard, err)
+			return
+		}
+		if claimed < r.opts.BatchSize {
+			return
+		}
+	}
+}
+
+func (r *OutboxRelay) relayInTx(ctx context.Context, shard int, db *pgxpool.Pool) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, outboxTimeout)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(context.WithoutCancel(ctx)) }()
+
+	claimed, err := r.relay(ctx, shard, tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit outbox: %w", err)
+	}
+	return claimed, nil
+}
+
+// relay claims a batch of due events, publishes them and records the outcome of every event
+func (r *OutboxRelay) relay(ctx context.Context, shard int, db sqlc.DBTX) (int, error) {
+	events, err := claimEvents(ctx, db, r.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		metrics.OutboxLag.WithLabelValues(strconv.Itoa(shard)).Set(0)
+		return 0, nil
+	}
+	metrics.OutboxLag.WithLabelValues(strconv.Itoa(shard)).Set(time.Since(events[0].CreatedAt).Seconds())
+
+	// Every round publishes the earliest event left of every aggregate, so that an event is only published
+	// after the earlier ones of its aggregate. The events after a failed one are left due, and are claimed
+	// again once it is sent or dead-lettered.
+	var sent []int64
+	failed := make(map[int64]bool) // aggregate id -> an event failed
+	for pending := events; len(pending) > 0; {
+		var round, next []outboxEvent
+		inRound := make(map[int64]bool)
+		for _, e := range pending {
+			switch {
+			case failed[e.AggregateID]:
+				// Held back until the failed event is sent or dead-lettered
+			case inRound[e.AggregateID]:
+				next = append(next, e)
+			default:
+				inRound[e.AggregateID] = true
+				round = append(round, e)
+			}
+		}
+		pending = next
+
+		pipe := r.rdb.Pipeline()
+		cmds := make([]*redis.StringCmd, len(round))
+		for i, e := range round {
+			cmds[i] = pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: r.opts.Stream,
+				MaxLen: r.opts.StreamMaxLen,
+				Approx: r.opts.StreamMaxLen > 0,
+				Values: e.values(),
+			})
+		}
+		// Failures are read from every command below
+		_, _ = pipe.Exec(ctx)
+
+		for i, e := range round {
+			if err := cmds[i].Err(); err != nil {
+				failed[e.AggregateID] = true
+				if err := r.fail(ctx, db, e, err); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			sent = append(sent, e.ID)
+		}
+	}
+	if len(sent) > 0 {
+		if _, err := db.Exec(ctx, markOutboxSent, sent); err != nil {
+			return 0, fmt.Errorf("mark outbox events sent: %w", err)
+		}
+		metrics.OutboxEvents.WithLabelValues("sent").Add(float64(len(sent)))
+	}
+	return len(events), nil
+}
+
+// fail schedules the next attempt of an event, or dead-letters it after its last attempt
+func (r *OutboxRelay) fail(ctx context.Context, db sqlc.DBTX, e outboxEvent, sendErr error) error {
+	attempts := int(e.attempts) + 1
+	if attempts >= r.opts.MaxAttempts {
+		log.Printf("dead-lettering outbox event %d (%s) after %d attempts: %v", e.ID, e.Type, attempts, sendErr)
+		if _, err := db.Exec(ctx, deadLetterOutbox, e.ID, sendErr.Error()); err != nil {
+			return fmt.Errorf("dead-letter outbox event %d: %w", e.ID, err)
+		}
+		metrics.OutboxEvents.WithLabelValues("dead_lettered").Inc()
+		return nil
+	}
+
+	if _, err := db.Exec(ctx, retryOutboxSend, e.ID, sendErr.Error(), r.backoff(attempts).Milliseconds()); err != nil {
+		return fmt.Errorf("schedule retry of outbox event %d: %w", e.ID, err)
+	}
+	metrics.OutboxEvents.WithLabelValues("retried").Inc()
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.opts.BaseBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+func claimEvents(ctx context.Context, db sqlc.DBTX, limit int) ([]outboxEvent, error) {
+	rows, err := db.Query(ctx, claimOutboxEvents, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []outboxEvent
+	for rows.Next() {
+		var e outboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.AggregateID, &e.UserID, &e.Payload, &e.CreatedAt, &e.attempts); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claim outbox events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,175 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOutboxDB serves claimed outbox events from memory, recording how the relay settled them
+type fakeOutboxDB struct {
+	events       []outboxEvent
+	sent         []int64
+	retries      map[int64]int64 // event id -> backoff in milliseconds
+	deadLettered []int64
+}
+
+func (db *fakeOutboxDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	switch sql {
+	case markOutboxSent:
+		db.sent = append(db.sent, args[0].([]int64)...)
+	case retryOutboxSend:
+		db.retries[args[0].(int64)] = args[2].(int64)
+	case deadLetterOutbox:
+		db.deadLettered = append(db.deadLettered, args[0].(int64))
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeOutboxDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if sql != claimOutboxEvents {
+		return nil, errors.New("unexpected query")
+	}
+	rows := &fakeRows{}
+	for _, e := range db.events {
+		if len(rows.values) < args[0].(int) {
+			rows.values = append(rows.values, []interface{}{e.ID, e.Type, e.AggregateID, e.UserID, e.Payload, e.CreatedAt, e.attempts})
+		}
+	}
+	return rows, nil
+}
+
+func (db *fakeOutboxDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return fakePositionRow{err: pgx.ErrNoRows}
+}
+
+func (db *fakeOutboxDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+func outboxXAddArgs(e outboxEvent) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: "events:posts",
+		MaxLen: 1000,
+		Approx: true,
+		Values: []interface{}{
+			"event_id", strconv.FormatInt(e.ID, 10),
+			"type", e.Type,
+			"aggregate_id", strconv.FormatInt(e.AggregateID, 10),
+			"user_id", strconv.FormatInt(e.UserID, 10),
+			"payload", string(e.Payload),
+			"created_at", e.CreatedAt.Format(time.RFC3339Nano),
+		},
+	}
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	event := outboxEvent{Event: Event{ID: 1, Type: "post.created", AggregateID: 10, UserID: 100, Payload: []byte(`{"id":10}`), CreatedAt: createdAt}}
+	opts := OutboxOptions{Stream: "events:posts", StreamMaxLen: 1000, BatchSize: 10, MaxAttempts: 3, BaseBackoff: time.Second}
+
+	t.Run("publishes_and_marks_sent", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		db := &fakeOutboxDB{events: []outboxEvent{event}, retries: map[int64]int64{}}
+		rdbMock.ExpectXAdd(outboxXAddArgs(event)).SetVal("1-0")
+
+		claimed, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 1, claimed)
+		assert.Equal(t, []int64{1}, db.sent)
+		assert.Empty(t, db.retries)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("failure_schedules_retry_with_backoff", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		retried := event
+		retried.attempts = 1
+		db := &fakeOutboxDB{events: []outboxEvent{retried}, retries: map[int64]int64{}}
+		rdbMock.ExpectXAdd(outboxXAddArgs(retried)).SetErr(errors.New("redis down"))
+
+		_, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Empty(t, db.sent)
+		// Second failed attempt waits twice the base backoff
+		assert.Equal(t, map[int64]int64{1: 2000}, db.retries)
+	})
+
+	t.Run("failure_holds_back_later_events_of_aggregate", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		updated := event
+		updated.ID, updated.Type = 2, "post.updated"
+		other := event
+		other.ID, other.AggregateID = 3, 11
+		db := &fakeOutboxDB{events: []outboxEvent{event, updated, other}, retries: map[int64]int64{}}
+		// The first round holds the earliest event of every post, and fails whole
+		rdbMock.ExpectXAdd(outboxXAddArgs(event)).SetErr(errors.New("redis down"))
+
+		claimed, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 3, claimed)
+		assert.Empty(t, db.sent)
+		// The update is neither published nor retried, it is claimed again after the creation
+		assert.Equal(t, map[int64]int64{1: 1000, 3: 1000}, db.retries)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("publishes_events_of_aggregate_in_order", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		updated := event
+		updated.ID, updated.Type = 2, "post.updated"
+		other := event
+		other.ID, other.AggregateID = 3, 11
+		db := &fakeOutboxDB{events: []outboxEvent{event, updated, other}, retries: map[int64]int64{}}
+		rdbMock.ExpectXAdd(outboxXAddArgs(event)).SetVal("1-0")
+		rdbMock.ExpectXAdd(outboxXAddArgs(other)).SetVal("2-0")
+		rdbMock.ExpectXAdd(outboxXAddArgs(updated)).SetVal("3-0")
+
+		claimed, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, 3, claimed)
+		assert.Equal(t, []int64{1, 3, 2}, db.sent)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("last_failure_dead_letters", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		exhausted := event
+		exhausted.attempts = 2
+		db := &fakeOutboxDB{events: []outboxEvent{exhausted}, retries: map[int64]int64{}}
+		rdbMock.ExpectXAdd(outboxXAddArgs(exhausted)).SetErr(errors.New("redis down"))
+
+		_, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, db.deadLettered)
+		assert.Empty(t, db.retries)
+	})
+
+	t.Run("nothing_due", func(t *testing.T) {
+		rdb, _ := redismock.NewClientMock()
+		db := &fakeOutboxDB{retries: map[int64]int64{}}
+
+		claimed, err := NewOutboxRelay(nil, rdb, opts).relay(ctx, 0, db)
+		require.NoError(t, err)
+		assert.Zero(t, claimed)
+	})
+}
+
+func TestOutboxRelay_Backoff(t *testing.T) {
+	r := NewOutboxRelay(nil, nil, OutboxOptions{BaseBackoff: time.Second})
+	assert.Equal(t, time.Second, r.backoff(1))
+	assert.Equal(t, 4*time.Second, r.backoff(3))
+	assert.Equal(t, outboxMaxBackoff, r.backoff(30))
+}
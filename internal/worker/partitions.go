@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// createPostsPartitions runs the function created by the posts partitioning migration
+	createPostsPartitions = `SELECT create_posts_partitions($1, $2)`
+	// partitionTimeout bounds the maintenance of a single shard
+	partitionTimeout = time.Minute
+)
+
+// PartitionMaintainer creates the monthly posts partitions ahead of time on every shard, so that new
+// posts never land in the default partition
+type PartitionMaintainer struct {
+	dbs         []sqlc.DBTX
+	interval    time.Duration
+	monthsAhead int
+	now         func() time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPartitionMaintainer creates a new PartitionMaintainer keeping monthsAhead months of partitions
+// on every shard primary of dbs, checked every interval
+func NewPartitionMaintainer(dbs []sqlc.DBTX, interval time.Duration, monthsAhead int) *PartitionMaintainer {
+	if monthsAhead < 1 {
+		monthsAhead = 1
+	}
+	return &PartitionMaintainer{
+		dbs:         dbs,
+		interval:    interval,
+		monthsAhead: monthsAhead,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start maintains the partitions now and then every interval until Stop
+func (m *PartitionMaintainer) Start(ctx context.Context) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.Maintain(ctx)
+		if m.interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Maintain(ctx)
+			case <-m.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the maintenance
+func (m *PartitionMaintainer) Stop() {
+	close(m.stop)
+	m.wg.Wait()
+}
+
+// Maintain creates the missing partitions from the current month on every shard, a failing shard
+// is retried at the next interval
+func (m *PartitionMaintainer) Maintain(ctx context.Context) {
+	for i, db := range m.dbs {
+		created, err := m.maintain(ctx, db)
+		if err != nil {
+			log.Printf("failed to maintain posts partitions on shard %d: %v", i, err)
+			metrics.PostsPartitionMaintenance.WithLabelValues("failed").Inc()
+			continue
+		}
+		if created > 0 {
+			log.Printf("created %d posts partitions on shard %d", created, i)
+		}
+		metrics.PostsPartitionMaintenance.WithLabelValues("success").Inc()
+	}
+}
+
+func (m *PartitionMaintainer) maintain(ctx context.Context, db sqlc.DBTX) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, partitionTimeout)
+	defer cancel()
+
+	now := m.now()
+	var created int
+	if err := db.QueryRow(ctx, createPostsPartitions, now, now.AddDate(0, m.monthsAhead, 0)).Scan(&created); err != nil {
+		return 0, fmt.Errorf("create posts partitions: %w", err)
+	}
+	return created, nil
+}
@@ -0,0 +1,76 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeShardDB answers create_posts_partitions calls, recording their window
+type fakeShardDB struct {
+	err     error
+	windows [][2]time.Time
+}
+
+func (db *fakeShardDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeShardDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (db *fakeShardDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	if sql == createPostsPartitions {
+		db.windows = append(db.windows, [2]time.Time{args[0].(time.Time), args[1].(time.Time)})
+	}
+	return fakeCountRow{err: db.err}
+}
+
+func (db *fakeShardDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+type fakeCountRow struct {
+	err error
+}
+
+func (r fakeCountRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = 1
+	return nil
+}
+
+func TestPartitionMaintainer_MaintainsEveryShard(t *testing.T) {
+	now := time.Date(2025, time.January, 31, 12, 0, 0, 0, time.UTC)
+	failing := &fakeShardDB{err: errors.New("connection refused")}
+	healthy := &fakeShardDB{}
+
+	m := NewPartitionMaintainer([]sqlc.DBTX{failing, healthy}, time.Hour, 3)
+	m.now = func() time.Time { return now }
+	m.Maintain(context.Background())
+
+	// A failing shard does not stop the others
+	assert.Len(t, failing.windows, 1)
+	assert.Equal(t, [][2]time.Time{{now, now.AddDate(0, 3, 0)}}, healthy.windows)
+}
+
+func TestPartitionMaintainer_StartsWithMaintenance(t *testing.T) {
+	db := &fakeShardDB{}
+
+	m := NewPartitionMaintainer([]sqlc.DBTX{db}, time.Hour, 3)
+	m.Start(context.Background())
+	m.Stop()
+
+	assert.Len(t, db.windows, 1)
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// PostCountersGroup is the consumer group of PostCounter on the post events stream
+const PostCountersGroup = "post-counters"
+
+// PostCounter keeps the post count of every user from the post events, as an EventHandler of a
+// StreamConsumer. Every event is counted once, however often it is delivered.
+type PostCounter struct {
+	counts repository.PostCountRepository
+}
+
+// NewPostCounter creates a new PostCounter
+func NewPostCounter(counts repository.PostCountRepository) *PostCounter {
+	return &PostCounter{counts: counts}
+}
+
+func (p *PostCounter) HandleEvent(ctx context.Context, event Event) error {
+	var delta int64
+	switch event.Type {
+	case repository.EventPostCreated:
+		delta = 1
+	case repository.EventPostDeleted:
+		delta = -1
+	default:
+		return nil
+	}
+	return p.counts.AddPostCount(ctx, event.UserID, event.ID, delta)
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReactionCountFlusher flushes the reaction counts kept in Redis to DB, such as repository.ReactionCounter
+type ReactionCountFlusher interface {
+	// Flush flushes the counts of at most limit posts and returns the number of posts flushed
+	Flush(ctx context.Context, limit int) (int, error)
+}
+
+// ReactionFlusher flushes the reaction counts of the posts reacted to since the last flush every interval.
+// A flush interrupted by a crash is completed by the next one of any instance.
+type ReactionFlusher struct {
+	counter   ReactionCountFlusher
+	interval  time.Duration
+	batchSize int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReactionFlusher creates a new ReactionFlusher flushing batchSize posts at a time every interval
+func NewReactionFlusher(counter ReactionCountFlusher, interval time.Duration, batchSize int) *ReactionFlusher {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &ReactionFlusher{counter: counter, interval: interval, batchSize: batchSize}
+}
+
+// Start flushes the counts every interval until Stop
+func (f *ReactionFlusher) Start(ctx context.Context) {
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.drain(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop interrupts the flush in flight and stops flushing, the counts left are flushed by the next start
+func (f *ReactionFlusher) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.wg.Wait()
+}
+
+// drain flushes the dirty posts batch by batch
+func (f *ReactionFlusher) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		flushed, err := f.counter.Flush(ctx, f.batchSize)
+		if err != nil {
+			log.Printf("failed to flush reaction counts: %v", err)
+			return
+		}
+		if flushed < f.batchSize {
+			return
+		}
+	}
+}
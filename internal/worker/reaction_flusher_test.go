@@ -0,0 +1,69 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReactionCounter flushes the given batches in turn, then nothing
+type fakeReactionCounter struct {
+	mu      sync.Mutex
+	batches []int
+	err     error
+	calls   int
+}
+
+func (c *fakeReactionCounter) Flush(_ context.Context, limit int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.batches) == 0 {
+		return 0, nil
+	}
+	flushed := min(c.batches[0], limit)
+	c.batches = c.batches[1:]
+	return flushed, nil
+}
+
+func TestReactionFlusher_DrainsFullBatches(t *testing.T) {
+	counter := &fakeReactionCounter{batches: []int{10, 10, 3, 10}}
+	f := NewReactionFlusher(counter, time.Hour, 10)
+
+	f.drain(context.Background())
+
+	// Full batches are followed by another flush, a partial one ends the drain
+	assert.Equal(t, 3, counter.calls)
+	assert.Equal(t, []int{10}, counter.batches)
+}
+
+func TestReactionFlusher_StopsOnError(t *testing.T) {
+	counter := &fakeReactionCounter{err: errors.New("redis down")}
+	f := NewReactionFlusher(counter, time.Hour, 10)
+
+	f.drain(context.Background())
+
+	assert.Equal(t, 1, counter.calls)
+}
+
+func TestReactionFlusher_FlushesEveryInterval(t *testing.T) {
+	counter := &fakeReactionCounter{}
+	f := NewReactionFlusher(counter, 10*time.Millisecond, 10)
+
+	f.Start(context.Background())
+	assert.Eventually(t, func() bool {
+		counter.mu.Lock()
+		defer counter.mu.Unlock()
+		return counter.calls >= 2
+	}, time.Second, 5*time.Millisecond)
+	f.Stop()
+}
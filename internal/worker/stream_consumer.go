@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+)
+
+const (
+	// streamConsumerRetry is the delay before a failed read of the stream is retried
+	streamConsumerRetry = time.Second
+)
+
+// EventHandler handles the events read by a StreamConsumer. An event whose handler fails is delivered
+// again, and an event is delivered again anyway when a consumer stops before acknowledging it:
+// handlers must tolerate duplicates, e.g. by remembering the Event.ID they handled.
+type EventHandler interface {
+	HandleEvent(ctx context.Context, event Event) error
+}
+
+// ConsumerOptions tunes StreamConsumer
+type ConsumerOptions struct {
+	// Stream is the Redis Stream the events are read from
+	Stream string
+	// Group is the consumer group, every event is handled by one consumer of the group
+	Group string
+	// Consumer names this consumer within the group. A consumer restarted under the same name handles
+	// the events it left pending first.
+	Consumer string
+	// BatchSize is the number of events read at a time
+	BatchSize int64
+	// Block is how long a read waits for new events, and bounds how long Stop waits
+	Block time.Duration
+	// ClaimMinIdle is how long an event stays pending before it is claimed, from a failed handler or a
+	// consumer that is gone, and handled again
+	ClaimMinIdle time.Duration
+	// MaxDeliveries is the number of deliveries after which a pending event is dropped
+	MaxDeliveries int64
+}
+
+// StreamConsumer handles the events of a Redis Stream as a member of a consumer group. Events are read
+// with XREADGROUP and acknowledged with XACK once handled. Events left pending, by a handler that failed
+// or a consumer that died, are taken over with XAUTOCLAIM once idle for ClaimMinIdle, and dropped after
+// MaxDeliveries. The group is created at the start of the stream when missing.
+type StreamConsumer struct {
+	rdb     redis.Cmdable
+	handler EventHandler
+	opts    ConsumerOptions
+	now     func() time.Time
+
+	// claimStart is the XAUTOCLAIM cursor over the pending events of the group
+	claimStart string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewStreamConsumer creates a new StreamConsumer handling events with handler
+func NewStreamConsumer(rdb redis.Cmdable, handler EventHandler, opts ConsumerOptions) *StreamConsumer {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.Block <= 0 {
+		opts.Block = time.Second
+	}
+	if opts.ClaimMinIdle <= 0 {
+		opts.ClaimMinIdle = time.Minute
+	}
+	if opts.MaxDeliveries < 1 {
+		opts.MaxDeliveries = 1
+	}
+	return &StreamConsumer{
+		rdb:        rdb,
+		handler:    handler,
+		opts:       opts,
+		now:        time.Now,
+		claimStart: "0-0",
+	}
+}
+
+// Start consumes the stream until Stop
+func (c *StreamConsumer) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(ctx)
+	}()
+}
+
+// Stop stops reading new events once the events already read are handled and acknowledged
+func (c *StreamConsumer) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *StreamConsumer) run(ctx context.Context) {
+	ready := false
+	// The consumer's own pending events are read first, from the start of its history
+	backlog := "0"
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if !ready {
+			if err := c.createGroup(ctx); err != nil {
+				log.Printf("failed to create consumer group %s of %s: %v", c.opts.Group, c.opts.Stream, err)
+				c.wait(ctx, streamConsumerRetry)
+				continue
+			}
+			ready = true
+		}
+
+		if c.now().Sub(lastClaim) >= c.opts.ClaimMinIdle {
+			if err := c.claim(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to claim pending events of %s for %s: %v", c.opts.Stream, c.opts.Group, err)
+			}
+			lastClaim = c.now()
+		}
+
+		start := ">"
+		if backlog != "" {
+			start = backlog
+		}
+		last, err := c.read(ctx, start)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// The stream or its group was deleted
+				ready = false
+			}
+			log.Printf("failed to read %s for %s: %v", c.opts.Stream, c.opts.Group, err)
+			c.wait(ctx, streamConsumerRetry)
+			continue
+		}
+		if backlog != "" {
+			backlog = last
+		}
+	}
+}
+
+// createGroup creates the consumer group, and the stream along with it, unless it exists
+func (c *StreamConsumer) createGroup(ctx context.Context) error {
+	err := c.rdb.XGroupCreateMkStream(ctx, c.opts.Stream, c.opts.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// read reads and handles a batch of events from start, ">" for new events and an entry id for the
+// consumer's own pending events past it. It returns the id of the last entry read, empty when none.
+func (c *StreamConsumer) read(ctx context.Context, start string) (string, error) {
+	streams, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.opts.Group,
+		Consumer: c.opts.Consumer,
+		Streams:  []string{c.opts.Stream, start},
+		Count:    c.opts.BatchSize,
+		Block:    c.opts.Block,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			// Nothing new within the block time
+			metrics.StreamConsumerLag.WithLabelValues(c.opts.Stream, c.opts.Group).Set(0)
+			return "", nil
+		}
+		return "", err
+	}
+
+	var msgs []redis.XMessage
+	for _, s := range streams {
+		msgs = append(msgs, s.Messages...)
+	}
+	if len(msgs) == 0 {
+		return "", nil
+	}
+	c.handle(ctx, msgs)
+
+	last := msgs[len(msgs)-1].ID
+	if added, ok := entryTime(last); ok {
+		metrics.StreamConsumerLag.WithLabelValues(c.opts.Stream, c.opts.Group).Set(c.now().Sub(added).Seconds())
+	}
+	return last, nil
+}
+
+// claim drops the pending events delivered MaxDeliveries times already, then takes over a batch of the
+// events pending for longer than ClaimMinIdle and handles them
+func (c *StreamConsumer) claim(ctx context.Context) error {
+	pending, err := c.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.opts.Stream,
+		Group:  c.opts.Group,
+		Idle:   c.opts.ClaimMinIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  c.opts.BatchSize,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	var dropped []string
+	for _, p := range pending {
+		if p.RetryCount >= c.opts.MaxDeliveries {
+			dropped = append(dropped, p.ID)
+		}
+	}
+	if len(dropped) > 0 {
+		if err := c.rdb.XAck(ctx, c.opts.Stream, c.opts.Group, dropped...).Err(); err != nil {
+			return err
+		}
+		log.Printf("dropped events %v of %s for %s after %d deliveries", dropped, c.opts.Stream, c.opts.Group, c.opts.MaxDeliveries)
+		metrics.StreamConsumerEvents.WithLabelValues(c.opts.Group, "dropped").Add(float64(len(dropped)))
+	}
+
+	msgs, next, err := c.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   c.opts.Stream,
+		Group:    c.opts.Group,
+		MinIdle:  c.opts.ClaimMinIdle,
+		Start:    c.claimStart,
+		Count:    c.opts.BatchSize,
+		Consumer: c.opts.Consumer,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	// The cursor wraps around to 0-0 once every pending event was scanned
+	c.claimStart = next
+	if len(msgs) > 0 {
+		metrics.StreamConsumerEvents.WithLabelValues(c.opts.Group, "claimed").Add(float64(len(msgs)))
+		c.handle(ctx, msgs)
+	}
+
+	summary, err := c.rdb.XPending(ctx, c.opts.Stream, c.opts.Group).Result()
+	if err != nil {
+		return err
+	}
+	metrics.StreamConsumerPending.WithLabelValues(c.opts.Stream, c.opts.Group).Set(float64(summary.Count))
+	return nil
+}
+
+// handle handles a batch of events and acknowledges those handled. Events that fail stay pending to be
+// claimed again. The batch is finished even when ctx is canceled, so that Stop does not redeliver it.
+func (c *StreamConsumer) handle(ctx context.Context, msgs []redis.XMessage) {
+	ctx = context.WithoutCancel(ctx)
+
+	var acked []string
+	for _, msg := range msgs {
+		event, err := parseEvent(msg)
+		if err != nil {
+			// Delivering it again would fail the same way
+			log.Printf("dropping malformed entry %s of %s: %v", msg.ID, c.opts.Stream, err)
+			metrics.StreamConsumerEvents.WithLabelValues(c.opts.Group, "malformed").Inc()
+			acked = append(acked, msg.ID)
+			continue
+		}
+		if err := c.handler.HandleEvent(ctx, event); err != nil {
+			log.Printf("failed to handle event %d (%s) for %s: %v", event.ID, event.Type, c.opts.Group, err)
+			metrics.StreamConsumerEvents.WithLabelValues(c.opts.Group, "failed").Inc()
+			continue
+		}
+		metrics.StreamConsumerEvents.WithLabelValues(c.opts.Group, "handled").Inc()
+		acked = append(acked, msg.ID)
+	}
+
+	if len(acked) > 0 {
+		if err := c.rdb.XAck(ctx, c.opts.Stream, c.opts.Group, acked...).Err(); err != nil {
+			// Unacknowledged events are claimed and handled again
+			log.Printf("failed to acknowledge %d events of %s for %s: %v", len(acked), c.opts.Stream, c.opts.Group, err)
+		}
+	}
+}
+
+func (c *StreamConsumer) wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
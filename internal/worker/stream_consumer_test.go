@@ -0,0 +1,199 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEventHandler records the events it handled, failing those listed in fail
+type fakeEventHandler struct {
+	handled []Event
+	fail    map[int64]bool
+}
+
+func (h *fakeEventHandler) HandleEvent(_ context.Context, event Event) error {
+	if h.fail[event.ID] {
+		return errors.New("handler failed")
+	}
+	h.handled = append(h.handled, event)
+	return nil
+}
+
+func streamEntry(id string, event Event) redis.XMessage {
+	values := event.values()
+	msg := redis.XMessage{ID: id, Values: make(map[string]interface{}, len(values)/2)}
+	for i := 0; i < len(values); i += 2 {
+		msg.Values[values[i].(string)] = values[i+1]
+	}
+	return msg
+}
+
+func TestStreamConsumer_Read(t *testing.T) {
+	ctx := context.Background()
+	opts := ConsumerOptions{Stream: "events:posts", Group: "counters", Consumer: "api-1", BatchSize: 10, Block: time.Second}
+	readArgs := func(start string) *redis.XReadGroupArgs {
+		return &redis.XReadGroupArgs{Group: "counters", Consumer: "api-1", Streams: []string{"events:posts", start}, Count: 10, Block: time.Second}
+	}
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := Event{ID: 1, Type: repository.EventPostCreated, AggregateID: 10, UserID: 100, Payload: []byte(`{"id":10}`), CreatedAt: createdAt}
+	second := Event{ID: 2, Type: repository.EventPostDeleted, AggregateID: 10, UserID: 100, Payload: []byte(`{"id":10}`), CreatedAt: createdAt}
+
+	t.Run("acknowledges_handled_events", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		handler := &fakeEventHandler{}
+		c := NewStreamConsumer(rdb, handler, opts)
+
+		rdbMock.ExpectXReadGroup(readArgs(">")).SetVal([]redis.XStream{{
+			Stream:   "events:posts",
+			Messages: []redis.XMessage{streamEntry("1714564800000-0", first), streamEntry("1714564800000-1", second)},
+		}})
+		rdbMock.ExpectXAck("events:posts", "counters", "1714564800000-0", "1714564800000-1").SetVal(2)
+
+		last, err := c.read(ctx, ">")
+		require.NoError(t, err)
+		assert.Equal(t, "1714564800000-1", last)
+		assert.Equal(t, []Event{first, second}, handler.handled)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("failed_event_stays_pending", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		handler := &fakeEventHandler{fail: map[int64]bool{1: true}}
+		c := NewStreamConsumer(rdb, handler, opts)
+
+		rdbMock.ExpectXReadGroup(readArgs("0")).SetVal([]redis.XStream{{
+			Stream:   "events:posts",
+			Messages: []redis.XMessage{streamEntry("1-0", first), streamEntry("2-0", second)},
+		}})
+		rdbMock.ExpectXAck("events:posts", "counters", "2-0").SetVal(1)
+
+		_, err := c.read(ctx, "0")
+		require.NoError(t, err)
+		assert.Equal(t, []Event{second}, handler.handled)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("malformed_entry_is_dropped", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		handler := &fakeEventHandler{}
+		c := NewStreamConsumer(rdb, handler, opts)
+
+		rdbMock.ExpectXReadGroup(readArgs(">")).SetVal([]redis.XStream{{
+			Stream:   "events:posts",
+			Messages: []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"type": "post.created"}}},
+		}})
+		rdbMock.ExpectXAck("events:posts", "counters", "1-0").SetVal(1)
+
+		_, err := c.read(ctx, ">")
+		require.NoError(t, err)
+		assert.Empty(t, handler.handled)
+		assert.NoError(t, rdbMock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing_new", func(t *testing.T) {
+		rdb, rdbMock := redismock.NewClientMock()
+		c := NewStreamConsumer(rdb, &fakeEventHandler{}, opts)
+
+		rdbMock.ExpectXReadGroup(readArgs(">")).RedisNil()
+
+		last, err := c.read(ctx, ">")
+		require.NoError(t, err)
+		assert.Empty(t, last)
+	})
+}
+
+func TestStreamConsumer_Claim(t *testing.T) {
+	ctx := context.Background()
+	opts := ConsumerOptions{Stream: "events:posts", Group: "counters", Consumer: "api-1", BatchSize: 10, ClaimMinIdle: time.Minute, MaxDeliveries: 3}
+	event := Event{ID: 1, Type: repository.EventPostCreated, AggregateID: 10, UserID: 100, Payload: []byte(`{}`), CreatedAt: time.Now().UTC()}
+
+	rdb, rdbMock := redismock.NewClientMock()
+	handler := &fakeEventHandler{}
+	c := NewStreamConsumer(rdb, handler, opts)
+
+	// 1-0 keeps failing and is dropped, 2-0 was left by a consumer that is gone
+	rdbMock.ExpectXPendingExt(&redis.XPendingExtArgs{
+		Stream: "events:posts", Group: "counters", Idle: time.Minute, Start: "-", End: "+", Count: 10,
+	}).SetVal([]redis.XPendingExt{
+		{ID: "1-0", Consumer: "api-1", RetryCount: 3},
+		{ID: "2-0", Consumer: "api-2", RetryCount: 1},
+	})
+	rdbMock.ExpectXAck("events:posts", "counters", "1-0").SetVal(1)
+	rdbMock.ExpectXAutoClaim(&redis.XAutoClaimArgs{
+		Stream: "events:posts", Group: "counters", MinIdle: time.Minute, Start: "0-0", Count: 10, Consumer: "api-1",
+	}).SetVal([]redis.XMessage{streamEntry("2-0", event)}, "0-0")
+	rdbMock.ExpectXAck("events:posts", "counters", "2-0").SetVal(1)
+	rdbMock.ExpectXPending("events:posts", "counters").SetVal(&redis.XPending{Count: 0})
+
+	require.NoError(t, c.claim(ctx))
+	assert.Equal(t, []Event{event}, handler.handled)
+	assert.NoError(t, rdbMock.ExpectationsWereMet())
+}
+
+// fakePostCounts records the counted deltas per user, counting every event once
+type fakePostCounts struct {
+	counts  map[int64]int64
+	counted map[int64]bool
+}
+
+func (f *fakePostCounts) AddPostCount(_ context.Context, userID, eventID, delta int64) error {
+	if !f.counted[eventID] {
+		f.counted[eventID] = true
+		f.counts[userID] += delta
+	}
+	return nil
+}
+
+func (f *fakePostCounts) GetPostCount(_ context.Context, userID int64) (int64, error) {
+	return f.counts[userID], nil
+}
+
+func TestPostCounter_HandleEvent(t *testing.T) {
+	ctx := context.Background()
+	counts := &fakePostCounts{counts: map[int64]int64{}, counted: map[int64]bool{}}
+	counter := NewPostCounter(counts)
+
+	events := []Event{
+		{ID: 1, Type: repository.EventPostCreated, UserID: 100},
+		{ID: 2, Type: repository.EventPostCreated, UserID: 100},
+		{ID: 3, Type: repository.EventPostUpdated, UserID: 100},
+		{ID: 4, Type: repository.EventPostDeleted, UserID: 100},
+		// Redelivered
+		{ID: 2, Type: repository.EventPostCreated, UserID: 100},
+	}
+	for _, e := range events {
+		require.NoError(t, counter.HandleEvent(ctx, e))
+	}
+
+	count, _ := counts.GetPostCount(ctx, 100)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestTimelinePublisher_HandleEvent(t *testing.T) {
+	ctx := context.Background()
+	rdb, rdbMock := redismock.NewClientMock()
+	publisher := NewTimelinePublisher(rdb)
+	payload := []byte(`{"id":10,"user_id":100,"content":"hello","created_at":"2024-05-01T12:00:00Z","updated_at":"2024-05-01T12:00:00Z"}`)
+
+	post, err := Event{Payload: payload}.Post()
+	require.NoError(t, err)
+	published, err := json.Marshal(post)
+	require.NoError(t, err)
+	rdbMock.ExpectPublish("timeline:100", published).SetVal(1)
+
+	require.NoError(t, publisher.HandleEvent(ctx, Event{ID: 1, Type: repository.EventPostCreated, AggregateID: 10, UserID: 100, Payload: payload}))
+	// Only new posts are published
+	require.NoError(t, publisher.HandleEvent(ctx, Event{ID: 2, Type: repository.EventPostDeleted, AggregateID: 10, UserID: 100, Payload: payload}))
+	assert.NoError(t, rdbMock.ExpectationsWereMet())
+}
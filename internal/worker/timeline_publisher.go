@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/n1207n/cache-query-aggregator/internal/live"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// LiveTimelinesGroup is the consumer group of TimelinePublisher on the post events stream
+const LiveTimelinesGroup = "live-timelines"
+
+// TimelinePublisher publishes the new posts of the post events to the live timelines of every instance,
+// as an EventHandler of a StreamConsumer
+type TimelinePublisher struct {
+	rdb redis.Cmdable
+}
+
+// NewTimelinePublisher creates a new TimelinePublisher
+func NewTimelinePublisher(rdb redis.Cmdable) *TimelinePublisher {
+	return &TimelinePublisher{rdb: rdb}
+}
+
+func (p *TimelinePublisher) HandleEvent(ctx context.Context, event Event) error {
+	if event.Type != repository.EventPostCreated {
+		return nil
+	}
+	post, err := event.Post()
+	if err != nil {
+		return err
+	}
+	return live.Publish(ctx, p.rdb, post)
+}
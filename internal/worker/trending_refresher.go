@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// TrendingWindowRefresher rebuilds the trending windows from the engagement buckets, such as
+// repository.TrendingRepository
+type TrendingWindowRefresher interface {
+	// Refresh rebuilds every window of every shard
+	Refresh(ctx context.Context) error
+}
+
+// TrendingRefresher rebuilds the time-decayed trending windows every interval, so that reads never
+// union buckets. Refreshes of several instances overlap harmlessly, each one replaces the windows.
+type TrendingRefresher struct {
+	trending TrendingWindowRefresher
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTrendingRefresher creates a new TrendingRefresher refreshing the windows every interval
+func NewTrendingRefresher(trending TrendingWindowRefresher, interval time.Duration) *TrendingRefresher {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &TrendingRefresher{trending: trending, interval: interval}
+}
+
+// Start refreshes the windows right away, then every interval until Stop
+func (r *TrendingRefresher) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			if err := r.trending.Refresh(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to refresh trending posts: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop interrupts the refresh in flight and stops refreshing
+func (r *TrendingRefresher) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
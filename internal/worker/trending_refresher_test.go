@@ -0,0 +1,55 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTrendingRefresher signals every refresh
+type fakeTrendingRefresher struct {
+	refreshed chan struct{}
+	err       error
+}
+
+func (r *fakeTrendingRefresher) Refresh(context.Context) error {
+	r.refreshed <- struct{}{}
+	return r.err
+}
+
+func TestTrendingRefresher_RefreshesOnStartAndEveryInterval(t *testing.T) {
+	trending := &fakeTrendingRefresher{refreshed: make(chan struct{}), err: errors.New("redis down")}
+	refresher := NewTrendingRefresher(trending, 10*time.Millisecond)
+	refresher.Start(context.Background())
+
+	// A failed refresh does not stop the next ones
+	for i := 0; i < 2; i++ {
+		select {
+		case <-trending.refreshed:
+		case <-time.After(time.Second):
+			require.FailNow(t, "trending windows not refreshed")
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		refresher.Stop()
+		close(stopped)
+	}()
+	// Let a refresh caught in flight by Stop finish
+	select {
+	case <-trending.refreshed:
+	case <-stopped:
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "refresher not stopped")
+	}
+}
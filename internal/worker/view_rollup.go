@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ViewRoller rolls the viewers recorded since the last rollup up by hour and day, such as
+// repository.ViewRepository
+type ViewRoller interface {
+	// Rollup rolls up the viewers of at most limit posts and timelines and returns the number handled
+	Rollup(ctx context.Context, limit int) (int, error)
+}
+
+// ViewRollup merges the unique viewers of posts and timelines into their hourly and daily rollups every
+// interval. A rollup interrupted by a crash is completed by the next one of any instance.
+type ViewRollup struct {
+	views     ViewRoller
+	interval  time.Duration
+	batchSize int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewViewRollup creates a new ViewRollup rolling up batchSize posts and timelines at a time every interval
+func NewViewRollup(views ViewRoller, interval time.Duration, batchSize int) *ViewRollup {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &ViewRollup{views: views, interval: interval, batchSize: batchSize}
+}
+
+// Start rolls up the viewers every interval until Stop
+func (r *ViewRollup) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.drain(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop interrupts the rollup in flight and stops rolling up, the viewers left are rolled up by the next start
+func (r *ViewRollup) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// drain rolls up the viewed posts and timelines batch by batch
+func (r *ViewRollup) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		rolledUp, err := r.views.Rollup(ctx, r.batchSize)
+		if err != nil {
+			log.Printf("failed to roll up unique views: %v", err)
+			return
+		}
+		if rolledUp < r.batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeViewRoller rolls up the given batches in turn, then nothing
+type fakeViewRoller struct {
+	batches []int
+	err     error
+	calls   int
+}
+
+func (r *fakeViewRoller) Rollup(_ context.Context, limit int) (int, error) {
+	r.calls++
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(r.batches) == 0 {
+		return 0, nil
+	}
+	rolledUp := min(r.batches[0], limit)
+	r.batches = r.batches[1:]
+	return rolledUp, nil
+}
+
+func TestViewRollup_DrainsFullBatches(t *testing.T) {
+	views := &fakeViewRoller{batches: []int{5, 5, 0}}
+	NewViewRollup(views, time.Hour, 5).drain(context.Background())
+
+	assert.Equal(t, 3, views.calls)
+	assert.Empty(t, views.batches)
+}
+
+func TestViewRollup_StopsOnError(t *testing.T) {
+	views := &fakeViewRoller{err: errors.New("redis down")}
+	NewViewRollup(views, time.Hour, 5).drain(context.Background())
+
+	assert.Equal(t, 1, views.calls)
+}
@@ -0,0 +1,368 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/metrics"
+	"github.com/n1207n/cache-query-aggregator/internal/util"
+)
+
+// Headers of the webhook deliveries
+const (
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// claimWebhookDeliveries leases the due deliveries of the enabled webhooks for $2 milliseconds, skipping
+	// those another instance is claiming. A delivery whose attempt is lost with its instance is due again
+	// once the lease is over.
+	claimWebhookDeliveries = `UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+FROM webhooks w
+WHERE w.id = d.webhook_id AND d.id IN (
+    SELECT pending.id FROM webhook_deliveries pending
+    JOIN webhooks hook ON hook.id = pending.webhook_id
+    WHERE pending.status = 'pending' AND pending.next_attempt_at <= NOW() AND hook.disabled_at IS NULL
+    ORDER BY pending.next_attempt_at, pending.id LIMIT $1
+    FOR UPDATE OF pending SKIP LOCKED
+)
+RETURNING d.id, d.webhook_id, d.event_type, d.payload, d.attempts, w.url, w.secret`
+	markWebhookDelivered = `UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1,
+response_status = $2, last_error = NULL, delivered_at = NOW() WHERE id = $1`
+	retryWebhookDelivery = `UPDATE webhook_deliveries SET attempts = attempts + 1, response_status = $2, last_error = $3,
+next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond' WHERE id = $1`
+	failWebhookDelivery = `UPDATE webhook_deliveries SET status = 'failed', attempts = attempts + 1,
+response_status = $2, last_error = $3 WHERE id = $1`
+	resetWebhookFailures = `UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0`
+	// countWebhookFailure disables the webhook at its $2th consecutive failure
+	countWebhookFailure = `UPDATE webhooks SET consecutive_failures = consecutive_failures + 1,
+disabled_at = CASE WHEN consecutive_failures + 1 >= $2 THEN COALESCE(disabled_at, NOW()) ELSE disabled_at END
+WHERE id = $1 RETURNING consecutive_failures`
+	pruneWebhookDeliveries = `DELETE FROM webhook_deliveries WHERE status <> 'pending'
+AND created_at < NOW() - $1 * INTERVAL '1 millisecond'`
+
+	// webhookMaxBackoff caps the delay between the attempts of a delivery
+	webhookMaxBackoff = time.Hour
+	// webhookPruneInterval is the interval between the prunes of the delivery log
+	webhookPruneInterval = time.Hour
+	// webhookMaxResponse bounds the response body read from a receiver, so that the connection is reused
+	webhookMaxResponse = 64 << 10
+)
+
+// WebhookOptions tunes WebhookDeliverer
+type WebhookOptions struct {
+	// BatchSize is the number of deliveries attempted at a time
+	BatchSize int
+	// PollInterval is the interval between checks for due deliveries
+	PollInterval time.Duration
+	// Timeout bounds a delivery request
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery is marked failed
+	MaxAttempts int
+	// BaseBackoff is the delay before the second attempt, doubled on every further attempt
+	BaseBackoff time.Duration
+	// DisableAfter is the number of consecutive failed attempts after which a webhook is disabled
+	DisableAfter int
+	// Retention is how long delivered and failed deliveries are kept in the delivery log
+	Retention time.Duration
+	// AllowPrivateTargets lets deliveries reach loopback, link-local and private addresses
+	AllowPrivateTargets bool
+}
+
+// WebhookDeliverer POSTs the deliveries queued by WebhookDispatcher to their webhooks, from the catalog
+// shard. Every request is signed with the secret of its webhook. A delivery is attempted at least once:
+// receivers tell duplicates apart by the X-Webhook-Delivery header. Failed attempts are retried with
+// exponential backoff until MaxAttempts, and a webhook failing DisableAfter times in a row is disabled.
+type WebhookDeliverer struct {
+	db     *pgxpool.Pool
+	client *http.Client
+	opts   WebhookOptions
+	now    func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// webhookDelivery is a claimed delivery, along with its webhook
+type webhookDelivery struct {
+	id        int64
+	webhookID int64
+	eventType string
+	payload   []byte
+	attempts  int32
+	url       string
+	secret    string
+}
+
+// NewWebhookDeliverer creates a new WebhookDeliverer over the primary of the catalog shard. Redirects
+// are not followed, they fail the attempt. Unless AllowPrivateTargets is set, connections are only made
+// to public addresses, checked once the host is resolved so that a name cannot point inside later on.
+func NewWebhookDeliverer(db *pgxpool.Pool, opts WebhookOptions) *WebhookDeliverer {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.DisableAfter < 1 {
+		opts.DisableAfter = 1
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !opts.AllowPrivateTargets {
+		dialer.Control = dialPublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect to the receiver itself, out of reach of the dialer's check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &WebhookDeliverer{
+		db: db,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// dialPublicOnly refuses connections to non-public addresses, given resolved by the dialer
+func dialPublicOnly(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	return util.CheckPublicAddress(addrPort.Addr())
+}
+
+// Start delivers the due deliveries every poll interval until Stop
+func (d *WebhookDeliverer) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(ctx)
+	}()
+}
+
+// Stop interrupts the attempts in flight and stops delivering
+func (d *WebhookDeliverer) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
+
+func (d *WebhookDeliverer) run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		d.drain(ctx)
+		if d.opts.Retention > 0 && time.Since(lastPrune) >= webhookPruneInterval {
+			if _, err := d.db.Exec(ctx, pruneWebhookDeliveries, d.opts.Retention.Milliseconds()); err != nil {
+				log.Printf("failed to prune webhook deliveries: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain attempts the due deliveries batch by batch
+func (d *WebhookDeliverer) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimed, err := d.deliver(ctx, d.db)
+		if err != nil {
+			log.Printf("failed to deliver webhooks: %v", err)
+			return
+		}
+		if claimed < d.opts.BatchSize {
+			return
+		}
+	}
+}
+
+// deliver claims a batch of due deliveries, attempts them concurrently and records the outcome of every one
+func (d *WebhookDeliverer) deliver(ctx context.Context, db sqlc.DBTX) (int, error) {
+	deliveries, err := d.claim(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	statuses := make([]int, len(deliveries))
+	errs := make([]error, len(deliveries))
+	var wg sync.WaitGroup
+	for i, delivery := range deliveries {
+		wg.Add(1)
+		go func(i int, delivery webhookDelivery) {
+			defer wg.Done()
+			statuses[i], errs[i] = d.send(ctx, delivery)
+		}(i, delivery)
+	}
+	wg.Wait()
+
+	// Attempts made are recorded even when stopping, those interrupted are made again once their lease is over
+	stopping := ctx.Err() != nil
+	ctx = context.WithoutCancel(ctx)
+	for i, delivery := range deliveries {
+		if stopping && errs[i] != nil {
+			continue
+		}
+		if err := d.record(ctx, db, delivery, statuses[i], errs[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *WebhookDeliverer) claim(ctx context.Context, db sqlc.DBTX) ([]webhookDelivery, error) {
+	// The lease outlasts the attempt, so that a delivery is not claimed again while in flight
+	lease := 2 * d.opts.Timeout
+	rows, err := db.Query(ctx, claimWebhookDeliveries, d.opts.BatchSize, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []webhookDelivery
+	for rows.Next() {
+		var w webhookDelivery
+		if err := rows.Scan(&w.id, &w.webhookID, &w.eventType, &w.payload, &w.attempts, &w.url, &w.secret); err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claim webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// send POSTs the delivery, returning the status of the response if any. Only 2xx responses succeed.
+func (d *WebhookDeliverer) send(ctx context.Context, delivery webhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.id, 10))
+	req.Header.Set(WebhookEventHeader, delivery.eventType)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(delivery.secret, timestamp, delivery.payload))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	metrics.WebhookDeliveryDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookMaxResponse))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record settles an attempt: the delivery is marked delivered, retried later or failed, and the
+// consecutive failures of its webhook are counted
+func (d *WebhookDeliverer) record(ctx context.Context, db sqlc.DBTX, delivery webhookDelivery, status int, sendErr error) error {
+	responseStatus := pgtype.Int4{Int32: int32(status), Valid: status != 0}
+	if sendErr == nil {
+		if _, err := db.Exec(ctx, markWebhookDelivered, delivery.id, responseStatus); err != nil {
+			return fmt.Errorf("mark webhook delivery %d delivered: %w", delivery.id, err)
+		}
+		if _, err := db.Exec(ctx, resetWebhookFailures, delivery.webhookID); err != nil {
+			return fmt.Errorf("reset failures of webhook %d: %w", delivery.webhookID, err)
+		}
+		metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		return nil
+	}
+
+	attempts := int(delivery.attempts) + 1
+	if attempts >= d.opts.MaxAttempts {
+		log.Printf("webhook delivery %d to webhook %d failed after %d attempts: %v", delivery.id, delivery.webhookID, attempts, sendErr)
+		if _, err := db.Exec(ctx, failWebhookDelivery, delivery.id, responseStatus, sendErr.Error()); err != nil {
+			return fmt.Errorf("mark webhook delivery %d failed: %w", delivery.id, err)
+		}
+		metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+	} else {
+		if _, err := db.Exec(ctx, retryWebhookDelivery, delivery.id, responseStatus, sendErr.Error(), d.backoff(attempts).Milliseconds()); err != nil {
+			return fmt.Errorf("schedule retry of webhook delivery %d: %w", delivery.id, err)
+		}
+		metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+	}
+
+	var failures int64
+	if err := db.QueryRow(ctx, countWebhookFailure, delivery.webhookID, d.opts.DisableAfter).Scan(&failures); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Deleted meanwhile
+			return nil
+		}
+		return fmt.Errorf("count failure of webhook %d: %w", delivery.webhookID, err)
+	}
+	if failures == int64(d.opts.DisableAfter) {
+		log.Printf("disabled webhook %d after %d consecutive failures", delivery.webhookID, failures)
+		metrics.WebhooksDisabled.Inc()
+	}
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (d *WebhookDeliverer) backoff(attempts int) time.Duration {
+	delay := d.opts.BaseBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// WebhookSignature signs a delivery body sent at timestamp, as the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed with the webhook secret and prefixed by "sha256=". Receivers compute it again to authenticate
+// a delivery, and reject old timestamps to prevent replays.
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+)
+
+// WebhooksGroup is the consumer group of WebhookDispatcher on the post events stream
+const WebhooksGroup = "webhooks"
+
+// webhookBody is the body POSTed to webhooks, the same on every attempt of a delivery
+type webhookBody struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookDispatcher queues a delivery of every post event to the webhooks subscribed to it, as an
+// EventHandler of a StreamConsumer. The deliveries are sent by WebhookDeliverer.
+type WebhookDispatcher struct {
+	webhooks repository.WebhookRepository
+}
+
+// NewWebhookDispatcher creates a new WebhookDispatcher
+func NewWebhookDispatcher(webhooks repository.WebhookRepository) *WebhookDispatcher {
+	return &WebhookDispatcher{webhooks: webhooks}
+}
+
+// HandleEvent queues the deliveries of the event. An event handled again is not queued twice to a webhook.
+func (d *WebhookDispatcher) HandleEvent(ctx context.Context, event Event) error {
+	webhooks, err := d.webhooks.ListWebhooksForEvent(ctx, event.Type, event.UserID)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(webhookBody{ID: event.ID, Type: event.Type, CreatedAt: event.CreatedAt, Data: event.Payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook body of event %d: %w", event.ID, err)
+	}
+	for _, webhook := range webhooks {
+		if _, err := d.webhooks.CreateDelivery(ctx, sqlc.CreateWebhookDeliveryParams{
+			WebhookID: webhook.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   body,
+		}); err != nil {
+			return fmt.Errorf("failed to queue event %d for webhook %d: %w", event.ID, webhook.ID, err)
+		}
+	}
+	return nil
+}
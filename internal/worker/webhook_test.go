@@ -0,0 +1,259 @@
+//go:build unit
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n1207n/cache-query-aggregator/db/sqlc"
+	"github.com/n1207n/cache-query-aggregator/internal/repository"
+	"github.com/n1207n/cache-query-aggregator/internal/repository/mocks"
+	"github.com/n1207n/cache-query-aggregator/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookOutcome is how the deliverer settled a delivery
+type webhookOutcome struct {
+	status         string
+	responseStatus pgtype.Int4
+	lastError      string
+	backoff        int64 // milliseconds, for retried deliveries
+}
+
+// fakeWebhookDB serves claimed deliveries from memory, recording how the deliverer settled them
+type fakeWebhookDB struct {
+	mu         sync.Mutex
+	deliveries []webhookDelivery
+	outcomes   map[int64]webhookOutcome
+	failures   map[int64]int64 // webhook id -> consecutive failures
+}
+
+func newFakeWebhookDB(deliveries ...webhookDelivery) *fakeWebhookDB {
+	return &fakeWebhookDB{deliveries: deliveries, outcomes: map[int64]webhookOutcome{}, failures: map[int64]int64{}}
+}
+
+func (db *fakeWebhookDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch sql {
+	case markWebhookDelivered:
+		db.outcomes[args[0].(int64)] = webhookOutcome{status: repository.WebhookDeliveryDelivered, responseStatus: args[1].(pgtype.Int4)}
+	case retryWebhookDelivery:
+		db.outcomes[args[0].(int64)] = webhookOutcome{status: repository.WebhookDeliveryPending, responseStatus: args[1].(pgtype.Int4), lastError: args[2].(string), backoff: args[3].(int64)}
+	case failWebhookDelivery:
+		db.outcomes[args[0].(int64)] = webhookOutcome{status: repository.WebhookDeliveryFailed, responseStatus: args[1].(pgtype.Int4), lastError: args[2].(string)}
+	case resetWebhookFailures:
+		db.failures[args[0].(int64)] = 0
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (db *fakeWebhookDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if sql != claimWebhookDeliveries {
+		return nil, errors.New("unexpected query")
+	}
+	rows := &fakeRows{}
+	for _, d := range db.deliveries {
+		if len(rows.values) < args[0].(int) {
+			rows.values = append(rows.values, []interface{}{d.id, d.webhookID, d.eventType, d.payload, d.attempts, d.url, d.secret})
+		}
+	}
+	return rows, nil
+}
+
+func (db *fakeWebhookDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if sql != countWebhookFailure {
+		return fakePositionRow{err: pgx.ErrNoRows}
+	}
+	db.failures[args[0].(int64)]++
+	return fakePositionRow{position: db.failures[args[0].(int64)]}
+}
+
+func (db *fakeWebhookDB) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, nil
+}
+
+// webhookReceiver stands in for a webhook receiver, answering status and recording the requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	r.mu.Unlock()
+	w.WriteHeader(r.status)
+}
+
+func TestWebhookDeliverer_Deliver(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"id":1,"type":"post.created","created_at":"2024-05-01T12:00:00Z","data":{"id":10}}`)
+	// Test receivers listen on the loopback interface
+	opts := WebhookOptions{BatchSize: 10, Timeout: time.Second, MaxAttempts: 3, BaseBackoff: time.Second, DisableAfter: 3, AllowPrivateTargets: true}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newDeliverer := func() *WebhookDeliverer {
+		d := NewWebhookDeliverer(nil, opts)
+		d.now = func() time.Time { return now }
+		return d
+	}
+
+	t.Run("signs_and_marks_delivered", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusNoContent}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		db := newFakeWebhookDB(webhookDelivery{id: 7, webhookID: 1, eventType: "post.created", payload: payload, url: srv.URL, secret: "a-long-enough-secret"})
+		db.failures[1] = 2
+
+		claimed, err := newDeliverer().deliver(ctx, db)
+		require.NoError(t, err)
+		assert.Equal(t, 1, claimed)
+		assert.Equal(t, webhookOutcome{status: repository.WebhookDeliveryDelivered, responseStatus: pgtype.Int4{Int32: 204, Valid: true}}, db.outcomes[7])
+		assert.Zero(t, db.failures[1])
+
+		require.Len(t, receiver.requests, 1)
+		req := receiver.requests[0]
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, "7", req.Header.Get(WebhookDeliveryHeader))
+		assert.Equal(t, "post.created", req.Header.Get(WebhookEventHeader))
+		assert.Equal(t, "1714564800", req.Header.Get(WebhookTimestampHeader))
+		assert.Equal(t, payload, receiver.bodies[0])
+		// The receiver authenticates the delivery with the shared secret
+		assert.Equal(t, WebhookSignature("a-long-enough-secret", "1714564800", receiver.bodies[0]), req.Header.Get(WebhookSignatureHeader))
+	})
+
+	t.Run("failure_schedules_retry_with_backoff", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusInternalServerError}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		db := newFakeWebhookDB(webhookDelivery{id: 7, webhookID: 1, eventType: "post.created", payload: payload, attempts: 1, url: srv.URL, secret: "s"})
+
+		_, err := newDeliverer().deliver(ctx, db)
+		require.NoError(t, err)
+		outcome := db.outcomes[7]
+		assert.Equal(t, repository.WebhookDeliveryPending, outcome.status)
+		assert.Equal(t, pgtype.Int4{Int32: 500, Valid: true}, outcome.responseStatus)
+		assert.Equal(t, "receiver answered 500 Internal Server Error", outcome.lastError)
+		// Second failed attempt waits twice the base backoff
+		assert.Equal(t, int64(2000), outcome.backoff)
+		assert.Equal(t, int64(1), db.failures[1])
+	})
+
+	t.Run("redirect_fails", func(t *testing.T) {
+		srv := httptest.NewServer(http.RedirectHandler("http://127.0.0.1:1/elsewhere", http.StatusFound))
+		defer srv.Close()
+		db := newFakeWebhookDB(webhookDelivery{id: 7, webhookID: 1, eventType: "post.created", payload: payload, url: srv.URL, secret: "s"})
+
+		_, err := newDeliverer().deliver(ctx, db)
+		require.NoError(t, err)
+		assert.Equal(t, pgtype.Int4{Int32: 302, Valid: true}, db.outcomes[7].responseStatus)
+		assert.Equal(t, repository.WebhookDeliveryPending, db.outcomes[7].status)
+	})
+
+	t.Run("private_target_refused", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusNoContent}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		// The host is only known to be loopback once resolved
+		target := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+		db := newFakeWebhookDB(webhookDelivery{id: 7, webhookID: 1, eventType: "post.created", payload: payload, url: target, secret: "s"})
+		publicOnly := opts
+		publicOnly.AllowPrivateTargets = false
+		d := NewWebhookDeliverer(nil, publicOnly)
+		d.now = func() time.Time { return now }
+
+		_, err := d.deliver(ctx, db)
+		require.NoError(t, err)
+		assert.Equal(t, repository.WebhookDeliveryPending, db.outcomes[7].status)
+		assert.False(t, db.outcomes[7].responseStatus.Valid)
+		assert.Contains(t, db.outcomes[7].lastError, util.ErrNonPublicAddress.Error())
+		assert.Empty(t, receiver.requests)
+	})
+
+	t.Run("last_failure_marks_failed_and_disables_webhook", func(t *testing.T) {
+		srv := httptest.NewServer(&webhookReceiver{status: http.StatusOK})
+		srv.Close()
+		db := newFakeWebhookDB(webhookDelivery{id: 7, webhookID: 1, eventType: "post.created", payload: payload, attempts: 2, url: srv.URL, secret: "s"})
+		db.failures[1] = 2
+
+		_, err := newDeliverer().deliver(ctx, db)
+		require.NoError(t, err)
+		outcome := db.outcomes[7]
+		assert.Equal(t, repository.WebhookDeliveryFailed, outcome.status)
+		// No response from a receiver that is gone
+		assert.False(t, outcome.responseStatus.Valid)
+		assert.NotEmpty(t, outcome.lastError)
+		assert.Equal(t, int64(3), db.failures[1])
+	})
+
+	t.Run("nothing_due", func(t *testing.T) {
+		claimed, err := newDeliverer().deliver(ctx, newFakeWebhookDB())
+		require.NoError(t, err)
+		assert.Zero(t, claimed)
+	})
+}
+
+func TestWebhookDeliverer_Backoff(t *testing.T) {
+	d := NewWebhookDeliverer(nil, WebhookOptions{BaseBackoff: 10 * time.Second})
+	assert.Equal(t, 10*time.Second, d.backoff(1))
+	assert.Equal(t, 40*time.Second, d.backoff(3))
+	assert.Equal(t, webhookMaxBackoff, d.backoff(30))
+}
+
+func TestWebhookDispatcher_HandleEvent(t *testing.T) {
+	ctx := context.Background()
+	event := Event{ID: 1, Type: repository.EventPostCreated, AggregateID: 10, UserID: 100, Payload: []byte(`{"id":10,"user_id":100}`), CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+	t.Run("queues_a_delivery_per_webhook", func(t *testing.T) {
+		webhooks := new(mocks.WebhookRepository)
+		webhooks.On("ListWebhooksForEvent", ctx, repository.EventPostCreated, int64(100)).Return([]sqlc.Webhook{{ID: 1}, {ID: 2}}, nil)
+		var queued []sqlc.CreateWebhookDeliveryParams
+		webhooks.On("CreateDelivery", ctx, mock.Anything).Run(func(args mock.Arguments) {
+			queued = append(queued, args.Get(1).(sqlc.CreateWebhookDeliveryParams))
+		}).Return(true, nil)
+
+		require.NoError(t, NewWebhookDispatcher(webhooks).HandleEvent(ctx, event))
+
+		require.Len(t, queued, 2)
+		assert.Equal(t, []int64{1, 2}, []int64{queued[0].WebhookID, queued[1].WebhookID})
+		assert.Equal(t, int64(1), queued[0].EventID)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(queued[0].Payload, &body))
+		assert.Equal(t, map[string]interface{}{
+			"id":         float64(1),
+			"type":       "post.created",
+			"created_at": "2024-05-01T12:00:00Z",
+			"data":       map[string]interface{}{"id": float64(10), "user_id": float64(100)},
+		}, body)
+		webhooks.AssertExpectations(t)
+	})
+
+	t.Run("failure_is_retried", func(t *testing.T) {
+		webhooks := new(mocks.WebhookRepository)
+		webhooks.On("ListWebhooksForEvent", ctx, repository.EventPostCreated, int64(100)).Return([]sqlc.Webhook{{ID: 1}}, nil)
+		webhooks.On("CreateDelivery", ctx, mock.Anything).Return(false, errors.New("db down"))
+
+		assert.Error(t, NewWebhookDispatcher(webhooks).HandleEvent(ctx, event))
+	})
+}
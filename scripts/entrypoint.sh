@@ -36,7 +36,11 @@ unset PGPASSWORD
 echo "PostgreSQL started."
 
 echo "Running database migrations..."
-migrate -path /app/db/migration -database "$DB_URL" up
+# Every shard of the shard map gets the same schema, replicas listed after "|" are skipped
+SHARD_URLS=${POSTGRES_SHARD_URLS:-$DB_URL}
+for SHARD_URL in $(echo "$SHARD_URLS" | tr ',' ' '); do
+  migrate -path /app/db/migration -database "${SHARD_URL%%|*}" up
+done
 echo "Database migrations completed."
 
 echo "Starting application..."